@@ -0,0 +1,165 @@
+package main
+
+// This tool runs the extractors again on the documents already indexed, e.g.
+// after an extractor changed, and updates the fields they find. It doesn't
+// need the pages nor the OCR API: the extractors work on the indexed text.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/backfill"
+	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	logutils "github.com/denysvitali/odi-backend/pkg/logutils"
+)
+
+var args struct {
+	All                bool          `arg:"--all" help:"Process all the documents, not only the ones analyzed by an older version of the extractors"`
+	BatchSize          int           `arg:"--batch-size,env:BATCH_SIZE" default:"500" help:"Number of documents fetched and updated at once"`
+	Checkpoint         string        `arg:"--checkpoint,env:CHECKPOINT" default:"backfill-checkpoint.json" help:"File where the progress is saved, an interrupted backfill resumes from it"`
+	CompaniesFile      string        `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex     string        `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	CompanyCacheFile   string        `arg:"--company-cache-file,env:COMPANY_CACHE_FILE" help:"File where the company lookups are kept between runs"`
+	Extractors         []string      `arg:"--extractors,env:EXTRACTORS" help:"Extractors to run: dates, amounts, entities or companies, all of them by default (barcodes can't be found again without the page, they're kept)"`
+	IgnoreCompanies    []string      `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	LogLevel           string        `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	OpenSearchAddr     string        `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchIndex    string        `arg:"--opensearch-index,env:OPENSEARCH_INDEX" default:"documents"`
+	OpenSearchPassword string        `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS  bool          `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername string        `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	ProgressInterval   time.Duration `arg:"--progress-interval" default:"10s" help:"How often the progress is logged"`
+	ZefixDsn           string        `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional)"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	if err := cli.FillKeychainValues(&args); err != nil {
+		log.Fatalf("fill keychain values: %v", err)
+	}
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	selected := args.Extractors
+	if len(selected) == 0 {
+		selected = textExtractors
+	}
+	disabled, err := disabledExtractors(selected)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	idx := getIndexer(disabled)
+
+	query := idx.StaleQuery()
+	if args.All {
+		query = nil
+	}
+
+	start := time.Now()
+	var lastLog time.Time
+	runner, err := backfill.New(backfill.Config{
+		BatchSize:          args.BatchSize,
+		CheckpointFile:     args.Checkpoint,
+		OpenSearchAddr:     args.OpenSearchAddr,
+		OpenSearchIndex:    args.OpenSearchIndex,
+		OpenSearchPassword: args.OpenSearchPassword,
+		OpenSearchSkipTLS:  args.OpenSearchSkipTLS,
+		OpenSearchUsername: args.OpenSearchUsername,
+		Pipeline:           idx.Pipeline(),
+		Query:              query,
+		Progress: func(p backfill.Progress) {
+			if time.Since(lastLog) < args.ProgressInterval {
+				return
+			}
+			lastLog = time.Now()
+			log.Infof("processed %d/%d documents, %d updated, %d failed (%v)",
+				p.Processed, p.Total, p.Updated, p.Failed, time.Since(start).Round(time.Second))
+		},
+	})
+	if err != nil {
+		log.Fatalf("create backfill: %v", err)
+	}
+
+	// Interrupting saves the checkpoint of the last batch
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	p, err := runner.Run(ctx)
+	if saveErr := idx.SaveCompanyCache(); saveErr != nil {
+		log.Errorf("save company cache: %v", saveErr)
+	}
+	if err != nil {
+		log.Fatalf("backfill: %v (processed %d documents, run again to resume)", err, p.Processed)
+	}
+	log.Infof("processed %d documents, %d updated, %d failed in %v",
+		p.Processed, p.Updated, p.Failed, time.Since(start).Round(time.Second))
+}
+
+// textExtractors are the extractors that only need the indexed text
+var textExtractors = []string{
+	indexer.ExtractorDates,
+	indexer.ExtractorAmounts,
+	indexer.ExtractorEntities,
+	indexer.ExtractorCompanies,
+}
+
+// disabledExtractors returns the built-in extractors that weren't selected
+func disabledExtractors(selected []string) ([]string, error) {
+	enabled := map[string]bool{}
+	for _, name := range selected {
+		enabled[name] = true
+	}
+	var disabled []string
+	for _, name := range indexer.ExtractorNames {
+		if enabled[name] {
+			delete(enabled, name)
+			continue
+		}
+		disabled = append(disabled, name)
+	}
+	for name := range enabled {
+		return nil, fmt.Errorf("unknown extractor %q", name)
+	}
+	return disabled, nil
+}
+
+func getIndexer(disabled []string) *indexer.Indexer {
+	opts := []indexer.Option{
+		indexer.WithDocumentsIndex(args.OpenSearchIndex),
+		indexer.WithDisabledExtractors(disabled...),
+	}
+	if args.OpenSearchUsername != "" {
+		opts = append(opts, indexer.WithOpenSearchUsername(args.OpenSearchUsername))
+	}
+	if args.OpenSearchPassword != "" {
+		opts = append(opts, indexer.WithOpenSearchPassword(args.OpenSearchPassword))
+	}
+	if args.OpenSearchSkipTLS {
+		opts = append(opts, indexer.WithOpenSearchSkipTLS())
+	}
+	if len(args.IgnoreCompanies) > 0 {
+		opts = append(opts, indexer.WithIgnoredCompanies(args.IgnoreCompanies...))
+	}
+	if args.CompaniesFile != "" {
+		opts = append(opts, indexer.WithCompaniesFile(args.CompaniesFile))
+	}
+	if args.CompaniesIndex != "" {
+		opts = append(opts, indexer.WithCompaniesIndex(args.CompaniesIndex))
+	}
+	if args.CompanyCacheFile != "" {
+		opts = append(opts, indexer.WithCompanyCacheFile(args.CompanyCacheFile))
+	}
+	// Without an OCR API, the indexer only runs the extractors
+	idx, err := indexer.New(args.OpenSearchAddr, "", args.ZefixDsn, opts...)
+	if err != nil {
+		log.Fatalf("create indexer: %v", err)
+	}
+	return idx
+}
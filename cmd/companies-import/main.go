@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/alexflint/go-arg"
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+var args struct {
+	CompaniesIndex     string `arg:"--companies-index,env:COMPANIES_INDEX" default:"companies" help:"OpenSearch index of the companies"`
+	File               string `arg:"positional,required" help:"CSV file of the companies (columns: legalName, name, uid, locality, type, address, uri)"`
+	LogLevel           string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	OpenSearchAddr     string `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchPassword string `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS  bool   `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername string `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	resolver, err := zefix.LoadCSV(args.File)
+	if err != nil {
+		log.Fatalf("unable to read companies: %v", err)
+	}
+
+	client, err := opensearch.NewClient(opensearch.Config{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: args.OpenSearchSkipTLS},
+		},
+		Addresses: []string{args.OpenSearchAddr},
+		Username:  args.OpenSearchUsername,
+		Password:  args.OpenSearchPassword,
+	})
+	if err != nil {
+		log.Fatalf("unable to create OpenSearch client: %v", err)
+	}
+
+	companies := resolver.Companies()
+	if err := zefix.NewOpenSearchResolver(client, args.CompaniesIndex).Index(companies); err != nil {
+		log.Fatalf("unable to index companies: %v", err)
+	}
+	log.Infof("indexed %d companies in %s", len(companies), args.CompaniesIndex)
+}
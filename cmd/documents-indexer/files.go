@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".heic": true,
+	".heif": true,
+}
+
+// scanDir is a directory imported as a scan
+type scanDir struct {
+	dir   string
+	files []string
+}
+
+// findScans lists the images to import, grouped by directory. Globs are
+// matched against both the name and the path relative to root.
+func findScans(root string, recursive bool, include []string, exclude []string) ([]scanDir, error) {
+	var scans []*scanDir
+	byDir := map[string]*scanDir{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			if !recursive || matchAny(exclude, rel, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !imageExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if matchAny(exclude, rel, d.Name()) {
+			return nil
+		}
+		if len(include) > 0 && !matchAny(include, rel, d.Name()) {
+			return nil
+		}
+
+		// Files and subdirectories are walked in lexical order, the files of
+		// a directory aren't necessarily contiguous
+		dir := filepath.Dir(path)
+		scan, ok := byDir[dir]
+		if !ok {
+			scan = &scanDir{dir: dir}
+			byDir[dir] = scan
+			scans = append(scans, scan)
+		}
+		scan.files = append(scan.files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]scanDir, len(scans))
+	for i, s := range scans {
+		result[i] = *s
+	}
+	return result, nil
+}
+
+func matchAny(globs []string, rel string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, filepath.ToSlash(rel)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
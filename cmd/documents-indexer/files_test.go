@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		globs     []string
+		rel, name string
+		want      bool
+	}{
+		{nil, "a/1.jpg", "1.jpg", false},
+		{[]string{"*.jpg"}, "a/1.jpg", "1.jpg", true},
+		{[]string{"*.png"}, "a/1.jpg", "1.jpg", false},
+		{[]string{"a/*"}, "a/1.jpg", "1.jpg", true},
+		{[]string{"b/*", "drafts"}, "drafts", "drafts", true},
+		{[]string{"a/*"}, "a/b/1.jpg", "1.jpg", false},
+		{[]string{"["}, "a/1.jpg", "1.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := matchAny(tt.globs, tt.rel, tt.name); got != tt.want {
+			t.Errorf("matchAny(%q, %q, %q) = %v, want %v", tt.globs, tt.rel, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindScans(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{
+		"1.jpg", "2.PNG", "notes.txt",
+		"a/1.jpeg", "a/b/1.jpg", "a/z.jpg",
+		"drafts/1.jpg",
+	} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// format lists the files of each scan relative to root
+	format := func(scans []scanDir) string {
+		var result []string
+		for _, s := range scans {
+			dir, _ := filepath.Rel(root, s.dir)
+			var files []string
+			for _, f := range s.files {
+				rel, _ := filepath.Rel(root, f)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			result = append(result, fmt.Sprintf("%s: %v", filepath.ToSlash(dir), files))
+		}
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		name             string
+		recursive        bool
+		include, exclude []string
+		want             string
+	}{
+		{"images of the root", false, nil, nil, "[.: [1.jpg 2.PNG]]"},
+		{"recursive", true, nil, nil, "[.: [1.jpg 2.PNG] a: [a/1.jpeg a/z.jpg] a/b: [a/b/1.jpg] drafts: [drafts/1.jpg]]"},
+		{"excluded directory", true, nil, []string{"drafts", "a/b"}, "[.: [1.jpg 2.PNG] a: [a/1.jpeg a/z.jpg]]"},
+		{"excluded file", true, nil, []string{"1.*"}, "[.: [2.PNG] a: [a/z.jpg]]"},
+		// a/b is walked before a/z.jpg
+		{"included files", true, []string{"*.jpg"}, nil, "[.: [1.jpg] a/b: [a/b/1.jpg] a: [a/z.jpg] drafts: [drafts/1.jpg]]"},
+		{"included path", true, []string{"a/*"}, nil, "[a: [a/1.jpeg a/z.jpg]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scans, err := findScans(root, tt.recursive, tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(scans); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := findScans(filepath.Join(root, "missing"), false, nil, nil); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
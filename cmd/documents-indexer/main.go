@@ -1,32 +1,73 @@
 package main
 
+// documents-indexer imports a directory of scanned images: every directory
+// becomes a scan whose pages are stored and indexed like the scanned ones.
+
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"os"
-	"path"
-	"sync"
-
-	"github.com/denysvitali/odi-backend/pkg/cli"
+	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
-	"github.com/denysvitali/odi-backend/pkg/indexer"
-	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/storage"
+	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
 )
 
 type argsT struct {
 	InputDir string `arg:"positional,required"`
 
-	Debug                        *bool  `arg:"-D,--debug,env:OCR_CLIENT_DEBUG"`
-	OcrApi                       string `arg:"-o,--ocr-api,env:OCR_API_ADDR,required" help:"Address of the OCR API"`
-	OcrApiCaPath                 string `arg:"--ocr-api-ca-path,env:OCR_API_CA_PATH"`
-	OpenSearchAddr               string `arg:"-a,--os-address,env:OPENSEARCH_ADDR,required"`
-	OpenSearchInsecureSkipVerify bool   `arg:"--insecure,env:OPENSEARCH_INSECURE_SKIP_VERIFY"`
-	OpenSearchPassword           string `arg:"-p,--os-password,env:OPENSEARCH_PASSWORD,required"`
-	OpenSearchUsername           string `arg:"-u,--os-username,env:OPENSEARCH_USERNAME,required"`
-	Workers                      int    `arg:"-w" default:"4"`
-	ZefixDsn                     string `arg:"--zefix-dsn,env:ZEFIX_DSN,required" help:"DSN to connect to the Zefix database"`
+	B2AccountId                  string        `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey                 string        `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	B2BucketName                 string        `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
+	B2Passphrase                 string        `arg:"--b2-passphrase,env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
+	Bulk                         bool          `arg:"--bulk,env:BULK" help:"Index the documents with bulk requests"`
+	BulkFlushDocuments           int           `arg:"--bulk-flush-documents,env:BULK_FLUSH_DOCUMENTS" default:"100" help:"Number of documents sent in a bulk request - with --bulk"`
+	BulkFlushInterval            time.Duration `arg:"--bulk-flush-interval,env:BULK_FLUSH_INTERVAL" default:"2s" help:"Longest a document waits for a bulk request - with --bulk"`
+	Debug                        *bool         `arg:"-D,--debug,env:OCR_CLIENT_DEBUG"`
+	CompaniesFile                string        `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex               string        `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	DisableExtractors            []string      `arg:"--disable-extractors,env:DISABLE_EXTRACTORS" help:"Extractors not to run on the pages: dates, amounts, entities, companies or barcodes"`
+	Exclude                      []string      `arg:"--exclude,separate" help:"Glob of the files and directories to skip, can be repeated"`
+	Force                        bool          `arg:"--force" help:"Import the files even if they have already been indexed"`
+	FsPath                       string        `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
+	IgnoreCompanies              []string      `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	Include                      []string      `arg:"--include,separate" help:"Glob of the files to import (e.g. *.jpg), can be repeated"`
+	OcrApi                       string        `arg:"-o,--ocr-api,env:OCR_API_ADDR,required" help:"Address of the OCR API"`
+	OcrApiCaPath                 string        `arg:"--ocr-api-ca-path,env:OCR_API_CA_PATH"`
+	OcrConcurrency               int           `arg:"--ocr-concurrency,env:OCR_CONCURRENCY" default:"2" help:"Maximum number of pages analyzed by the OCR API at the same time"`
+	OpenSearchAddr               string        `arg:"-a,--os-address,env:OPENSEARCH_ADDR,required"`
+	OpenSearchInsecureSkipVerify bool          `arg:"--insecure,env:OPENSEARCH_INSECURE_SKIP_VERIFY"`
+	OpenSearchPassword           string        `arg:"-p,--os-password,env:OPENSEARCH_PASSWORD,required"`
+	OpenSearchUsername           string        `arg:"-u,--os-username,env:OPENSEARCH_USERNAME,required"`
+	Owner                        string        `arg:"--owner,env:OWNER" help:"Owner of the imported documents"`
+	QueueSize                    int           `arg:"--queue-size,env:QUEUE_SIZE" default:"8" help:"Maximum number of scanned pages waiting to be processed before the scanner is paused"`
+	RclonePassphrase             string        `arg:"--rclone-passphrase,env:RCLONE_PASSPHRASE" help:"Passphrase for rclone storage (optional) - when using the rclone storage"`
+	RcloneRemote                 string        `arg:"--rclone-remote,env:RCLONE_REMOTE" help:"rclone remote (path, remote:path or connection string) - when using the rclone storage"`
+	Recursive                    bool          `arg:"-r,--recursive" help:"Import the subdirectories too, each directory becomes a separate scan"`
+	S3AccessKeyId                string        `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Bucket                     string        `arg:"--s3-bucket,env:S3_BUCKET" help:"Bucket name - when using the s3 storage"`
+	S3Endpoint                   string        `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3Passphrase                 string        `arg:"--s3-passphrase,env:S3_PASSPHRASE" help:"Passphrase for client-side encryption (optional) - when using the s3 storage"`
+	S3PathStyle                  bool          `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Prefix                     string        `arg:"--s3-prefix,env:S3_PREFIX" help:"Prefix of the keys in the bucket - when using the s3 storage"`
+	S3Region                     string        `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey            string        `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	S3ServerSideEncryption       string        `arg:"--s3-sse,env:S3_SSE" help:"Server-side encryption: AES256 or aws:kms (optional) - when using the s3 storage"`
+	StorageConcurrency           int           `arg:"--storage-concurrency,env:STORAGE_CONCURRENCY" default:"2" help:"Maximum number of pages uploaded to the storage at the same time"`
+	StorageType                  string        `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	Tags                         []string      `arg:"--tag,separate" help:"Tag added to the imported documents, can be repeated"`
+	Workers                      int           `arg:"-w,--workers,env:WORKERS" default:"4" help:"Number of pages processed at the same time"`
+	ZefixDsn                     string        `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional)"`
 }
 
 var args argsT
@@ -46,75 +87,138 @@ func run() {
 		log.SetLevel(logrus.DebugLevel)
 	}
 
-	if args.Workers <= 0 {
-		args.Workers = 4
-		log.Warnf("workers cannot be <= 0, resetting value to %d", args.Workers)
-	}
-	opts := []indexer.Option{
-		indexer.WithOpenSearchUsername(args.OpenSearchUsername),
-		indexer.WithOpenSearchPassword(args.OpenSearchPassword),
-		indexer.WithOcrApiCAPath(args.OcrApiCaPath),
-	}
-	if args.OpenSearchInsecureSkipVerify {
-		opts = append(opts, indexer.WithOpenSearchSkipTLS())
-	}
-
-	idx, err := indexer.New(
-		args.OpenSearchAddr,
-		args.OcrApi,
-		args.ZefixDsn,
-		opts...,
-	)
+	scans, err := findScans(args.InputDir, args.Recursive, args.Include, args.Exclude)
 	if err != nil {
-		log.Fatalf("unable to create indexer: %v", err)
+		log.Fatalf("unable to list files: %v", err)
 	}
 
-	res, err := idx.PingOpensearch()
+	i, err := ingestor.New(ingestor.Config{
+		BulkFlushDocuments: args.BulkFlushDocuments,
+		BulkFlushInterval:  args.BulkFlushInterval,
+		BulkIndexing:       args.Bulk,
+		CompaniesFile:      args.CompaniesFile,
+		CompaniesIndex:     args.CompaniesIndex,
+		DisabledExtractors: args.DisableExtractors,
+		IgnoredCompanies:   args.IgnoreCompanies,
+		OcrApiAddr:         args.OcrApi,
+		OcrApiCAPath:       args.OcrApiCaPath,
+		OcrConcurrency:     args.OcrConcurrency,
+		OpenSearchAddr:     args.OpenSearchAddr,
+		OpenSearchPassword: args.OpenSearchPassword,
+		OpenSearchSkipTLS:  args.OpenSearchInsecureSkipVerify,
+		OpenSearchUsername: args.OpenSearchUsername,
+		QueueSize:          args.QueueSize,
+		Storage:            getStorage(),
+		StorageConcurrency: args.StorageConcurrency,
+		Workers:            args.Workers,
+		ZefixDsn:           args.ZefixDsn,
+	})
 	if err != nil {
-		log.Fatalf("unable to ping OpenSearch: %v", err)
+		log.Fatalf("unable to create ingestor: %v", err)
 	}
 
-	if res.IsError() {
-		log.Fatalf("failed ping: %s", res.Status())
-	}
-
-	workers := args.Workers
-	ch := make(chan models.ScannedPage)
-	wg := sync.WaitGroup{}
+	imported, skipped, failed := 0, 0, 0
+	for _, scan := range scans {
+		files := scan.files
+		if !args.Force {
+			var unreadable int
+			files, unreadable = notImported(i, files)
+			failed += unreadable
+			skipped += len(scan.files) - len(files) - unreadable
+		}
+		if len(files) == 0 {
+			log.Infof("%s: nothing to import", scan.dir)
+			continue
+		}
 
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		w := indexer.NewWorker(i, ch)
-		w.SetIndexer(idx)
-		go w.Start(&wg)
+		log.Infof("%s: importing %d files", scan.dir, len(files))
+		scanner := ingestor.NewFileScanner(files...)
+		report, err := i.ScanPages(
+			scanner,
+			ingestor.WithTags(args.Tags...),
+			ingestor.WithOwner(args.Owner),
+		)
+		for _, f := range scanner.Skipped() {
+			log.Errorf("unable to import %s: %v", f.Name, f.Err)
+		}
+		failed += len(scanner.Skipped())
+		for _, p := range report.Failed() {
+			log.Errorf("unable to import %s: %s", scanner.Pages()[p.SequenceId-1], p.Error)
+		}
+		if err != nil && !errors.Is(err, ingestor.ErrPagesFailed) {
+			// The remaining files of the directory weren't read
+			log.Errorf("unable to import %s: %v", scan.dir, err)
+			failed += len(files) - len(report.Pages) - len(scanner.Skipped())
+		}
+		log.Infof("%s: %s", scan.dir, report.Summary())
+		imported += report.Indexed()
+		skipped += report.Duplicates()
+		failed += len(report.Failed())
+	}
+	log.Infof("done: %d files imported, %d skipped, %d failed", imported, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
 	}
+}
 
-	scanId := uuid.NewString()
-	seq := 0
-	for _, file := range listFiles(args.InputDir) {
-		if !file.IsDir() {
-			seq++
-			f, err := os.Open(path.Join(args.InputDir, file.Name()))
-			if err != nil {
-				log.Errorf("unable to open file: %v", err)
-				continue
-			}
-			ch <- models.ScannedPage{
-				Reader:     f,
-				ScanId:     scanId,
-				SequenceId: seq,
-			}
+// notImported filters out the files whose page is already indexed. The files
+// that can't be read are reported and left out too, their number is
+// returned.
+func notImported(i *ingestor.Ingestor, files []string) ([]string, int) {
+	var result []string
+	unreadable := 0
+	for _, f := range files {
+		page, _, err := ingestor.ReadImageFile(f)
+		if err != nil {
+			log.Errorf("unable to import %s: %v", f, err)
+			unreadable++
+			continue
+		}
+		h := sha1.Sum(page)
+		ok, err := i.IsImported(hex.EncodeToString(h[:]))
+		if err != nil {
+			// Import it anyway rather than stopping the whole import
+			log.Warnf("unable to check whether %s was imported: %v", f, err)
+		}
+		if ok {
+			log.Debugf("%s: already imported", f)
+			continue
 		}
+		result = append(result, f)
 	}
-	close(ch)
-	wg.Wait()
-	log.Infof("done")
+	return result, unreadable
 }
 
-func listFiles(dir string) []os.DirEntry {
-	d, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatalf("unable to read directory: %v", err)
+func getStorage() model.Storer {
+	switch strings.ToLower(args.StorageType) {
+	case "b2":
+		return storage.SetupB2Storage(b2.Config{
+			Account:    args.B2AccountId,
+			BucketName: args.B2BucketName,
+			Key:        args.B2AccountKey,
+			Passphrase: args.B2Passphrase,
+		})
+	case "fs":
+		return storage.SetupFsStorage(args.FsPath)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     args.RcloneRemote,
+			Passphrase: args.RclonePassphrase,
+		})
+	case "s3":
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:             args.S3Endpoint,
+			Region:               args.S3Region,
+			AccessKeyId:          args.S3AccessKeyId,
+			SecretAccessKey:      args.S3SecretAccessKey,
+			Bucket:               args.S3Bucket,
+			Prefix:               args.S3Prefix,
+			PathStyle:            args.S3PathStyle,
+			ServerSideEncryption: args.S3ServerSideEncryption,
+			Passphrase:           args.S3Passphrase,
+		})
 	}
-	return d
+
+	log.Fatalf("unknown storage type: %s", args.StorageType)
+	return nil
 }
@@ -13,9 +13,7 @@ import (
 )
 
 var args struct {
-	InputFile          string  `arg:"positional,required"`
-	MergeDistance      float64 `arg:"-d,--merge-distance" default:"150"`
-	HorizontalDistance float64 `arg:"-D,--horizontal-distance" default:"10"`
+	InputFile string `arg:"positional,required"`
 }
 
 var log = logrus.New()
@@ -26,7 +24,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to parse JSON: %v", err)
 	}
-	text := ocrtext.GetText(v, args.MergeDistance, args.HorizontalDistance)
+	text := ocrtext.GetText(v)
 	fmt.Println(text)
 }
 
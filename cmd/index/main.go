@@ -4,6 +4,8 @@ package main
 // or simply to re-index the files that failed to be indexed the first time.
 
 import (
+	"time"
+
 	"github.com/alexflint/go-arg"
 	"github.com/sirupsen/logrus"
 
@@ -17,17 +19,27 @@ import (
 var args struct {
 	ScanId string `arg:"positional,required"`
 
-	B2Account          string `arg:"env:B2_ACCOUNT"`
-	B2BucketName       string `arg:"env:B2_BUCKET_NAME"`
-	B2Key              string `arg:"env:B2_KEY"`
-	B2Passphrase       string `arg:"env:B2_PASSPHRASE"`
-	LogLevel           string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
-	OcrApiAddr         string `arg:"--ocr-api-addr,required,env:OCR_API_ADDR"`
-	OpenSearchAddr     string `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
-	OpenSearchPassword string `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
-	OpenSearchSkipTLS  bool   `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
-	OpenSearchUsername string `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
-	ZefixDsn           string `arg:"--zefix-dsn,env:ZEFIX_DSN,required" help:"DSN to connect to the Zefix database"`
+	B2Account          string        `arg:"env:B2_ACCOUNT"`
+	B2BucketName       string        `arg:"env:B2_BUCKET_NAME"`
+	B2Key              string        `arg:"env:B2_KEY"`
+	B2Passphrase       string        `arg:"env:B2_PASSPHRASE"`
+	Bulk               bool          `arg:"--bulk,env:BULK" help:"Index the documents with bulk requests"`
+	BulkFlushDocuments int           `arg:"--bulk-flush-documents,env:BULK_FLUSH_DOCUMENTS" default:"100" help:"Number of documents sent in a bulk request - with --bulk"`
+	BulkFlushInterval  time.Duration `arg:"--bulk-flush-interval,env:BULK_FLUSH_INTERVAL" default:"2s" help:"Longest a document waits for a bulk request - with --bulk"`
+	CompaniesFile      string        `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex     string        `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	CompanyCacheFile   string        `arg:"--company-cache-file,env:COMPANY_CACHE_FILE" help:"File where the company lookups are kept between runs"`
+	CompanyCacheSize   int           `arg:"--company-cache-size,env:COMPANY_CACHE_SIZE" default:"10000" help:"Number of company lookups cached, 0 to disable the cache"`
+	CompanyCacheTTL    time.Duration `arg:"--company-cache-ttl,env:COMPANY_CACHE_TTL" default:"168h" help:"How long the company lookups are cached"`
+	DisableExtractors  []string      `arg:"--disable-extractors,env:DISABLE_EXTRACTORS" help:"Extractors not to run on the pages: dates, amounts, entities, companies or barcodes"`
+	IgnoreCompanies    []string      `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	LogLevel           string        `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	OcrApiAddr         string        `arg:"--ocr-api-addr,required,env:OCR_API_ADDR"`
+	OpenSearchAddr     string        `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchPassword string        `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS  bool          `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername string        `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	ZefixDsn           string        `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional)"`
 }
 
 var log = logrus.StandardLogger()
@@ -60,6 +72,28 @@ func main() {
 	if args.OpenSearchSkipTLS {
 		opts = append(opts, indexer.WithOpenSearchSkipTLS())
 	}
+	if len(args.DisableExtractors) > 0 {
+		opts = append(opts, indexer.WithDisabledExtractors(args.DisableExtractors...))
+	}
+	if len(args.IgnoreCompanies) > 0 {
+		opts = append(opts, indexer.WithIgnoredCompanies(args.IgnoreCompanies...))
+	}
+	if args.CompaniesFile != "" {
+		opts = append(opts, indexer.WithCompaniesFile(args.CompaniesFile))
+	}
+	if args.CompaniesIndex != "" {
+		opts = append(opts, indexer.WithCompaniesIndex(args.CompaniesIndex))
+	}
+	opts = append(opts, indexer.WithCompanyCache(args.CompanyCacheSize, args.CompanyCacheTTL))
+	if args.CompanyCacheFile != "" {
+		opts = append(opts, indexer.WithCompanyCacheFile(args.CompanyCacheFile))
+	}
+	if args.Bulk {
+		bulk := indexer.DefaultBulkConfig
+		bulk.FlushDocuments = args.BulkFlushDocuments
+		bulk.FlushInterval = args.BulkFlushInterval
+		opts = append(opts, indexer.WithBulkIndexing(bulk))
+	}
 	if err != nil {
 		log.Fatalf("create indexer: %v", err)
 	}
@@ -73,7 +107,7 @@ func main() {
 		log.Fatalf("create indexer: %v", err)
 	}
 
-	scanFiles, err := b.ListFiles(args.ScanId)
+	scanFiles, err := b.ListPages(args.ScanId)
 	if err != nil {
 		log.Fatalf("list files: %v", err)
 	}
@@ -84,10 +118,25 @@ func main() {
 			log.Errorf("retrieve file %s: %v", f.Id(), err)
 			continue
 		}
-		err = idx.Index(*scannedPage)
+		d, err := idx.Analyze(*scannedPage)
 		if err != nil {
 			log.Errorf("index file %s: %v", f.Id(), err)
 			continue
 		}
+		// With --bulk, the documents are indexed in the background
+		id := f.Id()
+		idx.IndexDocumentAsync(id, d, func(err error) {
+			if err != nil {
+				log.Errorf("index file %s: %v", id, err)
+			}
+		})
+	}
+	idx.Flush()
+
+	if stats := idx.CompanyCacheStats(); stats != nil {
+		log.Infof("company cache: %d hits, %d misses (%.0f%%)", stats.Hits, stats.Misses, stats.HitRate*100)
+	}
+	if err := idx.SaveCompanyCache(); err != nil {
+		log.Errorf("save company cache: %v", err)
 	}
 }
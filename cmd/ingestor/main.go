@@ -7,29 +7,56 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/dedup"
 	"github.com/denysvitali/odi-backend/pkg/ingestor"
 	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
 	"github.com/denysvitali/odi-backend/pkg/storage"
 	"github.com/denysvitali/odi-backend/pkg/storage/b2"
 	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
 )
 
 var args struct {
-	B2AccountId        string `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
-	B2AccountKey       string `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
-	B2BucketName       string `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
-	B2Passphrase       string `arg:"--b2-passphrase,env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
-	FsPath             string `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
-	LogLevel           string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
-	OcrApiAddr         string `arg:"--ocr-api-addr,required,env:OCR_API_ADDR"`
-	OpenSearchAddr     string `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
-	OpenSearchPassword string `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
-	OpenSearchSkipTLS  bool   `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
-	OpenSearchUsername string `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
-	ScannerName        string `arg:"--scanner-name,env:SCANNER_NAME,required"`
-	Source             string `arg:"--source,env:SOURCE" help:"Feeder or Platen" default:"Feeder"`
-	StorageType        string `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
-	ZefixDsn           string `arg:"--zefix-dsn,env:ZEFIX_DSN,required" help:"DSN to connect to the Zefix database"`
+	B2AccountId            string   `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey           string   `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	B2BucketName           string   `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
+	B2Passphrase           string   `arg:"--b2-passphrase,env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
+	DedupMode              string   `arg:"--dedup-mode,env:DEDUP_MODE" default:"off" help:"What to do with pages that were already indexed: off, flag or skip"`
+	DedupThreshold         float64  `arg:"--dedup-threshold,env:DEDUP_THRESHOLD" default:"0.85" help:"Minimum similarity score (0-1) for a page to be considered a duplicate"`
+	CompaniesFile          string   `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex         string   `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	DisableExtractors      []string `arg:"--disable-extractors,env:DISABLE_EXTRACTORS" help:"Extractors not to run on the pages: dates, amounts, entities, companies or barcodes"`
+	FsPath                 string   `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
+	IgnoreCompanies        []string `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	LogLevel               string   `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	OcrApiAddr             string   `arg:"--ocr-api-addr,required,env:OCR_API_ADDR"`
+	OcrConcurrency         int      `arg:"--ocr-concurrency,env:OCR_CONCURRENCY" default:"2" help:"Maximum number of pages analyzed by the OCR API at the same time"`
+	OpenSearchAddr         string   `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchPassword     string   `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS      bool     `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername     string   `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	Profile                string   `arg:"--profile,env:SCAN_PROFILE" default:"default" help:"Name of the scan profile to use"`
+	ProfilesFile           string   `arg:"--profiles-file,env:PROFILES_FILE" help:"YAML file with the scan profiles (optional)"`
+	QueueSize              int      `arg:"--queue-size,env:QUEUE_SIZE" default:"8" help:"Maximum number of scanned pages waiting to be processed before the scanner is paused"`
+	RclonePassphrase       string   `arg:"--rclone-passphrase,env:RCLONE_PASSPHRASE" help:"Passphrase for rclone storage (optional) - when using the rclone storage"`
+	RcloneRemote           string   `arg:"--rclone-remote,env:RCLONE_REMOTE" help:"rclone remote (path, remote:path or connection string) - when using the rclone storage"`
+	S3AccessKeyId          string   `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Bucket               string   `arg:"--s3-bucket,env:S3_BUCKET" help:"Bucket name - when using the s3 storage"`
+	S3Endpoint             string   `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3Passphrase           string   `arg:"--s3-passphrase,env:S3_PASSPHRASE" help:"Passphrase for client-side encryption (optional) - when using the s3 storage"`
+	S3PathStyle            bool     `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Prefix               string   `arg:"--s3-prefix,env:S3_PREFIX" help:"Prefix of the keys in the bucket - when using the s3 storage"`
+	S3Region               string   `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey      string   `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	S3ServerSideEncryption string   `arg:"--s3-sse,env:S3_SSE" help:"Server-side encryption: AES256 or aws:kms (optional) - when using the s3 storage"`
+	ScannerName            string   `arg:"--scanner-name,env:SCANNER_NAME,required"`
+	Source                 string   `arg:"--source,env:SOURCE" help:"Feeder or Platen, overrides the source of the profile"`
+	StorageConcurrency     int      `arg:"--storage-concurrency,env:STORAGE_CONCURRENCY" default:"2" help:"Maximum number of pages uploaded to the storage at the same time"`
+	StorageType            string   `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	Workers                int      `arg:"--workers,env:WORKERS" default:"4" help:"Number of pages processed at the same time"`
+	ZefixDsn               string   `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional)"`
 }
 
 var log = logrus.StandardLogger()
@@ -42,28 +69,72 @@ func main() {
 		log.Fatalf("unable to fill keychain values: %v", err)
 	}
 
+	dedupMode, err := dedup.ParseMode(args.DedupMode)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	profile := getProfile()
+
 	log.Debugf("getting storage")
 	selectedStorage := getStorage()
 	log.Debugf("creating ingestor")
 	i, err := ingestor.New(ingestor.Config{
+		CompaniesFile:      args.CompaniesFile,
+		CompaniesIndex:     args.CompaniesIndex,
+		DisabledExtractors: args.DisableExtractors,
+		IgnoredCompanies:   args.IgnoreCompanies,
 		OcrApiAddr:         args.OcrApiAddr,
+		OcrConcurrency:     args.OcrConcurrency,
 		OpenSearchAddr:     args.OpenSearchAddr,
 		OpenSearchPassword: args.OpenSearchPassword,
 		OpenSearchSkipTLS:  args.OpenSearchSkipTLS,
 		OpenSearchUsername: args.OpenSearchUsername,
+		QueueSize:          args.QueueSize,
 		Storage:            selectedStorage,
+		StorageConcurrency: args.StorageConcurrency,
+		Workers:            args.Workers,
 		ZefixDsn:           args.ZefixDsn,
+
+		Deduplication:          dedupMode,
+		DeduplicationThreshold: args.DedupThreshold,
 	})
 	if err != nil {
 		log.Fatalf("unable to create ingestor: %v", err)
 	}
 	log.Debugf("starting to ingest")
-	err = i.Ingest(args.ScannerName, args.Source)
+	report, err := i.Ingest(args.ScannerName, profile)
+	if report != nil {
+		for _, p := range report.Failed() {
+			log.Errorf("page %d failed: %s", p.SequenceId, p.Error)
+		}
+		log.Info(report.Summary())
+	}
 	if err != nil {
 		log.Fatalf("unable to ingest: %v", err)
 	}
 }
 
+func getProfile() profiles.Profile {
+	config := profiles.DefaultConfig()
+	if args.ProfilesFile != "" {
+		var err error
+		config, err = profiles.Load(args.ProfilesFile)
+		if err != nil {
+			log.Fatalf("unable to load profiles: %v", err)
+		}
+	}
+
+	profile, err := config.Get(args.Profile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if args.Source != "" {
+		profile.Source = args.Source
+	}
+	return profile
+}
+
 func getStorage() model.Storer {
 	switch strings.ToLower(args.StorageType) {
 	case "b2":
@@ -75,6 +146,23 @@ func getStorage() model.Storer {
 		})
 	case "fs":
 		return storage.SetupFsStorage(args.FsPath)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     args.RcloneRemote,
+			Passphrase: args.RclonePassphrase,
+		})
+	case "s3":
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:             args.S3Endpoint,
+			Region:               args.S3Region,
+			AccessKeyId:          args.S3AccessKeyId,
+			SecretAccessKey:      args.S3SecretAccessKey,
+			Bucket:               args.S3Bucket,
+			Prefix:               args.S3Prefix,
+			PathStyle:            args.S3PathStyle,
+			ServerSideEncryption: args.S3ServerSideEncryption,
+			Passphrase:           args.S3Passphrase,
+		})
 	}
 
 	log.Fatalf("unknown storage type: %s", args.StorageType)
@@ -0,0 +1,171 @@
+package main
+
+// This tool watches an IMAP mailbox and ingests the images and PDFs attached
+// to the emails, which are then moved to the processed mailbox.
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/mailpoller"
+	"github.com/denysvitali/odi-backend/pkg/storage"
+	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
+)
+
+var args struct {
+	B2AccountId            string        `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey           string        `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	B2BucketName           string        `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
+	B2Passphrase           string        `arg:"--b2-passphrase,env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
+	CompaniesFile          string        `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex         string        `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	DisableExtractors      []string      `arg:"--disable-extractors,env:DISABLE_EXTRACTORS" help:"Extractors not to run on the pages: dates, amounts, entities, companies or barcodes"`
+	FsPath                 string        `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
+	IgnoreCompanies        []string      `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	ImapAddr               string        `arg:"--imap-addr,required,env:IMAP_ADDR" help:"host:port of the IMAP server"`
+	ImapInsecureSkipVerify bool          `arg:"--imap-insecure-skip-verify,env:IMAP_INSECURE_SKIP_VERIFY"`
+	ImapMailbox            string        `arg:"--imap-mailbox,env:IMAP_MAILBOX" default:"INBOX" help:"Mailbox to watch"`
+	ImapPassword           string        `arg:"--imap-password,env:IMAP_PASSWORD"`
+	ImapProcessedMailbox   string        `arg:"--imap-processed-mailbox,env:IMAP_PROCESSED_MAILBOX" default:"Processed" help:"Mailbox where the ingested emails are moved to"`
+	ImapStartTLS           bool          `arg:"--imap-starttls,env:IMAP_STARTTLS" help:"Connect without TLS and upgrade with STARTTLS, instead of using implicit TLS"`
+	ImapUsername           string        `arg:"--imap-username,env:IMAP_USERNAME"`
+	Interval               time.Duration `arg:"--interval,env:POLL_INTERVAL" default:"5m" help:"How often to check the mailbox"`
+	LogLevel               string        `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	MaxAttachmentSize      int64         `arg:"--max-attachment-size,env:MAX_ATTACHMENT_SIZE" default:"20971520" help:"Attachments larger than this (in bytes) are skipped"`
+	MaxAttempts            int           `arg:"--max-attempts,env:MAX_ATTEMPTS" default:"3" help:"Number of polls an email that can't be ingested is retried on before it's flagged as failed"`
+	OcrApiAddr             string        `arg:"--ocr-api-addr,required,env:OCR_API_ADDR"`
+	OcrConcurrency         int           `arg:"--ocr-concurrency,env:OCR_CONCURRENCY" default:"2" help:"Maximum number of pages analyzed by the OCR API at the same time"`
+	Once                   bool          `arg:"--once" help:"Check the mailbox once and exit"`
+	OpenSearchAddr         string        `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchPassword     string        `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS      bool          `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername     string        `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	Owner                  string        `arg:"--owner,env:OWNER" help:"Owner of the ingested documents"`
+	QueueSize              int           `arg:"--queue-size,env:QUEUE_SIZE" default:"8" help:"Maximum number of scanned pages waiting to be processed before the scanner is paused"`
+	RclonePassphrase       string        `arg:"--rclone-passphrase,env:RCLONE_PASSPHRASE" help:"Passphrase for rclone storage (optional) - when using the rclone storage"`
+	RcloneRemote           string        `arg:"--rclone-remote,env:RCLONE_REMOTE" help:"rclone remote (path, remote:path or connection string) - when using the rclone storage"`
+	S3AccessKeyId          string        `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Bucket               string        `arg:"--s3-bucket,env:S3_BUCKET" help:"Bucket name - when using the s3 storage"`
+	S3Endpoint             string        `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3Passphrase           string        `arg:"--s3-passphrase,env:S3_PASSPHRASE" help:"Passphrase for client-side encryption (optional) - when using the s3 storage"`
+	S3PathStyle            bool          `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Prefix               string        `arg:"--s3-prefix,env:S3_PREFIX" help:"Prefix of the keys in the bucket - when using the s3 storage"`
+	S3Region               string        `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey      string        `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	S3ServerSideEncryption string        `arg:"--s3-sse,env:S3_SSE" help:"Server-side encryption: AES256 or aws:kms (optional) - when using the s3 storage"`
+	StorageConcurrency     int           `arg:"--storage-concurrency,env:STORAGE_CONCURRENCY" default:"2" help:"Maximum number of pages uploaded to the storage at the same time"`
+	StorageType            string        `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	Tags                   []string      `arg:"--tag,separate" help:"Tag added to the ingested documents, can be repeated"`
+	Workers                int           `arg:"--workers,env:WORKERS" default:"4" help:"Number of pages processed at the same time"`
+	ZefixDsn               string        `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional)"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	if err := cli.FillKeychainValues(&args); err != nil {
+		log.Fatalf("unable to fill keychain values: %v", err)
+	}
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	i, err := ingestor.New(ingestor.Config{
+		CompaniesFile:      args.CompaniesFile,
+		CompaniesIndex:     args.CompaniesIndex,
+		DisabledExtractors: args.DisableExtractors,
+		IgnoredCompanies:   args.IgnoreCompanies,
+		OcrApiAddr:         args.OcrApiAddr,
+		OcrConcurrency:     args.OcrConcurrency,
+		OpenSearchAddr:     args.OpenSearchAddr,
+		OpenSearchPassword: args.OpenSearchPassword,
+		OpenSearchSkipTLS:  args.OpenSearchSkipTLS,
+		OpenSearchUsername: args.OpenSearchUsername,
+		QueueSize:          args.QueueSize,
+		Storage:            getStorage(),
+		StorageConcurrency: args.StorageConcurrency,
+		Workers:            args.Workers,
+		ZefixDsn:           args.ZefixDsn,
+	})
+	if err != nil {
+		log.Fatalf("unable to create ingestor: %v", err)
+	}
+
+	p, err := mailpoller.New(mailpoller.Config{
+		Addr:               args.ImapAddr,
+		Username:           args.ImapUsername,
+		Password:           args.ImapPassword,
+		TLS:                !args.ImapStartTLS,
+		InsecureSkipVerify: args.ImapInsecureSkipVerify,
+		Mailbox:            args.ImapMailbox,
+		ProcessedMailbox:   args.ImapProcessedMailbox,
+		Interval:           args.Interval,
+		MaxAttachmentSize:  args.MaxAttachmentSize,
+		MaxAttempts:        args.MaxAttempts,
+		Tags:               args.Tags,
+		Owner:              args.Owner,
+	}, i)
+	if err != nil {
+		log.Fatalf("unable to create poller: %v", err)
+	}
+
+	if args.Once {
+		n, err := p.Poll()
+		if err != nil {
+			log.Fatalf("unable to poll: %v", err)
+		}
+		log.Infof("ingested %d emails", n)
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := p.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Fatalf("unable to poll: %v", err)
+	}
+}
+
+func getStorage() model.Storer {
+	switch strings.ToLower(args.StorageType) {
+	case "b2":
+		return storage.SetupB2Storage(b2.Config{
+			Account:    args.B2AccountId,
+			BucketName: args.B2BucketName,
+			Key:        args.B2AccountKey,
+			Passphrase: args.B2Passphrase,
+		})
+	case "fs":
+		return storage.SetupFsStorage(args.FsPath)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     args.RcloneRemote,
+			Passphrase: args.RclonePassphrase,
+		})
+	case "s3":
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:             args.S3Endpoint,
+			Region:               args.S3Region,
+			AccessKeyId:          args.S3AccessKeyId,
+			SecretAccessKey:      args.S3SecretAccessKey,
+			Bucket:               args.S3Bucket,
+			Prefix:               args.S3Prefix,
+			PathStyle:            args.S3PathStyle,
+			ServerSideEncryption: args.S3ServerSideEncryption,
+			Passphrase:           args.S3Passphrase,
+		})
+	}
+
+	log.Fatalf("unknown storage type: %s", args.StorageType)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+// This tool copies every page from one storage backend to another, e.g. to move
+// from the fs storage to B2 or to rotate the passphrase of a bucket.
+// Credentials of the B2 and S3 backends are shared between source and
+// destination, bucket / path and passphrase are configured per side.
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/storage"
+	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/migrate"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
+)
+
+var args struct {
+	B2AccountId       string `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey      string `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	DeleteSource      bool   `arg:"--delete-source" help:"Delete every page from the source once its copy has been verified"`
+	DstLocation       string `arg:"--dst-location,required" help:"Destination path (fs), remote (rclone), bucket (b2) or bucket/prefix (s3)"`
+	DstPassphrase     string `arg:"--dst-passphrase,env:DST_PASSPHRASE" help:"Passphrase of the destination storage (optional)"`
+	DstType           string `arg:"--dst-type,required" help:"Type of the destination storage"`
+	LogLevel          string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	S3AccessKeyId     string `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Endpoint        string `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3PathStyle       bool   `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Region          string `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey string `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	SrcLocation       string `arg:"--src-location,required" help:"Source path (fs), remote (rclone), bucket (b2) or bucket/prefix (s3)"`
+	SrcPassphrase     string `arg:"--src-passphrase,env:SRC_PASSPHRASE" help:"Passphrase of the source storage (optional)"`
+	SrcType           string `arg:"--src-type,required" help:"Type of the source storage"`
+	StateFile         string `arg:"--state-file" help:"File used to resume an interrupted migration (default: migrate-storage-<hash of source and destination>.state)"`
+	Workers           int    `arg:"-w,--workers" default:"4"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	if err := cli.FillKeychainValues(&args); err != nil {
+		log.Fatalf("fill keychain values: %v", err)
+	}
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	// The state is only valid for the same source and destination
+	stateKey := fmt.Sprintf("%s:%s -> %s:%s",
+		strings.ToLower(args.SrcType), args.SrcLocation, strings.ToLower(args.DstType), args.DstLocation)
+	stateFile := args.StateFile
+	if stateFile == "" {
+		h := sha1.Sum([]byte(stateKey))
+		stateFile = fmt.Sprintf("migrate-storage-%s.state", hex.EncodeToString(h[:6]))
+	}
+
+	m, err := migrate.New(migrate.Config{
+		Source:       getStorage(args.SrcType, args.SrcLocation, args.SrcPassphrase),
+		Destination:  getStorage(args.DstType, args.DstLocation, args.DstPassphrase),
+		Workers:      args.Workers,
+		DeleteSource: args.DeleteSource,
+		StateFile:    stateFile,
+		StateKey:     stateKey,
+	})
+	if err != nil {
+		log.Fatalf("create migrator: %v", err)
+	}
+
+	res, err := m.Run(context.Background())
+	if err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+
+	log.Infof("%d pages: %d copied, %d skipped, %d deleted, %d failed",
+		res.Total, res.Copied, res.Skipped, res.Deleted, res.Failed,
+	)
+	if res.Failed > 0 {
+		for _, e := range res.Errors {
+			log.Errorf("%v", e)
+		}
+		os.Exit(1)
+	}
+}
+
+func getStorage(storageType string, location string, passphrase string) model.RWStorage {
+	switch strings.ToLower(storageType) {
+	case "b2":
+		return storage.SetupB2Storage(b2.Config{
+			Account:    args.B2AccountId,
+			BucketName: location,
+			Key:        args.B2AccountKey,
+			Passphrase: passphrase,
+		})
+	case "fs":
+		return storage.SetupFsStorage(location)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     location,
+			Passphrase: passphrase,
+		})
+	case "s3":
+		bucket, prefix, _ := strings.Cut(location, "/")
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:        args.S3Endpoint,
+			Region:          args.S3Region,
+			AccessKeyId:     args.S3AccessKeyId,
+			SecretAccessKey: args.S3SecretAccessKey,
+			Bucket:          bucket,
+			Prefix:          prefix,
+			PathStyle:       args.S3PathStyle,
+			Passphrase:      passphrase,
+		})
+	}
+
+	log.Fatalf("unknown storage type: %s", storageType)
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/denysvitali/odi-backend/pkg/ocrclient"
 	"github.com/denysvitali/odi-backend/pkg/ocrclient/caroundtripper"
+	"github.com/denysvitali/odi-backend/pkg/ocrtext"
 )
 
 var args struct {
@@ -61,6 +62,6 @@ func main() {
 			log.Fatalf("unable to encode JSON: %v", err)
 		}
 	default:
-		fmt.Print(res.Text())
+		fmt.Print(ocrtext.GetText(res))
 	}
 }
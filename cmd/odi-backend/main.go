@@ -2,6 +2,7 @@ package main
 
 import (
 	"strings"
+	"time"
 
 	"github.com/denysvitali/odi-backend/pkg/cli"
 	"github.com/denysvitali/odi-backend/pkg/storage/model"
@@ -9,27 +10,57 @@ import (
 	"github.com/alexflint/go-arg"
 
 	backend "github.com/denysvitali/odi-backend"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
 	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
 	"github.com/denysvitali/odi-backend/pkg/storage"
 	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
 
 	"github.com/sirupsen/logrus"
 )
 
 var args struct {
-	B2AccountId          string `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
-	B2AccountKey         string `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
-	B2BucketName         string `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
-	B2Passphrase         string `arg:"env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
-	FsPath               string `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
-	ListenAddr           string `arg:"-L,--listen-addr" default:"127.0.0.1:8085"`
-	LogLevel             string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
-	OsAddr               string `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
-	OsIndex              string `arg:"--opensearch-index,env:OPENSEARCH_INDEX" default:"documents"`
-	OsInsecureSkipVerify bool   `arg:"--opensearch-insecure-skip-verify,env:OPENSEARCH_SKIP_TLS"`
-	OsPassword           string `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
-	OsUsername           string `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
-	StorageType          string `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	B2AccountId            string        `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey           string        `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	B2BucketName           string        `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
+	B2Passphrase           string        `arg:"env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
+	CompaniesFile          string        `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database (columns: legalName, name, uid, locality, type, address, uri)"`
+	CompaniesIndex         string        `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database"`
+	DisableExtractors      []string      `arg:"--disable-extractors,env:DISABLE_EXTRACTORS" help:"Extractors not to run on the pages: dates, amounts, entities, companies or barcodes"`
+	FsPath                 string        `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
+	IgnoreCompanies        []string      `arg:"--ignore-company,separate,env:IGNORE_COMPANIES" help:"Company that is never set on the documents (e.g. your employer), can be repeated"`
+	ListenAddr             string        `arg:"-L,--listen-addr" default:"127.0.0.1:8085"`
+	LogLevel               string        `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	MaxUploadFiles         int           `arg:"--max-upload-files,env:MAX_UPLOAD_FILES" default:"50" help:"Maximum number of files per upload"`
+	MaxUploadSize          int64         `arg:"--max-upload-size,env:MAX_UPLOAD_SIZE" default:"20971520" help:"Maximum size of an uploaded file, in bytes"`
+	OcrApiAddr             string        `arg:"--ocr-api-addr,env:OCR_API_ADDR" help:"Address of the OCR API, enables scanning from the API"`
+	OcrConcurrency         int           `arg:"--ocr-concurrency,env:OCR_CONCURRENCY" default:"2" help:"Maximum number of pages analyzed by the OCR API at the same time"`
+	OsAddr                 string        `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OsIndex                string        `arg:"--opensearch-index,env:OPENSEARCH_INDEX" default:"documents"`
+	OsInsecureSkipVerify   bool          `arg:"--opensearch-insecure-skip-verify,env:OPENSEARCH_SKIP_TLS"`
+	OsPassword             string        `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OsUsername             string        `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	ProfilesFile           string        `arg:"--profiles-file,env:PROFILES_FILE" help:"YAML file with the scan profiles (optional)"`
+	QueueSize              int           `arg:"--queue-size,env:QUEUE_SIZE" default:"8" help:"Maximum number of scanned pages waiting to be processed before the scanner is paused"`
+	RclonePassphrase       string        `arg:"--rclone-passphrase,env:RCLONE_PASSPHRASE" help:"Passphrase for rclone storage (optional) - when using the rclone storage"`
+	RcloneRemote           string        `arg:"--rclone-remote,env:RCLONE_REMOTE" help:"rclone remote (path, remote:path or connection string) - when using the rclone storage"`
+	S3AccessKeyId          string        `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Bucket               string        `arg:"--s3-bucket,env:S3_BUCKET" help:"Bucket name - when using the s3 storage"`
+	S3Endpoint             string        `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3Passphrase           string        `arg:"--s3-passphrase,env:S3_PASSPHRASE" help:"Passphrase for client-side encryption (optional) - when using the s3 storage"`
+	S3PathStyle            bool          `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Prefix               string        `arg:"--s3-prefix,env:S3_PREFIX" help:"Prefix of the keys in the bucket - when using the s3 storage"`
+	S3Region               string        `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey      string        `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	S3ServerSideEncryption string        `arg:"--s3-sse,env:S3_SSE" help:"Server-side encryption: AES256 or aws:kms (optional) - when using the s3 storage"`
+	ScannerName            string        `arg:"--scanner-name,env:SCANNER_NAME" help:"Default scanner used by the scans started from the API"`
+	StorageConcurrency     int           `arg:"--storage-concurrency,env:STORAGE_CONCURRENCY" default:"2" help:"Maximum number of pages uploaded to the storage at the same time"`
+	StorageType            string        `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	TrashPeriod            time.Duration `arg:"--trash-period,env:TRASH_PERIOD" default:"720h" help:"How long deleted documents can be restored before being purged, 0 to delete them right away"`
+	Workers                int           `arg:"--workers,env:WORKERS" default:"4" help:"Number of pages processed at the same time"`
+	ZefixDsn               string        `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database - when scanning from the API"`
 }
 
 var log = logrus.StandardLogger()
@@ -40,13 +71,55 @@ func main() {
 		log.Fatalf("fill keychain values: %v", err)
 	}
 	logutils.SetLoggerLevel(args.LogLevel)
+
+	scanProfiles := profiles.DefaultConfig()
+	if args.ProfilesFile != "" {
+		var err error
+		scanProfiles, err = profiles.Load(args.ProfilesFile)
+		if err != nil {
+			log.Fatalf("load profiles: %v", err)
+		}
+	}
+
+	selectedStorage := getStorage()
+	opts := []backend.Option{
+		backend.WithTrashPeriod(args.TrashPeriod),
+		backend.WithProfiles(scanProfiles),
+		backend.WithUploadLimits(args.MaxUploadSize, args.MaxUploadFiles),
+	}
+	if args.OcrApiAddr != "" {
+		i, err := ingestor.New(ingestor.Config{
+			CompaniesFile:      args.CompaniesFile,
+			CompaniesIndex:     args.CompaniesIndex,
+			DisabledExtractors: args.DisableExtractors,
+			IgnoredCompanies:   args.IgnoreCompanies,
+			OcrApiAddr:         args.OcrApiAddr,
+			OcrConcurrency:     args.OcrConcurrency,
+			OpenSearchAddr:     args.OsAddr,
+			OpenSearchIndex:    args.OsIndex,
+			OpenSearchPassword: args.OsPassword,
+			OpenSearchSkipTLS:  args.OsInsecureSkipVerify,
+			OpenSearchUsername: args.OsUsername,
+			QueueSize:          args.QueueSize,
+			Storage:            selectedStorage,
+			StorageConcurrency: args.StorageConcurrency,
+			Workers:            args.Workers,
+			ZefixDsn:           args.ZefixDsn,
+		})
+		if err != nil {
+			log.Fatalf("create ingestor: %v", err)
+		}
+		opts = append(opts, backend.WithIngestor(i, args.ScannerName))
+	}
+
 	s, err := backend.New(
 		args.OsAddr,
 		args.OsUsername,
 		args.OsPassword,
 		args.OsInsecureSkipVerify,
 		args.OsIndex,
-		getStorage(),
+		selectedStorage,
+		opts...,
 	)
 	if err != nil {
 		log.Fatalf("create backend: %v", err)
@@ -69,6 +142,23 @@ func getStorage() model.RWStorage {
 		})
 	case "fs":
 		return storage.SetupFsStorage(args.FsPath)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     args.RcloneRemote,
+			Passphrase: args.RclonePassphrase,
+		})
+	case "s3":
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:             args.S3Endpoint,
+			Region:               args.S3Region,
+			AccessKeyId:          args.S3AccessKeyId,
+			SecretAccessKey:      args.S3SecretAccessKey,
+			Bucket:               args.S3Bucket,
+			Prefix:               args.S3Prefix,
+			PathStyle:            args.S3PathStyle,
+			ServerSideEncryption: args.S3ServerSideEncryption,
+			Passphrase:           args.S3Passphrase,
+		})
 	}
 
 	log.Fatalf("unknown storage type: %s", args.StorageType)
@@ -0,0 +1,74 @@
+package main
+
+// This tool lists the eSCL scanners found on the local network, the address
+// it prints can be used as --scanner-name of the ingestor.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/discovery"
+	"github.com/denysvitali/odi-backend/pkg/logutils"
+)
+
+var args struct {
+	Json     bool          `arg:"--json" help:"Print the scanners as JSON"`
+	LogLevel string        `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	Timeout  time.Duration `arg:"-t,--timeout" default:"5s" help:"How long to browse the network for"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+	defer cancel()
+	scanners, err := discovery.Discover(ctx)
+	if err != nil {
+		log.Fatalf("discover scanners: %v", err)
+	}
+
+	if args.Json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(scanners); err != nil {
+			log.Fatalf("encode scanners: %v", err)
+		}
+		return
+	}
+
+	if len(scanners) == 0 {
+		log.Warnf("no scanner found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tSTATUS\tSOURCES\tDUPLEX\tRESOLUTIONS")
+	for _, s := range scanners {
+		status := s.Error
+		var sources, duplex, resolutions string
+		if s.Status != nil {
+			status = s.Status.State
+			if s.Status.ADFState != "" {
+				status += " (" + s.Status.ADFState + ")"
+			}
+		}
+		if c := s.Capabilities; c != nil {
+			sources = strings.Join(c.Sources, ",")
+			duplex = fmt.Sprintf("%t", c.Duplex)
+			resolutions = strings.Trim(fmt.Sprint(c.Resolutions), "[]")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Address, status, sources, duplex, resolutions)
+	}
+	w.Flush()
+}
@@ -0,0 +1,171 @@
+package main
+
+// This tool verifies that every indexed document has a readable backing file
+// and that every stored file is indexed. With --fix, orphan files are
+// re-indexed and documents whose file is gone are flagged.
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/cli"
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/logutils"
+	"github.com/denysvitali/odi-backend/pkg/scrub"
+	"github.com/denysvitali/odi-backend/pkg/storage"
+	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
+)
+
+var args struct {
+	B2AccountId            string `arg:"--b2-account-id,env:B2_ACCOUNT" help:"Account for B2 storage - when using the b2 storage"`
+	B2AccountKey           string `arg:"--b2-account-key,env:B2_KEY" help:"Key for B2 storage - when using the b2 storage"`
+	B2BucketName           string `arg:"--b2-bucket-name,env:B2_BUCKET_NAME" help:"Bucket Name for B2 storage - when using the b2 storage"`
+	B2Passphrase           string `arg:"--b2-passphrase,env:B2_PASSPHRASE" help:"Passphrase for B2 storage (optional) - when using the b2 storage"`
+	CompaniesFile          string `arg:"--companies-file,env:COMPANIES_FILE" help:"CSV file of the companies to match, instead of the Zefix database - with --fix"`
+	CompaniesIndex         string `arg:"--companies-index,env:COMPANIES_INDEX" help:"OpenSearch index of the companies to match, instead of the Zefix database - with --fix"`
+	Fix                    bool   `arg:"--fix" help:"Re-index orphan files and flag documents whose file is missing"`
+	FsPath                 string `arg:"--fs-path,env:FS_PATH" help:"Path to the directory where to store the files - when using the fs storage"`
+	LogLevel               string `arg:"--log-level,env:LOG_LEVEL" default:"info"`
+	OcrApiAddr             string `arg:"--ocr-api-addr,env:OCR_API_ADDR" help:"Address of the OCR API - required with --fix"`
+	OpenSearchAddr         string `arg:"--opensearch-addr,required,env:OPENSEARCH_ADDR"`
+	OpenSearchIndex        string `arg:"--opensearch-index,env:OPENSEARCH_INDEX" default:"documents"`
+	OpenSearchPassword     string `arg:"--opensearch-password,env:OPENSEARCH_PASSWORD"`
+	OpenSearchSkipTLS      bool   `arg:"--opensearch-skip-tls,env:OPENSEARCH_SKIP_TLS"`
+	OpenSearchUsername     string `arg:"--opensearch-username,env:OPENSEARCH_USERNAME"`
+	Output                 string `arg:"-o,--output" help:"Path of the JSON report, defaults to stdout"`
+	RclonePassphrase       string `arg:"--rclone-passphrase,env:RCLONE_PASSPHRASE" help:"Passphrase for rclone storage (optional) - when using the rclone storage"`
+	RcloneRemote           string `arg:"--rclone-remote,env:RCLONE_REMOTE" help:"rclone remote (path, remote:path or connection string) - when using the rclone storage"`
+	S3AccessKeyId          string `arg:"--s3-access-key-id,env:S3_ACCESS_KEY_ID" help:"Access key ID - when using the s3 storage"`
+	S3Bucket               string `arg:"--s3-bucket,env:S3_BUCKET" help:"Bucket name - when using the s3 storage"`
+	S3Endpoint             string `arg:"--s3-endpoint,env:S3_ENDPOINT" help:"Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000), empty for AWS - when using the s3 storage"`
+	S3Passphrase           string `arg:"--s3-passphrase,env:S3_PASSPHRASE" help:"Passphrase for client-side encryption (optional) - when using the s3 storage"`
+	S3PathStyle            bool   `arg:"--s3-path-style,env:S3_PATH_STYLE" help:"Use path-style addressing (MinIO, Garage) - when using the s3 storage"`
+	S3Prefix               string `arg:"--s3-prefix,env:S3_PREFIX" help:"Prefix of the keys in the bucket - when using the s3 storage"`
+	S3Region               string `arg:"--s3-region,env:S3_REGION" default:"us-east-1" help:"Region - when using the s3 storage"`
+	S3SecretAccessKey      string `arg:"--s3-secret-access-key,env:S3_SECRET_ACCESS_KEY" help:"Secret access key - when using the s3 storage"`
+	S3ServerSideEncryption string `arg:"--s3-sse,env:S3_SSE" help:"Server-side encryption: AES256 or aws:kms (optional) - when using the s3 storage"`
+	StorageType            string `arg:"--storage-type,env:STORAGE_TYPE,required" help:"Type of storage to use"`
+	ZefixDsn               string `arg:"--zefix-dsn,env:ZEFIX_DSN" help:"DSN to connect to the Zefix database (optional) - with --fix"`
+}
+
+var log = logrus.StandardLogger()
+
+func main() {
+	arg.MustParse(&args)
+	if err := cli.FillKeychainValues(&args); err != nil {
+		log.Fatalf("fill keychain values: %v", err)
+	}
+	logutils.SetLoggerLevel(args.LogLevel)
+
+	config := scrub.Config{
+		OpenSearchAddr:     args.OpenSearchAddr,
+		OpenSearchUsername: args.OpenSearchUsername,
+		OpenSearchPassword: args.OpenSearchPassword,
+		OpenSearchSkipTLS:  args.OpenSearchSkipTLS,
+		OpenSearchIndex:    args.OpenSearchIndex,
+		Storage:            getStorage(),
+		Fix:                args.Fix,
+	}
+	if args.Fix {
+		config.Indexer = getIndexer()
+	}
+
+	s, err := scrub.New(config)
+	if err != nil {
+		log.Fatalf("create scrubber: %v", err)
+	}
+
+	report, err := s.Run(context.Background())
+	if err != nil {
+		log.Fatalf("scrub: %v", err)
+	}
+
+	out := os.Stdout
+	if args.Output != "" {
+		out, err = os.Create(args.Output)
+		if err != nil {
+			log.Fatalf("create report: %v", err)
+		}
+		defer out.Close()
+	}
+	if err := report.Write(out); err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+
+	for t, count := range report.Summary() {
+		log.Infof("%s: %d", t, count)
+	}
+	if unfixed := report.Unfixed(); unfixed > 0 {
+		log.Errorf("%d issues need attention", unfixed)
+		out.Close()
+		os.Exit(1)
+	}
+}
+
+func getIndexer() *indexer.Indexer {
+	if args.OcrApiAddr == "" {
+		log.Fatalf("--ocr-api-addr is required with --fix")
+	}
+	opts := []indexer.Option{indexer.WithDocumentsIndex(args.OpenSearchIndex)}
+	if args.OpenSearchUsername != "" {
+		opts = append(opts, indexer.WithOpenSearchUsername(args.OpenSearchUsername))
+	}
+	if args.OpenSearchPassword != "" {
+		opts = append(opts, indexer.WithOpenSearchPassword(args.OpenSearchPassword))
+	}
+	if args.OpenSearchSkipTLS {
+		opts = append(opts, indexer.WithOpenSearchSkipTLS())
+	}
+	if args.CompaniesFile != "" {
+		opts = append(opts, indexer.WithCompaniesFile(args.CompaniesFile))
+	}
+	if args.CompaniesIndex != "" {
+		opts = append(opts, indexer.WithCompaniesIndex(args.CompaniesIndex))
+	}
+	idx, err := indexer.New(args.OpenSearchAddr, args.OcrApiAddr, args.ZefixDsn, opts...)
+	if err != nil {
+		log.Fatalf("create indexer: %v", err)
+	}
+	return idx
+}
+
+func getStorage() model.RWStorage {
+	switch strings.ToLower(args.StorageType) {
+	case "b2":
+		return storage.SetupB2Storage(b2.Config{
+			Account:    args.B2AccountId,
+			BucketName: args.B2BucketName,
+			Key:        args.B2AccountKey,
+			Passphrase: args.B2Passphrase,
+		})
+	case "fs":
+		return storage.SetupFsStorage(args.FsPath)
+	case "rclone":
+		return storage.SetupRcloneStorage(rclone.Config{
+			Remote:     args.RcloneRemote,
+			Passphrase: args.RclonePassphrase,
+		})
+	case "s3":
+		return storage.SetupS3Storage(s3.Config{
+			Endpoint:             args.S3Endpoint,
+			Region:               args.S3Region,
+			AccessKeyId:          args.S3AccessKeyId,
+			SecretAccessKey:      args.S3SecretAccessKey,
+			Bucket:               args.S3Bucket,
+			Prefix:               args.S3Prefix,
+			PathStyle:            args.S3PathStyle,
+			ServerSideEncryption: args.S3ServerSideEncryption,
+			Passphrase:           args.S3Passphrase,
+		})
+	}
+
+	log.Fatalf("unknown storage type: %s", args.StorageType)
+	return nil
+}
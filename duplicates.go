@@ -0,0 +1,215 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+type DuplicatePair struct {
+	Id          string  `json:"id"`
+	DuplicateOf string  `json:"duplicateOf"`
+	Score       float64 `json:"score"`
+}
+
+// handleGetDuplicates lists the documents flagged as duplicates during the
+// indexing, so that they can be reviewed
+func (s *Server) handleGetDuplicates(c *gin.Context) {
+	body, err := json.Marshal(map[string]any{
+		"size":    500,
+		"_source": []string{"duplicateOf", "duplicateScore"},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must":     map[string]any{"exists": map[string]any{"field": "duplicateOf"}},
+				"must_not": isDeleted,
+			},
+		},
+		"sort": []any{
+			map[string]any{"indexedAt": "desc"},
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	req := opensearchapi.SearchRequest{
+		Index: []string{s.osIndex},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), s.osClient)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		log.Warnf("unable to get duplicates: %s", res.Status())
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	var docs struct {
+		Hits struct {
+			Hits []struct {
+				Id     string `json:"_id"`
+				Source struct {
+					DuplicateOf    string  `json:"duplicateOf"`
+					DuplicateScore float64 `json:"duplicateScore"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&docs); err != nil {
+		log.Errorf("unable to decode duplicates: %v", err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	pairs := []DuplicatePair{}
+	for _, h := range docs.Hits.Hits {
+		pairs = append(pairs, DuplicatePair{
+			Id:          h.Id,
+			DuplicateOf: h.Source.DuplicateOf,
+			Score:       h.Source.DuplicateScore,
+		})
+	}
+	c.JSON(http.StatusOK, pairs)
+}
+
+// handleDismissDuplicate marks a flagged document as not being a duplicate
+func (s *Server) handleDismissDuplicate(c *gin.Context) {
+	docId := c.Param("id")
+	if !docIdRegexp.MatchString(docId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"script": map[string]any{
+			"source": "ctx._source.remove('duplicateOf'); ctx._source.remove('duplicateScore')",
+			"lang":   "painless",
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	req := opensearchapi.UpdateRequest{
+		Index:      s.osIndex,
+		DocumentID: docId,
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), s.osClient)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+	if res.IsError() {
+		log.Warnf("unable to dismiss duplicate %s: %s", docId, res.Status())
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleMergeDuplicate merges a flagged document into the one it duplicates:
+// its tags are added to the original document, then it's deleted like with
+// DELETE /documents/:id, so it goes to the trash unless ?permanent=true
+func (s *Server) handleMergeDuplicate(c *gin.Context) {
+	docId := c.Param("id")
+	if !docIdRegexp.MatchString(docId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+
+	doc, err := s.getDocument(c.Request.Context(), docId)
+	if err != nil {
+		log.Errorf("unable to get document %s: %v", docId, err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if doc == nil || doc.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+	if doc.DuplicateOf == "" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "not a duplicate",
+		})
+		return
+	}
+	original, err := s.getDocument(c.Request.Context(), doc.DuplicateOf)
+	if err != nil {
+		log.Errorf("unable to get document %s: %v", doc.DuplicateOf, err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if original == nil || original.DeletedAt != nil {
+		// The original was deleted, the duplicate is the only copy left
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "the original document no longer exists",
+		})
+		return
+	}
+
+	if len(doc.Tags) > 0 {
+		if err := s.addTags(c.Request.Context(), doc.DuplicateOf, doc.Tags); err != nil {
+			log.Errorf("unable to merge duplicate %s into %s: %v", docId, doc.DuplicateOf, err)
+			c.JSON(http.StatusInternalServerError, internalServerError)
+			return
+		}
+	}
+	s.deleteByQuery(c, map[string]any{
+		"ids": map[string]any{"values": []string{docId}},
+	})
+}
+
+// addTags adds the tags the document doesn't have yet
+func (s *Server) addTags(ctx context.Context, docId string, tags []string) error {
+	body, err := json.Marshal(map[string]any{
+		"script": map[string]any{
+			"source": "if (ctx._source.tags == null) { ctx._source.tags = [] } " +
+				"for (t in params.tags) { if (!ctx._source.tags.contains(t)) { ctx._source.tags.add(t) } }",
+			"lang":   "painless",
+			"params": map[string]any{"tags": tags},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.UpdateRequest{
+		Index:      s.osIndex,
+		DocumentID: docId,
+		Body:       bytes.NewReader(body),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+	return nil
+}
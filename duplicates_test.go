@@ -0,0 +1,53 @@
+package backend_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+func TestMergeDuplicate(t *testing.T) {
+	const scanId = "0a1b"
+	f := &fakeOpenSearch{docs: map[string]models.Document{
+		scanId + "_1": {ScanId: scanId, SequenceId: 1, Tags: []string{"bank"}},
+		scanId + "_2": {ScanId: scanId, SequenceId: 2, Tags: []string{"bank", "2024"}, DuplicateOf: scanId + "_1", DuplicateScore: 0.9},
+		scanId + "_3": {ScanId: scanId, SequenceId: 3},
+		scanId + "_4": {ScanId: scanId, SequenceId: 4, DuplicateOf: scanId + "_9", DuplicateScore: 0.9},
+	}}
+	handler := newServer(t, f, newStorage(t, scanId, 4))
+	merge := func(id string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/duplicates/"+id+"/merge", nil))
+		return w.Code
+	}
+
+	if status := merge(scanId + "_2"); status != http.StatusAccepted {
+		t.Fatalf("unexpected status %d", status)
+	}
+	if tags := f.docs[scanId+"_1"].Tags; fmt.Sprint(tags) != "[bank 2024]" {
+		t.Errorf("expected the tags to be merged, got %v", tags)
+	}
+	if f.docs[scanId+"_2"].DeletedAt == nil {
+		t.Error("expected the duplicate to be in the trash")
+	}
+
+	for id, want := range map[string]int{
+		// Already merged
+		scanId + "_2": http.StatusNotFound,
+		scanId + "_3": http.StatusConflict,
+		// The original no longer exists
+		scanId + "_4": http.StatusConflict,
+		scanId + "_9": http.StatusNotFound,
+		"invalid":     http.StatusBadRequest,
+	} {
+		if status := merge(id); status != want {
+			t.Errorf("%s: expected status %d, got %d", id, want, status)
+		}
+	}
+	if f.docs[scanId+"_3"].DeletedAt != nil || f.docs[scanId+"_4"].DeletedAt != nil {
+		t.Error("expected the other documents to be kept")
+	}
+}
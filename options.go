@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
+)
+
+type Option func(*Server)
+
+// DefaultTrashPeriod is how long deleted documents can be restored before
+// being purged
+const DefaultTrashPeriod = 30 * 24 * time.Hour
+
+// WithTrashPeriod sets how long deleted documents are kept, a zero period
+// purges them right away
+func WithTrashPeriod(period time.Duration) Option {
+	return func(s *Server) {
+		s.trashPeriod = period
+	}
+}
+
+// WithPurgeInterval sets how often the trash is checked for documents to purge
+func WithPurgeInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.purgeInterval = interval
+	}
+}
+
+// WithProfiles sets the scan profiles exposed through the API
+func WithProfiles(config *profiles.Config) Option {
+	return func(s *Server) {
+		s.profiles = config
+	}
+}
+
+// WithIngestor enables starting scans from the API, scannerName is the
+// scanner used when the request doesn't specify one
+func WithIngestor(ing *ingestor.Ingestor, scannerName string) Option {
+	return func(s *Server) {
+		s.ingestor = ing
+		s.scannerName = scannerName
+	}
+}
+
+// WithUploadLimits sets the maximum size of each uploaded file and the
+// maximum number of files per upload
+func WithUploadLimits(maxFileSize int64, maxFiles int) Option {
+	return func(s *Server) {
+		s.maxUploadFileSize = maxFileSize
+		s.maxUploadFiles = maxFiles
+	}
+}
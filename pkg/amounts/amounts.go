@@ -0,0 +1,280 @@
+// Package amounts finds the monetary amounts in the text of a document and
+// tells which ones are the total and the VAT.
+package amounts
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+type Kind string
+
+const (
+	KindTotal    Kind = "total"
+	KindSubtotal Kind = "subtotal"
+	KindVat      Kind = "vat"
+	KindOther    Kind = "other"
+)
+
+// Amount is an amount found in the text
+type Amount struct {
+	models.Amount
+	Kind Kind
+	// Line is the line of text the amount was found on
+	Line string
+}
+
+// Result holds the amounts of a document, in the order they appear
+type Result struct {
+	Amounts []Amount
+
+	Total   *models.Amount
+	Vat     *models.Amount
+	VatRate *float64
+}
+
+var currencies = map[string]string{
+	"chf":  "CHF",
+	"fr":   "CHF",
+	"sfr":  "CHF",
+	"frs":  "CHF",
+	"eur":  "EUR",
+	"€":    "EUR",
+	"usd":  "USD",
+	"$":    "USD",
+	"gbp":  "GBP",
+	"£":    "GBP",
+	"euro": "EUR",
+}
+
+var (
+	currency = `(CHF|SFr\.?|Frs?\.?|EUR|Euro|€|USD|\$|GBP|£)`
+	// 1'234.50, 1’234.50, 1.234,50, 1,234.50, 1234.50, 12.-, 12.–
+	decimal = `\d{1,3}(?:['’]\d{3})+(?:[.,]\d{2}|\.[-–])?|\d{1,3}(?:[.,]\d{3})+[.,]\d{2}|\d+[.,]\d{2}|\d+\.[-–]{1,2}`
+	// Integers are only amounts next to a currency: "CHF 50"
+	number = `(` + decimal + `|\d+)`
+
+	// The currency is a word on its own: "Fr" isn't the start of "Frühling".
+	// \b isn't used as it only knows ASCII letters.
+	currencyBefore = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])` + currency + `\s*` + number)
+	currencyAfter  = regexp.MustCompile(`(?i)` + number + `\s*` + currency + `(?:[^\p{L}\p{N}]|$)`)
+	bareNumber     = regexp.MustCompile(`(` + decimal + `)`)
+	percentage     = regexp.MustCompile(`(\d{1,2}(?:[.,]\d{1,2})?)\s*%`)
+)
+
+// The keywords are matched on the whole line. VAT is checked first, unless
+// the line is a total including it ("Total inkl. MWST"): the words that only
+// mean "amount" ("MWST-Betrag", "montant TVA") don't make it a total.
+// Subtotals are checked next, as "Zwischensumme" and "sous-total" would
+// otherwise be totals.
+var (
+	subtotalKeywords = regexp.MustCompile(`(?i)(zwischensumme|zwischentotal|sous-total|subtotal|subtotale|imponibile|netto|exkl|excl|hors taxe|\bht\b|ohne mwst)`)
+	vatKeywords      = regexp.MustCompile(`(?i)(mwst|mehrwertsteuer|\bust\b|\btva\b|\biva\b|\bvat\b)`)
+	totalKeywords    = regexp.MustCompile(`(?i)(total|gesamt|summe|rechnungsbetrag|zahlbetrag|endbetrag|zu zahlen|à payer|a payer|da pagare|amount due|balance due)`)
+	amountKeywords   = regexp.MustCompile(`(?i)(montant|importo|betrag|amount)`)
+)
+
+// Find returns the amounts of the text. Amounts without a currency are only
+// considered on the lines that are labelled as total or VAT.
+func Find(text string) Result {
+	var r Result
+	for _, line := range strings.Split(text, "\n") {
+		kind := lineKind(line)
+		found := findInLine(line, kind != KindOther)
+		if kind == KindVat {
+			if rate := vatRate(line); rate != nil {
+				if r.VatRate == nil {
+					r.VatRate = rate
+				}
+				found = vatAmount(found, *rate)
+			}
+		}
+		for _, a := range found {
+			r.Amounts = append(r.Amounts, Amount{Amount: a, Kind: kind, Line: strings.TrimSpace(line)})
+		}
+	}
+	r.classify()
+	return r
+}
+
+func lineKind(line string) Kind {
+	switch {
+	case vatKeywords.MatchString(line) && !totalKeywords.MatchString(vatKeywords.ReplaceAllString(line, "")):
+		return KindVat
+	case subtotalKeywords.MatchString(line):
+		return KindSubtotal
+	case totalKeywords.MatchString(line), amountKeywords.MatchString(line):
+		return KindTotal
+	}
+	return KindOther
+}
+
+// findInLine returns the amounts of a line, bare numbers are only returned
+// when the line is labelled
+func findInLine(line string, labelled bool) []models.Amount {
+	var amounts []models.Amount
+	var taken [][]int
+	overlaps := func(start int, end int) bool {
+		for _, t := range taken {
+			if start < t[1] && end > t[0] {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(start int, end int, value string, cur string) {
+		if overlaps(start, end) || !isolated(line, start, end) {
+			return
+		}
+		v, ok := parseNumber(value)
+		if !ok {
+			return
+		}
+		taken = append(taken, []int{start, end})
+		amounts = append(amounts, models.Amount{Value: v, Currency: cur})
+	}
+
+	for _, m := range currencyBefore.FindAllStringSubmatchIndex(line, -1) {
+		add(m[2], m[1], line[m[4]:m[5]], normalizeCurrency(line[m[2]:m[3]]))
+	}
+	for _, m := range currencyAfter.FindAllStringSubmatchIndex(line, -1) {
+		add(m[2], m[5], line[m[2]:m[3]], normalizeCurrency(line[m[4]:m[5]]))
+	}
+	if labelled {
+		for _, m := range bareNumber.FindAllStringSubmatchIndex(line, -1) {
+			if strings.HasPrefix(strings.TrimSpace(line[m[1]:]), "%") {
+				continue
+			}
+			add(m[0], m[1], line[m[2]:m[3]], "")
+		}
+	}
+	return amounts
+}
+
+// isolated checks that the amount isn't part of a longer number, e.g. a date
+// (12.03.2023) or a reference
+func isolated(line string, start int, end int) bool {
+	if start > 0 {
+		c := line[start-1]
+		if (c >= '0' && c <= '9') || c == '.' || c == ',' || c == '\'' {
+			return false
+		}
+	}
+	if end < len(line) {
+		c := line[end]
+		if c >= '0' && c <= '9' {
+			return false
+		}
+		if (c == '.' || c == ',') && end+1 < len(line) && line[end+1] >= '0' && line[end+1] <= '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeCurrency(c string) string {
+	c = strings.ToLower(strings.TrimSuffix(c, "."))
+	return currencies[c]
+}
+
+// parseNumber parses Swiss (1'234.50), German (1.234,50) and English
+// (1,234.50) amounts. "12.-" is 12.
+func parseNumber(s string) (float64, bool) {
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	s = strings.TrimRight(s, "-–")
+	s = strings.TrimSuffix(s, ".")
+
+	// The decimal separator is the last one when it's followed by two digits
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && len(s)-i-1 == 2 {
+		s = strings.NewReplacer(".", "", ",", "").Replace(s[:i]) + "." + s[i+1:]
+	} else {
+		s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+func vatRate(line string) *float64 {
+	m := percentage.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	v, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+	if err != nil || v <= 0 || v >= 30 {
+		return nil
+	}
+	return &v
+}
+
+// vatAmount keeps the VAT of lines like "MWST 8.1% von CHF 100.00: CHF
+// 8.10", where the base the rate applies to is also written
+func vatAmount(amounts []models.Amount, rate float64) []models.Amount {
+	if len(amounts) < 2 {
+		return amounts
+	}
+	for _, a := range amounts {
+		for _, base := range amounts {
+			if math.Abs(base.Value*rate/100-a.Value) < 0.05 && a.Value != base.Value {
+				return []models.Amount{a}
+			}
+		}
+	}
+	return amounts[len(amounts)-1:]
+}
+
+func (r *Result) classify() {
+	var total *Amount
+	for i, a := range r.Amounts {
+		switch a.Kind {
+		case KindTotal:
+			// The last total is usually the one including VAT and fees, the
+			// largest one when they're on the same line
+			if total == nil || total.Kind != KindTotal || a.Value >= total.Value || a.Line != total.Line {
+				total = &r.Amounts[i]
+			}
+		case KindVat:
+			if r.Vat == nil {
+				v := a.Amount
+				r.Vat = &v
+			}
+		case KindOther:
+			// Without a labelled total, the largest amount with a currency
+			if a.Currency != "" && (total == nil || total.Kind != KindTotal && a.Value > total.Value) {
+				total = &r.Amounts[i]
+			}
+		}
+	}
+	if total != nil {
+		t := total.Amount
+		if t.Currency == "" {
+			t.Currency = r.currency()
+		}
+		r.Total = &t
+	}
+	if r.Vat != nil && r.Vat.Currency == "" && r.Total != nil {
+		r.Vat.Currency = r.Total.Currency
+	}
+}
+
+// currency returns the most frequent currency of the document
+func (r *Result) currency() string {
+	counts := map[string]int{}
+	best := ""
+	for _, a := range r.Amounts {
+		if a.Currency == "" {
+			continue
+		}
+		counts[a.Currency]++
+		if counts[a.Currency] > counts[best] {
+			best = a.Currency
+		}
+	}
+	return best
+}
@@ -0,0 +1,119 @@
+package amounts_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/amounts"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		total   *models.Amount
+		vat     *models.Amount
+		vatRate float64
+	}{
+		{
+			name: "swiss invoice",
+			text: "Rechnung Nr. 2024-001 vom 12.03.2024\n" +
+				"Beratung 10 h à CHF 120.00\tCHF 1'200.00\n" +
+				"Spesen\tCHF 34.50\n" +
+				"Total exkl. MWST\tCHF 1'234.50\n" +
+				"MWST 8.1% von CHF 1'234.50\tCHF 100.00\n" +
+				"Total inkl. MWST\tCHF 1'334.50\n" +
+				"Zahlbar innert 30 Tagen",
+			total:   &models.Amount{Value: 1334.50, Currency: "CHF"},
+			vat:     &models.Amount{Value: 100, Currency: "CHF"},
+			vatRate: 8.1,
+		},
+		{
+			name: "receipt",
+			text: "Migros\n" +
+				"Milch 1.65\n" +
+				"Brot 3.20\n" +
+				"TOTAL 4.85\n" +
+				"Bar Fr. 10.-\n" +
+				"Rückgeld 5.15\n" +
+				"MWST 2.6% 0.12",
+			total:   &models.Amount{Value: 4.85, Currency: "CHF"},
+			vat:     &models.Amount{Value: 0.12, Currency: "CHF"},
+			vatRate: 2.6,
+		},
+		{
+			name: "french invoice in euros",
+			text: "Facture du 02.07.2023\n" +
+				"Sous-total 1.000,00 €\n" +
+				"TVA 20 % 200,00 €\n" +
+				"Montant total à payer: 1.200,00 EUR",
+			total:   &models.Amount{Value: 1200, Currency: "EUR"},
+			vat:     &models.Amount{Value: 200, Currency: "EUR"},
+			vatRate: 20,
+		},
+		{
+			name: "without labels",
+			text: "Ihre Bestellung vom 01.02.2024\n" +
+				"Artikel A CHF 20\n" +
+				"Artikel B CHF 45.50\n" +
+				"Telefon 044 123 45 67",
+			total: &models.Amount{Value: 45.50, Currency: "CHF"},
+		},
+		{
+			name: "dates and references aren't amounts",
+			text: "Total Seiten 12\nDatum 12.03.2024\nReferenz 00 12345 67890",
+		},
+		{
+			name:    "VAT amount",
+			text:    "Total CHF 108.10\nMWST-Betrag 8.1% CHF 8.10",
+			total:   &models.Amount{Value: 108.10, Currency: "CHF"},
+			vat:     &models.Amount{Value: 8.10, Currency: "CHF"},
+			vatRate: 8.1,
+		},
+		{
+			name:  "amount label",
+			text:  "Rechnung\nBetrag EUR 42.00",
+			total: &models.Amount{Value: 42, Currency: "EUR"},
+		},
+		{
+			name: "currency in a word",
+			text: "Referenz 12.50 Frühling\nFrank 7.20",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := amounts.Find(tt.text)
+			checkAmount(t, "total", tt.total, r.Total)
+			checkAmount(t, "vat", tt.vat, r.Vat)
+			if tt.vatRate == 0 {
+				if r.VatRate != nil {
+					t.Errorf("expected no VAT rate, got %v", *r.VatRate)
+				}
+			} else if r.VatRate == nil || *r.VatRate != tt.vatRate {
+				t.Errorf("expected a VAT rate of %v, got %v", tt.vatRate, r.VatRate)
+			}
+			if t.Failed() {
+				t.Logf("amounts: %+v", r.Amounts)
+			}
+		})
+	}
+}
+
+func checkAmount(t *testing.T, name string, expected *models.Amount, got *models.Amount) {
+	t.Helper()
+	if expected == nil {
+		if got != nil {
+			t.Errorf("%s: expected nothing, got %+v", name, *got)
+		}
+		return
+	}
+	if got == nil {
+		t.Errorf("%s: expected %+v, got nothing", name, *expected)
+		return
+	}
+	if math.Abs(got.Value-expected.Value) > 0.001 || got.Currency != expected.Currency {
+		t.Errorf("%s: expected %+v, got %+v", name, *expected, *got)
+	}
+}
@@ -0,0 +1,504 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+var log = logrus.StandardLogger().WithField("package", "backfill")
+
+// Pipeline runs extractors on a document, usually an *indexer.Pipeline
+type Pipeline interface {
+	Run(in indexer.ExtractorInput, d *models.Document)
+}
+
+type Config struct {
+	OpenSearchAddr     string
+	OpenSearchUsername string
+	OpenSearchPassword string
+	OpenSearchSkipTLS  bool
+	OpenSearchIndex    string
+
+	Pipeline Pipeline
+	// Query selects the documents to process (e.g. Indexer.StaleQuery), all
+	// of them when nil
+	Query map[string]any
+	// BatchSize is the number of documents fetched and updated at once,
+	// DefaultBatchSize by default
+	BatchSize int
+	// CheckpointFile is where the progress is saved after each batch. When
+	// it exists, the backfill resumes after the last document it records,
+	// it's removed once all the documents have been processed.
+	CheckpointFile string
+	// Progress is called after each batch
+	Progress func(Progress)
+}
+
+const (
+	DefaultBatchSize = 500
+	// keepAlive is how long the point in time is kept between two batches
+	keepAlive = 5 * time.Minute
+)
+
+// Progress counts the documents processed since the backfill started,
+// including the runs it resumed
+type Progress struct {
+	// Total is the number of documents matching the query when the current
+	// run started
+	Total     int64 `json:"total"`
+	Processed int64 `json:"processed"`
+	Updated   int64 `json:"updated"`
+	Failed    int64 `json:"failed"`
+}
+
+// checkpoint is the progress and the sort values of the last document
+// processed, the next run searches after them. Index, Query and Extractors
+// identify the backfill: a checkpoint saved by another one isn't resumed.
+type checkpoint struct {
+	Progress
+	SearchAfter json.RawMessage `json:"searchAfter,omitempty"`
+
+	Index      string          `json:"index"`
+	Query      json.RawMessage `json:"query"`
+	Extractors map[string]int  `json:"extractors,omitempty"`
+}
+
+// sameBackfill tells whether both checkpoints were saved by the same backfill
+func (cp checkpoint) sameBackfill(other checkpoint) bool {
+	if cp.Index != other.Index || len(cp.Extractors) != len(other.Extractors) {
+		return false
+	}
+	for name, version := range cp.Extractors {
+		if v, ok := other.Extractors[name]; !ok || v != version {
+			return false
+		}
+	}
+	var a, b bytes.Buffer
+	if json.Compact(&a, cp.Query) != nil || json.Compact(&b, other.Query) != nil {
+		return false
+	}
+	return bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// sortOrder is stable across points in time so that a checkpoint can be
+// resumed with a new one
+var sortOrder = []any{
+	map[string]any{"scanId.keyword": map[string]any{"order": "asc", "unmapped_type": "keyword"}},
+	map[string]any{"sequenceId": map[string]any{"order": "asc", "unmapped_type": "long"}},
+}
+
+// Runner runs extractors on the documents already indexed and updates the
+// fields they change
+type Runner struct {
+	osClient       *opensearch.Client
+	index          string
+	pipeline       Pipeline
+	query          map[string]any
+	batchSize      int
+	checkpointFile string
+	progress       func(Progress)
+	identity       checkpoint
+}
+
+func New(config Config) (*Runner, error) {
+	if config.Pipeline == nil {
+		return nil, fmt.Errorf("pipeline is required")
+	}
+	if config.OpenSearchIndex == "" {
+		return nil, fmt.Errorf("opensearch index is required")
+	}
+
+	osClient, err := opensearch.NewClient(opensearch.Config{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.OpenSearchSkipTLS},
+		},
+		Addresses: []string{config.OpenSearchAddr},
+		Username:  config.OpenSearchUsername,
+		Password:  config.OpenSearchPassword,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opensearch client: %w", err)
+	}
+
+	r := &Runner{
+		osClient:       osClient,
+		index:          config.OpenSearchIndex,
+		pipeline:       config.Pipeline,
+		query:          config.Query,
+		batchSize:      config.BatchSize,
+		checkpointFile: config.CheckpointFile,
+		progress:       config.Progress,
+	}
+	if r.query == nil {
+		r.query = map[string]any{"match_all": map[string]any{}}
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = DefaultBatchSize
+	}
+	r.identity, err = identity(r.index, r.query, r.pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	return r, nil
+}
+
+// identity returns an empty checkpoint of the backfill. The versions of the
+// extractors are known when the pipeline is an *indexer.Pipeline.
+func identity(index string, query map[string]any, pipeline Pipeline) (checkpoint, error) {
+	cp := checkpoint{Index: index}
+	var err error
+	cp.Query, err = json.Marshal(query)
+	if err != nil {
+		return cp, err
+	}
+	if p, ok := pipeline.(interface{ Extractors() []indexer.Extractor }); ok {
+		cp.Extractors = map[string]int{}
+		for _, e := range p.Extractors() {
+			cp.Extractors[e.Name()] = e.Version()
+		}
+	}
+	return cp, nil
+}
+
+// Run processes the documents matching the query in batches, until they've
+// all been processed or ctx is done. A batch that can't be fetched or
+// updated stops the run, it can be resumed from the checkpoint; the
+// documents that fail on their own are counted and skipped.
+func (r *Runner) Run(ctx context.Context) (Progress, error) {
+	cp, err := r.loadCheckpoint()
+	if err != nil {
+		return Progress{}, fmt.Errorf("unable to load checkpoint: %w", err)
+	}
+	if cp.SearchAfter != nil {
+		log.Infof("resuming after %d documents", cp.Processed)
+	}
+
+	pitId, err := r.createPit(ctx)
+	if err != nil {
+		return cp.Progress, fmt.Errorf("unable to create point in time: %w", err)
+	}
+	// The point in time ID can change with each search
+	defer func() {
+		r.deletePit(pitId)
+	}()
+
+	first := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return cp.Progress, err
+		}
+		result, err := r.search(ctx, pitId, cp.SearchAfter)
+		if err != nil {
+			return cp.Progress, fmt.Errorf("unable to fetch documents: %w", err)
+		}
+		if result.PitId != "" {
+			pitId = result.PitId
+		}
+		if first {
+			cp.Total = result.Hits.Total.Value
+			first = false
+		}
+		if len(result.Hits.Hits) == 0 {
+			break
+		}
+
+		updated, failed, err := r.process(ctx, result.Hits.Hits)
+		if err != nil {
+			return cp.Progress, fmt.Errorf("unable to update documents: %w", err)
+		}
+		cp.Processed += int64(len(result.Hits.Hits))
+		cp.Updated += updated
+		cp.Failed += failed
+		cp.SearchAfter = result.Hits.Hits[len(result.Hits.Hits)-1].Sort
+		if err := r.saveCheckpoint(cp); err != nil {
+			log.Warnf("unable to save checkpoint: %v", err)
+		}
+		if r.progress != nil {
+			r.progress(cp.Progress)
+		}
+	}
+
+	if r.checkpointFile != "" {
+		if err := os.Remove(r.checkpointFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("unable to remove checkpoint: %v", err)
+		}
+	}
+	return cp.Progress, nil
+}
+
+type hit struct {
+	Id     string          `json:"_id"`
+	Source models.Document `json:"_source"`
+	Sort   json.RawMessage `json:"sort"`
+}
+
+type searchResult struct {
+	PitId string `json:"pit_id"`
+	Hits  struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []hit `json:"hits"`
+	} `json:"hits"`
+}
+
+func (r *Runner) search(ctx context.Context, pitId string, searchAfter json.RawMessage) (*searchResult, error) {
+	query := map[string]any{
+		"size":             r.batchSize,
+		"query":            r.query,
+		"sort":             sortOrder,
+		"track_total_hits": true,
+		"pit": map[string]any{
+			"id":         pitId,
+			"keep_alive": fmt.Sprintf("%dm", int(keepAlive.Minutes())),
+		},
+	}
+	if searchAfter != nil {
+		query["search_after"] = searchAfter
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is part of the point in time
+	req := opensearchapi.SearchRequest{Body: bytes.NewReader(body)}
+	res, err := req.Do(ctx, r.osClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+	var result searchResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// process runs the pipeline on the documents and updates the fields that
+// changed with a single bulk request
+func (r *Runner) process(ctx context.Context, hits []hit) (int64, int64, error) {
+	var body bytes.Buffer
+	var failed int64
+	for _, h := range hits {
+		changes, err := r.changes(h.Source)
+		if err != nil {
+			log.Warnf("%s: %v", h.Id, err)
+			failed++
+			continue
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		action, err := json.Marshal(map[string]any{"update": map[string]any{"_id": h.Id}})
+		if err != nil {
+			return 0, 0, err
+		}
+		doc, err := json.Marshal(map[string]any{"doc": changes})
+		if err != nil {
+			return 0, 0, err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	if body.Len() == 0 {
+		return 0, failed, nil
+	}
+
+	req := opensearchapi.BulkRequest{
+		Index: r.index,
+		Body:  &body,
+	}
+	res, err := req.Do(ctx, r.osClient)
+	if err != nil {
+		return 0, failed, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, failed, responseError(res)
+	}
+
+	var result struct {
+		Items []map[string]struct {
+			Id     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  any    `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, failed, err
+	}
+	var updated int64
+	for _, item := range result.Items {
+		for _, u := range item {
+			if u.Error != nil || u.Status >= 300 {
+				log.Warnf("%s: unable to update: %v", u.Id, u.Error)
+				failed++
+				continue
+			}
+			updated++
+		}
+	}
+	return updated, failed, nil
+}
+
+// changes runs the pipeline on a copy of d and returns the fields that
+// changed, by JSON name
+func (r *Runner) changes(d models.Document) (map[string]json.RawMessage, error) {
+	updated := d
+	updated.Extractors = make(map[string]int, len(d.Extractors))
+	for name, version := range d.Extractors {
+		updated.Extractors[name] = version
+	}
+	r.pipeline.Run(indexer.ExtractorInput{
+		Page: models.ScannedPage{
+			ScanId:     d.ScanId,
+			SequenceId: d.SequenceId,
+			ScanTime:   d.IndexedAt,
+		},
+		Text:     d.Text,
+		Blocks:   d.Blocks,
+		Barcodes: barcodes(d),
+	}, &updated)
+
+	before, err := fields(d)
+	if err != nil {
+		return nil, err
+	}
+	after, err := fields(updated)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]json.RawMessage{}
+	for name, value := range after {
+		if !bytes.Equal(before[name], value) {
+			changes[name] = value
+		}
+	}
+	// The fields an extractor doesn't find anymore are removed
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes[name] = json.RawMessage("null")
+		}
+	}
+	return changes, nil
+}
+
+func barcodes(d models.Document) []models.Barcode {
+	if d.Barcode == nil {
+		return nil
+	}
+	return append([]models.Barcode{*d.Barcode}, d.AdditionalBarcodes...)
+}
+
+func fields(d models.Document) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+func (r *Runner) createPit(ctx context.Context) (string, error) {
+	req := opensearchapi.PointInTimeCreateRequest{
+		Index:     []string{r.index},
+		KeepAlive: keepAlive,
+	}
+	res, pit, err := req.Do(ctx, r.osClient)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+	if res.IsError() {
+		return "", fmt.Errorf("unexpected status %s", res.Status())
+	}
+	return pit.PitID, nil
+}
+
+func (r *Runner) deletePit(pitId string) {
+	req := opensearchapi.PointInTimeDeleteRequest{PitID: []string{pitId}}
+	res, _, err := req.Do(context.Background(), r.osClient)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		log.Warnf("unable to delete point in time: %v", err)
+		return
+	}
+	if res.IsError() {
+		log.Warnf("unable to delete point in time: %s", res.Status())
+	}
+}
+
+// loadCheckpoint returns the checkpoint to resume from, the backfill starts
+// over when it was saved for another index, query or extractors
+func (r *Runner) loadCheckpoint() (checkpoint, error) {
+	if r.checkpointFile == "" {
+		return r.identity, nil
+	}
+	f, err := os.Open(r.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return r.identity, nil
+	}
+	if err != nil {
+		return r.identity, err
+	}
+	defer f.Close()
+	var cp checkpoint
+	if err := json.NewDecoder(f).Decode(&cp); err != nil {
+		return r.identity, err
+	}
+	if !cp.sameBackfill(r.identity) {
+		log.Warnf("%s was saved by another backfill (index %s, query %s, extractors %v), starting over",
+			r.checkpointFile, cp.Index, cp.Query, cp.Extractors)
+		return r.identity, nil
+	}
+	return cp, nil
+}
+
+// saveCheckpoint replaces the checkpoint file, it's written next to it
+// first so that an interrupted run doesn't leave a partial file
+func (r *Runner) saveCheckpoint(cp checkpoint) error {
+	if r.checkpointFile == "" {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := r.checkpointFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.checkpointFile)
+}
+
+func responseError(res *opensearchapi.Response) error {
+	body, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("unexpected status %s: %s", res.Status(), strings.TrimSpace(string(body)))
+}
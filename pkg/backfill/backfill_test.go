@@ -0,0 +1,286 @@
+package backfill_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/backfill"
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// fakeOpenSearch serves the point in time, search and bulk APIs over a list
+// of documents sorted by ID
+type fakeOpenSearch struct {
+	mu      sync.Mutex
+	docs    []models.Document
+	updates map[string]map[string]json.RawMessage
+	pits    int
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_search/point_in_time") && r.Method == http.MethodPost:
+		f.pits++
+		fmt.Fprintf(w, `{"pit_id": "pit-%d"}`, f.pits)
+	case r.URL.Path == "/_search/point_in_time" && r.Method == http.MethodDelete:
+		f.pits--
+		fmt.Fprint(w, `{"pits": []}`)
+	case r.URL.Path == "/_search":
+		f.search(w, r)
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		f.bulk(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeOpenSearch) search(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Size        int   `json:"size"`
+		SearchAfter []any `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type hit struct {
+		Id     string          `json:"_id"`
+		Source models.Document `json:"_source"`
+		Sort   []any           `json:"sort"`
+	}
+	var hits []hit
+	for _, d := range f.docs {
+		if req.SearchAfter != nil && d.ScanId <= req.SearchAfter[0].(string) {
+			continue
+		}
+		if len(hits) == req.Size {
+			break
+		}
+		hits = append(hits, hit{Id: d.ScanId + "_1", Source: d, Sort: []any{d.ScanId, d.SequenceId}})
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"hits": map[string]any{
+			"total": map[string]any{"value": len(f.docs)},
+			"hits":  hits,
+		},
+	})
+}
+
+func (f *fakeOpenSearch) bulk(w http.ResponseWriter, r *http.Request) {
+	var items []any
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action struct {
+			Update struct {
+				Id string `json:"_id"`
+			} `json:"update"`
+		}
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		var doc struct {
+			Doc map[string]json.RawMessage `json:"doc"`
+		}
+		json.Unmarshal(scanner.Bytes(), &doc)
+		f.updates[action.Update.Id] = doc.Doc
+		items = append(items, map[string]any{"update": map[string]any{"_id": action.Update.Id, "status": 200}})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+// ownerPipeline sets the owner of the invoices
+type ownerPipeline struct{}
+
+func (ownerPipeline) Run(in indexer.ExtractorInput, d *models.Document) {
+	if strings.Contains(in.Text, "invoice") {
+		d.Owner = "accounting"
+	}
+	if d.Extractors == nil {
+		d.Extractors = map[string]int{}
+	}
+	d.Extractors["owner"] = 1
+}
+
+func newFake() *fakeOpenSearch {
+	return &fakeOpenSearch{
+		docs: []models.Document{
+			{ScanId: "a", SequenceId: 1, Text: "invoice 1"},
+			{ScanId: "b", SequenceId: 1, Text: "letter", Extractors: map[string]int{"owner": 1}},
+			{ScanId: "c", SequenceId: 1, Text: "invoice 2", Tags: []string{"paid"}},
+		},
+		updates: map[string]map[string]json.RawMessage{},
+	}
+}
+
+func TestRun(t *testing.T) {
+	fake := newFake()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var batches []backfill.Progress
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+	r, err := backfill.New(backfill.Config{
+		OpenSearchAddr:  server.URL,
+		OpenSearchIndex: "documents",
+		Pipeline:        ownerPipeline{},
+		BatchSize:       2,
+		CheckpointFile:  checkpoint,
+		Progress: func(p backfill.Progress) {
+			batches = append(batches, p)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Total != 3 || p.Processed != 3 || p.Updated != 2 || p.Failed != 0 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	if len(batches) != 2 {
+		t.Errorf("expected 2 batches, got %+v", batches)
+	}
+	// b didn't change, the others only have the changed fields
+	if len(fake.updates) != 2 || fake.updates["b_1"] != nil {
+		t.Errorf("unexpected updates %v", fake.updates)
+	}
+	for id, doc := range fake.updates {
+		if len(doc) != 2 || string(doc["owner"]) != `"accounting"` || string(doc["extractors"]) != `{"owner":1}` {
+			t.Errorf("%s: unexpected update %s", id, doc)
+		}
+	}
+	if fake.pits != 0 {
+		t.Errorf("expected the point in time to be deleted")
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed, got %v", err)
+	}
+}
+
+const resumable = `{"processed": 2, "updated": 1, "searchAfter": ["b", 1], ` +
+	`"index": "documents", "query": {"match_all": {}}}`
+
+func TestRunResume(t *testing.T) {
+	fake := newFake()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+	err := os.WriteFile(checkpoint, []byte(resumable), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := backfill.New(backfill.Config{
+		OpenSearchAddr:  server.URL,
+		OpenSearchIndex: "documents",
+		Pipeline:        ownerPipeline{},
+		CheckpointFile:  checkpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Processed != 3 || p.Updated != 2 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	if len(fake.updates) != 1 || fake.updates["c_1"] == nil {
+		t.Errorf("expected only c to be updated, got %v", fake.updates)
+	}
+}
+
+func TestRunAnotherCheckpoint(t *testing.T) {
+	for name, config := range map[string]backfill.Config{
+		"index":      {OpenSearchIndex: "archive", Pipeline: ownerPipeline{}},
+		"query":      {OpenSearchIndex: "documents", Pipeline: ownerPipeline{}, Query: map[string]any{"term": map[string]any{"owner": "bob"}}},
+		"extractors": {OpenSearchIndex: "documents", Pipeline: newPipeline(t)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := newFake()
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+			if err := os.WriteFile(checkpoint, []byte(resumable), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config.OpenSearchAddr = server.URL
+			config.CheckpointFile = checkpoint
+			r, err := backfill.New(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := r.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The checkpoint of another backfill isn't resumed
+			if p.Processed != 3 {
+				t.Errorf("expected all the documents to be processed, got %+v", p)
+			}
+		})
+	}
+}
+
+func newPipeline(t *testing.T) *indexer.Pipeline {
+	t.Helper()
+	p, err := indexer.NewPipeline([]indexer.Extractor{
+		indexer.NewExtractor("tags", 2, func(in indexer.ExtractorInput) (*models.Document, error) {
+			if strings.Contains(in.Text, "invoice") {
+				return &models.Document{Tags: []string{"invoice"}}, nil
+			}
+			return nil, nil
+		}, "Tags"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRunRemovesFields(t *testing.T) {
+	fake := newFake()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	r, err := backfill.New(backfill.Config{
+		OpenSearchAddr:  server.URL,
+		OpenSearchIndex: "documents",
+		Pipeline:        newPipeline(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The tags of c were found by an older run, the extractor replaces them
+	if got := string(fake.updates["c_1"]["tags"]); got != `["invoice"]` {
+		t.Errorf("expected the tags of c to be replaced, got %s", got)
+	}
+	fake.docs[2].Tags = []string{"paid"}
+	fake.docs[2].Text = "receipt"
+	fake.updates = map[string]map[string]json.RawMessage{}
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := fake.updates["c_1"]["tags"]; !ok || string(got) != "null" {
+		t.Errorf("expected the tags of c to be removed, got %s", fake.updates["c_1"])
+	}
+}
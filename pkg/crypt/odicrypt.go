@@ -6,11 +6,18 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha1"
+	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// ErrAuthenticationFailed is returned by Decrypt when the GCM tag doesn't
+// match, which means that either the passphrase is wrong or the data has been
+// tampered with / corrupted.
+var ErrAuthenticationFailed = errors.New("authentication failed")
+
 type OdiCrypt struct {
 	gcm           cipher.AEAD
 	encryptionKey []byte
@@ -58,7 +65,7 @@ func (o *OdiCrypt) Decrypt(objReader io.ReadCloser) (io.ReadSeeker, error) {
 	// Read the nonce
 	nonceSize := o.gcm.NonceSize()
 	nonce := make([]byte, nonceSize)
-	_, err := objReader.Read(nonce)
+	_, err := io.ReadFull(objReader, nonce)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +80,7 @@ func (o *OdiCrypt) Decrypt(objReader io.ReadCloser) (io.ReadSeeker, error) {
 	// Decrypt the data
 	plainText, err := o.gcm.Open(nil, nonce, cipherTextBuffer.Bytes(), nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticationFailed, err)
 	}
 
 	return bytes.NewReader(plainText), nil
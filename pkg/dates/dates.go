@@ -0,0 +1,238 @@
+// Package dates finds the dates of a document and tells what they are: the
+// date the document was issued, when it's due or the period it covers.
+package dates
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+type Role string
+
+const (
+	RoleIssue       Role = "issue"
+	RoleDue         Role = "due"
+	RolePeriodStart Role = "periodStart"
+	RolePeriodEnd   Role = "periodEnd"
+	// RoleBirth dates are never used as the date of the document
+	RoleBirth Role = "birth"
+	RoleOther Role = "other"
+)
+
+// Date is a date found on the page
+type Date struct {
+	Date time.Time `json:"date"`
+	Role Role      `json:"role"`
+	// Score ranks the dates that could be the date of the document, higher
+	// is more likely
+	Score float64 `json:"score"`
+	// Line is the line of text the date was found on
+	Line string `json:"line"`
+}
+
+// Result holds the dates of a page, in the order they appear, and the ones
+// that were recognized
+type Result struct {
+	Dates []Date
+
+	// Date is the date of the document: the issue date or, without one, the
+	// most plausible date
+	Date        *time.Time
+	Issue       *time.Time
+	Due         *time.Time
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time
+}
+
+// The keywords are matched on the text before the date (same line, then the
+// previous line), the closest one wins. The tiers are tried in order: the
+// generic keywords are only used when no specific one is found, as in
+// "Fälligkeitsdatum" or "date de naissance", and the weak ones last since
+// "le" or "vom" also precede due dates and order dates.
+var keywords = []struct {
+	role Role
+	tier int
+	expr *regexp.Regexp
+}{
+	{RoleDue, 0, regexp.MustCompile(`(?i)(fällig|zahlbar|zahlungsfrist|zahlungstermin|échéance|echeance|payable|à payer|a payer|scadenza|pagabile|da pagare|entro il|\bdue\b|pay by|payment date)`)},
+	{RoleBirth, 0, regexp.MustCompile(`(?i)(geburt|geb\.|naissance|né le|née le|nascita|nato il|nata il|birth|born|\bdob\b)`)},
+	{RolePeriodStart, 0, regexp.MustCompile(`(?i)(periode|zeitraum|période|periodo|period)`)},
+	{RoleIssue, 1, regexp.MustCompile(`(?i)(datum|date|data|ausgestellt|émis|emesso|issued|^\s*\p{Lu}[\p{L} .'-]+,\s*$)`)},
+	{RoleIssue, 2, regexp.MustCompile(`(?i)(\bvom\b|\bden\b|\ble\b|\bdu\b|\bil\b|\bdel\b|\bdal\b|\bon\b)`)},
+}
+
+// Range separators between the start and end of a period
+var rangeSeparator = regexp.MustCompile(`(?i)^\s*(-|–|—|bis|au|al|to|until|jusqu'au|fino al)\s*$`)
+
+// Find returns the dates of the page. scanTime is used to discard implausible
+// dates (e.g. an issue date in the future), time.Now() is used when it's zero.
+func Find(blocks []models.TextBlock, scanTime time.Time) Result {
+	if scanTime.IsZero() {
+		scanTime = time.Now()
+	}
+	pageHeight := 0
+	for _, b := range blocks {
+		if b.BoundingBox.Bottom > pageHeight {
+			pageHeight = b.BoundingBox.Bottom
+		}
+	}
+
+	var result Result
+	for _, b := range blocks {
+		lines := strings.Split(b.Text, "\n")
+		for l, line := range lines {
+			matches := findInLine(line, scanTime)
+			for n, m := range matches {
+				role := RoleOther
+				if n > 0 && rangeSeparator.MatchString(line[matches[n-1].end:m.start]) && matches[n-1].date.Before(m.date) {
+					// Second date of a range: the first one starts the period
+					role = RolePeriodEnd
+					result.Dates[len(result.Dates)-1].Role = RolePeriodStart
+				} else {
+					prefix := line[:m.start]
+					if n > 0 {
+						prefix = line[matches[n-1].end:m.start]
+					}
+					role = keywordRole(prefix)
+					if role == RoleOther && n == 0 && strings.TrimSpace(prefix) == "" && l > 0 {
+						// The label is often on the line above the date
+						role = keywordRole(lines[l-1])
+					}
+				}
+
+				result.Dates = append(result.Dates, Date{
+					Date:  m.date,
+					Role:  role,
+					Score: score(m.date, role, b.BoundingBox, pageHeight, scanTime),
+					Line:  strings.TrimSpace(line),
+				})
+			}
+		}
+	}
+
+	result.classify(scanTime)
+	return result
+}
+
+func keywordRole(text string) Role {
+	for tier := 0; tier <= 2; tier++ {
+		best := RoleOther
+		bestPos := -1
+		for _, k := range keywords {
+			if k.tier != tier {
+				continue
+			}
+			locs := k.expr.FindAllStringIndex(text, -1)
+			if len(locs) == 0 {
+				continue
+			}
+			if pos := locs[len(locs)-1][1]; pos > bestPos {
+				best = k.role
+				bestPos = pos
+			}
+		}
+		if best != RoleOther {
+			return best
+		}
+	}
+	return RoleOther
+}
+
+// score is higher for the dates that look like the date of the document:
+// labelled as such, at the top of the page and not too far from the scan
+func score(d time.Time, role Role, box models.BoundingBox, pageHeight int, scanTime time.Time) float64 {
+	s := 1.0
+	switch role {
+	case RoleIssue:
+		s += 3
+	case RoleOther:
+	default:
+		s -= 1
+	}
+	if pageHeight > 0 {
+		s += 1 - float64(box.Top)/float64(pageHeight)
+	}
+	s += plausibility(d, scanTime)
+	return s
+}
+
+// plausibility penalizes the dates after the scan and the ones too old to be
+// the date of a document that is being scanned
+func plausibility(d time.Time, scanTime time.Time) float64 {
+	age := scanTime.Sub(d)
+	switch {
+	case age < -24*time.Hour:
+		return -3
+	case age > 50*365*24*time.Hour:
+		return -3
+	case age > 10*365*24*time.Hour:
+		return -1
+	case age < 365*24*time.Hour:
+		return 0.5
+	}
+	return 0
+}
+
+func (r *Result) classify(scanTime time.Time) {
+	pick := func(role Role, plausible func(Date) bool) *time.Time {
+		var best *Date
+		for i, d := range r.Dates {
+			if d.Role != role || !plausible(d) {
+				continue
+			}
+			if best == nil || d.Score > best.Score {
+				best = &r.Dates[i]
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		t := best.Date
+		return &t
+	}
+	notFuture := func(d Date) bool {
+		return !d.Date.After(scanTime.Add(24 * time.Hour))
+	}
+	always := func(Date) bool { return true }
+
+	r.Issue = pick(RoleIssue, notFuture)
+	r.Due = pick(RoleDue, func(d Date) bool {
+		return r.Issue == nil || !d.Date.Before(*r.Issue)
+	})
+	for i, d := range r.Dates {
+		if d.Role == RolePeriodStart {
+			start := d.Date
+			r.PeriodStart = &start
+			if i+1 < len(r.Dates) && r.Dates[i+1].Role == RolePeriodEnd {
+				end := r.Dates[i+1].Date
+				r.PeriodEnd = &end
+			}
+			break
+		}
+	}
+	if r.PeriodEnd == nil {
+		r.PeriodEnd = pick(RolePeriodEnd, always)
+	}
+
+	r.Date = r.Issue
+	if r.Date == nil {
+		// The most plausible date that isn't something else
+		candidates := make([]Date, 0, len(r.Dates))
+		for _, d := range r.Dates {
+			if d.Role == RoleOther && plausibility(d.Date, scanTime) > -3 {
+				candidates = append(candidates, d)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Score > candidates[j].Score
+		})
+		if len(candidates) > 0 {
+			t := candidates[0].Date
+			r.Date = &t
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package dates_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/dates"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+func block(top int, text string) models.TextBlock {
+	return models.TextBlock{
+		Text:        text,
+		BoundingBox: models.BoundingBox{Top: top, Bottom: top + 40, Left: 100, Right: 900},
+	}
+}
+
+func day(year int, month time.Month, d int) *time.Time {
+	t := time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocks   []models.TextBlock
+		scanTime time.Time
+		expected dates.Result
+	}{
+		{
+			name: "german invoice",
+			blocks: []models.TextBlock{
+				block(50, "Muster AG\nBahnhofstrasse 1\n8001 Zürich"),
+				block(300, "Zürich, 12. März 2023"),
+				block(400, "Rechnung Nr. 2023-123\nPeriode: 01.02.2023 - 28.02.2023"),
+				block(500, "Versicherte Person\nGeburtsdatum: 04.07.1985"),
+				block(900, "Zahlbar bis 11.04.2023"),
+			},
+			scanTime: time.Date(2023, 3, 15, 10, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date:        day(2023, time.March, 12),
+				Issue:       day(2023, time.March, 12),
+				Due:         day(2023, time.April, 11),
+				PeriodStart: day(2023, time.February, 1),
+				PeriodEnd:   day(2023, time.February, 28),
+			},
+		},
+		{
+			name: "french invoice",
+			blocks: []models.TextBlock{
+				block(100, "Date de facture: 02.07.2023"),
+				block(200, "Date de naissance 12.05.1990"),
+				block(300, "Période du 01.06.2023 au 30.06.2023"),
+				block(800, "Échéance: 01.08.2023"),
+			},
+			scanTime: time.Date(2023, 7, 10, 0, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date:        day(2023, time.July, 2),
+				Issue:       day(2023, time.July, 2),
+				Due:         day(2023, time.August, 1),
+				PeriodStart: day(2023, time.June, 1),
+				PeriodEnd:   day(2023, time.June, 30),
+			},
+		},
+		{
+			name: "italian letter",
+			blocks: []models.TextBlock{
+				block(100, "Lugano, 11 maggio 2023"),
+				block(700, "Da pagare entro il 10.06.2023"),
+			},
+			scanTime: time.Date(2023, 5, 20, 0, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date:  day(2023, time.May, 11),
+				Issue: day(2023, time.May, 11),
+				Due:   day(2023, time.June, 10),
+			},
+		},
+		{
+			name: "english invoice",
+			blocks: []models.TextBlock{
+				block(100, "Invoice date: March 3, 2023"),
+				block(150, "Due date: 2023-04-02"),
+			},
+			scanTime: time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date:  day(2023, time.March, 3),
+				Issue: day(2023, time.March, 3),
+				Due:   day(2023, time.April, 2),
+			},
+		},
+		{
+			name: "label on the previous line",
+			blocks: []models.TextBlock{
+				block(100, "Datum\n05.05.2023"),
+			},
+			scanTime: time.Date(2023, 5, 6, 0, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date:  day(2023, time.May, 5),
+				Issue: day(2023, time.May, 5),
+			},
+		},
+		{
+			name: "without labels",
+			blocks: []models.TextBlock{
+				block(100, "Gültig bis 31.12.2030"),
+				block(200, "Kunde seit 01.01.1960"),
+				block(800, "15.01.23"),
+			},
+			scanTime: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+			expected: dates.Result{
+				Date: day(2023, time.January, 15),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dates.Find(tt.blocks, tt.scanTime)
+			check := func(name string, expected *time.Time, got *time.Time) {
+				if expected == nil && got == nil {
+					return
+				}
+				if expected == nil || got == nil || !expected.Equal(*got) {
+					t.Errorf("%s: expected %v, got %v (dates: %+v)", name, expected, got, r.Dates)
+				}
+			}
+			check("date", tt.expected.Date, r.Date)
+			check("issue", tt.expected.Issue, r.Issue)
+			check("due", tt.expected.Due, r.Due)
+			check("period start", tt.expected.PeriodStart, r.PeriodStart)
+			check("period end", tt.expected.PeriodEnd, r.PeriodEnd)
+		})
+	}
+}
+
+func TestFindRoles(t *testing.T) {
+	r := dates.Find([]models.TextBlock{
+		block(100, "Geburtsdatum: 04.07.1985"),
+		block(200, "Fälligkeitsdatum 30.06.2023"),
+	}, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	if len(r.Dates) != 2 {
+		t.Fatalf("expected 2 dates, got %+v", r.Dates)
+	}
+	if r.Dates[0].Role != dates.RoleBirth {
+		t.Errorf("expected a birth date, got %s", r.Dates[0].Role)
+	}
+	if r.Dates[1].Role != dates.RoleDue {
+		t.Errorf("expected a due date, got %s", r.Dates[1].Role)
+	}
+	if r.Date != nil {
+		t.Errorf("expected no document date, got %v", r.Date)
+	}
+}
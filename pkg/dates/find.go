@@ -0,0 +1,117 @@
+package dates
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// match is a date found in a line of text, start and end are the byte
+// offsets of the date in the line
+type match struct {
+	date  time.Time
+	start int
+	end   int
+}
+
+var months = map[string]time.Month{
+	// German
+	"januar": time.January, "jänner": time.January, "februar": time.February, "märz": time.March,
+	"april": time.April, "mai": time.May, "juni": time.June, "juli": time.July, "august": time.August,
+	"september": time.September, "oktober": time.October, "november": time.November, "dezember": time.December,
+	// French
+	"janvier": time.January, "février": time.February, "fevrier": time.February, "mars": time.March,
+	"avril": time.April, "juin": time.June, "juillet": time.July, "août": time.August, "aout": time.August,
+	"septembre": time.September, "octobre": time.October, "novembre": time.November,
+	"décembre": time.December, "decembre": time.December,
+	// Italian
+	"gennaio": time.January, "febbraio": time.February, "marzo": time.March, "aprile": time.April,
+	"maggio": time.May, "giugno": time.June, "luglio": time.July, "agosto": time.August,
+	"settembre": time.September, "ottobre": time.October, "dicembre": time.December,
+	// English
+	"january": time.January, "february": time.February, "march": time.March, "may": time.May,
+	"june": time.June, "july": time.July, "october": time.October, "december": time.December,
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"jun": time.June, "jul": time.July, "aug": time.August, "sep": time.September, "sept": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+var (
+	monthNames = func() string {
+		var names []string
+		for name := range months {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		// Longest first, so that "sept" isn't matched as "sep"
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+		return strings.Join(names, "|")
+	}()
+
+	// 31.12.2023, 31/12/2023, 31.12.23
+	dayMonthYear = regexp.MustCompile(`\b(\d{1,2})[./](\d{1,2})[./](\d{4}|\d{2})\b`)
+	// 2023-12-31
+	isoDate = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	// 31. Dezember 2023, 31 décembre 2023, 1er janvier 2023, 31 Dec 2023
+	dayNameYear = regexp.MustCompile(`(?i)\b(\d{1,2})(?:\.|er)?\s*(` + monthNames + `)\.?\s*(\d{4})\b`)
+	// December 31, 2023
+	nameDayYear = regexp.MustCompile(`(?i)\b(` + monthNames + `)\.?\s+(\d{1,2}),?\s+(\d{4})\b`)
+)
+
+// findInLine returns the dates of a line, in the order they appear
+func findInLine(line string, now time.Time) []match {
+	var matches []match
+	add := func(start int, end int, year int, month time.Month, day int) {
+		if month < time.January || month > time.December || day < 1 || day > 31 {
+			return
+		}
+		d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		if d.Day() != day {
+			// e.g. 31.02.2023
+			return
+		}
+		for _, m := range matches {
+			if start < m.end && end > m.start {
+				return
+			}
+		}
+		matches = append(matches, match{date: d, start: start, end: end})
+	}
+
+	for _, m := range dayNameYear.FindAllStringSubmatchIndex(line, -1) {
+		add(m[0], m[1], atoi(line[m[6]:m[7]]), months[strings.ToLower(line[m[4]:m[5]])], atoi(line[m[2]:m[3]]))
+	}
+	for _, m := range nameDayYear.FindAllStringSubmatchIndex(line, -1) {
+		add(m[0], m[1], atoi(line[m[6]:m[7]]), months[strings.ToLower(line[m[2]:m[3]])], atoi(line[m[4]:m[5]]))
+	}
+	for _, m := range isoDate.FindAllStringSubmatchIndex(line, -1) {
+		add(m[0], m[1], atoi(line[m[2]:m[3]]), time.Month(atoi(line[m[4]:m[5]])), atoi(line[m[6]:m[7]]))
+	}
+	for _, m := range dayMonthYear.FindAllStringSubmatchIndex(line, -1) {
+		year := line[m[6]:m[7]]
+		add(m[0], m[1], fullYear(year, now), time.Month(atoi(line[m[4]:m[5]])), atoi(line[m[2]:m[3]]))
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	return matches
+}
+
+// fullYear expands two-digit years: years up to ten years after now are in
+// the current century, the others in the previous one
+func fullYear(year string, now time.Time) int {
+	y := atoi(year)
+	if len(year) == 4 {
+		return y
+	}
+	century := now.Year() / 100 * 100
+	if century+y > now.Year()+10 {
+		return century - 100 + y
+	}
+	return century + y
+}
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
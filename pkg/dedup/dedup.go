@@ -0,0 +1,162 @@
+// Package dedup finds pages that have been scanned more than once by
+// comparing a perceptual hash of the image and the similarity of their text.
+package dedup
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Mode string
+
+const (
+	// ModeOff disables the deduplication
+	ModeOff Mode = "off"
+	// ModeFlag indexes the duplicates, marking them with the original document
+	ModeFlag Mode = "flag"
+	// ModeSkip doesn't index the duplicates
+	ModeSkip Mode = "skip"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case ModeOff, ModeFlag, ModeSkip:
+		return m, nil
+	case "":
+		return ModeOff, nil
+	}
+	return "", fmt.Errorf("invalid deduplication mode %q", s)
+}
+
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// PerceptualHash returns the difference hash (dHash) of an image: the image is
+// reduced to 9x8 grayscale pixels and every bit tells whether a pixel is
+// brighter than its right neighbour. Similar images have a small Hamming
+// distance between their hashes, regardless of scale and compression.
+func PerceptualHash(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode image: %w", err)
+	}
+
+	pixels := downscale(img, hashWidth, hashHeight)
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if pixels[y][x] > pixels[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// downscale returns the average luminance of each cell of a w x h grid
+func downscale(img image.Image, w int, h int) [][]float64 {
+	bounds := img.Bounds()
+	sums := make([][]float64, h)
+	counts := make([][]int, h)
+	for i := range sums {
+		sums[i] = make([]float64, w)
+		counts[i] = make([]int, w)
+	}
+
+	// Sampling every pixel of a 300 DPI scan isn't needed to get 72 cells
+	step := max(1, min(bounds.Dx(), bounds.Dy())/256)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		cy := (y - bounds.Min.Y) * h / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			cx := (x - bounds.Min.X) * w / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			sums[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cy][cx]++
+		}
+	}
+
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= float64(counts[y][x])
+			}
+		}
+	}
+	return sums
+}
+
+// FormatHash returns the hex representation of a perceptual hash, as stored
+// in the documents
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// ImageSimilarity returns a value between 0 (completely different) and 1
+// (identical) based on the Hamming distance of two perceptual hashes
+func ImageSimilarity(a uint64, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+const shingleSize = 3
+
+// TextSimilarity returns the Jaccard similarity of the word shingles of two
+// texts, it's tolerant to OCR output that is split or ordered slightly
+// differently
+func TextSimilarity(a string, b string) float64 {
+	sa := shingles(a)
+	sb := shingles(b)
+	if len(sa) == 0 || len(sb) == 0 {
+		return 0
+	}
+
+	intersection := 0
+	for s := range sa {
+		if sb[s] {
+			intersection++
+		}
+	}
+	union := len(sa) + len(sb) - intersection
+	return float64(intersection) / float64(union)
+}
+
+func shingles(text string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	result := map[string]bool{}
+	if len(words) < shingleSize {
+		if len(words) > 0 {
+			result[strings.Join(words, " ")] = true
+		}
+		return result
+	}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		result[strings.Join(words[i:i+shingleSize], " ")] = true
+	}
+	return result
+}
+
+// Score combines the image and text similarity of two pages. The text weighs
+// more since two different letters from the same sender look alike.
+// When one of the pages has no text, only the image is considered.
+func Score(imageSimilarity float64, textSimilarity float64, hasText bool) float64 {
+	if !hasText {
+		return imageSimilarity
+	}
+	return 0.4*imageSimilarity + 0.6*textSimilarity
+}
@@ -0,0 +1,86 @@
+package dedup_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+)
+
+// page draws a fake document: white background with some dark "text lines"
+func page(width int, height int, lines []int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: 250})
+		}
+	}
+	for _, l := range lines {
+		top := l * height / 100
+		for y := top; y < top+height/50; y++ {
+			for x := width / 10; x < width*(5+l%4)/10; x++ {
+				img.SetGray(x, y, color.Gray{Y: 20})
+			}
+		}
+	}
+	return img
+}
+
+func hash(t *testing.T, img image.Image, quality int) uint64 {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := dedup.PerceptualHash(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestPerceptualHash(t *testing.T) {
+	letter := []int{10, 15, 30, 35, 40, 45, 80}
+	original := hash(t, page(850, 1100, letter), 90)
+	rescan := hash(t, page(1700, 2200, letter), 60)
+	other := hash(t, page(850, 1100, []int{5, 50, 55, 60, 65, 70, 90}), 90)
+
+	rescanSimilarity := dedup.ImageSimilarity(original, rescan)
+	if rescanSimilarity < 0.95 {
+		t.Fatalf("expected the rescan to be similar, got %f", rescanSimilarity)
+	}
+	// Mostly white pages always look a bit alike, that's why the text
+	// similarity weighs more in the final score.
+	if s := dedup.ImageSimilarity(original, other); s >= rescanSimilarity {
+		t.Fatalf("expected different pages to be less similar than a rescan, got %f", s)
+	}
+
+	parsed, err := dedup.ParseHash(dedup.FormatHash(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != original {
+		t.Fatalf("expected %x, got %x", original, parsed)
+	}
+}
+
+func TestTextSimilarity(t *testing.T) {
+	letter := `Gentile Cliente,
+La corrispondenza che le abbiamo inviata ci è stata rispedita.
+Come conseguenza abbiamo deattivato l'invio postale al suo indirizzo fino a nuovo avviso.`
+	rescan := `Gentile Cliente, La corrispondenza che le abbiamo inviata ci è stata rispedita.
+Come conseguenza abbiamo deattivato l'invio postale al suo indirizzo fino a nuovo awiso.`
+	other := `Sehr geehrte Damen und Herren, wir bestätigen den Eingang Ihrer Zahlung.`
+
+	if s := dedup.TextSimilarity(letter, rescan); s < 0.8 {
+		t.Fatalf("expected the rescan to be similar, got %f", s)
+	}
+	if s := dedup.TextSimilarity(letter, other); s != 0 {
+		t.Fatalf("expected no similarity, got %f", s)
+	}
+	if s := dedup.TextSimilarity(letter, ""); s != 0 {
+		t.Fatalf("expected no similarity with an empty text, got %f", s)
+	}
+}
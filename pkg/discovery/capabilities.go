@@ -0,0 +1,87 @@
+package discovery
+
+import "sort"
+
+// capabilities is the subset of the eSCL ScannerCapabilities document we
+// care about. airscan only decodes one resolution per setting profile, hence
+// the own definition.
+type capabilities struct {
+	MakeAndModel string     `xml:"MakeAndModel"`
+	UUID         string     `xml:"UUID"`
+	Platen       *inputCaps `xml:"Platen>PlatenInputCaps"`
+	AdfSimplex   *inputCaps `xml:"Adf>AdfSimplexInputCaps"`
+	AdfDuplex    *inputCaps `xml:"Adf>AdfDuplexInputCaps"`
+	AdfOptions   []string   `xml:"Adf>AdfOptions>AdfOption"`
+}
+
+type inputCaps struct {
+	SettingProfiles []settingProfile `xml:"SettingProfiles>SettingProfile"`
+}
+
+type settingProfile struct {
+	ColorModes         []string     `xml:"ColorModes>ColorMode"`
+	DocumentFormats    []string     `xml:"DocumentFormats>DocumentFormat"`
+	DocumentFormatsExt []string     `xml:"DocumentFormats>DocumentFormatExt"`
+	Resolutions        []resolution `xml:"SupportedResolutions>DiscreteResolutions>DiscreteResolution"`
+}
+
+type resolution struct {
+	X int `xml:"XResolution"`
+	Y int `xml:"YResolution"`
+}
+
+func (c capabilities) summary() *Capabilities {
+	s := &Capabilities{}
+	var inputs []*inputCaps
+	if c.Platen != nil {
+		s.Sources = append(s.Sources, "Platen")
+		inputs = append(inputs, c.Platen)
+	}
+	if c.AdfSimplex != nil || c.AdfDuplex != nil {
+		s.Sources = append(s.Sources, "Feeder")
+		inputs = append(inputs, c.AdfSimplex, c.AdfDuplex)
+	}
+	s.Duplex = c.AdfDuplex != nil
+	for _, o := range c.AdfOptions {
+		if o == "Duplex" {
+			s.Duplex = true
+		}
+	}
+
+	resolutions := map[int]bool{}
+	colorModes := map[string]bool{}
+	formats := map[string]bool{}
+	for _, in := range inputs {
+		if in == nil {
+			continue
+		}
+		for _, p := range in.SettingProfiles {
+			for _, r := range p.Resolutions {
+				resolutions[r.X] = true
+			}
+			for _, m := range p.ColorModes {
+				colorModes[m] = true
+			}
+			for _, f := range append(p.DocumentFormats, p.DocumentFormatsExt...) {
+				formats[f] = true
+			}
+		}
+	}
+
+	for r := range resolutions {
+		s.Resolutions = append(s.Resolutions, r)
+	}
+	sort.Ints(s.Resolutions)
+	s.ColorModes = sortedKeys(colorModes)
+	s.Formats = sortedKeys(formats)
+	return s
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
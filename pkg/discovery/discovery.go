@@ -0,0 +1,191 @@
+// Package discovery finds the eSCL (AirScan) scanners of the local network
+// through DNS-SD and queries their capabilities and status.
+package discovery
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brutella/dnssd"
+	"github.com/sirupsen/logrus"
+	"github.com/stapelberg/airscan"
+)
+
+var log = logrus.StandardLogger().WithField("package", "discovery")
+
+const queryTimeout = 10 * time.Second
+
+type Scanner struct {
+	// Name is the human-readable name announced by the scanner
+	Name string `json:"name"`
+	// Address can be passed as --scanner-name to the ingestor
+	Address      string        `json:"address"`
+	Host         string        `json:"host"`
+	MakeAndModel string        `json:"makeAndModel,omitempty"`
+	UUID         string        `json:"uuid,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Status       *Status       `json:"status,omitempty"`
+	// Error is set when the scanner couldn't be queried
+	Error string `json:"error,omitempty"`
+}
+
+type Capabilities struct {
+	// Sources are the eSCL input sources: Platen and / or Feeder
+	Sources     []string `json:"sources"`
+	Duplex      bool     `json:"duplex"`
+	Resolutions []int    `json:"resolutions"`
+	ColorModes  []string `json:"colorModes"`
+	Formats     []string `json:"formats"`
+}
+
+type Status struct {
+	// State is Idle, Processing, Testing or Stopped
+	State string `xml:"State" json:"state"`
+	// ADFState tells whether there's paper in the feeder, e.g. ScannerAdfLoaded
+	ADFState string `xml:"AdfState" json:"adfState,omitempty"`
+}
+
+// Discover browses the network for eSCL scanners until the context is done,
+// then queries each of them
+func Discover(ctx context.Context) ([]Scanner, error) {
+	var mu sync.Mutex
+	entries := map[string]dnssd.BrowseEntry{}
+	add := func(e dnssd.BrowseEntry) {
+		log.Debugf("found %s (%s:%d)", e.Name, e.Host, e.Port)
+		mu.Lock()
+		entries[e.Name] = e
+		mu.Unlock()
+	}
+	remove := func(e dnssd.BrowseEntry) {
+		mu.Lock()
+		delete(entries, e.Name)
+		mu.Unlock()
+	}
+
+	err := dnssd.LookupType(ctx, airscan.ServiceName, add, remove)
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("unable to browse for scanners: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	scanners := make([]Scanner, len(entries))
+	wg := sync.WaitGroup{}
+	i := 0
+	for _, e := range entries {
+		wg.Add(1)
+		go func(i int, e dnssd.BrowseEntry) {
+			defer wg.Done()
+			scanners[i] = describe(e)
+		}(i, e)
+		i++
+	}
+	wg.Wait()
+
+	sort.Slice(scanners, func(i, j int) bool {
+		return scanners[i].Name < scanners[j].Name
+	})
+	return scanners, nil
+}
+
+func describe(e dnssd.BrowseEntry) Scanner {
+	s := Scanner{
+		Name:    humanName(e),
+		Address: address(e),
+		Host:    e.Host,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	client := airscan.NewClientForService(&e).HTTPClient
+	baseUrl := "http://" + net.JoinHostPort(e.Host, strconv.Itoa(e.Port)) + "/" + resourcePath(e)
+	if err := Query(ctx, client, baseUrl, &s); err != nil {
+		log.Warnf("unable to query %s: %v", s.Name, err)
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// HTTPClient is satisfied by *http.Client
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Query fills the capabilities and the status of the scanner reachable at
+// baseUrl (e.g. http://192.168.1.10/eSCL)
+func Query(ctx context.Context, client HTTPClient, baseUrl string, s *Scanner) error {
+	var c capabilities
+	if err := get(ctx, client, baseUrl+"/ScannerCapabilities", &c); err != nil {
+		return fmt.Errorf("unable to get capabilities: %w", err)
+	}
+	s.MakeAndModel = c.MakeAndModel
+	s.UUID = c.UUID
+	s.Capabilities = c.summary()
+
+	var status Status
+	if err := get(ctx, client, baseUrl+"/ScannerStatus", &status); err != nil {
+		return fmt.Errorf("unable to get status: %w", err)
+	}
+	s.Status = &status
+	return nil
+}
+
+func get(ctx context.Context, client HTTPClient, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+func humanName(e dnssd.BrowseEntry) string {
+	if ty := e.Text["ty"]; ty != "" {
+		return ty
+	}
+	return strings.ReplaceAll(e.Name, "\\", "")
+}
+
+// address prefers an IPv4 address, since mDNS host names don't resolve
+// everywhere
+func address(e dnssd.BrowseEntry) string {
+	port := strconv.Itoa(e.Port)
+	for _, ip := range e.IPs {
+		if ip.To4() != nil {
+			return net.JoinHostPort(ip.String(), port)
+		}
+	}
+	if len(e.IPs) > 0 {
+		return net.JoinHostPort(e.IPs[0].String(), port)
+	}
+	return net.JoinHostPort(e.Host, port)
+}
+
+func resourcePath(e dnssd.BrowseEntry) string {
+	if rs := strings.Trim(e.Text["rs"], "/"); rs != "" {
+		return rs
+	}
+	return "eSCL"
+}
@@ -0,0 +1,85 @@
+package discovery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/discovery"
+)
+
+const capabilitiesXml = `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerCapabilities xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:MakeAndModel>Brother ADS-1700W</pwg:MakeAndModel>
+  <scan:UUID>e3248000-80ce-11db-8000-30055c8f6e4d</scan:UUID>
+  <scan:Adf>
+    <scan:AdfSimplexInputCaps>
+      <scan:SettingProfiles>
+        <scan:SettingProfile>
+          <scan:ColorModes>
+            <scan:ColorMode>Grayscale8</scan:ColorMode>
+            <scan:ColorMode>RGB24</scan:ColorMode>
+          </scan:ColorModes>
+          <scan:DocumentFormats>
+            <pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat>
+            <scan:DocumentFormatExt>application/pdf</scan:DocumentFormatExt>
+          </scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:DiscreteResolutions>
+              <scan:DiscreteResolution><scan:XResolution>300</scan:XResolution><scan:YResolution>300</scan:YResolution></scan:DiscreteResolution>
+              <scan:DiscreteResolution><scan:XResolution>100</scan:XResolution><scan:YResolution>100</scan:YResolution></scan:DiscreteResolution>
+              <scan:DiscreteResolution><scan:XResolution>600</scan:XResolution><scan:YResolution>600</scan:YResolution></scan:DiscreteResolution>
+            </scan:DiscreteResolutions>
+          </scan:SupportedResolutions>
+        </scan:SettingProfile>
+      </scan:SettingProfiles>
+    </scan:AdfSimplexInputCaps>
+    <scan:AdfOptions>
+      <scan:AdfOption>Duplex</scan:AdfOption>
+    </scan:AdfOptions>
+  </scan:Adf>
+</scan:ScannerCapabilities>`
+
+const statusXml = `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerStatus xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm" xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:State>Idle</pwg:State>
+  <scan:AdfState>ScannerAdfLoaded</scan:AdfState>
+</scan:ScannerStatus>`
+
+func TestQuery(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eSCL/ScannerCapabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(capabilitiesXml))
+	})
+	mux.HandleFunc("/eSCL/ScannerStatus", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(statusXml))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var s discovery.Scanner
+	if err := discovery.Query(context.Background(), srv.Client(), srv.URL+"/eSCL", &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.MakeAndModel != "Brother ADS-1700W" {
+		t.Fatalf("unexpected make and model %q", s.MakeAndModel)
+	}
+	expected := &discovery.Capabilities{
+		Sources:     []string{"Feeder"},
+		Duplex:      true,
+		Resolutions: []int{100, 300, 600},
+		ColorModes:  []string{"Grayscale8", "RGB24"},
+		Formats:     []string{"application/pdf", "image/jpeg"},
+	}
+	if !reflect.DeepEqual(s.Capabilities, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, s.Capabilities)
+	}
+	if s.Status == nil || s.Status.State != "Idle" || s.Status.ADFState != "ScannerAdfLoaded" {
+		t.Fatalf("unexpected status %+v", s.Status)
+	}
+}
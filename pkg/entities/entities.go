@@ -0,0 +1,199 @@
+// Package entities finds the identifiers in the text of a document: IBANs,
+// Swiss company UIDs, AHV numbers, phone numbers, email addresses and the
+// reference numbers that follow a label ("Kundennummer", "N° de police",
+// ...). Identifiers with a checksum are only returned when it's valid.
+package entities
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+const (
+	TypeIban           = "iban"
+	TypeUid            = "uid"
+	TypeAhv            = "ahv"
+	TypePhone          = "phone"
+	TypeEmail          = "email"
+	TypeCustomerNumber = "customerNumber"
+	TypePolicyNumber   = "policyNumber"
+	TypeInvoiceNumber  = "invoiceNumber"
+)
+
+// Extractor finds one or more types of entities in a text
+type Extractor interface {
+	Extract(text string) []models.Entity
+}
+
+// ExtractorFunc is a function used as an Extractor
+type ExtractorFunc func(text string) []models.Entity
+
+func (f ExtractorFunc) Extract(text string) []models.Entity {
+	return f(text)
+}
+
+// Default are the extractors used when none is given to Find
+var Default = []Extractor{
+	ExtractorFunc(Ibans),
+	ExtractorFunc(Uids),
+	ExtractorFunc(AhvNumbers),
+	ExtractorFunc(PhoneNumbers),
+	ExtractorFunc(Emails),
+	ExtractorFunc(References),
+}
+
+// Find runs the extractors on the text and returns the entities they found,
+// each one only once
+func Find(text string, extractors ...Extractor) []models.Entity {
+	if len(extractors) == 0 {
+		extractors = Default
+	}
+	var found []models.Entity
+	seen := map[models.Entity]bool{}
+	for _, e := range extractors {
+		for _, entity := range e.Extract(text) {
+			key := models.Entity{Type: entity.Type, Value: entity.Value}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			found = append(found, entity)
+		}
+	}
+	return found
+}
+
+// Values returns the values an identifier written as text is stored with,
+// so that it can be searched as it's written on the document: "044 123 45
+// 67" is stored as +41441234567 and "CHE 109 322 551" as CHE-109.322.551.
+// The text without spaces, in lower and upper case, is also returned for
+// the identifiers that aren't recognized without their context (e.g. a
+// customer number without its label).
+func Values(text string) []string {
+	var values []string
+	seen := map[string]bool{}
+	add := func(v string) {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	text = strings.TrimSpace(text)
+	// The IBANs and UIDs are only recognized in upper case
+	for _, e := range Find(text + "\n" + strings.ToUpper(text)) {
+		add(e.Value)
+	}
+	compact := strings.Join(strings.Fields(text), "")
+	add(compact)
+	add(strings.ToLower(compact))
+	add(strings.ToUpper(compact))
+	return values
+}
+
+var (
+	email = regexp.MustCompile(`(?i)[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}`)
+
+	// Swiss numbers: 044 123 45 67, +41 44 123 45 67, +41 (0)44 123 45 67,
+	// 0041 79 123 45 67
+	swissPhone = regexp.MustCompile(`(?:(?:\+|00)41\s?(?:\(0\)\s?)?|0)(\d{2})[\s/.-]?(\d{3})[\s.-]?(\d{2})[\s.-]?(\d{2})`)
+	// Other international numbers, only with the leading +
+	internationalPhone = regexp.MustCompile(`\+([1-9]\d{0,2})((?:[\s.-]?\d){6,12})`)
+)
+
+// Emails returns the email addresses, lower-cased
+func Emails(text string) []models.Entity {
+	var found []models.Entity
+	for _, m := range email.FindAllString(text, -1) {
+		m = strings.TrimRight(m, ".")
+		found = append(found, models.Entity{Type: TypeEmail, Value: strings.ToLower(m), Text: m})
+	}
+	return found
+}
+
+// PhoneNumbers returns the Swiss phone numbers and the international ones
+// written with their country code, in the E.164 format (+41441234567)
+func PhoneNumbers(text string) []models.Entity {
+	var found []models.Entity
+	var taken [][]int
+	for _, m := range swissPhone.FindAllStringSubmatchIndex(text, -1) {
+		if !isolated(text, m[0], m[1]) {
+			continue
+		}
+		taken = append(taken, m[:2])
+		value := "+41" + text[m[2]:m[3]] + text[m[4]:m[5]] + text[m[6]:m[7]] + text[m[8]:m[9]]
+		found = append(found, models.Entity{Type: TypePhone, Value: value, Text: text[m[0]:m[1]]})
+	}
+	for _, m := range internationalPhone.FindAllStringSubmatchIndex(text, -1) {
+		if overlaps(taken, m[0], m[1]) || !isolated(text, m[0], m[1]) {
+			continue
+		}
+		value := "+" + digits(text[m[2]:m[5]])
+		found = append(found, models.Entity{Type: TypePhone, Value: value, Text: text[m[0]:m[1]]})
+	}
+	return found
+}
+
+// References returns the customer, policy and invoice numbers that follow
+// their label, on the same line
+func References(text string) []models.Entity {
+	var found []models.Entity
+	for _, r := range references {
+		for _, m := range r.expr.FindAllStringSubmatch(text, -1) {
+			value := strings.TrimRight(m[1], ".-/")
+			if !strings.ContainsAny(value, "0123456789") {
+				continue
+			}
+			found = append(found, models.Entity{Type: r.kind, Value: strings.ToUpper(value), Text: value})
+		}
+	}
+	return found
+}
+
+// The labels are followed by separators ("Nr.", ":", "#", "°") and the
+// number, which must contain a digit
+const referenceValue = `[ \t.:#°]+(?:nummer|number|numéro|numero|nr\.?|no\.?|n°|#)?[ \t.:#°]*([A-Z0-9][A-Z0-9./-]*)`
+
+var references = []struct {
+	kind string
+	expr *regexp.Regexp
+}{
+	{TypeCustomerNumber, regexp.MustCompile(`(?i)\b(?:kunden-?(?:nr|nummer)|kd\.?-?nr|kundennummer|kunde|n° client|n° de client|numéro de client|no\.? client|numero cliente|n\.? cliente|cliente n|customer(?: no| number| id)?|client(?: no| number| id))` + referenceValue)},
+	{TypePolicyNumber, regexp.MustCompile(`(?i)\b(?:police(?:n)?-?(?:nr|nummer)?|versicherungs-?(?:nr|nummer)|n° de police|numéro de police|polizza|numero di polizza|policy(?: no| number)?)` + referenceValue)},
+	{TypeInvoiceNumber, regexp.MustCompile(`(?i)\b(?:rechnungs-?(?:nr|nummer)|rechnung|facture|n° de facture|numéro de facture|fattura|numero (?:di )?fattura|invoice(?: no| number)?)` + referenceValue)},
+}
+
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isolated checks that the match isn't part of a longer number or word
+func isolated(text string, start int, end int) bool {
+	if start > 0 && isAlphanumeric(text[start-1]) {
+		return false
+	}
+	if end < len(text) && isAlphanumeric(text[end]) {
+		return false
+	}
+	return true
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func overlaps(taken [][]int, start int, end int) bool {
+	for _, t := range taken {
+		if start < t[1] && end > t[0] {
+			return true
+		}
+	}
+	return false
+}
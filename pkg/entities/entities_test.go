@@ -0,0 +1,83 @@
+package entities_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/entities"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+func TestFind(t *testing.T) {
+	text := "Muster Versicherungen AG, CHE-109.322.551 MWST\n" +
+		"Telefon 044 123 45 67, Fax +41 (0)44 123 45 68\n" +
+		"E-Mail: Kundendienst@Muster.ch.\n" +
+		"Kundennummer: 4711-0815\n" +
+		"Police Nr. 12.345.678\n" +
+		"Rechnung Nr. 2024-001 vom 12.03.2024\n" +
+		"Versicherte Person: AHV-Nr. 756.9217.0769.85\n" +
+		"Zahlbar auf IBAN CH93 0076 2011 6238 5295 7 CHF\n" +
+		"Ungültig: CH93 0076 2011 6238 5295 8, CHE-123.456.789, 756.9217.0769.84\n" +
+		"Kunde seit 2010"
+
+	expected := []models.Entity{
+		{Type: entities.TypeIban, Value: "CH9300762011623852957", Text: "CH93 0076 2011 6238 5295 7"},
+		{Type: entities.TypeUid, Value: "CHE-109.322.551", Text: "CHE-109.322.551"},
+		{Type: entities.TypeAhv, Value: "756.9217.0769.85", Text: "756.9217.0769.85"},
+		{Type: entities.TypePhone, Value: "+41441234567", Text: "044 123 45 67"},
+		{Type: entities.TypePhone, Value: "+41441234568", Text: "+41 (0)44 123 45 68"},
+		{Type: entities.TypeEmail, Value: "kundendienst@muster.ch", Text: "Kundendienst@Muster.ch"},
+		{Type: entities.TypeCustomerNumber, Value: "4711-0815", Text: "4711-0815"},
+		{Type: entities.TypePolicyNumber, Value: "12.345.678", Text: "12.345.678"},
+		{Type: entities.TypeInvoiceNumber, Value: "2024-001", Text: "2024-001"},
+	}
+
+	got := entities.Find(text)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d entities, got %d: %+v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], got[i])
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	tests := map[string]string{
+		"044 123 45 67":              "[+41441234567 0441234567]",
+		"+41 (0)44 123 45 67":        "[+41441234567 +41(0)441234567]",
+		"CHE 109 322 551":            "[CHE-109.322.551 CHE109322551 che109322551]",
+		"756 9217 0769 85":           "[756.9217.0769.85 7569217076985]",
+		"ch93 0076 2011 6238 5295 7": "[CH9300762011623852957 ch9300762011623852957]",
+		"Kundendienst@Muster.ch":     "[kundendienst@muster.ch Kundendienst@Muster.ch KUNDENDIENST@MUSTER.CH]",
+		" 4711-0815 ":                "[4711-0815]",
+		"":                           "[]",
+	}
+	for text, expected := range tests {
+		if got := fmt.Sprint(entities.Values(text)); got != expected {
+			t.Errorf("%q: expected %s, got %s", text, expected, got)
+		}
+	}
+}
+
+func TestFindExtractors(t *testing.T) {
+	text := "Rechnung Nr. 42, info@example.com, info@example.com"
+	got := entities.Find(text, entities.ExtractorFunc(entities.Emails))
+	if len(got) != 1 || got[0].Value != "info@example.com" {
+		t.Errorf("expected a single email, got %+v", got)
+	}
+}
+
+func TestInternationalPhoneNumbers(t *testing.T) {
+	got := entities.PhoneNumbers("Tel. +49 30 1234567, Ref. 0041 79 123 45 67")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 phone numbers, got %+v", got)
+	}
+	if got[0].Value != "+41791234567" {
+		t.Errorf("expected +41791234567, got %s", got[0].Value)
+	}
+	if got[1].Value != "+49301234567" {
+		t.Errorf("expected +49301234567, got %s", got[1].Value)
+	}
+}
@@ -0,0 +1,120 @@
+package entities
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/almerlucke/go-iban/iban"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+var (
+	// The candidates can be longer than the IBAN when it's followed by other
+	// uppercase words, the checksum tells where it ends
+	ibanCandidate = regexp.MustCompile(`[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,32}`)
+	// CHE-123.456.789, CHE 123 456 789, CHE123456789
+	uid = regexp.MustCompile(`CHE[\s.-]?(\d{3})[\s.]?(\d{3})[\s.]?(\d{3})`)
+	// 756.1234.5678.97
+	ahv = regexp.MustCompile(`756[\s.]?(\d{4})[\s.]?(\d{4})[\s.]?(\d{2})`)
+)
+
+// Ibans returns the IBANs with a valid checksum, without spaces
+func Ibans(text string) []models.Entity {
+	var found []models.Entity
+	for _, m := range ibanCandidate.FindAllStringIndex(text, -1) {
+		if m[0] > 0 && isAlphanumeric(text[m[0]-1]) {
+			continue
+		}
+		candidate := text[m[0]:m[1]]
+		compact := strings.ReplaceAll(candidate, " ", "")
+		for n := len(compact); n >= 15; n-- {
+			i, err := iban.NewIBAN(compact[:n])
+			if err != nil {
+				continue
+			}
+			found = append(found, models.Entity{Type: TypeIban, Value: i.Code, Text: prefix(candidate, n)})
+			break
+		}
+	}
+	return found
+}
+
+// Uids returns the Swiss company identification numbers with a valid
+// checksum, formatted as CHE-123.456.789
+func Uids(text string) []models.Entity {
+	var found []models.Entity
+	for _, m := range uid.FindAllStringSubmatchIndex(text, -1) {
+		if !isolated(text, m[0], m[1]) {
+			continue
+		}
+		d := text[m[2]:m[3]] + text[m[4]:m[5]] + text[m[6]:m[7]]
+		if !validUid(d) {
+			continue
+		}
+		value := "CHE-" + d[0:3] + "." + d[3:6] + "." + d[6:9]
+		found = append(found, models.Entity{Type: TypeUid, Value: value, Text: text[m[0]:m[1]]})
+	}
+	return found
+}
+
+// AhvNumbers returns the Swiss social security numbers (AHV / AVS) with a
+// valid checksum, formatted as 756.1234.5678.97
+func AhvNumbers(text string) []models.Entity {
+	var found []models.Entity
+	for _, m := range ahv.FindAllStringSubmatchIndex(text, -1) {
+		if !isolated(text, m[0], m[1]) {
+			continue
+		}
+		d := "756" + text[m[2]:m[3]] + text[m[4]:m[5]] + text[m[6]:m[7]]
+		if !validEan13(d) {
+			continue
+		}
+		value := d[0:3] + "." + d[3:7] + "." + d[7:11] + "." + d[11:13]
+		found = append(found, models.Entity{Type: TypeAhv, Value: value, Text: text[m[0]:m[1]]})
+	}
+	return found
+}
+
+// validUid checks the modulo 11 check digit of the 9 digits of a UID
+func validUid(d string) bool {
+	weights := []int{5, 4, 3, 2, 7, 6, 5, 4}
+	sum := 0
+	for i, w := range weights {
+		sum += int(d[i]-'0') * w
+	}
+	check := 11 - sum%11
+	if check == 11 {
+		check = 0
+	}
+	return check != 10 && check == int(d[8]-'0')
+}
+
+// validEan13 checks the check digit of a 13 digits EAN, which AHV numbers
+// use
+func validEan13(d string) bool {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		v := int(d[i] - '0')
+		if i%2 == 1 {
+			v *= 3
+		}
+		sum += v
+	}
+	return (10-sum%10)%10 == int(d[12]-'0')
+}
+
+// prefix returns the beginning of s that holds n characters other than
+// spaces
+func prefix(s string, n int) string {
+	for i, c := range s {
+		if c == ' ' {
+			continue
+		}
+		if n == 0 {
+			return strings.TrimSpace(s[:i])
+		}
+		n--
+	}
+	return s
+}
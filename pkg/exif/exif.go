@@ -0,0 +1,214 @@
+// Package exif reads the date a photo was taken and its orientation from the
+// EXIF metadata of a JPEG file. Only the few tags needed for that are parsed.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when the file has no EXIF date
+var ErrNotFound = errors.New("exif date not found")
+
+const dateLayout = "2006:01:02 15:04:05"
+
+const (
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+// DateTime returns the date the picture was taken, falling back to the
+// digitization and modification dates. EXIF dates don't have a time zone:
+// they're returned in the local one.
+func DateTime(jpeg []byte) (time.Time, error) {
+	tiff, err := findExif(jpeg)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	p, err := newParser(tiff)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ifd0, err := p.readIFD(p.uint32(4))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var exifIFD map[uint16]entry
+	if e, ok := ifd0[tagExifIFD]; ok && e.typ == typeLong {
+		exifIFD, err = p.readIFD(e.value)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	for _, e := range []struct {
+		ifd map[uint16]entry
+		tag uint16
+	}{
+		{exifIFD, tagDateTimeOriginal},
+		{exifIFD, tagDateTimeDigitized},
+		{ifd0, tagDateTime},
+	} {
+		s, ok := p.ascii(e.ifd, e.tag)
+		if !ok {
+			continue
+		}
+		t, err := time.ParseInLocation(dateLayout, s, time.Local)
+		if err != nil {
+			// Cameras without a clock write things like "0000:00:00 00:00:00"
+			continue
+		}
+		return t, nil
+	}
+	return time.Time{}, ErrNotFound
+}
+
+// Orientation returns how the picture must be transformed to be displayed
+// upright, as the EXIF value: 1 is upright, 3 is upside down, 6 and 8 are
+// rotated by 90° and the other values are mirrored. Pictures without an
+// orientation are upright.
+func Orientation(jpeg []byte) (int, error) {
+	tiff, err := findExif(jpeg)
+	if errors.Is(err, ErrNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := newParser(tiff)
+	if err != nil {
+		return 0, err
+	}
+	ifd0, err := p.readIFD(p.uint32(4))
+	if err != nil {
+		return 0, err
+	}
+	e, ok := ifd0[tagOrientation]
+	if !ok || e.typ != typeShort || e.count != 1 {
+		return 1, nil
+	}
+	o := int(p.order.Uint16(e.inline))
+	if o < 1 || o > 8 {
+		return 1, nil
+	}
+	return o, nil
+}
+
+// findExif returns the TIFF structure stored in the APP1 segment of a JPEG
+func findExif(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+	pos := 2
+	for pos+4 <= len(b) {
+		if b[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid marker at offset %d", pos)
+		}
+		marker := b[pos+1]
+		if marker == 0xD9 || marker == 0xDA {
+			// End of image or start of the compressed data: no more metadata
+			break
+		}
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if length < 2 || pos+2+length > len(b) {
+			return nil, fmt.Errorf("invalid segment length at offset %d", pos)
+		}
+		segment := b[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+		pos += 2 + length
+	}
+	return nil, ErrNotFound
+}
+
+type entry struct {
+	typ   uint16
+	count uint32
+	// value is the offset of the data, or the data itself when it fits in
+	// four bytes
+	value  uint32
+	inline []byte
+}
+
+type parser struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func newParser(tiff []byte) (*parser, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("TIFF header too short")
+	}
+	p := &parser{b: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		p.order = binary.LittleEndian
+	case "MM":
+		p.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid byte order %q", tiff[:2])
+	}
+	if p.order.Uint16(tiff[2:]) != 42 {
+		return nil, fmt.Errorf("invalid TIFF header")
+	}
+	return p, nil
+}
+
+func (p *parser) uint32(offset int) uint32 {
+	return p.order.Uint32(p.b[offset:])
+}
+
+func (p *parser) readIFD(offset uint32) (map[uint16]entry, error) {
+	if int(offset)+2 > len(p.b) {
+		return nil, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+	n := int(p.order.Uint16(p.b[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(p.b) {
+		return nil, fmt.Errorf("IFD at offset %d is truncated", offset)
+	}
+
+	entries := make(map[uint16]entry, n)
+	for i := 0; i < n; i++ {
+		e := p.b[start+i*12 : start+(i+1)*12]
+		entries[p.order.Uint16(e)] = entry{
+			typ:    p.order.Uint16(e[2:]),
+			count:  p.order.Uint32(e[4:]),
+			value:  p.order.Uint32(e[8:]),
+			inline: e[8:12],
+		}
+	}
+	return entries, nil
+}
+
+func (p *parser) ascii(ifd map[uint16]entry, tag uint16) (string, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.typ != typeASCII {
+		return "", false
+	}
+	var data []byte
+	if e.count <= 4 {
+		data = e.inline[:e.count]
+	} else {
+		end := uint64(e.value) + uint64(e.count)
+		if end > uint64(len(p.b)) {
+			return "", false
+		}
+		data = p.b[e.value:end]
+	}
+	return string(bytes.TrimRight(data, "\x00 ")), true
+}
@@ -0,0 +1,179 @@
+package exif_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/exif"
+)
+
+func jpegImage(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type tag struct {
+	id    uint16
+	value string
+}
+
+// withExif inserts an APP1 segment with an IFD0 holding DateTime and, when
+// set, an Exif IFD holding DateTimeOriginal
+func withExif(img []byte, order binary.ByteOrder, dateTime string, original string) []byte {
+	tiff := bytes.NewBuffer(nil)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	_ = binary.Write(tiff, order, uint16(42))
+	_ = binary.Write(tiff, order, uint32(8))
+
+	ifd0 := []tag{{0x0132, dateTime}}
+	var exifIFD []tag
+	if original != "" {
+		exifIFD = []tag{{0x9003, original}}
+	}
+
+	// IFD0 at 8, followed by its data, then the Exif IFD and its data
+	ifd0Size := 2 + 12*(len(ifd0)+1) + 4
+	data := 8 + ifd0Size
+	exifOffset := data + len(dateTime) + 1
+
+	writeEntry := func(id uint16, typ uint16, count uint32, value uint32) {
+		_ = binary.Write(tiff, order, id)
+		_ = binary.Write(tiff, order, typ)
+		_ = binary.Write(tiff, order, count)
+		_ = binary.Write(tiff, order, value)
+	}
+
+	_ = binary.Write(tiff, order, uint16(len(ifd0)+1))
+	writeEntry(0x0132, 2, uint32(len(dateTime)+1), uint32(data))
+	writeEntry(0x8769, 4, 1, uint32(exifOffset))
+	_ = binary.Write(tiff, order, uint32(0))
+	tiff.WriteString(dateTime + "\x00")
+
+	exifData := exifOffset + 2 + 12*len(exifIFD) + 4
+	_ = binary.Write(tiff, order, uint16(len(exifIFD)))
+	for _, e := range exifIFD {
+		writeEntry(e.id, 2, uint32(len(e.value)+1), uint32(exifData))
+	}
+	_ = binary.Write(tiff, order, uint32(0))
+	for _, e := range exifIFD {
+		tiff.WriteString(e.value + "\x00")
+	}
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := bytes.NewBuffer(nil)
+	out.Write(img[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(img[2:])
+	return out.Bytes()
+}
+
+func TestDateTime(t *testing.T) {
+	img := jpegImage(t)
+	tests := []struct {
+		name     string
+		order    binary.ByteOrder
+		dateTime string
+		original string
+		expected time.Time
+	}{
+		{"original", binary.BigEndian, "2023:05:01 10:00:00", "2023:04:30 18:12:45", time.Date(2023, 4, 30, 18, 12, 45, 0, time.Local)},
+		{"little endian", binary.LittleEndian, "2023:05:01 10:00:00", "2023:04:30 18:12:45", time.Date(2023, 4, 30, 18, 12, 45, 0, time.Local)},
+		{"modification date", binary.BigEndian, "2021:12:24 08:30:00", "", time.Date(2021, 12, 24, 8, 30, 0, 0, time.Local)},
+		{"invalid original", binary.LittleEndian, "2021:12:24 08:30:00", "0000:00:00 00:00:00", time.Date(2021, 12, 24, 8, 30, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := exif.DateTime(withExif(img, tt.order, tt.dateTime, tt.original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !d.Equal(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, d)
+			}
+		})
+	}
+}
+
+func TestDateTimeNotFound(t *testing.T) {
+	_, err := exif.DateTime(jpegImage(t))
+	if !errors.Is(err, exif.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := exif.DateTime([]byte("\x89PNG\r\n")); err == nil {
+		t.Fatal("expected an error for a PNG file")
+	}
+}
+
+// withOrientation inserts an APP1 segment with an IFD0 holding the
+// orientation
+func withOrientation(img []byte, order binary.ByteOrder, orientation uint16) []byte {
+	tiff := bytes.NewBuffer(nil)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	_ = binary.Write(tiff, order, uint16(42))
+	_ = binary.Write(tiff, order, uint32(8))
+	_ = binary.Write(tiff, order, uint16(1))
+	_ = binary.Write(tiff, order, uint16(0x0112))
+	_ = binary.Write(tiff, order, uint16(3))
+	_ = binary.Write(tiff, order, uint32(1))
+	_ = binary.Write(tiff, order, orientation)
+	_ = binary.Write(tiff, order, uint16(0))
+	_ = binary.Write(tiff, order, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := bytes.NewBuffer(nil)
+	out.Write(img[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(img[2:])
+	return out.Bytes()
+}
+
+func TestOrientation(t *testing.T) {
+	img := jpegImage(t)
+	tests := []struct {
+		name     string
+		img      []byte
+		expected int
+	}{
+		{"rotated", withOrientation(img, binary.BigEndian, 6), 6},
+		{"little endian", withOrientation(img, binary.LittleEndian, 3), 3},
+		{"invalid", withOrientation(img, binary.BigEndian, 9), 1},
+		{"no orientation", withExif(img, binary.BigEndian, "2021:12:24 08:30:00", ""), 1},
+		{"no EXIF", img, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := exif.Orientation(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, o)
+			}
+		})
+	}
+
+	if _, err := exif.Orientation([]byte("\x89PNG\r\n")); err == nil {
+		t.Fatal("expected an error for a PNG file")
+	}
+}
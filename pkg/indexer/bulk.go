@@ -0,0 +1,287 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// BulkConfig sets when the documents are sent with a bulk request: as soon
+// as FlushDocuments documents or FlushBytes bytes are waiting, or
+// FlushInterval after the first one
+type BulkConfig struct {
+	FlushDocuments int
+	FlushBytes     int
+	FlushInterval  time.Duration
+
+	// MaxRetries is the number of times a request, or a document, rejected
+	// with 429 or 503 is sent again. The first retry waits RetryBackoff,
+	// each following one twice as long as the previous one.
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+var DefaultBulkConfig = BulkConfig{
+	FlushDocuments: 100,
+	FlushBytes:     5 << 20,
+	FlushInterval:  2 * time.Second,
+	MaxRetries:     3,
+	RetryBackoff:   500 * time.Millisecond,
+}
+
+// BulkItemError is returned for a document that OpenSearch rejected in a
+// bulk request
+type BulkItemError struct {
+	Id     string
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *BulkItemError) Error() string {
+	return fmt.Sprintf("unable to index %s: status %d: %s: %s", e.Id, e.Status, e.Type, e.Reason)
+}
+
+type bulkItem struct {
+	id string
+	// body is the action and the document, one per line
+	body []byte
+	done func(error)
+	// err is the reason of the last rejection, returned when there are no
+	// retries left
+	err error
+}
+
+// bulkBatch is the documents of a bulk request, sent is closed once they're
+// all indexed or have failed
+type bulkBatch struct {
+	items []bulkItem
+	sent  chan struct{}
+}
+
+type bulkIndexer struct {
+	transport opensearchapi.Transport
+	index     string
+	config    BulkConfig
+
+	mu      sync.Mutex
+	pending []bulkItem
+	size    int
+	timer   *time.Timer
+	// sending are the batches taken and not sent yet
+	sending map[*bulkBatch]struct{}
+}
+
+func newBulkIndexer(transport opensearchapi.Transport, index string, config BulkConfig) *bulkIndexer {
+	if config.FlushDocuments <= 0 {
+		config.FlushDocuments = DefaultBulkConfig.FlushDocuments
+	}
+	if config.FlushBytes <= 0 {
+		config.FlushBytes = DefaultBulkConfig.FlushBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultBulkConfig.FlushInterval
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultBulkConfig.RetryBackoff
+	}
+	return &bulkIndexer{
+		transport: transport,
+		index:     index,
+		config:    config,
+		sending:   map[*bulkBatch]struct{}{},
+	}
+}
+
+// add queues the document, done is called once it's indexed or has failed
+func (b *bulkIndexer) add(id string, d *models.Document, done func(error)) {
+	doc, err := json.Marshal(d)
+	if err != nil {
+		done(fmt.Errorf("unable to encode JSON: %v", err))
+		return
+	}
+	action, err := json.Marshal(map[string]any{"index": map[string]any{"_id": id}})
+	if err != nil {
+		done(err)
+		return
+	}
+	body := make([]byte, 0, len(action)+len(doc)+2)
+	body = append(append(body, action...), '\n')
+	body = append(append(body, doc...), '\n')
+
+	b.mu.Lock()
+	b.pending = append(b.pending, bulkItem{id: id, body: body, done: done})
+	b.size += len(body)
+	if len(b.pending) >= b.config.FlushDocuments || b.size >= b.config.FlushBytes {
+		batch := b.take()
+		b.mu.Unlock()
+		b.send(batch)
+		return
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.config.FlushInterval, b.flushPending)
+	}
+	b.mu.Unlock()
+}
+
+// take returns the pending documents, or nil when there are none, and marks
+// them as being sent, b.mu must be held
+func (b *bulkIndexer) take() *bulkBatch {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+	batch := &bulkBatch{items: b.pending, sent: make(chan struct{})}
+	b.pending = nil
+	b.size = 0
+	b.sending[batch] = struct{}{}
+	return batch
+}
+
+func (b *bulkIndexer) flushPending() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// flush sends the pending documents and waits until all the documents
+// added so far are indexed or have failed. The batches taken afterwards,
+// e.g. by the scans running concurrently, aren't waited for.
+func (b *bulkIndexer) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	sending := make([]*bulkBatch, 0, len(b.sending))
+	for s := range b.sending {
+		sending = append(sending, s)
+	}
+	b.mu.Unlock()
+
+	b.send(batch)
+	for _, s := range sending {
+		<-s.sent
+	}
+}
+
+func (b *bulkIndexer) send(batch *bulkBatch) {
+	if batch == nil {
+		return
+	}
+	defer func() {
+		b.mu.Lock()
+		delete(b.sending, batch)
+		b.mu.Unlock()
+		close(batch.sent)
+	}()
+	b.sendItems(batch.items)
+}
+
+func (b *bulkIndexer) sendItems(batch []bulkItem) {
+	backoff := b.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := b.sendOnce(batch)
+		if err != nil {
+			for _, item := range batch {
+				item.done(err)
+			}
+			return
+		}
+		if len(retry) == 0 {
+			return
+		}
+		if attempt >= b.config.MaxRetries {
+			for _, item := range retry {
+				item.done(item.err)
+			}
+			return
+		}
+		log.Debugf("retrying %d documents in %v", len(retry), backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		batch = retry
+	}
+}
+
+// sendOnce sends the documents and calls done for the ones indexed or
+// rejected for good, it returns the ones to send again
+func (b *bulkIndexer) sendOnce(batch []bulkItem) ([]bulkItem, error) {
+	var body bytes.Buffer
+	for _, item := range batch {
+		body.Write(item.body)
+	}
+	log.Debugf("indexing %d documents", len(batch))
+	req := opensearchapi.BulkRequest{
+		Index: b.index,
+		Body:  &body,
+	}
+	res, err := req.Do(context.Background(), b.transport)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if retryable(res.StatusCode) {
+		err := fmt.Errorf("opensearch returned %s", res.Status())
+		for n := range batch {
+			batch[n].err = err
+		}
+		return batch, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("opensearch returned an invalid status %s: %s", res.Status(), decodeError(res.Body))
+	}
+
+	var result struct {
+		Items []map[string]struct {
+			Id     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unable to decode bulk response: %v", err)
+	}
+	if len(result.Items) != len(batch) {
+		return nil, fmt.Errorf("expected %d items in the bulk response, got %d", len(batch), len(result.Items))
+	}
+
+	var retry []bulkItem
+	// The items are in the order of the request
+	for n, item := range result.Items {
+		r := item["index"]
+		if r.Error == nil && r.Status < 300 {
+			batch[n].done(nil)
+			continue
+		}
+		e := &BulkItemError{Id: batch[n].id, Status: r.Status}
+		if r.Error != nil {
+			e.Type, e.Reason = r.Error.Type, r.Error.Reason
+		}
+		if retryable(r.Status) {
+			batch[n].err = e
+			retry = append(retry, batch[n])
+			continue
+		}
+		batch[n].done(e)
+	}
+	return retry, nil
+}
+
+// retryable tells whether OpenSearch is only too busy to index the
+// documents now
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
@@ -0,0 +1,199 @@
+package indexer_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// fakeBulk accepts the index creation and answers the bulk requests with the
+// status given by the text of each document
+type fakeBulk struct {
+	mu       sync.Mutex
+	requests []int
+	attempts map[string]int
+	busy     int
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		fmt.Fprint(w, `{"acknowledged": true}`)
+		return
+	}
+	if f.busy > 0 {
+		f.busy--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"type": "es_rejected_execution_exception", "reason": "busy"}}`)
+		return
+	}
+
+	var items []any
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action struct {
+			Index struct {
+				Id string `json:"_id"`
+			} `json:"index"`
+		}
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		var doc models.Document
+		json.Unmarshal(scanner.Bytes(), &doc)
+
+		id := action.Index.Id
+		f.attempts[id]++
+		item := map[string]any{"_id": id, "status": 201}
+		switch {
+		case doc.Text == "invalid":
+			item["status"] = 400
+			item["error"] = map[string]any{"type": "mapper_parsing_exception", "reason": "failed to parse field [date]"}
+		case doc.Text == "retry" && f.attempts[id] == 1:
+			item["status"] = 429
+			item["error"] = map[string]any{"type": "es_rejected_execution_exception", "reason": "busy"}
+		}
+		items = append(items, map[string]any{"index": item})
+	}
+	f.requests = append(f.requests, len(items))
+	json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func newBulkIndexer(t *testing.T, f *fakeBulk, config indexer.BulkConfig) *indexer.Indexer {
+	t.Helper()
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	idx, err := indexer.New(server.URL, "", "", indexer.WithBulkIndexing(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestBulkIndexing(t *testing.T) {
+	f := &fakeBulk{attempts: map[string]int{}}
+	idx := newBulkIndexer(t, f, indexer.BulkConfig{
+		FlushDocuments: 2,
+		FlushInterval:  time.Hour,
+		MaxRetries:     2,
+		RetryBackoff:   time.Millisecond,
+	})
+
+	var mu sync.Mutex
+	results := map[string]error{}
+	for n, text := range []string{"ok", "invalid", "retry"} {
+		id := fmt.Sprintf("scan_%d", n+1)
+		idx.IndexDocumentAsync(id, &models.Document{Text: text}, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			results[id] = err
+		})
+	}
+	idx.Flush()
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %v", results)
+	}
+	if results["scan_1"] != nil {
+		t.Errorf("expected scan_1 to be indexed, got %v", results["scan_1"])
+	}
+	var itemErr *indexer.BulkItemError
+	if !errors.As(results["scan_2"], &itemErr) || itemErr.Status != 400 || itemErr.Type != "mapper_parsing_exception" {
+		t.Errorf("expected a mapping error for scan_2, got %v", results["scan_2"])
+	}
+	if results["scan_3"] != nil || f.attempts["scan_3"] != 2 {
+		t.Errorf("expected scan_3 to be indexed after a retry, got %v (%d attempts)", results["scan_3"], f.attempts["scan_3"])
+	}
+	// Two documents, then the last one when flushing and its retry
+	if fmt.Sprint(f.requests) != "[2 1 1]" {
+		t.Errorf("unexpected requests %v", f.requests)
+	}
+}
+
+func TestBulkIndexingInterval(t *testing.T) {
+	f := &fakeBulk{attempts: map[string]int{}, busy: 1}
+	idx := newBulkIndexer(t, f, indexer.BulkConfig{
+		FlushInterval: 10 * time.Millisecond,
+		RetryBackoff:  time.Millisecond,
+		MaxRetries:    1,
+	})
+
+	// IndexDocument waits for the bulk request, sent after the interval and
+	// retried once OpenSearch isn't busy anymore
+	if err := idx.IndexDocument("scan_1", &models.Document{Text: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.attempts["scan_1"] != 1 || len(f.requests) != 1 {
+		t.Errorf("unexpected requests %v", f.requests)
+	}
+}
+
+// blockingBulk holds the bulk requests with a document whose text is a key
+// of blocked until the channel is closed
+type blockingBulk struct {
+	*fakeBulk
+	blocked  map[string]chan struct{}
+	received chan string
+}
+
+func (f *blockingBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	for text, release := range f.blocked {
+		if bytes.Contains(body, []byte(`"text":"`+text+`"`)) {
+			f.received <- text
+			<-release
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.fakeBulk.ServeHTTP(w, r)
+}
+
+func TestBulkFlushConcurrentScans(t *testing.T) {
+	f := &blockingBulk{
+		fakeBulk: &fakeBulk{attempts: map[string]int{}},
+		blocked:  map[string]chan struct{}{"first": make(chan struct{}), "second": make(chan struct{})},
+		received: make(chan string),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	idx, err := indexer.New(server.URL, "", "", indexer.WithBulkIndexing(indexer.BulkConfig{
+		FlushDocuments: 2,
+		FlushInterval:  time.Hour,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { close(f.blocked["second"]) })
+
+	idx.IndexDocumentAsync("scan_1", &models.Document{Text: "first"}, func(error) {})
+	flushed := make(chan struct{})
+	go func() {
+		idx.Flush()
+		close(flushed)
+	}()
+	<-f.received
+
+	// The documents added by another scan once the flush has started aren't
+	// waited for
+	go idx.IndexDocumentAsync("scan_2", &models.Document{Text: "second"}, func(error) {})
+	go idx.IndexDocumentAsync("scan_3", &models.Document{Text: "ok"}, func(error) {})
+	<-f.received
+	close(f.blocked["first"])
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the flush waited for the documents added afterwards")
+	}
+}
@@ -0,0 +1,180 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+)
+
+// ErrDuplicate is returned by Index when the page is a duplicate of an
+// already indexed document and the deduplication mode is dedup.ModeSkip
+var ErrDuplicate = errors.New("duplicate page")
+
+// DefaultDeduplicationThreshold is the minimum score for two pages to be
+// considered duplicates
+const DefaultDeduplicationThreshold = 0.85
+
+const maxDuplicateCandidates = 10
+
+// isDeleted matches the documents in the trash: a page is not a duplicate of
+// a trashed copy, which is purged along with its stored file
+var isDeleted = map[string]any{
+	"exists": map[string]any{"field": "deletedAt"},
+}
+
+type duplicate struct {
+	Id    string
+	Score float64
+}
+
+type duplicateCandidate struct {
+	Id     string `json:"_id"`
+	Source struct {
+		Text           string `json:"text"`
+		Hash           string `json:"hash"`
+		PerceptualHash string `json:"perceptualHash"`
+	} `json:"_source"`
+}
+
+// findDuplicate looks for an already indexed document that is likely the same
+// page: the candidates are the documents with the same file hash or with a
+// similar text, outside of the trash. They're then scored by image and text
+// similarity.
+func (i *Indexer) findDuplicate(id string, hash string, perceptualHash string, text string) (*duplicate, error) {
+	should := []any{
+		map[string]any{"term": map[string]any{"hash.keyword": hash}},
+	}
+	if text != "" {
+		should = append(should, map[string]any{
+			"more_like_this": map[string]any{
+				"fields":          []string{"text"},
+				"like":            text,
+				"min_term_freq":   1,
+				"min_doc_freq":    1,
+				"max_query_terms": 50,
+			},
+		})
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"size":    maxDuplicateCandidates,
+		"_source": []string{"text", "hash", "perceptualHash"},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"should":               should,
+				"minimum_should_match": 1,
+				"must_not": []any{
+					map[string]any{"ids": map[string]any{"values": []string{id}}},
+					isDeleted,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req := opensearchapi.SearchRequest{
+		Index: []string{i.documentsIndex},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), i.opensearchClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("opensearch returned an invalid status %s: %s", res.Status(), decodeError(res.Body))
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []duplicateCandidate `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var best *duplicate
+	for _, c := range result.Hits.Hits {
+		score := i.duplicateScore(hash, perceptualHash, text, c)
+		log.Debugf("%s: duplicate candidate %s has score %.2f", id, c.Id, score)
+		if score < i.dedupThreshold {
+			continue
+		}
+		if best == nil || score > best.Score {
+			best = &duplicate{Id: c.Id, Score: score}
+		}
+	}
+	return best, nil
+}
+
+func (i *Indexer) duplicateScore(hash string, perceptualHash string, text string, c duplicateCandidate) float64 {
+	if c.Source.Hash == hash {
+		return 1
+	}
+
+	a, errA := dedup.ParseHash(perceptualHash)
+	b, errB := dedup.ParseHash(c.Source.PerceptualHash)
+	if errA != nil || errB != nil {
+		// Without an image hash (e.g. documents indexed before it existed)
+		// we can only rely on the text
+		return dedup.TextSimilarity(text, c.Source.Text)
+	}
+
+	hasText := text != "" && c.Source.Text != ""
+	return dedup.Score(
+		dedup.ImageSimilarity(a, b),
+		dedup.TextSimilarity(text, c.Source.Text),
+		hasText,
+	)
+}
+
+// HasHash returns whether a document with the given file hash exists outside
+// of the trash
+func (i *Indexer) HasHash(hash string) (bool, error) {
+	body, err := json.Marshal(map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must":     map[string]any{"term": map[string]any{"hash.keyword": hash}},
+				"must_not": isDeleted,
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	req := opensearchapi.CountRequest{
+		Index: []string{i.documentsIndex},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), i.opensearchClient)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		// Nothing has been indexed yet
+		return false, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("opensearch returned an invalid status %s: %s", res.Status(), decodeError(res.Body))
+	}
+
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Count > 0, nil
+}
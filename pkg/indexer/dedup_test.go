@@ -0,0 +1,244 @@
+package indexer_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+const letterText = "Dear customer, please find attached the invoice for the consulting services of March, payable within thirty days"
+
+// fakeOcr finds the text of the letter on every page
+type fakeOcr struct{}
+
+func (fakeOcr) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"textBlocks": []any{map[string]any{
+			"text":        letterText,
+			"lines":       []any{map[string]any{"text": letterText}},
+			"boundingBox": map[string]any{"top": 30, "bottom": 40, "left": 20, "right": 160},
+		}},
+	})
+}
+
+// fakeCandidates returns the same duplicate candidates for every search, the
+// id of a candidate is its _id and the other fields its _source. The
+// candidates in the trash are left out when the search excludes them.
+type fakeCandidates struct {
+	candidates []map[string]any
+
+	mu       sync.Mutex
+	searches []string
+}
+
+func (f *fakeCandidates) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/_search") {
+		fmt.Fprint(w, `{"acknowledged": true}`)
+		return
+	}
+	var body bytes.Buffer
+	body.ReadFrom(r.Body)
+	f.mu.Lock()
+	f.searches = append(f.searches, body.String())
+	f.mu.Unlock()
+
+	notDeleted := strings.Contains(body.String(), `"must_not":[`) &&
+		strings.Contains(body.String(), `{"exists":{"field":"deletedAt"}}`)
+	var hits []any
+	for _, c := range f.candidates {
+		if _, deleted := c["deletedAt"]; deleted && notDeleted {
+			continue
+		}
+		source := maps.Clone(c)
+		delete(source, "id")
+		hits = append(hits, map[string]any{"_id": c["id"], "_source": source})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"hits": hits}})
+}
+
+// letter draws a white page with some dark text lines
+func letter(t *testing.T, lines ...int) []byte {
+	img := image.NewGray(image.Rect(0, 0, 200, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.Gray{Y: 250}
+			for _, l := range lines {
+				if y >= l*3 && y < l*3+6 && x >= 20 && x < 160 {
+					c = color.Gray{Y: 20}
+				}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func perceptualHash(t *testing.T, page []byte) string {
+	h, err := dedup.PerceptualHash(bytes.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dedup.FormatHash(h)
+}
+
+func TestAnalyzeDuplicates(t *testing.T) {
+	page := letter(t, 10, 15, 30, 35, 80)
+	sum := sha1.Sum(page)
+	hash := hex.EncodeToString(sum[:])
+	pHash := perceptualHash(t, page)
+	otherPHash := perceptualHash(t, letter(t, 5, 50, 55, 60, 90))
+	const otherText = "Grocery receipt: milk, bread, eggs and coffee paid by card at the corner shop"
+
+	tests := []struct {
+		name       string
+		candidates []map[string]any
+		want       string
+		score      float64
+	}{
+		{
+			name:       "no candidates",
+			candidates: nil,
+		},
+		{
+			name:       "same file",
+			candidates: []map[string]any{{"id": "scan_1", "hash": hash, "text": otherText, "perceptualHash": otherPHash}},
+			want:       "scan_1",
+			score:      1,
+		},
+		{
+			name:       "rescan",
+			candidates: []map[string]any{{"id": "scan_1", "hash": "other", "text": letterText, "perceptualHash": pHash}},
+			want:       "scan_1",
+			score:      1,
+		},
+		{
+			// Indexed before the perceptual hash existed
+			name:       "text only",
+			candidates: []map[string]any{{"id": "scan_1", "hash": "other", "text": letterText}},
+			want:       "scan_1",
+			score:      1,
+		},
+		{
+			name:       "image only",
+			candidates: []map[string]any{{"id": "scan_1", "hash": "other", "perceptualHash": pHash}},
+			want:       "scan_1",
+			score:      1,
+		},
+		{
+			// The text weighs more than the image
+			name:       "same layout, other text",
+			candidates: []map[string]any{{"id": "scan_1", "hash": "other", "text": otherText, "perceptualHash": pHash}},
+		},
+		{
+			name:       "other page",
+			candidates: []map[string]any{{"id": "scan_1", "hash": "other", "text": otherText, "perceptualHash": otherPHash}},
+		},
+		{
+			// It will be purged along with its stored page
+			name:       "trashed copy",
+			candidates: []map[string]any{{"id": "scan_1", "hash": hash, "text": letterText, "perceptualHash": pHash, "deletedAt": "2024-01-01T00:00:00Z"}},
+		},
+		{
+			name: "best candidate",
+			candidates: []map[string]any{
+				{"id": "scan_1", "hash": "other", "text": otherText, "perceptualHash": pHash},
+				{"id": "scan_2", "hash": "other", "text": letterText, "perceptualHash": otherPHash},
+				{"id": "scan_3", "hash": "other", "text": letterText, "perceptualHash": pHash},
+			},
+			want:  "scan_3",
+			score: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []dedup.Mode{dedup.ModeFlag, dedup.ModeSkip} {
+				f := &fakeCandidates{candidates: tt.candidates}
+				opensearch := httptest.NewServer(f)
+				defer opensearch.Close()
+				ocr := httptest.NewServer(fakeOcr{})
+				defer ocr.Close()
+				idx, err := indexer.New(opensearch.URL, ocr.URL, "",
+					indexer.WithDeduplication(mode, indexer.DefaultDeduplicationThreshold))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				d, err := idx.Analyze(models.ScannedPage{Reader: bytes.NewReader(page), ScanId: "scan", SequenceId: 2})
+				if len(f.searches) != 1 || !strings.Contains(f.searches[0], `"values":["scan_2"]`) {
+					t.Errorf("%s: expected a search excluding the page, got %v", mode, f.searches)
+				}
+				if mode == dedup.ModeSkip {
+					if tt.want != "" && !errors.Is(err, indexer.ErrDuplicate) {
+						t.Errorf("%s: expected ErrDuplicate, got %v", mode, err)
+					}
+					if tt.want == "" && err != nil {
+						t.Errorf("%s: unexpected error %v", mode, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d.Hash != hash || d.PerceptualHash != pHash {
+					t.Errorf("unexpected hashes %s and %s", d.Hash, d.PerceptualHash)
+				}
+				if d.DuplicateOf != tt.want || d.DuplicateScore != tt.score {
+					t.Errorf("expected a duplicate of %q (score %.2f), got %q (score %.2f)", tt.want, tt.score, d.DuplicateOf, d.DuplicateScore)
+				}
+			}
+		})
+	}
+}
+
+func TestHasHash(t *testing.T) {
+	var query string
+	opensearch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_count") {
+			fmt.Fprint(w, `{"acknowledged": true}`)
+			return
+		}
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+		query = body.String()
+		fmt.Fprint(w, `{"count": 1}`)
+	}))
+	defer opensearch.Close()
+	idx, err := indexer.New(opensearch.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := idx.HasHash("abc")
+	if err != nil || !ok {
+		t.Fatalf("expected the hash to be found, got %v: %v", ok, err)
+	}
+	// A file whose only copy is in the trash is imported again
+	for _, clause := range []string{`{"term":{"hash.keyword":"abc"}}`, `"must_not":{"exists":{"field":"deletedAt"}}`} {
+		if !strings.Contains(query, clause) {
+			t.Errorf("expected %s in the query, got %s", clause, query)
+		}
+	}
+}
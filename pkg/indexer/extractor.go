@@ -0,0 +1,231 @@
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+)
+
+// ExtractorInput is what the extractors work on, it's shared between them
+// and must not be modified
+type ExtractorInput struct {
+	Page   models.ScannedPage
+	Ocr    *ocrclient.OcrResult
+	Text   string
+	Blocks []models.TextBlock
+	// Barcodes are the barcodes found when the page was indexed, they're
+	// set when the extractors run again on an indexed document without the
+	// page
+	Barcodes []models.Barcode
+}
+
+// Extractor finds metadata in a page. Extract returns a document holding only
+// the fields it found, they're copied to the indexed document.
+//
+// Version must be increased whenever the extractor returns different results
+// for the same page, it's stored in the document so that the documents
+// analyzed by an older version can be reindexed.
+type Extractor interface {
+	Name() string
+	Version() int
+	Extract(in ExtractorInput) (*models.Document, error)
+}
+
+// FieldsExtractor is implemented by the extractors that own some fields of
+// the document, by Go name: these fields are replaced by the ones Extract
+// returns even when they're not set, so that running the extractor again
+// removes what it doesn't find anymore. The other fields are only copied
+// when they're set.
+type FieldsExtractor interface {
+	Fields() []string
+}
+
+type extractor struct {
+	name    string
+	version int
+	fields  []string
+	extract func(in ExtractorInput) (*models.Document, error)
+}
+
+// NewExtractor returns an Extractor that calls fn and owns the given fields
+// (see FieldsExtractor)
+func NewExtractor(name string, version int, fn func(in ExtractorInput) (*models.Document, error), fields ...string) Extractor {
+	return &extractor{name: name, version: version, fields: fields, extract: fn}
+}
+
+func (e *extractor) Name() string {
+	return e.name
+}
+
+func (e *extractor) Version() int {
+	return e.version
+}
+
+func (e *extractor) Fields() []string {
+	return e.fields
+}
+
+func (e *extractor) Extract(in ExtractorInput) (*models.Document, error) {
+	return e.extract(in)
+}
+
+// ExtractorStats are the runs of an extractor since the indexer was created
+type ExtractorStats struct {
+	Runs     int64         `json:"runs"`
+	Failures int64         `json:"failures"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Pipeline runs the extractors in order: the fields set by an extractor
+// replace the ones set by the previous ones. An extractor that fails or
+// panics is logged and skipped, the others still run.
+type Pipeline struct {
+	extractors []Extractor
+
+	mu    sync.Mutex
+	stats map[string]*ExtractorStats
+}
+
+// NewPipeline returns a pipeline running the extractors that aren't
+// disabled. Disabling an extractor that doesn't exist is an error.
+func NewPipeline(extractors []Extractor, disabled ...string) (*Pipeline, error) {
+	names := map[string]bool{}
+	for _, e := range extractors {
+		if names[e.Name()] {
+			return nil, fmt.Errorf("duplicate extractor %q", e.Name())
+		}
+		names[e.Name()] = true
+		for _, field := range ownedFields(e) {
+			if _, ok := reflect.TypeOf(models.Document{}).FieldByName(field); !ok {
+				return nil, fmt.Errorf("extractor %q: unknown field %q", e.Name(), field)
+			}
+		}
+	}
+	skip := map[string]bool{}
+	for _, name := range disabled {
+		if !names[name] {
+			return nil, fmt.Errorf("unknown extractor %q", name)
+		}
+		skip[name] = true
+	}
+
+	p := &Pipeline{stats: map[string]*ExtractorStats{}}
+	for _, e := range extractors {
+		if skip[e.Name()] {
+			log.Infof("extractor %s is disabled", e.Name())
+			continue
+		}
+		p.extractors = append(p.extractors, e)
+		p.stats[e.Name()] = &ExtractorStats{}
+	}
+	return p, nil
+}
+
+// Extractors returns the enabled extractors
+func (p *Pipeline) Extractors() []Extractor {
+	return p.extractors
+}
+
+// Run sets the fields found by the extractors on d and records their
+// versions in d.Extractors
+func (p *Pipeline) Run(in ExtractorInput, d *models.Document) {
+	for _, e := range p.extractors {
+		start := time.Now()
+		found, err := extract(e, in)
+		elapsed := time.Since(start)
+		p.record(e.Name(), elapsed, err)
+		if err != nil {
+			log.Warnf("%s: extractor %s failed: %v", in.Page.Id(), e.Name(), err)
+			continue
+		}
+		log.Debugf("%s: extractor %s took %v", in.Page.Id(), e.Name(), elapsed)
+		merge(d, found, ownedFields(e))
+		if d.Extractors == nil {
+			d.Extractors = map[string]int{}
+		}
+		d.Extractors[e.Name()] = e.Version()
+	}
+}
+
+func extract(e Extractor, in ExtractorInput) (d *models.Document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return e.Extract(in)
+}
+
+func ownedFields(e Extractor) []string {
+	if f, ok := e.(FieldsExtractor); ok {
+		return f.Fields()
+	}
+	return nil
+}
+
+// merge copies the fields of src that are set to dst, the owned fields are
+// replaced even when src doesn't set them. src can be nil.
+func merge(dst *models.Document, src *models.Document, owned []string) {
+	d := reflect.ValueOf(dst).Elem()
+	for _, name := range owned {
+		f := d.FieldByName(name)
+		f.Set(reflect.Zero(f.Type()))
+	}
+	if src == nil {
+		return
+	}
+	s := reflect.ValueOf(src).Elem()
+	for f := 0; f < s.NumField(); f++ {
+		if !s.Field(f).IsZero() {
+			d.Field(f).Set(s.Field(f))
+		}
+	}
+}
+
+func (p *Pipeline) record(name string, elapsed time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats[name]
+	s.Runs++
+	s.Duration += elapsed
+	if err != nil {
+		s.Failures++
+	}
+}
+
+// Stats returns the stats of the enabled extractors by name
+func (p *Pipeline) Stats() map[string]ExtractorStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]ExtractorStats, len(p.stats))
+	for name, s := range p.stats {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// StaleQuery returns an OpenSearch query matching the documents that weren't
+// analyzed by the current version of all the enabled extractors
+func (p *Pipeline) StaleQuery() map[string]any {
+	var should []any
+	for _, e := range p.extractors {
+		field := "extractors." + e.Name()
+		should = append(should,
+			map[string]any{"range": map[string]any{field: map[string]any{"lt": e.Version()}}},
+			map[string]any{"bool": map[string]any{
+				"must_not": map[string]any{"exists": map[string]any{"field": field}},
+			}},
+		)
+	}
+	if len(should) == 0 {
+		return map[string]any{"match_none": map[string]any{}}
+	}
+	return map[string]any{"bool": map[string]any{
+		"should":               should,
+		"minimum_should_match": 1,
+	}}
+}
@@ -0,0 +1,132 @@
+package indexer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+func TestPipeline(t *testing.T) {
+	date := time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+	p, err := indexer.NewPipeline([]indexer.Extractor{
+		indexer.NewExtractor("date", 2, func(in indexer.ExtractorInput) (*models.Document, error) {
+			return &models.Document{Date: &date, Tags: []string{"dated"}}, nil
+		}),
+		indexer.NewExtractor("failing", 1, func(in indexer.ExtractorInput) (*models.Document, error) {
+			return &models.Document{Owner: "nobody"}, fmt.Errorf("unavailable")
+		}),
+		indexer.NewExtractor("panicking", 1, func(in indexer.ExtractorInput) (*models.Document, error) {
+			panic("boom")
+		}),
+		indexer.NewExtractor("tags", 1, func(in indexer.ExtractorInput) (*models.Document, error) {
+			return &models.Document{Tags: []string{strings.ToLower(in.Text)}}, nil
+		}),
+		indexer.NewExtractor("disabled", 1, func(in indexer.ExtractorInput) (*models.Document, error) {
+			t.Errorf("disabled extractor was run")
+			return nil, nil
+		}),
+	}, "disabled")
+	if err != nil {
+		t.Fatalf("unable to create pipeline: %v", err)
+	}
+
+	d := &models.Document{Owner: "alice", Text: "INVOICE"}
+	p.Run(indexer.ExtractorInput{Text: d.Text}, d)
+
+	if d.Date == nil || !d.Date.Equal(date) {
+		t.Errorf("expected date %v, got %v", date, d.Date)
+	}
+	if d.Owner != "alice" {
+		t.Errorf("a failing extractor changed the owner to %q", d.Owner)
+	}
+	if len(d.Tags) != 1 || d.Tags[0] != "invoice" {
+		t.Errorf("expected the tags of the last extractor, got %v", d.Tags)
+	}
+	expected := map[string]int{"date": 2, "tags": 1}
+	if fmt.Sprint(d.Extractors) != fmt.Sprint(expected) {
+		t.Errorf("expected versions %v, got %v", expected, d.Extractors)
+	}
+
+	stats := p.Stats()
+	if _, ok := stats["disabled"]; ok {
+		t.Errorf("expected no stats for the disabled extractor")
+	}
+	if stats["failing"].Runs != 1 || stats["failing"].Failures != 1 || stats["panicking"].Failures != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats["date"].Failures != 0 {
+		t.Errorf("unexpected failures: %+v", stats["date"])
+	}
+}
+
+func TestPipelineOwnedFields(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	p, err := indexer.NewPipeline([]indexer.Extractor{
+		indexer.NewExtractor("dates", 2, func(in indexer.ExtractorInput) (*models.Document, error) {
+			return nil, nil
+		}, "Date", "Dates"),
+		indexer.NewExtractor("amounts", 1, func(in indexer.ExtractorInput) (*models.Document, error) {
+			return &models.Document{Total: &models.Amount{Value: 12, Currency: "CHF"}}, nil
+		}, "Total", "Vat"),
+	})
+	if err != nil {
+		t.Fatalf("unable to create pipeline: %v", err)
+	}
+
+	// The fields found by an older run are replaced, the other ones kept
+	d := &models.Document{
+		Date:  &old,
+		Dates: []time.Time{old},
+		Vat:   &models.Amount{Value: 1},
+		Tags:  []string{"paid"},
+	}
+	p.Run(indexer.ExtractorInput{}, d)
+	if d.Date != nil || d.Dates != nil || d.Vat != nil {
+		t.Errorf("expected the dates and the VAT to be removed, got %v, %v and %v", d.Date, d.Dates, d.Vat)
+	}
+	if d.Total == nil || d.Total.Value != 12 || len(d.Tags) != 1 {
+		t.Errorf("unexpected document %+v", d)
+	}
+}
+
+func TestNewPipeline(t *testing.T) {
+	noop := func(in indexer.ExtractorInput) (*models.Document, error) { return nil, nil }
+	if _, err := indexer.NewPipeline([]indexer.Extractor{indexer.NewExtractor("a", 1, noop)}, "b"); err == nil {
+		t.Errorf("expected an error when disabling an unknown extractor")
+	}
+	if _, err := indexer.NewPipeline([]indexer.Extractor{
+		indexer.NewExtractor("a", 1, noop),
+		indexer.NewExtractor("a", 2, noop),
+	}); err == nil {
+		t.Errorf("expected an error with duplicate extractors")
+	}
+	if _, err := indexer.NewPipeline([]indexer.Extractor{indexer.NewExtractor("a", 1, noop, "Missing")}); err == nil {
+		t.Errorf("expected an error with an unknown field")
+	}
+}
+
+func TestStaleQuery(t *testing.T) {
+	noop := func(in indexer.ExtractorInput) (*models.Document, error) { return nil, nil }
+	p, err := indexer.NewPipeline([]indexer.Extractor{
+		indexer.NewExtractor("dates", 3, noop),
+		indexer.NewExtractor("amounts", 1, noop),
+	}, "amounts")
+	if err != nil {
+		t.Fatalf("unable to create pipeline: %v", err)
+	}
+	q, err := json.Marshal(p.StaleQuery())
+	if err != nil {
+		t.Fatalf("unable to encode query: %v", err)
+	}
+	expected := `{"bool":{"minimum_should_match":1,"should":[` +
+		`{"range":{"extractors.dates":{"lt":3}}},` +
+		`{"bool":{"must_not":{"exists":{"field":"extractors.dates"}}}}]}}`
+	if string(q) != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"github.com/denysvitali/odi-backend/pkg/amounts"
+	"github.com/denysvitali/odi-backend/pkg/dates"
+	"github.com/denysvitali/odi-backend/pkg/entities"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// Names of the built-in extractors
+const (
+	ExtractorDates     = "dates"
+	ExtractorAmounts   = "amounts"
+	ExtractorEntities  = "entities"
+	ExtractorCompanies = "companies"
+	ExtractorBarcodes  = "barcodes"
+)
+
+// ExtractorNames are the names of the built-in extractors, in the order they
+// run
+var ExtractorNames = []string{
+	ExtractorDates,
+	ExtractorAmounts,
+	ExtractorEntities,
+	ExtractorCompanies,
+	ExtractorBarcodes,
+}
+
+// defaultExtractors are the built-in extractors, the barcodes come after the
+// amounts so that the amount of a QR bill replaces the one found in the text.
+// The barcodes don't own Total: a page without a QR bill keeps the amount of
+// the text. The amounts keep the one of a QR bill found by a previous run.
+func (i *Indexer) defaultExtractors() []Extractor {
+	return []Extractor{
+		NewExtractor(ExtractorDates, 1, extractDates,
+			"Date", "Dates", "IssueDate", "DueDate", "PeriodStart", "PeriodEnd"),
+		NewExtractor(ExtractorAmounts, 1, extractAmounts, "Total", "Vat", "VatRate"),
+		NewExtractor(ExtractorEntities, 1, i.extractEntities, "Entities"),
+		NewExtractor(ExtractorCompanies, 2, i.extractCompanies, "Company", "Companies", "CompanyScore"),
+		NewExtractor(ExtractorBarcodes, 1, i.extractBarcodes, "Barcode", "AdditionalBarcodes"),
+	}
+}
+
+func extractDates(in ExtractorInput) (*models.Document, error) {
+	found := dates.Find(in.Blocks, in.Page.ScanTime)
+	d := &models.Document{
+		Date:        found.Date,
+		IssueDate:   found.Issue,
+		DueDate:     found.Due,
+		PeriodStart: found.PeriodStart,
+		PeriodEnd:   found.PeriodEnd,
+	}
+	for _, f := range found.Dates {
+		d.Dates = append(d.Dates, f.Date)
+	}
+	return d, nil
+}
+
+func extractAmounts(in ExtractorInput) (*models.Document, error) {
+	found := amounts.Find(in.Text)
+	d := &models.Document{
+		Total:   found.Total,
+		Vat:     found.Vat,
+		VatRate: found.VatRate,
+	}
+	if total := models.QRBillAmount(in.Barcodes); total != nil {
+		d.Total = total
+	}
+	return d, nil
+}
+
+func (i *Indexer) extractEntities(in ExtractorInput) (*models.Document, error) {
+	if len(i.entityExtractors) == 0 {
+		return nil, nil
+	}
+	return &models.Document{Entities: entities.Find(in.Text, i.entityExtractors...)}, nil
+}
+
+func (i *Indexer) extractCompanies(in ExtractorInput) (*models.Document, error) {
+	matches := i.zefixProcessor.Match(in.Text)
+	log.Debugf("found %d companies", len(matches))
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	d := &models.Document{CompanyScore: matches[0].Score}
+	for _, m := range matches {
+		d.Companies = append(d.Companies, m.Company)
+	}
+	d.Company = &d.Companies[0]
+	return d, nil
+}
+
+// extractBarcodes needs the OCR result, without the page (e.g. on a backfill)
+// the barcodes found when it was indexed are kept
+func (i *Indexer) extractBarcodes(in ExtractorInput) (*models.Document, error) {
+	barcodes := in.Barcodes
+	if in.Ocr != nil {
+		barcodes = i.getBarcodes(in.Ocr)
+	}
+	if len(barcodes) == 0 {
+		return nil, nil
+	}
+	d := &models.Document{
+		Barcode: &barcodes[0],
+		Total:   models.QRBillAmount(barcodes),
+	}
+	if len(barcodes) > 1 {
+		d.AdditionalBarcodes = barcodes[1:]
+	}
+	return d, nil
+}
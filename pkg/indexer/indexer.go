@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,9 +18,10 @@ import (
 	"github.com/opensearch-project/opensearch-go/opensearchapi"
 	"github.com/sirupsen/logrus"
 
-	"github.com/denysvitali/go-datesfinder"
 	swissqrcode "github.com/denysvitali/go-swiss-qr-bill"
 
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+	"github.com/denysvitali/odi-backend/pkg/entities"
 	"github.com/denysvitali/odi-backend/pkg/models"
 	"github.com/denysvitali/odi-backend/pkg/ocrclient"
 	"github.com/denysvitali/odi-backend/pkg/ocrclient/caroundtripper"
@@ -36,14 +38,34 @@ type Indexer struct {
 	ocrApiAddr                   string
 	ocrApiCaPath                 string
 	zefixDsn                     string
+	companiesFile                string
+	companiesIndex               string
+	companyResolver              zefix.CompanyResolver
+	companyCacheSize             int
+	companyCacheTTL              time.Duration
+	companyCacheFile             string
+	ignoredCompanies             []string
 
 	opensearchClient *opensearch.Client
 	ocrClient        *ocrclient.Client
 	zefixProcessor   *zefix.Processor
+	companyCache     *zefix.CachedResolver
+	// noCompanies is set when the companies are looked up in a
+	// zefix.NoopResolver
+	noCompanies bool
 
-	initCalled         bool
-	mergeDistance      float64
-	horizontalDistance float64
+	initCalled bool
+
+	dedupMode      dedup.Mode
+	dedupThreshold float64
+
+	entityExtractors   []entities.Extractor
+	extractors         []Extractor
+	disabledExtractors []string
+	pipeline           *Pipeline
+
+	bulkConfig *BulkConfig
+	bulk       *bulkIndexer
 }
 
 const DefaultDocumentsIndex = "documents"
@@ -54,12 +76,15 @@ var log = logrus.StandardLogger().WithField("package", "indexer")
 
 func New(opensearchAddr string, ocrApiAddr string, zefixDsn string, opts ...Option) (*Indexer, error) {
 	idx := &Indexer{
-		opensearchAddr:     opensearchAddr,
-		ocrApiAddr:         ocrApiAddr,
-		zefixDsn:           zefixDsn,
-		documentsIndex:     DefaultDocumentsIndex,
-		mergeDistance:      150,
-		horizontalDistance: 10,
+		opensearchAddr:   opensearchAddr,
+		ocrApiAddr:       ocrApiAddr,
+		zefixDsn:         zefixDsn,
+		documentsIndex:   DefaultDocumentsIndex,
+		dedupMode:        dedup.ModeOff,
+		dedupThreshold:   DefaultDeduplicationThreshold,
+		entityExtractors: entities.Default,
+		companyCacheSize: zefix.DefaultCacheSize,
+		companyCacheTTL:  zefix.DefaultCacheTTL,
 	}
 	for _, opt := range opts {
 		opt(idx)
@@ -128,40 +153,103 @@ func (i *Indexer) ensureOcrApiClient() error {
 }
 
 func (i *Indexer) init() error {
-	err := i.ensureOcrApiClient()
-	if err != nil {
-		return fmt.Errorf("ocr client: %w", err)
+	// Without an OCR API, pages can't be analyzed but the extractors can
+	// still run on indexed documents (see Pipeline)
+	if i.ocrApiAddr != "" {
+		if err := i.ensureOcrApiClient(); err != nil {
+			return fmt.Errorf("ocr client: %w", err)
+		}
 	}
-	err = i.ensureOpensearchClient()
+	err := i.ensureOpensearchClient()
 	if err != nil {
 		return fmt.Errorf("opensearchClient: %w", err)
 	}
+	if i.bulkConfig != nil {
+		i.bulk = newBulkIndexer(i.opensearchClient, i.documentsIndex, *i.bulkConfig)
+	}
 
 	err = i.ensureZefixClient()
 	if err != nil {
 		return fmt.Errorf("zefix client: %w", err)
 	}
 
+	extractors := i.defaultExtractors()
+	for _, e := range i.extractors {
+		extractors = replaceExtractor(extractors, e)
+	}
+	disabled := i.disabledExtractors
+	if i.noCompanies && !slices.Contains(disabled, ExtractorCompanies) && !hasExtractor(i.extractors, ExtractorCompanies) {
+		// The documents must not record the version of an extractor that
+		// couldn't match anything: once a registry is configured,
+		// StaleQuery selects them
+		disabled = append(slices.Clip(disabled), ExtractorCompanies)
+	}
+	i.pipeline, err = NewPipeline(extractors, disabled...)
+	if err != nil {
+		return fmt.Errorf("extractors: %w", err)
+	}
+
 	// Create OpenSearch index
 	err = i.createOpensearchIndex()
 	if err != nil {
 		return fmt.Errorf("unable to create opensearch index: %v", err)
 	}
+	if err := i.putMapping(); err != nil {
+		// Indexing still works, range queries on the amounts might not
+		log.Warnf("unable to update the mapping of %s: %v", i.documentsIndex, err)
+	}
 
 	// Check if API ping works
-	h, err := i.ocrClient.Healthz()
-	if err != nil {
-		return fmt.Errorf("unable to ping OCR API: %v", err)
-	}
+	if i.ocrClient != nil {
+		h, err := i.ocrClient.Healthz()
+		if err != nil {
+			return fmt.Errorf("unable to ping OCR API: %v", err)
+		}
 
-	if !h {
-		return fmt.Errorf("OCR API is not healthy")
+		if !h {
+			return fmt.Errorf("OCR API is not healthy")
+		}
 	}
 
 	i.initCalled = true
 	return nil
 }
 
+// replaceExtractor replaces the extractor with the same name as e, or adds e
+// at the end
+func hasExtractor(extractors []Extractor, name string) bool {
+	return slices.ContainsFunc(extractors, func(e Extractor) bool { return e.Name() == name })
+}
+
+func replaceExtractor(extractors []Extractor, e Extractor) []Extractor {
+	for n, existing := range extractors {
+		if existing.Name() == e.Name() {
+			extractors[n] = e
+			return extractors
+		}
+	}
+	return append(extractors, e)
+}
+
+// ExtractorStats returns the stats of the enabled extractors by name
+func (i *Indexer) ExtractorStats() map[string]ExtractorStats {
+	if i.pipeline == nil {
+		return nil
+	}
+	return i.pipeline.Stats()
+}
+
+// Pipeline returns the pipeline of the enabled extractors
+func (i *Indexer) Pipeline() *Pipeline {
+	return i.pipeline
+}
+
+// StaleQuery returns an OpenSearch query matching the documents that have to
+// be reindexed because an extractor changed since they were analyzed
+func (i *Indexer) StaleQuery() map[string]any {
+	return i.pipeline.StaleQuery()
+}
+
 func (i *Indexer) ensureInitCalled() error {
 	if !i.initCalled {
 		return fmt.Errorf("init wasn't called")
@@ -169,66 +257,117 @@ func (i *Indexer) ensureInitCalled() error {
 	return nil
 }
 
+// Index analyzes the page and indexes the resulting document
 func (i *Indexer) Index(page models.ScannedPage) error {
-	log.Debugf("indexing %s", page.Id())
-	err := i.ensureInitCalled()
+	d, err := i.Analyze(page)
 	if err != nil {
 		return err
 	}
+	return i.IndexDocument(page.Id(), d)
+}
+
+// Analyze runs the OCR on the page and extracts its metadata, without
+// indexing it. It returns ErrDuplicate when the page is a duplicate and the
+// deduplication mode is dedup.ModeSkip.
+func (i *Indexer) Analyze(page models.ScannedPage) (*models.Document, error) {
+	log.Debugf("analyzing %s", page.Id())
+	err := i.ensureInitCalled()
+	if err != nil {
+		return nil, err
+	}
+	if i.ocrClient == nil {
+		return nil, fmt.Errorf("no OCR API configured")
+	}
+
+	hash, err := documentHash(page.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to hash page: %v", err)
+	}
+	if _, err := page.Reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to seek page: %v", err)
+	}
+
+	var perceptualHash string
+	if p, err := dedup.PerceptualHash(page.Reader); err != nil {
+		log.Warnf("%s: unable to compute the perceptual hash: %v", page.Id(), err)
+	} else {
+		perceptualHash = dedup.FormatHash(p)
+	}
+	if _, err := page.Reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to seek page: %v", err)
+	}
 
 	log.Debugf("processing %s via OCR client", page.Id())
 	ocrResult, err := i.ocrClient.Process(page.Reader)
 	if err != nil {
-		return fmt.Errorf("ocr client failed: %v", err)
+		return nil, fmt.Errorf("ocr client failed: %v", err)
 	}
 
 	log.Debugf("getting text")
 	documentText := i.getText(ocrResult)
-	log.Debugf("zefixProcessor finds the companies")
-	zefixCompanies := i.zefixProcessor.FindCompanies(documentText)
-	log.Debugf("found %d companies", len(zefixCompanies))
-
-	jsonBuffer := bytes.NewBuffer(nil)
-	enc := json.NewEncoder(jsonBuffer)
-	log.Debugf("getting barcodes for %s", page.Id())
-	barcodes := i.getBarcodes(ocrResult)
-	var barcode *models.Barcode
-	var additionalBarcodes []models.Barcode
-	if len(barcodes) > 1 {
-		additionalBarcodes = barcodes[1:]
+	blocks := getBlocks(ocrResult)
+	d := &models.Document{
+		Text:           documentText,
+		IndexedAt:      time.Now(),
+		Hash:           hash,
+		PerceptualHash: perceptualHash,
+		Blocks:         blocks,
+		Tags:           page.Tags,
+		Owner:          page.Owner,
+		Mail:           page.Mail,
+		ScanId:         page.ScanId,
+		SequenceId:     page.SequenceId,
+	}
+	i.pipeline.Run(ExtractorInput{
+		Page:   page,
+		Ocr:    ocrResult,
+		Text:   documentText,
+		Blocks: blocks,
+	}, d)
+	if i.dedupMode != dedup.ModeOff {
+		dup, err := i.findDuplicate(page.Id(), hash, perceptualHash, documentText)
+		if err != nil {
+			return nil, fmt.Errorf("unable to look for duplicates: %v", err)
+		}
+		if dup != nil {
+			log.Infof("%s is a duplicate of %s (score %.2f)", page.Id(), dup.Id, dup.Score)
+			if i.dedupMode == dedup.ModeSkip {
+				return nil, fmt.Errorf("%w: %s is a duplicate of %s", ErrDuplicate, page.Id(), dup.Id)
+			}
+			d.DuplicateOf = dup.Id
+			d.DuplicateScore = dup.Score
+		}
 	}
+	return d, nil
+}
 
-	if len(barcodes) >= 1 {
-		barcode = &barcodes[0]
+// IndexDocument stores the document in OpenSearch with the given ID. With
+// WithBulkIndexing, it waits for the bulk request that sends it: use
+// IndexDocumentAsync not to wait for the other documents.
+func (i *Indexer) IndexDocument(id string, d *models.Document) error {
+	if err := i.ensureInitCalled(); err != nil {
+		return err
 	}
-	dates := getDocumentDates(ocrResult)
-	d := &models.Document{
-		Text:               documentText,
-		Barcode:            barcode,
-		AdditionalBarcodes: additionalBarcodes,
-		IndexedAt:          time.Now(),
-		ScanId:             page.ScanId,
-		SequenceId:         page.SequenceId,
-	}
-	if len(dates) > 0 {
-		d.Date = &dates[0]
-		d.Dates = dates
-	}
-	if len(zefixCompanies) > 0 {
-		log.Debugf("found %d companies", len(zefixCompanies))
-		d.Company = &zefixCompanies[0]
-		d.Companies = zefixCompanies
-	}
-	err = enc.Encode(d)
+	if i.bulk != nil {
+		errCh := make(chan error, 1)
+		i.bulk.add(id, d, func(err error) {
+			errCh <- err
+		})
+		return <-errCh
+	}
+
+	jsonBuffer := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(jsonBuffer)
+	err := enc.Encode(d)
 	if err != nil {
 		return fmt.Errorf("unable to encode JSON: %v", err)
 	}
 
-	log.Debugf("indexing %s", page.Id())
+	log.Debugf("indexing %s", id)
 
 	req := opensearchapi.IndexRequest{
 		Index:      i.documentsIndex,
-		DocumentID: page.Id(),
+		DocumentID: id,
 		Body:       jsonBuffer,
 		OpType:     "index",
 	}
@@ -241,32 +380,56 @@ func (i *Indexer) Index(page models.ScannedPage) error {
 		errorMessage := decodeError(res.Body)
 		return fmt.Errorf("opensearch returned an invalid status %s: %s", res.Status(), errorMessage)
 	}
-	log.Debugf("indexed %s", page.Id())
+	log.Debugf("indexed %s", id)
 	return nil
 }
 
-func decodeError(body io.ReadCloser) string {
-	var errorMessage struct {
-		Error string `json:"error"`
+// IndexDocumentAsync stores the document in OpenSearch with the given ID,
+// done is called once it's indexed or has failed. With WithBulkIndexing, the
+// document is sent with the next bulk request, see Flush; otherwise it's
+// indexed before IndexDocumentAsync returns.
+func (i *Indexer) IndexDocumentAsync(id string, d *models.Document, done func(error)) {
+	if err := i.ensureInitCalled(); err != nil {
+		done(err)
+		return
 	}
-	dec := json.NewDecoder(body)
-	dec.Decode(&errorMessage)
-	return errorMessage.Error
+	if i.bulk == nil {
+		done(i.IndexDocument(id, d))
+		return
+	}
+	i.bulk.add(id, d, done)
 }
 
-// Given the result of the OCR, return the most likely date of the document
-func getDocumentDates(result *ocrclient.OcrResult) []time.Time {
-	// Try to parse the date from the text
-	var dates []time.Time
-	for _, t := range result.TextBlocks {
-		d, _ := datesfinder.FindDates(t.Text)
-		dates = append(dates, d...)
+// Flush sends the documents waiting for a bulk request and returns once all
+// the documents given to IndexDocumentAsync are indexed or have failed
+func (i *Indexer) Flush() {
+	if i.bulk != nil {
+		i.bulk.flush()
 	}
+}
 
-	if len(dates) == 0 {
-		return nil
+// decodeError returns the error of an OpenSearch response, it's either a
+// string or an object with a type and a reason
+func decodeError(body io.ReadCloser) string {
+	var errorMessage struct {
+		Error json.RawMessage `json:"error"`
+	}
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&errorMessage); err != nil || errorMessage.Error == nil {
+		return ""
+	}
+	var message string
+	if err := json.Unmarshal(errorMessage.Error, &message); err == nil {
+		return message
+	}
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(errorMessage.Error, &cause); err == nil && cause.Type != "" {
+		return cause.Type + ": " + cause.Reason
 	}
-	return dates
+	return string(errorMessage.Error)
 }
 
 func (i *Indexer) getBarcodes(result *ocrclient.OcrResult) []models.Barcode {
@@ -276,24 +439,48 @@ func (i *Indexer) getBarcodes(result *ocrclient.OcrResult) []models.Barcode {
 
 	var barcodes []models.Barcode
 	for _, b := range result.Barcodes {
+		barcode := models.Barcode{
+			Text: b.RawValue,
+			BoundingBox: &models.BoundingBox{
+				Top:    b.BoundingBox.Top,
+				Bottom: b.BoundingBox.Bottom,
+				Left:   b.BoundingBox.Left,
+				Right:  b.BoundingBox.Right,
+			},
+		}
 		if strings.HasPrefix(b.RawValue, "SPC") {
 			// Try to parse Swiss QR Bill
 			qrCode, err := swissqrcode.Decode(b.RawValue)
 			if err != nil {
 				log.Warnf("unable to decode Swiss QR Bill: %v", err)
-				barcodes = append(barcodes, models.Barcode{Text: b.RawValue})
-				continue
+			} else {
+				barcode.QRBill = qrCode
+				barcode.Text = ""
 			}
-			barcodes = append(barcodes, models.Barcode{QRBill: qrCode})
-		} else {
-			barcodes = append(barcodes, models.Barcode{Text: b.RawValue})
 		}
+		barcodes = append(barcodes, barcode)
 	}
 	return barcodes
 }
 
+func getBlocks(result *ocrclient.OcrResult) []models.TextBlock {
+	var blocks []models.TextBlock
+	for _, b := range result.TextBlocks {
+		blocks = append(blocks, models.TextBlock{
+			Text: b.Text,
+			BoundingBox: models.BoundingBox{
+				Top:    b.BoundingBox.Top,
+				Bottom: b.BoundingBox.Bottom,
+				Left:   b.BoundingBox.Left,
+				Right:  b.BoundingBox.Right,
+			},
+		})
+	}
+	return blocks
+}
+
 func (i *Indexer) getText(result *ocrclient.OcrResult) string {
-	return ocrtext.GetText(result, i.mergeDistance, i.horizontalDistance)
+	return ocrtext.GetText(result)
 }
 
 func documentHash(reader io.Reader) (string, error) {
@@ -322,10 +509,99 @@ func (i *Indexer) createOpensearchIndex() error {
 	return nil
 }
 
+// documentsMapping sets the types that can't be guessed from the first
+// document: an amount of 100 would otherwise map the field as an integer,
+// and entities are matched as a whole rather than analyzed
+var documentsMapping = map[string]any{
+	"properties": map[string]any{
+		"total": map[string]any{"properties": map[string]any{
+			"value": map[string]any{"type": "double"},
+		}},
+		"vat": map[string]any{"properties": map[string]any{
+			"value": map[string]any{"type": "double"},
+		}},
+		"vatRate": map[string]any{"type": "double"},
+		"entities": map[string]any{"properties": map[string]any{
+			"type":  map[string]any{"type": "keyword"},
+			"value": map[string]any{"type": "keyword"},
+		}},
+	},
+}
+
+func (i *Indexer) putMapping() error {
+	body, err := json.Marshal(documentsMapping)
+	if err != nil {
+		return err
+	}
+	req := opensearchapi.IndicesPutMappingRequest{
+		Index: []string{i.documentsIndex},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), i.opensearchClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("unexpected status %s: %s", res.Status(), decodeError(res.Body))
+	}
+	return nil
+}
+
+// ensureZefixClient picks the company registry: the resolver set with
+// WithCompanyResolver, the CSV file, the OpenSearch index or the Zefix
+// database, in this order. Without any, no company is found.
 func (i *Indexer) ensureZefixClient() error {
+	resolver := i.companyResolver
 	var err error
-	i.zefixProcessor, err = zefix.New(i.zefixDsn)
-	return err
+	switch {
+	case resolver != nil:
+	case i.companiesFile != "":
+		resolver, err = zefix.LoadCSV(i.companiesFile)
+	case i.companiesIndex != "":
+		resolver = zefix.NewOpenSearchResolver(i.opensearchClient, i.companiesIndex)
+	case i.zefixDsn != "":
+		resolver, err = zefix.NewPostgresResolver(i.zefixDsn)
+	default:
+		log.Info("no company registry configured, companies won't be matched")
+		resolver = zefix.NoopResolver{}
+	}
+	if err != nil {
+		return err
+	}
+	_, i.noCompanies = resolver.(zefix.NoopResolver)
+
+	// The same few companies are on most pages, their lookups are cached
+	if !i.noCompanies && i.companyCacheSize > 0 {
+		i.companyCache = zefix.NewCachedResolver(resolver, i.companyCacheSize, i.companyCacheTTL)
+		if i.companyCacheFile != "" {
+			if err := i.companyCache.LoadFile(i.companyCacheFile); err != nil {
+				log.Warnf("unable to load the company cache %s: %v", i.companyCacheFile, err)
+			}
+		}
+		resolver = i.companyCache
+	}
+	i.zefixProcessor = zefix.NewProcessor(resolver, zefix.WithIgnored(i.ignoredCompanies...))
+	return nil
+}
+
+// CompanyCacheStats returns the hits and misses of the company cache, nil
+// when companies aren't cached
+func (i *Indexer) CompanyCacheStats() *zefix.CacheStats {
+	if i.companyCache == nil {
+		return nil
+	}
+	stats := i.companyCache.Stats()
+	return &stats
+}
+
+// SaveCompanyCache saves the company cache to the file set with
+// WithCompanyCacheFile, so that the next run starts with it
+func (i *Indexer) SaveCompanyCache() error {
+	if i.companyCache == nil || i.companyCacheFile == "" {
+		return nil
+	}
+	return i.companyCache.SaveFile(i.companyCacheFile)
 }
 
 func (i *Indexer) PingZefix() error {
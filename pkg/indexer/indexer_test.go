@@ -0,0 +1,84 @@
+package indexer_test
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	zefixtools "github.com/denysvitali/zefix-tools/pkg/zefix"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+type fakeResolver struct{}
+
+func (fakeResolver) FindCompany(name string) (*zefixtools.Company, error) {
+	return nil, nil
+}
+
+func (fakeResolver) SearchCompanies(word string, limit int) ([]zefixtools.Company, error) {
+	return nil, nil
+}
+
+func TestCompaniesWithoutRegistry(t *testing.T) {
+	noop := func(in indexer.ExtractorInput) (*models.Document, error) { return nil, nil }
+	tests := []struct {
+		name      string
+		opts      []indexer.Option
+		companies bool
+	}{
+		{name: "no registry"},
+		{name: "noop resolver", opts: []indexer.Option{indexer.WithCompanyResolver(zefix.NoopResolver{})}},
+		{name: "resolver", opts: []indexer.Option{indexer.WithCompanyResolver(fakeResolver{})}, companies: true},
+		{
+			name:      "custom extractor",
+			opts:      []indexer.Option{indexer.WithExtractors(indexer.NewExtractor(indexer.ExtractorCompanies, 1, noop))},
+			companies: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeBulk{})
+			defer server.Close()
+			idx, err := indexer.New(server.URL, "", "", tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range idx.Pipeline().Extractors() {
+				names = append(names, e.Name())
+			}
+			if slices.Contains(names, indexer.ExtractorCompanies) != tt.companies {
+				t.Errorf("unexpected extractors %v", names)
+			}
+			if !slices.Contains(names, indexer.ExtractorDates) {
+				t.Errorf("expected the other extractors to run, got %v", names)
+			}
+		})
+	}
+}
+
+func TestBarcodesWithoutPage(t *testing.T) {
+	server := httptest.NewServer(&fakeBulk{})
+	defer server.Close()
+	idx, err := indexer.New(server.URL, "", "", indexer.WithDisabledExtractors(
+		indexer.ExtractorDates, indexer.ExtractorAmounts, indexer.ExtractorEntities, indexer.ExtractorCompanies,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As run by a backfill, with the barcodes of the indexed document
+	barcodes := []models.Barcode{{Text: "first"}, {Text: "second"}}
+	d := &models.Document{Barcode: &barcodes[0], AdditionalBarcodes: barcodes[1:]}
+	idx.Pipeline().Run(indexer.ExtractorInput{Barcodes: barcodes}, d)
+
+	if d.Barcode == nil || d.Barcode.Text != "first" || len(d.AdditionalBarcodes) != 1 {
+		t.Errorf("expected the barcodes to be kept, got %+v %+v", d.Barcode, d.AdditionalBarcodes)
+	}
+	if d.Extractors[indexer.ExtractorBarcodes] != 1 {
+		t.Errorf("unexpected extractors %v", d.Extractors)
+	}
+}
@@ -1,5 +1,13 @@
 package indexer
 
+import (
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+	"github.com/denysvitali/odi-backend/pkg/entities"
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
 func WithOpenSearchUsername(username string) Option {
 	return func(i *Indexer) {
 		i.opensearchUsername = username
@@ -23,3 +31,108 @@ func WithOcrApiCAPath(path string) Option {
 		i.ocrApiCaPath = path
 	}
 }
+
+func WithDocumentsIndex(index string) Option {
+	return func(i *Indexer) {
+		i.documentsIndex = index
+	}
+}
+
+// WithDeduplication enables the detection of pages that have already been
+// indexed: pages with a score above the threshold are either flagged or
+// skipped, depending on the mode
+func WithDeduplication(mode dedup.Mode, threshold float64) Option {
+	return func(i *Indexer) {
+		i.dedupMode = mode
+		if threshold > 0 {
+			i.dedupThreshold = threshold
+		}
+	}
+}
+
+// WithEntityExtractors replaces the extractors that find the entities of the
+// documents, entities.Default by default. Without extractors, no entity is
+// extracted.
+func WithEntityExtractors(extractors ...entities.Extractor) Option {
+	return func(i *Indexer) {
+		i.entityExtractors = extractors
+	}
+}
+
+// WithExtractors adds extractors to the built-in ones, an extractor with the
+// name of a built-in one replaces it
+func WithExtractors(extractors ...Extractor) Option {
+	return func(i *Indexer) {
+		i.extractors = append(i.extractors, extractors...)
+	}
+}
+
+// WithDisabledExtractors disables the extractors with the given names (e.g.
+// ExtractorCompanies)
+func WithDisabledExtractors(names ...string) Option {
+	return func(i *Indexer) {
+		i.disabledExtractors = append(i.disabledExtractors, names...)
+	}
+}
+
+// WithIgnoredCompanies adds companies to zefix.DefaultIgnored, they're never
+// set on the documents
+func WithIgnoredCompanies(names ...string) Option {
+	return func(i *Indexer) {
+		i.ignoredCompanies = append(i.ignoredCompanies, names...)
+	}
+}
+
+// WithCompanyResolver sets where the companies are looked up, instead of the
+// Zefix database
+func WithCompanyResolver(resolver zefix.CompanyResolver) Option {
+	return func(i *Indexer) {
+		i.companyResolver = resolver
+	}
+}
+
+// WithCompaniesFile looks up the companies in a CSV file (see zefix.ReadCSV)
+// instead of the Zefix database
+func WithCompaniesFile(path string) Option {
+	return func(i *Indexer) {
+		i.companiesFile = path
+	}
+}
+
+// WithCompaniesIndex looks up the companies in an OpenSearch index instead of
+// the Zefix database
+func WithCompaniesIndex(index string) Option {
+	return func(i *Indexer) {
+		i.companiesIndex = index
+	}
+}
+
+// WithCompanyCache sets the number of company lookups cached and for how
+// long, zefix.DefaultCacheSize and zefix.DefaultCacheTTL by default. A size
+// of 0 disables the cache.
+func WithCompanyCache(size int, ttl time.Duration) Option {
+	return func(i *Indexer) {
+		i.companyCacheSize = size
+		if ttl > 0 {
+			i.companyCacheTTL = ttl
+		}
+	}
+}
+
+// WithCompanyCacheFile loads the company cache from path, when it exists,
+// SaveCompanyCache saves it there
+func WithCompanyCacheFile(path string) Option {
+	return func(i *Indexer) {
+		i.companyCacheFile = path
+	}
+}
+
+// WithBulkIndexing sends the documents to OpenSearch with bulk requests, as
+// set by config (see DefaultBulkConfig), instead of one request each. The
+// documents waiting for a bulk request can't be found by the duplicate
+// detection, a page indexed twice before a flush isn't flagged.
+func WithBulkIndexing(config BulkConfig) Option {
+	return func(i *Indexer) {
+		i.bulkConfig = &config
+	}
+}
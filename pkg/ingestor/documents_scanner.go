@@ -1,9 +1,149 @@
 package ingestor
 
-import "io"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/exif"
+)
 
 type DocumentsScanner interface {
 	ScanPage() bool
 	CurrentPage() io.Reader
 	Err() error
 }
+
+// TimedScanner is implemented by the scanners that know when their pages
+// were captured, e.g. photos imported from a directory. ScanPages uses the
+// current time for the other ones.
+type TimedScanner interface {
+	CurrentPageTime() time.Time
+}
+
+// SliceScanner is a DocumentsScanner returning pages that are already in
+// memory, e.g. uploaded files
+type SliceScanner struct {
+	pages   []io.Reader
+	current int
+}
+
+func NewSliceScanner(pages ...io.Reader) *SliceScanner {
+	return &SliceScanner{pages: pages}
+}
+
+func (s *SliceScanner) ScanPage() bool {
+	if s.current >= len(s.pages) {
+		return false
+	}
+	s.current++
+	return true
+}
+
+func (s *SliceScanner) CurrentPage() io.Reader {
+	if s.current == 0 {
+		return nil
+	}
+	return s.pages[s.current-1]
+}
+
+func (s *SliceScanner) Err() error {
+	return nil
+}
+
+var _ DocumentsScanner = (*SliceScanner)(nil)
+
+// FileScanner is a DocumentsScanner returning the content of image files.
+// The files are read one at a time, PNG files are converted to JPEG. The
+// time of a page is the EXIF date of the photo or the modification time of
+// the file. The files that can't be read are skipped, see Skipped.
+type FileScanner struct {
+	files   []string
+	current int
+	page    []byte
+	time    time.Time
+	pages   []string
+	skipped []SkippedFile
+}
+
+// SkippedFile is a file that FileScanner couldn't read, e.g. a corrupt or
+// unsupported image
+type SkippedFile struct {
+	Name string
+	Err  error
+}
+
+func NewFileScanner(files ...string) *FileScanner {
+	return &FileScanner{files: files}
+}
+
+func (s *FileScanner) ScanPage() bool {
+	for s.current < len(s.files) {
+		name := s.files[s.current]
+		s.current++
+
+		page, t, err := ReadImageFile(name)
+		if err != nil {
+			s.skipped = append(s.skipped, SkippedFile{Name: name, Err: err})
+			continue
+		}
+		s.page = page
+		s.time = t
+		s.pages = append(s.pages, name)
+		return true
+	}
+	return false
+}
+
+func (s *FileScanner) CurrentPage() io.Reader {
+	return bytes.NewReader(s.page)
+}
+
+func (s *FileScanner) CurrentPageTime() time.Time {
+	return s.time
+}
+
+// Pages returns the files read so far: the page with the sequence id n is
+// the file n-1
+func (s *FileScanner) Pages() []string {
+	return s.pages
+}
+
+// Skipped returns the files that couldn't be read so far
+func (s *FileScanner) Skipped() []SkippedFile {
+	return s.skipped
+}
+
+func (s *FileScanner) Err() error {
+	return nil
+}
+
+var (
+	_ DocumentsScanner = (*FileScanner)(nil)
+	_ TimedScanner     = (*FileScanner)(nil)
+)
+
+// ReadImageFile reads an image file as it would be stored, together with the
+// time it was taken
+func ReadImageFile(name string) ([]byte, time.Time, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	page, err := NormalizeImage(b)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	t, err := exif.DateTime(b)
+	if err != nil {
+		t = fi.ModTime()
+	}
+	return page, t, nil
+}
@@ -0,0 +1,95 @@
+package ingestor_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+)
+
+func TestFileScanner(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+
+	jpegBuf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(jpegBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	pngBuf := bytes.NewBuffer(nil)
+	if err := png.Encode(pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{filepath.Join(dir, "1.jpg"), filepath.Join(dir, "2.png")}
+	mtime := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	for i, b := range [][]byte{jpegBuf.Bytes(), pngBuf.Bytes()} {
+		if err := os.WriteFile(files[i], b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(files[i], mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := ingestor.NewFileScanner(files...)
+	pages := 0
+	for s.ScanPage() {
+		pages++
+		b, err := io.ReadAll(s.CurrentPage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := http.DetectContentType(b); ct != "image/jpeg" {
+			t.Fatalf("page %d: expected a JPEG, got %s", pages, ct)
+		}
+		if !s.CurrentPageTime().Equal(mtime) {
+			t.Fatalf("page %d: expected the modification time %v, got %v", pages, mtime, s.CurrentPageTime())
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if pages != len(files) {
+		t.Fatalf("expected %d pages, got %d", len(files), pages)
+	}
+
+	if fmt.Sprint(s.Pages()) != fmt.Sprint(files) || len(s.Skipped()) != 0 {
+		t.Fatalf("unexpected pages %v, skipped %v", s.Pages(), s.Skipped())
+	}
+
+	// The files that can't be read are skipped
+	corrupt := filepath.Join(dir, "3.jpg")
+	if err := os.WriteFile(corrupt, []byte("not an image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.jpg")
+	s = ingestor.NewFileScanner(corrupt, files[0], missing, files[1])
+	pages = 0
+	for s.ScanPage() {
+		pages++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 || fmt.Sprint(s.Pages()) != fmt.Sprint(files) {
+		t.Fatalf("expected the pages %v, got %d pages: %v", files, pages, s.Pages())
+	}
+	skipped := s.Skipped()
+	if len(skipped) != 2 || skipped[0].Name != corrupt || skipped[1].Name != missing {
+		t.Fatalf("expected the corrupt and missing files to be skipped, got %v", skipped)
+	}
+	for _, f := range skipped {
+		if f.Err == nil {
+			t.Errorf("%s: expected an error", f.Name)
+		}
+	}
+}
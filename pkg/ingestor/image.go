@@ -0,0 +1,190 @@
+package ingestor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/denysvitali/odi-backend/pkg/exif"
+	"github.com/denysvitali/odi-backend/pkg/pdfimages"
+)
+
+// ErrUnsupportedType is returned by NormalizeImage for files that are not
+// JPEG, PNG or HEIC images
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+const jpegQuality = 90
+
+// heifConvert is the libheif tool HEIC images are converted with, there's no
+// HEIC decoder in the standard library
+const heifConvert = "heif-convert"
+
+// NormalizeImage makes sure the file is an image the pipeline can process:
+// JPEG files are returned as they are unless their EXIF orientation says
+// they must be rotated, PNG and HEIC files are converted to JPEG.
+func NormalizeImage(b []byte) ([]byte, error) {
+	if isHeic(b) {
+		return convertHeic(b)
+	}
+	switch contentType := http.DetectContentType(b); contentType {
+	case "image/jpeg":
+		if _, err := jpeg.DecodeConfig(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("invalid JPEG: %w", err)
+		}
+		return orientJpeg(b)
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PNG: %w", err)
+		}
+		return encodeJpeg(img)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+}
+
+// NormalizePages is NormalizeImage for files that can hold several pages:
+// the JPEG images of a scanned PDF are returned as its pages (see
+// pdfimages), the other files are a single page.
+func NormalizePages(b []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(b, []byte("%PDF-")) {
+		page, err := NormalizeImage(b)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{page}, nil
+	}
+
+	images, err := pdfimages.ExtractJPEGs(b)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([][]byte, len(images))
+	for n, img := range images {
+		if pages[n], err = NormalizeImage(img); err != nil {
+			return nil, fmt.Errorf("page %d: %w", n+1, err)
+		}
+	}
+	return pages, nil
+}
+
+// isHeic tells whether the file is a HEIF container (ISO BMFF) holding HEVC
+// images, as taken by phones
+func isHeic(b []byte) bool {
+	if len(b) < 12 || string(b[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(b[8:12]) {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+func convertHeic(b []byte) ([]byte, error) {
+	path, err := exec.LookPath(heifConvert)
+	if err != nil {
+		return nil, fmt.Errorf("%w: HEIC images need %s (libheif)", ErrUnsupportedType, heifConvert)
+	}
+
+	dir, err := os.MkdirTemp("", "odi-heic-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "page.heic")
+	out := filepath.Join(dir, "page.jpg")
+	if err := os.WriteFile(in, b, 0o600); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, "-q", fmt.Sprint(jpegQuality), in, out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("invalid HEIC: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	// libheif has already rotated the image, the EXIF orientation it copied
+	// must not be applied again: the JPEG is encoded without it
+	img, err := readJpeg(out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEIC: %w", err)
+	}
+	return encodeJpeg(img)
+}
+
+func readJpeg(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
+
+// orientJpeg rotates the photos whose EXIF orientation isn't upright, the
+// OCR expects the text to be horizontal
+func orientJpeg(b []byte) ([]byte, error) {
+	o, err := exif.Orientation(b)
+	if err != nil || o == 1 {
+		// Invalid metadata isn't a reason to reject the image
+		return b, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JPEG: %w", err)
+	}
+	return encodeJpeg(orient(img, o))
+}
+
+// orient transforms the image according to its EXIF orientation
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// src returns the point of the image displayed at x, y
+	var src func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		src = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		src = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		src = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		src = func(x, y int) (int, int) { return y, x }
+	case 6:
+		src = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7:
+		src = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8:
+		src = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func encodeJpeg(img image.Image) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("unable to encode JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,196 @@
+package ingestor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+)
+
+func TestNormalizeImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+
+	pngBuf := bytes.NewBuffer(nil)
+	if err := png.Encode(pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ingestor.NormalizeImage(pngBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := http.DetectContentType(b); ct != "image/jpeg" {
+		t.Fatalf("expected the PNG to be converted to JPEG, got %s", ct)
+	}
+
+	jpegBuf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(jpegBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ingestor.NormalizeImage(jpegBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, jpegBuf.Bytes()) {
+		t.Fatalf("expected the JPEG to be returned as it is")
+	}
+
+	_, err = ingestor.NormalizeImage([]byte("%PDF-1.4 not an image"))
+	if !errors.Is(err, ingestor.ErrUnsupportedType) {
+		t.Fatalf("expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+// halfDark is a 20x10 image whose left half is dark
+func halfDark(t *testing.T) []byte {
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.Gray{Y: 250}
+			if x < 10 {
+				c = color.Gray{Y: 10}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation inserts an APP1 segment with the EXIF orientation
+func withOrientation(img []byte, orientation uint16) []byte {
+	tiff := bytes.NewBuffer(nil)
+	tiff.WriteString("MM")
+	for _, v := range []any{uint16(42), uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		_ = binary.Write(tiff, binary.BigEndian, v)
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := bytes.NewBuffer(nil)
+	out.Write(img[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(img[2:])
+	return out.Bytes()
+}
+
+func isDark(img image.Image, x int, y int) bool {
+	gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+	return gray.Y < 128
+}
+
+func TestNormalizeImageOrientation(t *testing.T) {
+	tests := []struct {
+		orientation uint16
+		// size of the upright image, and a dark and a light point of it
+		width, height int
+		dark, light   image.Point
+	}{
+		{1, 20, 10, image.Pt(2, 5), image.Pt(17, 5)},
+		{2, 20, 10, image.Pt(17, 5), image.Pt(2, 5)},
+		{3, 20, 10, image.Pt(17, 5), image.Pt(2, 5)},
+		{4, 20, 10, image.Pt(2, 5), image.Pt(17, 5)},
+		{5, 10, 20, image.Pt(5, 2), image.Pt(5, 17)},
+		{6, 10, 20, image.Pt(5, 2), image.Pt(5, 17)},
+		{7, 10, 20, image.Pt(5, 17), image.Pt(5, 2)},
+		{8, 10, 20, image.Pt(5, 17), image.Pt(5, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.orientation), func(t *testing.T) {
+			b, err := ingestor.NormalizeImage(withOrientation(halfDark(t), tt.orientation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := img.Bounds().Size(); s.X != tt.width || s.Y != tt.height {
+				t.Fatalf("expected a %dx%d image, got %v", tt.width, tt.height, s)
+			}
+			if !isDark(img, tt.dark.X, tt.dark.Y) || isDark(img, tt.light.X, tt.light.Y) {
+				t.Fatalf("expected %v to be dark and %v light", tt.dark, tt.light)
+			}
+		})
+	}
+}
+
+func TestNormalizePages(t *testing.T) {
+	page := halfDark(t)
+	pdf := bytes.NewBufferString("%PDF-1.4\n")
+	for n, img := range [][]byte{page, withOrientation(page, 3)} {
+		fmt.Fprintf(pdf, "%d 0 obj\n<< /Type /XObject /Subtype /Image /Filter /DCTDecode /Length %d >>\nstream\n", n+1, len(img))
+		pdf.Write(img)
+		pdf.WriteString("\nendstream\nendobj\n")
+	}
+	pdf.WriteString("%%EOF\n")
+
+	pages, err := ingestor.NormalizePages(pdf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || !bytes.Equal(pages[0], page) || bytes.Equal(pages[1], page) {
+		t.Fatalf("expected the 2 pages of the PDF, the second one rotated, got %d pages", len(pages))
+	}
+
+	pages, err = ingestor.NormalizePages(page)
+	if err != nil || len(pages) != 1 {
+		t.Fatalf("expected the image as the only page, got %d pages: %v", len(pages), err)
+	}
+
+	if _, err := ingestor.NormalizePages([]byte("%PDF-1.4 no images")); err == nil {
+		t.Fatal("expected an error for a PDF without images")
+	}
+}
+
+func TestNormalizeImageHeic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake heif-convert is a shell script")
+	}
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	path := os.Getenv("PATH")
+
+	// Without libheif
+	t.Setenv("PATH", t.TempDir())
+	if _, err := ingestor.NormalizeImage(heic); !errors.Is(err, ingestor.ErrUnsupportedType) {
+		t.Fatalf("expected ErrUnsupportedType, got %v", err)
+	}
+
+	// The fake heif-convert writes an upright JPEG that still has the
+	// orientation of the photo
+	dir := t.TempDir()
+	converted := filepath.Join(dir, "converted.jpg")
+	if err := os.WriteFile(converted, withOrientation(halfDark(t), 6), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\n[ \"$1 $2\" = \"-q 90\" ] || exit 1\ncp %q \"$4\"\n", converted)
+	if err := os.WriteFile(filepath.Join(dir, "heif-convert"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	b, err := ingestor.NormalizeImage(heic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := img.Bounds().Size(); s.X != 20 || s.Y != 10 || !isDark(img, 2, 5) {
+		t.Fatalf("expected the converted image not to be rotated again, got %v", s)
+	}
+}
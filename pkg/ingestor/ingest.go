@@ -2,6 +2,7 @@ package ingestor
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -10,10 +11,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stapelberg/airscan"
-	"github.com/stapelberg/airscan/preset"
 
+	"github.com/denysvitali/odi-backend/pkg/dedup"
 	"github.com/denysvitali/odi-backend/pkg/indexer"
 	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
 	"github.com/denysvitali/odi-backend/pkg/storage/model"
 )
 
@@ -21,17 +23,80 @@ var log = logrus.StandardLogger()
 
 type Config struct {
 	OcrApiAddr         string
+	OcrApiCAPath       string
 	OpenSearchAddr     string
 	OpenSearchUsername string
 	OpenSearchPassword string
 	OpenSearchSkipTLS  bool
-	ZefixDsn           string
-	Storage            model.Storer
+	// OpenSearchIndex is the documents index, indexer.DefaultDocumentsIndex when empty
+	OpenSearchIndex string
+	ZefixDsn        string
+	Storage         model.Storer
+
+	// Deduplication sets what to do with pages that have already been
+	// indexed, pages skipped with dedup.ModeSkip are removed from the
+	// storage when it supports it
+	Deduplication          dedup.Mode
+	DeduplicationThreshold float64
+
+	// DisabledExtractors are the names of the extractors that aren't run on
+	// the pages (e.g. "companies")
+	DisabledExtractors []string
+	// IgnoredCompanies are never set on the documents, in addition to
+	// zefix.DefaultIgnored
+	IgnoredCompanies []string
+	// CompaniesFile and CompaniesIndex look up the companies in a CSV file
+	// or an OpenSearch index instead of the Zefix database (ZefixDsn). With
+	// none of them, companies aren't matched.
+	CompaniesFile  string
+	CompaniesIndex string
+
+	// BulkIndexing sends the documents to OpenSearch with bulk requests of
+	// up to BulkFlushDocuments documents, or after BulkFlushInterval. The
+	// pages of a scan are all indexed when ScanPages returns.
+	BulkIndexing       bool
+	BulkFlushDocuments int
+	BulkFlushInterval  time.Duration
+
+	// Workers is the number of pages of a scan processed at the same time,
+	// QueueSize the number of scanned pages waiting for a worker before the
+	// scanner is paused
+	Workers   int
+	QueueSize int
+	// StorageConcurrency and OcrConcurrency limit the number of pages being
+	// uploaded and analyzed at the same time, across all the scans
+	StorageConcurrency int
+	OcrConcurrency     int
+}
+
+const (
+	DefaultWorkers            = 4
+	DefaultQueueSize          = 8
+	DefaultStorageConcurrency = 2
+	DefaultOcrConcurrency     = 2
+)
+
+// Analyzer analyzes the pages and indexes the documents, it's implemented by
+// *indexer.Indexer
+type Analyzer interface {
+	Analyze(page models.ScannedPage) (*models.Document, error)
+	IndexDocumentAsync(id string, d *models.Document, done func(error))
+	// Flush waits until the documents passed to IndexDocumentAsync are
+	// indexed
+	Flush()
 }
 
 type Ingestor struct {
-	idx     *indexer.Indexer
-	storage model.Storer
+	// idx is nil when the ingestor was created with another analyzer
+	idx      *indexer.Indexer
+	analyzer Analyzer
+	storage  model.Storer
+
+	workers    int
+	queueSize  int
+	storageSem chan struct{}
+	ocrSem     chan struct{}
+	metrics    metrics
 }
 
 func New(config Config) (*Ingestor, error) {
@@ -42,9 +107,40 @@ func New(config Config) (*Ingestor, error) {
 	if config.OpenSearchPassword != "" {
 		opts = append(opts, indexer.WithOpenSearchPassword(config.OpenSearchPassword))
 	}
+	if config.OcrApiCAPath != "" {
+		opts = append(opts, indexer.WithOcrApiCAPath(config.OcrApiCAPath))
+	}
 	if config.OpenSearchSkipTLS {
 		opts = append(opts, indexer.WithOpenSearchSkipTLS())
 	}
+	if config.OpenSearchIndex != "" {
+		opts = append(opts, indexer.WithDocumentsIndex(config.OpenSearchIndex))
+	}
+	if config.Deduplication != "" && config.Deduplication != dedup.ModeOff {
+		opts = append(opts, indexer.WithDeduplication(config.Deduplication, config.DeduplicationThreshold))
+	}
+	if len(config.DisabledExtractors) > 0 {
+		opts = append(opts, indexer.WithDisabledExtractors(config.DisabledExtractors...))
+	}
+	if len(config.IgnoredCompanies) > 0 {
+		opts = append(opts, indexer.WithIgnoredCompanies(config.IgnoredCompanies...))
+	}
+	if config.CompaniesFile != "" {
+		opts = append(opts, indexer.WithCompaniesFile(config.CompaniesFile))
+	}
+	if config.CompaniesIndex != "" {
+		opts = append(opts, indexer.WithCompaniesIndex(config.CompaniesIndex))
+	}
+	if config.BulkIndexing {
+		bulk := indexer.DefaultBulkConfig
+		if config.BulkFlushDocuments > 0 {
+			bulk.FlushDocuments = config.BulkFlushDocuments
+		}
+		if config.BulkFlushInterval > 0 {
+			bulk.FlushInterval = config.BulkFlushInterval
+		}
+		opts = append(opts, indexer.WithBulkIndexing(bulk))
+	}
 	idx, err := indexer.New(
 		config.OpenSearchAddr, config.OcrApiAddr, config.ZefixDsn,
 		opts...,
@@ -53,7 +149,8 @@ func New(config Config) (*Ingestor, error) {
 		return nil, fmt.Errorf("unable to create indexer: %w", err)
 	}
 
-	ing := &Ingestor{idx: idx, storage: config.Storage}
+	ing := NewWithAnalyzer(config, idx)
+	ing.idx = idx
 
 	// Check that everything works:
 	log.Debugf("Pinging services")
@@ -63,92 +160,198 @@ func New(config Config) (*Ingestor, error) {
 	return ing, err
 }
 
-func (i *Ingestor) ScanPages(scanner DocumentsScanner) error {
-	pageChan := make(chan models.ScannedPage)
+// NewWithAnalyzer returns an ingestor that stores the pages in
+// config.Storage and hands them to the analyzer. The OCR, OpenSearch and
+// extractors settings of the config aren't used.
+func NewWithAnalyzer(config Config, analyzer Analyzer) *Ingestor {
+	return &Ingestor{
+		analyzer:   analyzer,
+		storage:    config.Storage,
+		workers:    orDefault(config.Workers, DefaultWorkers),
+		queueSize:  orDefault(config.QueueSize, DefaultQueueSize),
+		storageSem: make(chan struct{}, orDefault(config.StorageConcurrency, DefaultStorageConcurrency)),
+		ocrSem:     make(chan struct{}, orDefault(config.OcrConcurrency, DefaultOcrConcurrency)),
+	}
+}
+
+// ScanPages stores and indexes the pages returned by the scanner. The report
+// lists the outcome of every page, even when an error is returned: the error
+// wraps ErrPagesFailed when only some pages failed.
+func (i *Ingestor) ScanPages(scanner DocumentsScanner, opts ...ScanOption) (*ScanReport, error) {
+	o := &scanOptions{scanId: uuid.NewString()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.scanReport = newScanReport(o.scanId)
+
+	// The queue is bounded: once it's full, the scanner isn't read until a
+	// worker is available, so that only a few pages are kept in memory
+	pageChan := make(chan queuedPage, i.queueSize)
 	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go i.processPage(pageChan, &wg)
+	for w := 0; w < i.workers; w++ {
+		wg.Add(1)
+		go i.processPage(pageChan, o, &wg)
+	}
+	wait := func() error {
+		close(pageChan)
+		wg.Wait()
+		// The last pages might still be waiting for a bulk request
+		i.analyzer.Flush()
+		return o.scanReport.finish()
+	}
 
-	scanId := uuid.NewString()
 	seq := 0
 	for scanner.ScanPage() {
 		seq++
-		b, err := io.ReadAll(scanner.CurrentPage())
+		r := scanner.CurrentPage()
+		b, err := io.ReadAll(r)
+		if c, ok := r.(io.Closer); ok {
+			_ = c.Close()
+		}
 		if err != nil {
-			return fmt.Errorf("unable to read page: %w", err)
+			_ = wait()
+			return o.scanReport, fmt.Errorf("unable to read page %d: %w", seq, err)
+		}
+		scanTime := time.Now()
+		if ts, ok := scanner.(TimedScanner); ok && !ts.CurrentPageTime().IsZero() {
+			scanTime = ts.CurrentPageTime()
 		}
-		pageChan <- models.ScannedPage{
-			Reader:     bytes.NewReader(b),
-			ScanId:     scanId,
-			SequenceId: seq,
-			ScanTime:   time.Now(),
+		o.report(o.scanId, seq, StageScanned, nil)
+		i.metrics.queued.Add(1)
+		pageChan <- queuedPage{
+			data: b,
+			page: models.ScannedPage{
+				ScanId:     o.scanId,
+				SequenceId: seq,
+				ScanTime:   scanTime,
+				Tags:       o.tags,
+				Owner:      o.owner,
+				Mail:       o.mail,
+			},
 		}
-		time.Sleep(100 * time.Millisecond) // Slow down infinite loops
 	}
-	close(pageChan)
-	wg.Wait()
-	return nil
+	if err := scanner.Err(); err != nil {
+		_ = wait()
+		return o.scanReport, fmt.Errorf("unable to scan: %w", err)
+	}
+	return o.scanReport, wait()
 }
 
 // Ingest takes care of connecting to the specified scanner, processes the document via OCR and outputs that to OpenSearch
-func (i *Ingestor) Ingest(scannerName string, source string) error {
+func (i *Ingestor) Ingest(scannerName string, profile profiles.Profile, opts ...ScanOption) (*ScanReport, error) {
 	c := airscan.NewClient(scannerName)
-	settings := preset.GrayscaleA4ADF()
-	settings.Duplex = false
-	settings.ColorMode = "RGB24"
-	settings.DocumentFormat = "image/jpeg"
-	settings.InputSource = source
-
-	job, err := c.Scan(settings)
+	job, err := c.Scan(profile.ScanSettings())
 	if err != nil {
-		return fmt.Errorf("unable to create scan job: %w", err)
+		return nil, fmt.Errorf("unable to create scan job: %w", err)
 	}
-	err = i.ScanPages(job)
-	return err
+	defer func() {
+		if err := job.Close(); err != nil {
+			log.Warnf("unable to delete the scan job: %v", err)
+		}
+	}()
+	opts = append([]ScanOption{WithTags(profile.Tags...), WithOwner(profile.Owner)}, opts...)
+	return i.ScanPages(job, opts...)
 }
 
-func (i *Ingestor) processPage(pageChan <-chan models.ScannedPage, wg *sync.WaitGroup) {
-	for page := range pageChan {
-		wg.Add(1)
-		go i.processPageInner(page, wg)
-	}
-	wg.Done()
+// queuedPage is a scanned page waiting for a worker, data is the content of
+// the page
+type queuedPage struct {
+	page models.ScannedPage
+	data []byte
 }
 
-func (i *Ingestor) processPageInner(page models.ScannedPage, wg *sync.WaitGroup) {
+func (i *Ingestor) processPage(pageChan <-chan queuedPage, o *scanOptions, wg *sync.WaitGroup) {
 	defer wg.Done()
-	buffer := bytes.NewBuffer([]byte{})
-	_, err := io.Copy(buffer, page.Reader)
-	if err != nil {
-		log.Errorf("unable to read page: %v", err)
-		return
+	for p := range pageChan {
+		i.metrics.queued.Add(-1)
+		i.processPageInner(p, o)
 	}
+}
 
-	page.Reader = bytes.NewReader(buffer.Bytes())
+func (i *Ingestor) processPageInner(p queuedPage, o *scanOptions) {
+	page := p.page
 
-	err = i.storage.Store(models.ScannedPage{
-		Reader:     bytes.NewReader(buffer.Bytes()),
+	i.storageSem <- struct{}{}
+	i.metrics.storing.Add(1)
+	err := i.storage.Store(models.ScannedPage{
+		Reader:     bytes.NewReader(p.data),
 		ScanId:     page.ScanId,
 		SequenceId: page.SequenceId,
+		ScanTime:   page.ScanTime,
 	})
+	i.metrics.storing.Add(-1)
+	<-i.storageSem
 	if err != nil {
 		log.Errorf("unable to store page: %v", err)
+		i.metrics.failed.Add(1)
+		o.report(page.ScanId, page.SequenceId, StageFailed, err)
 		return
 	}
+	i.metrics.stored.Add(1)
+	o.report(page.ScanId, page.SequenceId, StageStored, nil)
 
-	i.ocrAndIndex(page)
+	page.Reader = bytes.NewReader(p.data)
+	i.ocrAndIndex(page, o)
 }
 
-func (i *Ingestor) ocrAndIndex(page models.ScannedPage) {
+func (i *Ingestor) ocrAndIndex(page models.ScannedPage, o *scanOptions) {
 	log.Debugf("ingesting page %d of scan %q", page.SequenceId, page.ScanId)
-	err := i.idx.Index(page)
+	i.ocrSem <- struct{}{}
+	i.metrics.analyzing.Add(1)
+	doc, err := i.analyzer.Analyze(page)
+	i.metrics.analyzing.Add(-1)
+	<-i.ocrSem
+	if errors.Is(err, indexer.ErrDuplicate) {
+		log.Infof("skipping page: %v", err)
+		i.deleteStoredPage(page)
+		i.metrics.duplicates.Add(1)
+		o.report(page.ScanId, page.SequenceId, StageDuplicate, nil)
+		return
+	}
 	if err != nil {
-		log.Errorf("unable to index: %v", err)
+		log.Errorf("unable to analyze: %v", err)
+		i.metrics.failed.Add(1)
+		o.report(page.ScanId, page.SequenceId, StageFailed, err)
+		return
 	}
+	o.report(page.ScanId, page.SequenceId, StageAnalyzed, nil)
+
+	i.analyzer.IndexDocumentAsync(page.Id(), doc, func(err error) {
+		if err != nil {
+			log.Errorf("unable to index: %v", err)
+			i.metrics.failed.Add(1)
+			o.report(page.ScanId, page.SequenceId, StageFailed, err)
+			return
+		}
+		i.metrics.indexed.Add(1)
+		o.report(page.ScanId, page.SequenceId, StageIndexed, nil)
+	})
+}
+
+func (i *Ingestor) deleteStoredPage(page models.ScannedPage) {
+	deleter, ok := i.storage.(model.Deleter)
+	if !ok {
+		return
+	}
+	if err := deleter.Delete(page.ScanId, page.SequenceId); err != nil {
+		log.Warnf("unable to delete duplicate page %s: %v", page.Id(), err)
+	}
+}
+
+// IsImported returns whether a page with the given SHA-1 hash has already
+// been indexed, including the pages in the trash
+func (i *Ingestor) IsImported(hash string) (bool, error) {
+	if i.idx == nil {
+		return false, nil
+	}
+	return i.idx.HasHash(hash)
 }
 
 // Ping makes sure the two APIs (OCR and OpenSearch) are reachable
 func (i *Ingestor) Ping() error {
+	if i.idx == nil {
+		return nil
+	}
 	log.Debugf("Pinging OpenSearch")
 	res, err := i.idx.PingOpensearch()
 	if err != nil {
@@ -169,6 +372,15 @@ func (i *Ingestor) Ping() error {
 	}
 
 	log.Debugf("Pinging Zefix")
-	err = i.idx.PingZefix()
+	if err := i.idx.PingZefix(); err != nil {
+		return fmt.Errorf("unable to ping Zefix: %v", err)
+	}
 	return nil
 }
+
+func orDefault(v int, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/denysvitali/odi-backend/pkg/ingestor"
 	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
 	"github.com/denysvitali/odi-backend/pkg/storage/fs"
 )
 
@@ -30,10 +31,11 @@ func TestIngest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = i.Ingest(scanner, "adf")
+	report, err := i.Ingest(scanner, profiles.Default())
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Log(report.Summary())
 }
 
 func getIngestor(t *testing.T) *ingestor.Ingestor {
@@ -126,10 +128,13 @@ func TestIngestWithExamplePictures(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = i.ScanPages(&s)
+	report, err := i.ScanPages(&s)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if report.Indexed() != len(s.files) {
+		t.Fatalf("expected %d indexed pages, got %d", len(s.files), report.Indexed())
+	}
 }
 
 func mustOpen(s string) io.Reader {
@@ -0,0 +1,56 @@
+package ingestor
+
+import (
+	"sync/atomic"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+// Metrics describes the pages going through the ingestor, across all the
+// scans since it was created
+type Metrics struct {
+	// Queued, Storing and Analyzing are the pages currently waiting for a
+	// worker, being uploaded to the storage and being analyzed (OCR)
+	Queued    int64 `json:"queued"`
+	Storing   int64 `json:"storing"`
+	Analyzing int64 `json:"analyzing"`
+
+	Stored     int64 `json:"stored"`
+	Indexed    int64 `json:"indexed"`
+	Duplicates int64 `json:"duplicates"`
+	Failed     int64 `json:"failed"`
+
+	// Extractors are the runs, failures and total duration of the extractors
+	Extractors map[string]indexer.ExtractorStats `json:"extractors,omitempty"`
+	// CompanyCache are the hits and misses of the company lookups
+	CompanyCache *zefix.CacheStats `json:"companyCache,omitempty"`
+}
+
+type metrics struct {
+	queued     atomic.Int64
+	storing    atomic.Int64
+	analyzing  atomic.Int64
+	stored     atomic.Int64
+	indexed    atomic.Int64
+	duplicates atomic.Int64
+	failed     atomic.Int64
+}
+
+// Metrics returns the current counters of the ingestor
+func (i *Ingestor) Metrics() Metrics {
+	m := Metrics{
+		Queued:     i.metrics.queued.Load(),
+		Storing:    i.metrics.storing.Load(),
+		Analyzing:  i.metrics.analyzing.Load(),
+		Stored:     i.metrics.stored.Load(),
+		Indexed:    i.metrics.indexed.Load(),
+		Duplicates: i.metrics.duplicates.Load(),
+		Failed:     i.metrics.failed.Load(),
+	}
+	if i.idx != nil {
+		m.Extractors = i.idx.ExtractorStats()
+		m.CompanyCache = i.idx.CompanyCacheStats()
+	}
+	return m
+}
@@ -0,0 +1,95 @@
+package ingestor
+
+import (
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// Stage is a step of the processing of a page, reported to the progress
+// function of a scan
+type Stage string
+
+const (
+	StageScanned   Stage = "scanned"
+	StageStored    Stage = "stored"
+	StageAnalyzed  Stage = "analyzed"
+	StageIndexed   Stage = "indexed"
+	StageDuplicate Stage = "duplicate"
+	StageFailed    Stage = "failed"
+)
+
+type PageEvent struct {
+	ScanId     string    `json:"scanId"`
+	SequenceId int       `json:"sequenceId"`
+	Stage      Stage     `json:"stage"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type scanOptions struct {
+	scanId   string
+	tags     []string
+	owner    string
+	mail     *models.MailMetadata
+	progress func(PageEvent)
+
+	scanReport *ScanReport
+}
+
+type ScanOption func(*scanOptions)
+
+// WithScanId sets the ID of the scan instead of generating a random one
+func WithScanId(scanId string) ScanOption {
+	return func(o *scanOptions) {
+		o.scanId = scanId
+	}
+}
+
+// WithTags sets the tags of every page of the scan
+func WithTags(tags ...string) ScanOption {
+	return func(o *scanOptions) {
+		o.tags = tags
+	}
+}
+
+// WithOwner sets the owner of every page of the scan
+func WithOwner(owner string) ScanOption {
+	return func(o *scanOptions) {
+		o.owner = owner
+	}
+}
+
+// WithMail records the email the pages were received with
+func WithMail(mail *models.MailMetadata) ScanOption {
+	return func(o *scanOptions) {
+		o.mail = mail
+	}
+}
+
+// WithProgress sets a function called every time a page reaches a new
+// stage. It's called from multiple goroutines.
+func WithProgress(progress func(PageEvent)) ScanOption {
+	return func(o *scanOptions) {
+		o.progress = progress
+	}
+}
+
+func (o *scanOptions) report(scanId string, sequenceId int, stage Stage, err error) {
+	if o.scanReport != nil {
+		o.scanReport.record(sequenceId, stage, err)
+	}
+	if o.progress == nil {
+		return
+	}
+	e := PageEvent{
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		Stage:      stage,
+		Time:       time.Now(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	o.progress(e)
+}
@@ -0,0 +1,53 @@
+package ingestor_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+)
+
+func TestWithProgress(t *testing.T) {
+	i := ingestor.NewWithAnalyzer(ingestor.Config{
+		Storage: &fakeStorage{gate: openGate()},
+		Workers: 2,
+	}, &fakeAnalyzer{gate: openGate()})
+	var pages []io.Reader
+	for seq := 1; seq <= 5; seq++ {
+		pages = append(pages, strings.NewReader(fmt.Sprintf("page %d", seq)))
+	}
+
+	var mu sync.Mutex
+	stages := map[int][]ingestor.Stage{}
+	_, _ = i.ScanPages(ingestor.NewSliceScanner(pages...),
+		ingestor.WithScanId("scan"),
+		ingestor.WithProgress(func(e ingestor.PageEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.ScanId != "scan" || e.Time.IsZero() {
+				t.Errorf("unexpected event %+v", e)
+			}
+			if (e.Stage == ingestor.StageFailed) != (e.Error != "") {
+				t.Errorf("expected only the failed pages to have an error, got %+v", e)
+			}
+			stages[e.SequenceId] = append(stages[e.SequenceId], e.Stage)
+		}),
+	)
+
+	// Page 4 can't be analyzed, page 5 is a duplicate
+	want := map[int]string{
+		1: "[scanned stored analyzed indexed]",
+		2: "[scanned stored analyzed indexed]",
+		3: "[scanned stored analyzed indexed]",
+		4: "[scanned stored failed]",
+		5: "[scanned stored duplicate]",
+	}
+	for seq, w := range want {
+		if got := fmt.Sprint(stages[seq]); got != w {
+			t.Errorf("page %d: expected %s, got %s", seq, w, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ingestor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrPagesFailed is returned by ScanPages when some pages couldn't be stored
+// or indexed, the ScanReport lists them
+var ErrPagesFailed = errors.New("pages failed")
+
+// PageResult is the outcome of a page: StageIndexed, StageDuplicate or
+// StageFailed
+type PageResult struct {
+	SequenceId int    `json:"sequenceId"`
+	Status     Stage  `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ScanReport lists the pages of a scan with their outcome
+type ScanReport struct {
+	ScanId     string       `json:"scanId"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Pages      []PageResult `json:"pages"`
+
+	mu sync.Mutex
+}
+
+func newScanReport(scanId string) *ScanReport {
+	return &ScanReport{ScanId: scanId, StartedAt: time.Now()}
+}
+
+func (r *ScanReport) record(sequenceId int, stage Stage, err error) {
+	switch stage {
+	case StageIndexed, StageDuplicate, StageFailed:
+	default:
+		return
+	}
+	res := PageResult{SequenceId: sequenceId, Status: stage}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Pages = append(r.Pages, res)
+}
+
+// finish sorts the pages and returns ErrPagesFailed when some of them failed
+func (r *ScanReport) finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+	sort.Slice(r.Pages, func(i, j int) bool {
+		return r.Pages[i].SequenceId < r.Pages[j].SequenceId
+	})
+	if failed := r.count(StageFailed); failed > 0 {
+		return fmt.Errorf("%w: %d of %d pages of scan %s", ErrPagesFailed, failed, len(r.Pages), r.ScanId)
+	}
+	return nil
+}
+
+func (r *ScanReport) count(stage Stage) int {
+	n := 0
+	for _, p := range r.Pages {
+		if p.Status == stage {
+			n++
+		}
+	}
+	return n
+}
+
+// Indexed returns the number of pages that were indexed
+func (r *ScanReport) Indexed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count(StageIndexed)
+}
+
+// Duplicates returns the number of pages skipped as duplicates
+func (r *ScanReport) Duplicates() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count(StageDuplicate)
+}
+
+// Failed returns the pages that couldn't be stored or indexed
+func (r *ScanReport) Failed() []PageResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var failed []PageResult
+	for _, p := range r.Pages {
+		if p.Status == StageFailed {
+			failed = append(failed, p)
+		}
+	}
+	return failed
+}
+
+// Summary returns a one-line description of the scan
+func (r *ScanReport) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprintf("scan %s: %d pages, %d indexed, %d duplicates, %d failed in %s",
+		r.ScanId, len(r.Pages), r.count(StageIndexed), r.count(StageDuplicate), r.count(StageFailed),
+		r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+}
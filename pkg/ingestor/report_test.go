@@ -0,0 +1,83 @@
+package ingestor_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+)
+
+func scan(t *testing.T, n int) (*ingestor.ScanReport, error) {
+	t.Helper()
+	i := ingestor.NewWithAnalyzer(ingestor.Config{
+		Storage: &fakeStorage{gate: openGate()},
+		Workers: 4,
+	}, &fakeAnalyzer{gate: openGate()})
+	var pages []io.Reader
+	for seq := 1; seq <= n; seq++ {
+		pages = append(pages, strings.NewReader(fmt.Sprintf("page %d", seq)))
+	}
+	return i.ScanPages(ingestor.NewSliceScanner(pages...), ingestor.WithScanId("scan"))
+}
+
+func TestScanReport(t *testing.T) {
+	report, err := scan(t, 8)
+	if !errors.Is(err, ingestor.ErrPagesFailed) || !strings.Contains(err.Error(), "1 of 8 pages of scan scan") {
+		t.Errorf("expected ErrPagesFailed for 1 of 8 pages, got %v", err)
+	}
+
+	// Only the outcome of the pages is recorded, sorted by sequence number
+	if len(report.Pages) != 8 {
+		t.Fatalf("expected 8 pages, got %+v", report.Pages)
+	}
+	for n, p := range report.Pages {
+		want := ingestor.StageIndexed
+		switch p.SequenceId {
+		case 4:
+			want = ingestor.StageFailed
+		case 5:
+			want = ingestor.StageDuplicate
+		}
+		if p.SequenceId != n+1 || p.Status != want {
+			t.Errorf("expected page %d to be %s, got %+v", n+1, want, p)
+		}
+	}
+	failed := report.Failed()
+	if len(failed) != 1 || failed[0].SequenceId != 4 || failed[0].Error != "OCR failed" {
+		t.Errorf("unexpected failed pages %+v", failed)
+	}
+	if report.Indexed() != 6 || report.Duplicates() != 1 {
+		t.Errorf("expected 6 indexed and 1 duplicate, got %d and %d", report.Indexed(), report.Duplicates())
+	}
+	if report.FinishedAt.Before(report.StartedAt) {
+		t.Errorf("finished at %v, before %v", report.FinishedAt, report.StartedAt)
+	}
+
+	summary := regexp.MustCompile(`^scan scan: 8 pages, 6 indexed, 1 duplicates, 1 failed in \d+s$`)
+	if !summary.MatchString(report.Summary()) {
+		t.Errorf("unexpected summary %q", report.Summary())
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `{"sequenceId":4,"status":"failed","error":"OCR failed"}`) {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestScanReportWithoutFailures(t *testing.T) {
+	report, err := scan(t, 3)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if report.Indexed() != 3 || len(report.Failed()) != 0 {
+		t.Errorf("unexpected report %s", report.Summary())
+	}
+}
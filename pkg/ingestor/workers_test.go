@@ -0,0 +1,209 @@
+package ingestor_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// gate counts the callers going through it and blocks them until it's
+// opened
+type gate struct {
+	open    chan struct{}
+	current atomic.Int64
+	max     atomic.Int64
+}
+
+func newGate() *gate {
+	return &gate{open: make(chan struct{})}
+}
+
+// openGate returns a gate that doesn't block
+func openGate() *gate {
+	g := newGate()
+	close(g.open)
+	return g
+}
+
+func (g *gate) pass() {
+	n := g.current.Add(1)
+	for {
+		m := g.max.Load()
+		if n <= m || g.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	<-g.open
+	g.current.Add(-1)
+}
+
+type fakeStorage struct {
+	gate    *gate
+	mu      sync.Mutex
+	stored  []string
+	deleted []string
+}
+
+func (s *fakeStorage) Store(page models.ScannedPage) error {
+	s.gate.pass()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored = append(s.stored, page.Id())
+	return nil
+}
+
+func (s *fakeStorage) Delete(scanId string, sequenceNumber int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, fmt.Sprintf("%s_%d", scanId, sequenceNumber))
+	return nil
+}
+
+// fakeAnalyzer fails on page 4 and finds that page 5 is a duplicate
+type fakeAnalyzer struct {
+	gate    *gate
+	flushed atomic.Bool
+}
+
+func (a *fakeAnalyzer) Analyze(page models.ScannedPage) (*models.Document, error) {
+	a.gate.pass()
+	switch page.SequenceId {
+	case 4:
+		return nil, errors.New("OCR failed")
+	case 5:
+		return nil, fmt.Errorf("%w: page 1", indexer.ErrDuplicate)
+	}
+	return &models.Document{}, nil
+}
+
+func (a *fakeAnalyzer) IndexDocumentAsync(id string, d *models.Document, done func(error)) {
+	done(nil)
+}
+
+func (a *fakeAnalyzer) Flush() {
+	a.flushed.Store(true)
+}
+
+// countingScanner counts the pages read from the scanner
+type countingScanner struct {
+	*ingestor.SliceScanner
+	read atomic.Int64
+}
+
+func (s *countingScanner) ScanPage() bool {
+	if !s.SliceScanner.ScanPage() {
+		return false
+	}
+	s.read.Add(1)
+	return true
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Make sure nothing else happens while the workers are blocked
+	time.Sleep(20 * time.Millisecond)
+	if !cond() {
+		t.Fatalf("%s didn't last", what)
+	}
+}
+
+func TestScanPagesLimits(t *testing.T) {
+	storage := &fakeStorage{gate: newGate()}
+	analyzer := &fakeAnalyzer{gate: newGate()}
+	i := ingestor.NewWithAnalyzer(ingestor.Config{
+		Storage:            storage,
+		Workers:            3,
+		QueueSize:          2,
+		StorageConcurrency: 1,
+		OcrConcurrency:     2,
+	}, analyzer)
+
+	var pages []io.Reader
+	for n := 1; n <= 10; n++ {
+		pages = append(pages, strings.NewReader(fmt.Sprintf("page %d", n)))
+	}
+	scanner := &countingScanner{SliceScanner: ingestor.NewSliceScanner(pages...)}
+
+	type result struct {
+		report *ingestor.ScanReport
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		report, err := i.ScanPages(scanner, ingestor.WithScanId("scan"))
+		done <- result{report, err}
+	}()
+
+	// One worker is storing a page, the two others wait for the storage and
+	// two pages are in the queue: the scanner is paused on the sixth page,
+	// which is counted as queued
+	waitFor(t, "the queue to be full", func() bool {
+		m := i.Metrics()
+		return scanner.read.Load() == 6 && m.Storing == 1 && m.Queued == 3
+	})
+	if n := storage.gate.current.Load(); n != 1 {
+		t.Errorf("expected 1 page being stored, got %d", n)
+	}
+
+	// The OCR is now the bottleneck: two workers analyze a page, the third
+	// one waits
+	close(storage.gate.open)
+	waitFor(t, "the OCR to be busy", func() bool {
+		m := i.Metrics()
+		return m.Analyzing == 2 && m.Stored == 3 && scanner.read.Load() == 6
+	})
+	close(analyzer.gate.open)
+
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scan")
+	}
+
+	if !errors.Is(res.err, ingestor.ErrPagesFailed) {
+		t.Errorf("expected ErrPagesFailed, got %v", res.err)
+	}
+	if storage.gate.max.Load() != 1 || analyzer.gate.max.Load() != 2 {
+		t.Errorf("expected at most 1 page stored and 2 analyzed at once, got %d and %d",
+			storage.gate.max.Load(), analyzer.gate.max.Load())
+	}
+	if len(storage.stored) != 10 || fmt.Sprint(storage.deleted) != "[scan_5]" {
+		t.Errorf("unexpected storage: stored %v, deleted %v", storage.stored, storage.deleted)
+	}
+	if !analyzer.flushed.Load() {
+		t.Error("expected the analyzer to be flushed")
+	}
+
+	report := res.report
+	if report.Indexed() != 8 || report.Duplicates() != 1 || len(report.Failed()) != 1 {
+		t.Errorf("unexpected report %s", report.Summary())
+	}
+	for n, p := range report.Pages {
+		if p.SequenceId != n+1 {
+			t.Errorf("expected the pages to be sorted, got %+v", report.Pages)
+			break
+		}
+	}
+
+	want := ingestor.Metrics{Stored: 10, Indexed: 8, Duplicates: 1, Failed: 1}
+	if m := i.Metrics(); fmt.Sprintf("%+v", m) != fmt.Sprintf("%+v", want) {
+		t.Errorf("expected metrics %+v, got %+v", want, m)
+	}
+}
@@ -0,0 +1,501 @@
+// Package mailpoller ingests the attachments of the emails of an IMAP
+// mailbox: every email becomes a scan whose pages are its images and the
+// images embedded in its PDFs. Processed emails are moved to another mailbox.
+package mailpoller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+var log = logrus.StandardLogger().WithField("package", "mailpoller")
+
+// IgnoredFlag marks the emails without anything to ingest, they're left in
+// the mailbox but not fetched again
+const IgnoredFlag = "$OdiIgnored"
+
+// FailedFlag marks the emails that couldn't be ingested after MaxAttempts
+// polls, they're left in the mailbox but not fetched again
+const FailedFlag = "$OdiFailed"
+
+// AttemptFlagPrefix is followed by the number of failed attempts at ingesting
+// the email (e.g. $OdiAttempt2), so that they're counted across runs
+const AttemptFlagPrefix = "$OdiAttempt"
+
+const (
+	DefaultMailbox           = "INBOX"
+	DefaultProcessedMailbox  = "Processed"
+	DefaultInterval          = 5 * time.Minute
+	DefaultMaxAttachmentSize = 20 << 20
+	DefaultMaxAttempts       = 3
+)
+
+type Config struct {
+	// Addr is the host:port of the IMAP server
+	Addr     string
+	Username string
+	Password string
+	// TLS connects with implicit TLS (usually port 993), otherwise STARTTLS
+	// is used when the server supports it
+	TLS                bool
+	InsecureSkipVerify bool
+
+	Mailbox           string
+	ProcessedMailbox  string
+	Interval          time.Duration
+	MaxAttachmentSize int64
+	// MaxAttempts is the number of polls an email is retried on when none of
+	// its pages could be ingested, the attempts are recorded in a flag of the
+	// email (see AttemptFlagPrefix)
+	MaxAttempts int
+
+	// Tags and Owner are set on every ingested document
+	Tags  []string
+	Owner string
+}
+
+// Ingestor is implemented by *ingestor.Ingestor
+type Ingestor interface {
+	ScanPages(scanner ingestor.DocumentsScanner, opts ...ingestor.ScanOption) (*ingestor.ScanReport, error)
+}
+
+type Poller struct {
+	config   Config
+	ingestor Ingestor
+	// attempts are the failed attempts by UID, for the servers that don't
+	// store the attempt flags
+	attempts map[uint32]int
+}
+
+func New(config Config, ing Ingestor) (*Poller, error) {
+	if config.Addr == "" {
+		return nil, fmt.Errorf("missing IMAP address")
+	}
+	if config.Mailbox == "" {
+		config.Mailbox = DefaultMailbox
+	}
+	if config.ProcessedMailbox == "" {
+		config.ProcessedMailbox = DefaultProcessedMailbox
+	}
+	if config.Mailbox == config.ProcessedMailbox {
+		return nil, fmt.Errorf("the processed mailbox must be different from the watched one")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MaxAttachmentSize <= 0 {
+		config.MaxAttachmentSize = DefaultMaxAttachmentSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	return &Poller{config: config, ingestor: ing, attempts: map[uint32]int{}}, nil
+}
+
+// Run polls the mailbox until the context is done
+func (p *Poller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := p.Poll()
+		if err != nil {
+			log.Errorf("unable to poll %s: %v", p.config.Mailbox, err)
+		} else if n > 0 {
+			log.Infof("ingested %d emails", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type fetchedMail struct {
+	uid          uint32
+	internalDate time.Time
+	body         []byte
+	// attempts is the number of failed attempts recorded in the flags
+	attempts int
+}
+
+// Poll ingests the emails currently in the mailbox and returns how many have
+// been ingested
+func (p *Poller) Poll() (int, error) {
+	c, err := p.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer c.Logout()
+
+	if err := p.ensureMailbox(c, p.config.ProcessedMailbox); err != nil {
+		return 0, err
+	}
+	if _, err := c.Select(p.config.Mailbox, false); err != nil {
+		return 0, fmt.Errorf("unable to select %s: %w", p.config.Mailbox, err)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{IgnoredFlag, FailedFlag, imap.DeletedFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return 0, fmt.Errorf("unable to search: %w", err)
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+
+	mails, err := p.fetch(c, uids)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := new(imap.SeqSet)
+	ignored := new(imap.SeqSet)
+	failed := new(imap.SeqSet)
+	for _, m := range mails {
+		pages, meta, err := p.parse(m)
+		if err != nil {
+			log.Warnf("ignoring email %d: %v", m.uid, err)
+			ignored.AddNum(m.uid)
+			continue
+		}
+		if len(pages) == 0 {
+			log.Infof("ignoring email %d (%q): no images or PDFs", m.uid, meta.Subject)
+			ignored.AddNum(m.uid)
+			continue
+		}
+
+		log.Infof("ingesting %d pages from %q (%s)", len(pages), meta.Subject, meta.From)
+		report, err := p.ingestor.ScanPages(ingestor.NewSliceScanner(pages...),
+			ingestor.WithMail(meta),
+			ingestor.WithTags(p.config.Tags...),
+			ingestor.WithOwner(p.config.Owner),
+		)
+		if err != nil && !partiallyIngested(report, err) {
+			attempts := max(p.attempts[m.uid], m.attempts) + 1
+			p.attempts[m.uid] = attempts
+			if attempts < p.config.MaxAttempts {
+				// Left in the mailbox, it will be retried on the next poll
+				log.Errorf("unable to ingest email %d (attempt %d of %d): %v", m.uid, attempts, p.config.MaxAttempts, err)
+				p.setAttempts(c, m, attempts)
+				continue
+			}
+			log.Errorf("unable to ingest email %d, giving up after %d attempts: %v", m.uid, attempts, err)
+			delete(p.attempts, m.uid)
+			failed.AddNum(m.uid)
+			continue
+		}
+		if err != nil {
+			// Ingesting the email again would duplicate the pages that were
+			// indexed, the failed ones are listed in the report
+			log.Warnf("email %d (%q) partially ingested: %v", m.uid, meta.Subject, err)
+		}
+		delete(p.attempts, m.uid)
+		processed.AddNum(m.uid)
+	}
+
+	p.flag(c, ignored, IgnoredFlag)
+	p.flag(c, failed, FailedFlag)
+	if processed.Empty() {
+		return 0, nil
+	}
+	if err := p.move(c, processed, p.config.ProcessedMailbox); err != nil {
+		return 0, fmt.Errorf("unable to move the processed emails: %w", err)
+	}
+	return len(processed.Set), nil
+}
+
+// partiallyIngested tells whether some pages of the scan were indexed even
+// though it failed
+func partiallyIngested(report *ingestor.ScanReport, err error) bool {
+	if report == nil || !errors.Is(err, ingestor.ErrPagesFailed) {
+		return false
+	}
+	return report.Indexed()+report.Duplicates() > 0
+}
+
+func (p *Poller) flag(c *client.Client, uids *imap.SeqSet, flag string) {
+	if uids.Empty() {
+		return
+	}
+	flags := []interface{}{flag}
+	if err := c.UidStore(uids, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+		log.Warnf("unable to flag the emails as %s: %v", flag, err)
+	}
+}
+
+// setAttempts replaces the attempt flag of the email
+func (p *Poller) setAttempts(c *client.Client, m fetchedMail, attempts int) {
+	uids := new(imap.SeqSet)
+	uids.AddNum(m.uid)
+	if m.attempts > 0 {
+		flags := []interface{}{attemptFlag(m.attempts)}
+		if err := c.UidStore(uids, imap.FormatFlagsOp(imap.RemoveFlags, true), flags, nil); err != nil {
+			log.Warnf("unable to remove the attempt flag of email %d: %v", m.uid, err)
+		}
+	}
+	p.flag(c, uids, attemptFlag(attempts))
+}
+
+func attemptFlag(attempts int) string {
+	return AttemptFlagPrefix + strconv.Itoa(attempts)
+}
+
+// attemptsOf returns the number of failed attempts recorded in the flags, the
+// keywords are case-insensitive and go-imap returns them in lower case
+func attemptsOf(flags []string) int {
+	attempts := 0
+	prefix := strings.ToLower(AttemptFlagPrefix)
+	for _, f := range flags {
+		n, ok := strings.CutPrefix(strings.ToLower(f), prefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(n); err == nil {
+			attempts = max(attempts, n)
+		}
+	}
+	return attempts
+}
+
+// move uses MOVE and falls back to COPY + UID EXPUNGE, since some servers
+// advertise the extension without supporting it for every mailbox
+func (p *Poller) move(c *client.Client, uids *imap.SeqSet, dest string) error {
+	err := c.UidMove(uids, dest)
+	if err == nil {
+		return nil
+	}
+	log.Debugf("MOVE failed, falling back to COPY: %v", err)
+
+	if err := c.UidCopy(uids, dest); err != nil {
+		return err
+	}
+	flags := []interface{}{imap.DeletedFlag}
+	if err := c.UidStore(uids, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+		return err
+	}
+	// A plain EXPUNGE would also remove the emails that someone else flagged
+	// as deleted
+	if ok, _ := c.Support("UIDPLUS"); !ok {
+		log.Warnf("the server doesn't support UIDPLUS, the processed emails are left in %s flagged as deleted", p.config.Mailbox)
+		return nil
+	}
+	status, err := c.Execute(&commands.Uid{Cmd: &expunge{uids: uids}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// expunge is the EXPUNGE command with a set of UIDs, that is only valid as
+// UID EXPUNGE (RFC 4315)
+type expunge struct {
+	uids *imap.SeqSet
+}
+
+func (cmd *expunge) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.uids}}
+}
+
+func (p *Poller) connect() (*client.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.config.InsecureSkipVerify}
+	if host, _, ok := strings.Cut(p.config.Addr, ":"); ok {
+		tlsConfig.ServerName = host
+	}
+
+	var c *client.Client
+	var err error
+	if p.config.TLS {
+		c, err = client.DialTLS(p.config.Addr, tlsConfig)
+	} else {
+		c, err = client.Dial(p.config.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", p.config.Addr, err)
+	}
+
+	if !p.config.TLS {
+		if ok, _ := c.SupportStartTLS(); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Logout()
+				return nil, fmt.Errorf("unable to start TLS: %w", err)
+			}
+		}
+	}
+
+	if err := c.Login(p.config.Username, p.config.Password); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("unable to login: %w", err)
+	}
+	return c, nil
+}
+
+func (p *Poller) ensureMailbox(c *client.Client, name string) error {
+	ch := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", name, ch)
+	}()
+	found := false
+	for range ch {
+		found = true
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("unable to list mailboxes: %w", err)
+	}
+	if found {
+		return nil
+	}
+	if err := c.Create(name); err != nil {
+		return fmt.Errorf("unable to create %s: %w", name, err)
+	}
+	return nil
+}
+
+func (p *Poller) fetch(c *client.Client, uids []uint32) ([]fetchedMail, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchFlags, section.FetchItem()}
+
+	ch := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, items, ch)
+	}()
+
+	var mails []fetchedMail
+	for msg := range ch {
+		body := msg.GetBody(section)
+		if body == nil {
+			continue
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read email %d: %w", msg.Uid, err)
+		}
+		mails = append(mails, fetchedMail{
+			uid:          msg.Uid,
+			internalDate: msg.InternalDate,
+			body:         b,
+			attempts:     attemptsOf(msg.Flags),
+		})
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("unable to fetch: %w", err)
+	}
+	return mails, nil
+}
+
+// parse returns the pages found in the attachments (and inline images) of
+// the email
+func (p *Poller) parse(m fetchedMail) ([]io.Reader, *models.MailMetadata, error) {
+	mr, err := mail.CreateReader(bytes.NewReader(m.body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse: %w", err)
+	}
+	defer mr.Close()
+
+	meta := &models.MailMetadata{ReceivedAt: m.internalDate}
+	if from, err := mr.Header.AddressList("From"); err == nil && len(from) > 0 {
+		meta.From = from[0].Address
+	}
+	meta.Subject, _ = mr.Header.Subject()
+	meta.MessageId, _ = mr.Header.MessageID()
+	if meta.ReceivedAt.IsZero() {
+		meta.ReceivedAt, _ = mr.Header.Date()
+	}
+
+	var pages []io.Reader
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, meta, fmt.Errorf("unable to read part: %w", err)
+		}
+
+		var contentType, name string
+		switch h := part.Header.(type) {
+		case *mail.AttachmentHeader:
+			contentType, _, _ = h.ContentType()
+			name, _ = h.Filename()
+		case *mail.InlineHeader:
+			contentType, _, _ = h.ContentType()
+		}
+		if !isSupported(contentType, name) {
+			continue
+		}
+
+		b, err := io.ReadAll(io.LimitReader(part.Body, p.config.MaxAttachmentSize+1))
+		if err != nil {
+			return nil, meta, fmt.Errorf("unable to read attachment %q: %w", name, err)
+		}
+		if int64(len(b)) > p.config.MaxAttachmentSize {
+			log.Warnf("skipping attachment %q of %q: larger than %d bytes", name, meta.Subject, p.config.MaxAttachmentSize)
+			continue
+		}
+
+		attachmentPages, err := toPages(b)
+		if err != nil {
+			log.Warnf("skipping attachment %q of %q: %v", name, meta.Subject, err)
+			continue
+		}
+		pages = append(pages, attachmentPages...)
+	}
+	return pages, meta, nil
+}
+
+func isSupported(contentType string, name string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/heic", "image/heif", "application/pdf":
+		return true
+	case "application/octet-stream":
+		// Some clients don't set the type of the attachments
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return false
+		}
+		ext := strings.ToLower(name[i:])
+		if ext == ".heic" || ext == ".heif" {
+			// Not in the built-in MIME types
+			return true
+		}
+		t, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+		return t != contentType && isSupported(t, "")
+	}
+	return false
+}
+
+func toPages(b []byte) ([]io.Reader, error) {
+	images, err := ingestor.NormalizePages(b)
+	if err != nil {
+		return nil, err
+	}
+	var pages []io.Reader
+	for _, img := range images {
+		pages = append(pages, bytes.NewReader(img))
+	}
+	return pages, nil
+}
@@ -0,0 +1,395 @@
+package mailpoller_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-message/mail"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/mailpoller"
+)
+
+type fakeIngestor struct {
+	mu    sync.Mutex
+	scans [][]io.Reader
+	// report and err are returned by ScanPages when set
+	report *ingestor.ScanReport
+	err    error
+}
+
+func (f *fakeIngestor) ScanPages(scanner ingestor.DocumentsScanner, _ ...ingestor.ScanOption) (*ingestor.ScanReport, error) {
+	var pages []io.Reader
+	for scanner.ScanPage() {
+		pages = append(pages, scanner.CurrentPage())
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scans = append(f.scans, pages)
+	if f.report != nil || f.err != nil {
+		return f.report, f.err
+	}
+	return &ingestor.ScanReport{}, nil
+}
+
+// uidPlus provides the UID EXPUNGE command of the UIDPLUS extension, that
+// the in-memory server lacks
+type uidPlus struct{}
+
+func (uidPlus) Capabilities(server.Conn) []string {
+	return []string{"UIDPLUS"}
+}
+
+func (uidPlus) Command(name string) server.HandlerFactory {
+	if name != "EXPUNGE" {
+		return nil
+	}
+	return func() server.Handler { return &uidExpunge{} }
+}
+
+type uidExpunge struct {
+	server.Expunge
+	uids *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	set, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.uids, err = imap.ParseSeqSet(set)
+	return err
+}
+
+// UidHandle keeps the deleted messages that aren't in the set by unflagging
+// them during the expunge
+func (cmd *uidExpunge) UidHandle(conn server.Conn) error {
+	mbox := conn.Context().Mailbox
+	if mbox == nil || cmd.uids == nil {
+		return errors.New("no mailbox selected or no UIDs")
+	}
+	deleted, err := mbox.SearchMessages(true, &imap.SearchCriteria{WithFlags: []string{imap.DeletedFlag}})
+	if err != nil {
+		return err
+	}
+	kept := new(imap.SeqSet)
+	for _, uid := range deleted {
+		if !cmd.uids.Contains(uid) {
+			kept.AddNum(uid)
+		}
+	}
+	if kept.Empty() {
+		return cmd.Handle(conn)
+	}
+	flags := []string{imap.DeletedFlag}
+	if err := mbox.UpdateMessagesFlags(true, kept, imap.RemoveFlags, flags); err != nil {
+		return err
+	}
+	defer mbox.UpdateMessagesFlags(true, kept, imap.AddFlags, flags)
+	return cmd.Handle(conn)
+}
+
+// startServer starts an in-memory IMAP server, with a single user
+// "username" / "password" that has one text-only email in the INBOX
+func startServer(t *testing.T, extensions ...server.Extension) string {
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	s.Enable(extensions...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func jpegImage(t *testing.T) []byte {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 100, 50)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func scannedPdf(images ...[]byte) []byte {
+	buf := bytes.NewBufferString("%PDF-1.4\n")
+	for i, img := range images {
+		fmt.Fprintf(buf, "%d 0 obj\n<< /Type /XObject /Subtype /Image /Filter /DCTDecode /Length %d >>\nstream\n", i+1, len(img))
+		buf.Write(img)
+		fmt.Fprintf(buf, "\nendstream\nendobj\n")
+	}
+	buf.WriteString("%%EOF\n")
+	return buf.Bytes()
+}
+
+func invoiceMail(t *testing.T) *bytes.Buffer {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{{Name: "Billing", Address: "billing@example.com"}})
+	h.SetSubject("Your invoice")
+
+	buf := bytes.NewBuffer(nil)
+	mw, err := mail.CreateWriter(buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw, err := mw.CreateInline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var th mail.InlineHeader
+	th.Set("Content-Type", "text/plain")
+	w, err := tw.CreatePart(th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Please find the invoice attached.")
+	w.Close()
+	tw.Close()
+
+	attachments := []struct {
+		name, contentType string
+		content           []byte
+	}{
+		{"photo.jpg", "image/jpeg", jpegImage(t)},
+		{"scan.pdf", "application/octet-stream", scannedPdf(jpegImage(t), jpegImage(t))},
+		{"notes.txt", "text/plain", []byte("not a page")},
+	}
+	for _, a := range attachments {
+		var ah mail.AttachmentHeader
+		ah.Set("Content-Type", a.contentType)
+		ah.SetFilename(a.name)
+		w, err := mw.CreateAttachment(ah)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(a.content)
+		w.Close()
+	}
+	mw.Close()
+	return buf
+}
+
+func mailboxSize(t *testing.T, c *client.Client, name string) uint32 {
+	status, err := c.Status(name, []imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status.Messages
+}
+
+func TestPoller_Poll(t *testing.T) {
+	addr := startServer(t, uidPlus{})
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logout()
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), invoiceMail(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	ing := &fakeIngestor{}
+	p, err := mailpoller.New(mailpoller.Config{
+		Addr:     addr,
+		Username: "username",
+		Password: "password",
+	}, ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 ingested email, got %d", n)
+	}
+	if len(ing.scans) != 1 || len(ing.scans[0]) != 3 {
+		t.Fatalf("expected a scan with the photo and the 2 PDF pages, got %v", ing.scans)
+	}
+
+	// The invoice has been moved, the text-only email stays in the INBOX
+	if s := mailboxSize(t, c, "INBOX"); s != 1 {
+		t.Fatalf("expected 1 email left in the INBOX, got %d", s)
+	}
+	if s := mailboxSize(t, c, mailpoller.DefaultProcessedMailbox); s != 1 {
+		t.Fatalf("expected 1 processed email, got %d", s)
+	}
+
+	// The ignored email isn't fetched again
+	n, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || len(ing.scans) != 1 {
+		t.Fatalf("expected nothing to ingest, got %d emails", n)
+	}
+}
+
+func newPoller(t *testing.T, ing *fakeIngestor, extensions ...server.Extension) (*mailpoller.Poller, *client.Client) {
+	t.Helper()
+	addr := startServer(t, extensions...)
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), invoiceMail(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := mailpoller.New(mailpoller.Config{
+		Addr:     addr,
+		Username: "username",
+		Password: "password",
+	}, ing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, c
+}
+
+func TestPoller_PollPartiallyIngested(t *testing.T) {
+	ing := &fakeIngestor{
+		report: &ingestor.ScanReport{Pages: []ingestor.PageResult{
+			{SequenceId: 1, Status: ingestor.StageIndexed},
+			{SequenceId: 2, Status: ingestor.StageFailed, Error: "unable to store"},
+			{SequenceId: 3, Status: ingestor.StageIndexed},
+		}},
+		err: fmt.Errorf("%w: 1 of 3 pages", ingestor.ErrPagesFailed),
+	}
+	p, c := newPoller(t, ing, uidPlus{})
+
+	// Ingesting the email again would duplicate the indexed pages
+	for poll := 0; poll < 2; poll++ {
+		if _, err := p.Poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ing.scans) != 1 {
+		t.Fatalf("expected the email to be ingested once, got %d scans", len(ing.scans))
+	}
+	if s := mailboxSize(t, c, mailpoller.DefaultProcessedMailbox); s != 1 {
+		t.Fatalf("expected 1 processed email, got %d", s)
+	}
+}
+
+func TestPoller_PollFailed(t *testing.T) {
+	ing := &fakeIngestor{err: fmt.Errorf("storage unavailable")}
+	p, c := newPoller(t, ing, uidPlus{})
+
+	for poll := 0; poll < mailpoller.DefaultMaxAttempts+2; poll++ {
+		n, err := p.Poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("expected nothing to be ingested, got %d emails", n)
+		}
+	}
+	if len(ing.scans) != mailpoller.DefaultMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", mailpoller.DefaultMaxAttempts, len(ing.scans))
+	}
+	// The email is left in the INBOX, flagged as failed
+	if s := mailboxSize(t, c, "INBOX"); s != 2 {
+		t.Fatalf("expected 2 emails left in the INBOX, got %d", s)
+	}
+}
+
+func TestPoller_PollFailedAcrossRuns(t *testing.T) {
+	ing := &fakeIngestor{err: fmt.Errorf("storage unavailable")}
+	addr := startServer(t, uidPlus{})
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), invoiceMail(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every poll is a new process, as with --once
+	for poll := 0; poll < mailpoller.DefaultMaxAttempts+2; poll++ {
+		p, err := mailpoller.New(mailpoller.Config{
+			Addr:     addr,
+			Username: "username",
+			Password: "password",
+		}, ing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ing.scans) != mailpoller.DefaultMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", mailpoller.DefaultMaxAttempts, len(ing.scans))
+	}
+}
+
+func TestPoller_PollKeepsDeletedEmails(t *testing.T) {
+	ing := &fakeIngestor{}
+	p, c := newPoller(t, ing, uidPlus{})
+	// Flagged as deleted by another client, but not expunged yet
+	if err := c.Append("INBOX", []string{imap.DeletedFlag}, time.Now(), invoiceMail(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := p.Poll(); err != nil || n != 1 {
+		t.Fatalf("expected 1 ingested email, got %d: %v", n, err)
+	}
+	if s := mailboxSize(t, c, "INBOX"); s != 2 {
+		t.Fatalf("expected the text-only and the deleted emails in the INBOX, got %d", s)
+	}
+	if s := mailboxSize(t, c, mailpoller.DefaultProcessedMailbox); s != 1 {
+		t.Fatalf("expected 1 processed email, got %d", s)
+	}
+}
+
+func TestPoller_PollWithoutUidPlus(t *testing.T) {
+	ing := &fakeIngestor{}
+	p, c := newPoller(t, ing)
+
+	// The processed email is copied and flagged as deleted, but not expunged
+	for poll := 0; poll < 2; poll++ {
+		if _, err := p.Poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ing.scans) != 1 {
+		t.Fatalf("expected the email to be ingested once, got %d scans", len(ing.scans))
+	}
+	if s := mailboxSize(t, c, "INBOX"); s != 2 {
+		t.Fatalf("expected 2 emails left in the INBOX, got %d", s)
+	}
+	if s := mailboxSize(t, c, mailpoller.DefaultProcessedMailbox); s != 1 {
+		t.Fatalf("expected 1 processed email, got %d", s)
+	}
+}
@@ -0,0 +1,8 @@
+package models
+
+// Amount is a sum of money, Currency is an ISO 4217 code (e.g. CHF) and is
+// empty when it's unknown
+type Amount struct {
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency,omitempty"`
+}
@@ -5,4 +5,22 @@ import "github.com/denysvitali/go-swiss-qr-bill"
 type Barcode struct {
 	QRBill *swiss_qr_code.QrCode `json:"qr_bill"`
 	Text   string                `json:"text"`
+	// BoundingBox is the position of the barcode on the page, it's nil for
+	// the barcodes indexed before it was stored
+	BoundingBox *BoundingBox `json:"boundingBox,omitempty"`
+}
+
+// QRBillAmount returns the amount of the first QR bill that has one
+func QRBillAmount(barcodes []Barcode) *Amount {
+	for _, b := range barcodes {
+		if b.QRBill == nil || b.QRBill.PaymentAmount.Amount == nil {
+			continue
+		}
+		a := b.QRBill.PaymentAmount.Amount
+		return &Amount{
+			Value:    float64(a.Base) + float64(a.Cents)/100,
+			Currency: b.QRBill.PaymentAmount.Currency,
+		}
+	}
+	return nil
 }
@@ -16,6 +16,56 @@ type Document struct {
 	Dates              []time.Time     `json:"dates,omitempty"`
 	IndexedAt          time.Time       `json:"indexedAt,omitempty"`
 
+	// CompanyScore is the confidence (0-1) that Company is mentioned in the
+	// document, Companies are sorted by confidence
+	CompanyScore float64 `json:"companyScore,omitempty"`
+
+	// Date is the issue date when one is found, otherwise the most plausible
+	// of the Dates. The other dates are recognized by their label and
+	// position on the page.
+	IssueDate   *time.Time `json:"issueDate,omitempty"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+	PeriodStart *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+
+	// Total is the amount of the invoice or receipt, taken from the QR bill
+	// when there is one, Vat the value added tax it includes
+	Total   *Amount  `json:"total,omitempty"`
+	Vat     *Amount  `json:"vat,omitempty"`
+	VatRate *float64 `json:"vatRate,omitempty"`
+
+	// Entities are the identifiers found in the text: IBANs, UIDs, AHV
+	// numbers, phone numbers, emails and labelled reference numbers
+	Entities []Entity `json:"entities,omitempty"`
+
+	// Extractors are the versions of the extractors that analyzed the page,
+	// by name. A missing extractor failed or was disabled.
+	Extractors map[string]int `json:"extractors,omitempty"`
+
+	// Hash is the SHA-1 of the page as it was sent to the storage backend,
+	// it's used to verify the integrity of the stored file.
+	Hash string `json:"hash,omitempty"`
+	// MissingFile is set when the stored page can't be found anymore
+	MissingFile bool `json:"missingFile,omitempty"`
+
+	// PerceptualHash is the dHash of the page image, used to find re-scans
+	PerceptualHash string `json:"perceptualHash,omitempty"`
+	// DuplicateOf is the ID of the document this page is likely a re-scan of
+	DuplicateOf    string  `json:"duplicateOf,omitempty"`
+	DuplicateScore float64 `json:"duplicateScore,omitempty"`
+
+	// Blocks are the text blocks found by the OCR, used to redact the text
+	// that lies in a region of the page
+	Blocks []TextBlock `json:"blocks,omitempty"`
+	// DeletedAt is set when the document has been moved to the trash, the
+	// document and its page are purged once the trash period has passed
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+	RedactedAt *time.Time `json:"redactedAt,omitempty"`
+
+	Tags  []string      `json:"tags,omitempty"`
+	Owner string        `json:"owner,omitempty"`
+	Mail  *MailMetadata `json:"mail,omitempty"`
+
 	// Scan specific fields
 	ScanId     string `json:"scanId"`
 	SequenceId int    `json:"sequenceId"`
@@ -0,0 +1,10 @@
+package models
+
+// Entity is an identifier found in the text of a document, such as an IBAN
+// or a customer number. Value is normalized (e.g. "CH9300762011623852957")
+// and Text is how it was written.
+type Entity struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Text  string `json:"text,omitempty"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// MailMetadata describes the email a document was received with
+type MailMetadata struct {
+	From       string    `json:"from"`
+	Subject    string    `json:"subject"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	MessageId  string    `json:"messageId,omitempty"`
+}
@@ -11,6 +11,12 @@ type ScannedPage struct {
 	ScanId     string
 	SequenceId int
 	ScanTime   time.Time
+
+	// Tags and Owner are copied to the indexed document
+	Tags  []string
+	Owner string
+	// Mail is set when the page was received by email
+	Mail *MailMetadata
 }
 
 func (s ScannedPage) Id() string {
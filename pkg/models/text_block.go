@@ -0,0 +1,21 @@
+package models
+
+type BoundingBox struct {
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+	Right  int `json:"right"`
+}
+
+// Intersects returns true when the two boxes overlap
+func (b BoundingBox) Intersects(o BoundingBox) bool {
+	return b.Left < o.Right && o.Left < b.Right &&
+		b.Top < o.Bottom && o.Top < b.Bottom
+}
+
+// TextBlock is a block of text recognized by the OCR, with its position on
+// the page
+type TextBlock struct {
+	Text        string      `json:"text"`
+	BoundingBox BoundingBox `json:"boundingBox"`
+}
@@ -6,12 +6,12 @@ import (
 	"image"
 	"image/color"
 	"os"
-	"sort"
 	"testing"
 
 	"gocv.io/x/gocv"
 
 	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+	"github.com/denysvitali/odi-backend/pkg/ocrtext"
 )
 
 var redColor = color.RGBA{R: 255, G: 0, B: 0, A: 255}
@@ -35,19 +35,10 @@ func TestSort(t *testing.T) {
 
 	img := gocv.IMRead("../../resources/testdata/ocr/private/1.jpg", gocv.IMReadColor)
 
-	sort.Sort(ocrclient.SortText(ocrResult.TextBlocks))
-	groups := ocrclient.GroupTextBlocks(ocrResult.TextBlocks, 5, 200)
-
-	for idx, b := range groups {
-		bb := ocrclient.TextBlockGroup(b).BoundingBox()
-		drawBB(&img, bb, idx)
-
-		for _, v := range b {
-			fmt.Printf("%s\n", v.Text)
-		}
-
-		fmt.Printf("\n")
+	for idx, r := range ocrtext.Analyze(&ocrResult) {
+		drawBB(&img, r.BoundingBox, idx)
 	}
+	fmt.Print(ocrtext.GetText(&ocrResult))
 
 	window := gocv.NewWindow("Result")
 	window.IMShow(img)
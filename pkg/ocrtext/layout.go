@@ -0,0 +1,340 @@
+package ocrtext
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+)
+
+// Kind is the kind of a region of the page
+type Kind string
+
+const (
+	KindParagraph Kind = "paragraph"
+	KindHeader    Kind = "header"
+	KindTable     Kind = "table"
+)
+
+// headerRatio is how much taller than the median line the lines of a header
+// are, at least
+const headerRatio = 1.4
+
+// Region is a part of the page that is read as a whole: a text block, or a
+// table made of several blocks
+type Region struct {
+	Kind        Kind
+	BoundingBox ocrclient.BoundingBox
+	// Lines are the lines of a paragraph or a header
+	Lines []string
+	// Rows are the cells of a table, from left to right
+	Rows [][]string
+}
+
+// Analyze returns the regions of the page in reading order.
+//
+// The text blocks made of single lines that line up in rows and columns are
+// tables, the blocks with a few lines taller than the others are headers.
+// The regions are then split recursively where there's a blank band across
+// the page (columns first, then rows), and read from left to right and from
+// top to bottom. The result only depends on the position of the blocks, not
+// on their order in the OCR result.
+func Analyze(v *ocrclient.OcrResult) []Region {
+	var blocks []ocrclient.TextBlock
+	for _, b := range v.TextBlocks {
+		bb := b.BoundingBox
+		if len(blockLines(b)) == 0 || bb.Bottom <= bb.Top || bb.Right <= bb.Left {
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return lessBox(blocks[i].BoundingBox, blocks[j].BoundingBox, blocks[i].Text, blocks[j].Text)
+	})
+
+	lineHeight := medianLineHeight(blocks)
+	regions, inTable := findTables(blocks, lineHeight)
+	for n, b := range blocks {
+		if inTable[n] {
+			continue
+		}
+		r := Region{Kind: KindParagraph, BoundingBox: b.BoundingBox, Lines: blockLines(b)}
+		if len(r.Lines) <= 2 && blockLineHeight(b) >= headerRatio*lineHeight {
+			r.Kind = KindHeader
+		}
+		regions = append(regions, r)
+	}
+	return readingOrder(regions, int(math.Ceil(lineHeight)))
+}
+
+// blockLines returns the text of the lines of the block
+func blockLines(b ocrclient.TextBlock) []string {
+	var lines []string
+	for _, l := range b.Lines {
+		if text := strings.TrimSpace(l.Text); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	if len(lines) > 0 {
+		return lines
+	}
+	// Some OCR results only have the text of the block
+	for _, l := range strings.Split(b.Text, "\n") {
+		if text := strings.TrimSpace(l); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return lines
+}
+
+func blockLineHeight(b ocrclient.TextBlock) float64 {
+	return float64(b.BoundingBox.Bottom-b.BoundingBox.Top) / float64(len(blockLines(b)))
+}
+
+// medianLineHeight returns the height of the lines of the body text, the
+// distances between the regions are compared with it
+func medianLineHeight(blocks []ocrclient.TextBlock) float64 {
+	heights := make([]float64, 0, len(blocks))
+	for _, b := range blocks {
+		heights = append(heights, blockLineHeight(b))
+	}
+	sort.Float64s(heights)
+	return max(heights[len(heights)/2], 1)
+}
+
+// findTables returns the tables of the page and which blocks they are made
+// of
+func findTables(blocks []ocrclient.TextBlock, lineHeight float64) ([]Region, []bool) {
+	inTable := make([]bool, len(blocks))
+	var single []int
+	for n, b := range blocks {
+		if len(blockLines(b)) == 1 {
+			single = append(single, n)
+		}
+	}
+	rows := groupRows(single, func(n int) ocrclient.BoundingBox { return blocks[n].BoundingBox })
+
+	var tables []Region
+	for start := 0; start < len(rows); {
+		end := start + 1
+		if len(rows[start]) >= 2 {
+			columns := make([][2]int, 0, len(rows[start]))
+			for _, n := range rows[start] {
+				columns = append(columns, [2]int{blocks[n].BoundingBox.Left, blocks[n].BoundingBox.Right})
+			}
+			for end < len(rows) && continuesTable(blocks, rows[end-1], rows[end], columns, lineHeight) {
+				end++
+			}
+		}
+		if end-start < 2 {
+			start = end
+			continue
+		}
+
+		table := Region{Kind: KindTable}
+		var boxes []ocrclient.BoundingBox
+		for _, row := range rows[start:end] {
+			cells := make([]string, 0, len(row))
+			for _, n := range row {
+				cells = append(cells, strings.Join(blockLines(blocks[n]), " "))
+				boxes = append(boxes, blocks[n].BoundingBox)
+				inTable[n] = true
+			}
+			table.Rows = append(table.Rows, cells)
+		}
+		table.BoundingBox = union(boxes)
+		tables = append(tables, table)
+		start = end
+	}
+	return tables, inTable
+}
+
+// continuesTable tells whether the row is the next one of the table: it's
+// close to the previous row and has a cell in at least two of the columns,
+// which are widened to include the cells
+func continuesTable(blocks []ocrclient.TextBlock, prev, row []int, columns [][2]int, lineHeight float64) bool {
+	gap := blocks[row[0]].BoundingBox.Top - blocks[prev[0]].BoundingBox.Bottom
+	if len(row) < 2 || float64(gap) > 1.5*lineHeight {
+		return false
+	}
+	matched := make([]int, 0, len(row))
+	for _, n := range row {
+		bb := blocks[n].BoundingBox
+		column := -1
+		for c, col := range columns {
+			if bb.Left < col[1] && bb.Right > col[0] {
+				if column != -1 {
+					// The cell spans several columns
+					return false
+				}
+				column = c
+			}
+		}
+		if column == -1 || (len(matched) > 0 && matched[len(matched)-1] >= column) {
+			return false
+		}
+		matched = append(matched, column)
+	}
+	for k, n := range row {
+		bb := blocks[n].BoundingBox
+		col := &columns[matched[k]]
+		col[0], col[1] = min(col[0], bb.Left), max(col[1], bb.Right)
+	}
+	return true
+}
+
+// groupRows groups the items sorted from top to bottom in rows: an item
+// whose vertical center is within the first item of the row is on the same
+// row. The items of a row are sorted from left to right.
+func groupRows[T any](items []T, box func(T) ocrclient.BoundingBox) [][]T {
+	var rows [][]T
+	for _, item := range items {
+		bb := box(item)
+		center := (bb.Top + bb.Bottom) / 2
+		if len(rows) > 0 {
+			first := box(rows[len(rows)-1][0])
+			if center >= first.Top && center < first.Bottom {
+				rows[len(rows)-1] = append(rows[len(rows)-1], item)
+				continue
+			}
+		}
+		rows = append(rows, []T{item})
+	}
+	for _, row := range rows {
+		sort.SliceStable(row, func(i, j int) bool {
+			return box(row[i]).Left < box(row[j]).Left
+		})
+	}
+	return rows
+}
+
+// readingOrder sorts the regions by splitting them in columns where there's
+// a vertical gap of at least minGap between them, or else in bands where
+// there's a horizontal gap
+func readingOrder(regions []Region, minGap int) []Region {
+	if len(regions) <= 1 {
+		return regions
+	}
+	if columns := split(regions, minGap, horizontalSpan); len(columns) > 1 {
+		var sorted []Region
+		for _, c := range columns {
+			sorted = append(sorted, readingOrder(c, minGap)...)
+		}
+		return sorted
+	}
+
+	bands := split(regions, 1, verticalSpan)
+	if len(bands) > 1 {
+		var sorted []Region
+		for _, b := range mergeColumns(bands, minGap) {
+			sorted = append(sorted, readingOrder(b, minGap)...)
+		}
+		return sorted
+	}
+
+	// The regions overlap, read them line by line
+	regions = append([]Region{}, regions...)
+	sort.SliceStable(regions, func(i, j int) bool {
+		return lessBox(regions[i].BoundingBox, regions[j].BoundingBox, "", "")
+	})
+	var sorted []Region
+	for _, row := range groupRows(regions, func(r Region) ocrclient.BoundingBox { return r.BoundingBox }) {
+		sorted = append(sorted, row...)
+	}
+	return sorted
+}
+
+// mergeColumns merges the consecutive bands that have regions on both sides
+// of the same vertical gap: the paragraphs of two columns may end at the same
+// height, the columns are still read one after the other
+func mergeColumns(bands [][]Region, minGap int) [][]Region {
+	merged := [][]Region{bands[0]}
+	for _, b := range bands[1:] {
+		last := merged[len(merged)-1]
+		both := append(append([]Region{}, last...), b...)
+		columns := split(both, minGap, horizontalSpan)
+		if len(columns) > 1 && spansColumns(columns, last) && spansColumns(columns, b) {
+			merged[len(merged)-1] = both
+			continue
+		}
+		merged = append(merged, b)
+	}
+	return merged
+}
+
+// spansColumns tells whether the band has regions in at least two of the
+// columns
+func spansColumns(columns [][]Region, band []Region) bool {
+	count := 0
+	for _, c := range columns {
+		boxes := make([]ocrclient.BoundingBox, 0, len(c))
+		for _, r := range c {
+			boxes = append(boxes, r.BoundingBox)
+		}
+		column := union(boxes)
+		for _, r := range band {
+			if r.BoundingBox.Left >= column.Left && r.BoundingBox.Right <= column.Right {
+				count++
+				break
+			}
+		}
+	}
+	return count >= 2
+}
+
+func horizontalSpan(bb ocrclient.BoundingBox) (int, int) { return bb.Left, bb.Right }
+func verticalSpan(bb ocrclient.BoundingBox) (int, int)   { return bb.Top, bb.Bottom }
+
+// split splits the regions where no region covers a gap of at least minGap
+// along one axis, span returns the interval a region covers on that axis
+func split(regions []Region, minGap int, span func(ocrclient.BoundingBox) (int, int)) [][]Region {
+	sorted := append([]Region{}, regions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := span(sorted[i].BoundingBox)
+		b, _ := span(sorted[j].BoundingBox)
+		return a < b
+	})
+
+	var parts [][]Region
+	var part []Region
+	var end int
+	for _, r := range sorted {
+		start, stop := span(r.BoundingBox)
+		if len(part) > 0 && start-end >= minGap {
+			parts = append(parts, part)
+			part = nil
+		}
+		if len(part) == 0 || stop > end {
+			end = stop
+		}
+		part = append(part, r)
+	}
+	return append(parts, part)
+}
+
+func lessBox(a, b ocrclient.BoundingBox, textA, textB string) bool {
+	switch {
+	case a.Top != b.Top:
+		return a.Top < b.Top
+	case a.Left != b.Left:
+		return a.Left < b.Left
+	case a.Bottom != b.Bottom:
+		return a.Bottom < b.Bottom
+	case a.Right != b.Right:
+		return a.Right < b.Right
+	}
+	return textA < textB
+}
+
+func union(boxes []ocrclient.BoundingBox) ocrclient.BoundingBox {
+	u := boxes[0]
+	for _, bb := range boxes[1:] {
+		u.Top, u.Bottom = min(u.Top, bb.Top), max(u.Bottom, bb.Bottom)
+		u.Left, u.Right = min(u.Left, bb.Left), max(u.Right, bb.Right)
+	}
+	return u
+}
@@ -0,0 +1,106 @@
+package ocrtext_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+	"github.com/denysvitali/odi-backend/pkg/ocrtext"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func loadResult(t *testing.T, path string) *ocrclient.OcrResult {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open OCR result: %v", err)
+	}
+	defer f.Close()
+	var v ocrclient.OcrResult
+	if err := json.NewDecoder(f).Decode(&v); err != nil {
+		t.Fatalf("unable to decode OCR result: %v", err)
+	}
+	return &v
+}
+
+// dump writes the regions with their kind, so that the golden files also
+// show the headers and tables that were found
+func dump(regions []ocrtext.Region) string {
+	var b strings.Builder
+	for _, r := range regions {
+		bb := r.BoundingBox
+		fmt.Fprintf(&b, "# %s (%d,%d)-(%d,%d)\n", r.Kind, bb.Left, bb.Top, bb.Right, bb.Bottom)
+		for _, l := range r.Lines {
+			fmt.Fprintf(&b, "%s\n", l)
+		}
+		for _, row := range r.Rows {
+			fmt.Fprintf(&b, "%s\n", strings.Join(row, " | "))
+		}
+	}
+	return b.String()
+}
+
+func TestAnalyzeGolden(t *testing.T) {
+	files, err := filepath.Glob("../../resources/testdata/ocr/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no OCR results found")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			v := loadResult(t, file)
+			got := dump(ocrtext.Analyze(v))
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read golden file: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("unexpected regions, got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeIsDeterministic(t *testing.T) {
+	v := loadResult(t, "../../resources/testdata/ocr/4.json")
+	want := ocrtext.GetText(v)
+
+	// The order of the blocks in the OCR result doesn't matter
+	for n := 0; n < len(v.TextBlocks); n++ {
+		blocks := append(append([]ocrclient.TextBlock{}, v.TextBlocks[n:]...), v.TextBlocks[:n]...)
+		if got := ocrtext.GetText(&ocrclient.OcrResult{TextBlocks: blocks}); got != want {
+			t.Fatalf("rotated by %d: got\n%s\nwant\n%s", n, got, want)
+		}
+	}
+}
+
+func TestGetText(t *testing.T) {
+	v := &ocrclient.OcrResult{TextBlocks: []ocrclient.TextBlock{
+		{Text: "Right", BoundingBox: ocrclient.BoundingBox{Top: 0, Bottom: 20, Left: 300, Right: 400}},
+		{Text: "Left\ncolumn", BoundingBox: ocrclient.BoundingBox{Top: 5, Bottom: 45, Left: 0, Right: 100}},
+		{Text: "   ", BoundingBox: ocrclient.BoundingBox{Top: 50, Bottom: 70, Left: 0, Right: 100}},
+	}}
+	want := "Left\ncolumn\n\nRight\n"
+	if got := ocrtext.GetText(v); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := ocrtext.GetText(&ocrclient.OcrResult{}); got != "" {
+		t.Errorf("expected no text, got %q", got)
+	}
+}
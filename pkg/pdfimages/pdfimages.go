@@ -0,0 +1,105 @@
+// Package pdfimages extracts the JPEG images embedded in PDF files.
+//
+// Scanners and scanning apps produce PDFs where every page is a single JPEG
+// image (a DCTDecode stream): these images can go through the OCR like
+// scanned pages without having to render the PDF. PDFs with other content
+// (text, vector graphics) are not supported.
+package pdfimages
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+var ErrNoImages = errors.New("no JPEG images found in the PDF")
+
+var (
+	streamKeyword = []byte("stream")
+	endstream     = []byte("endstream")
+	objKeyword    = []byte(" obj")
+	jpegMagic     = []byte{0xff, 0xd8}
+	lengthRegexp  = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	filterRegexp  = regexp.MustCompile(`/Filter\s*(\[\s*)?/DCTDecode\s*\]?`)
+)
+
+// ExtractJPEGs returns the JPEG images of the PDF in the order they appear in
+// the file, which is usually the order of the pages
+func ExtractJPEGs(pdf []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	var images [][]byte
+	offset := 0
+	for {
+		i := bytes.Index(pdf[offset:], streamKeyword)
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		offset = start + len(streamKeyword)
+
+		// Skip "endstream"
+		if start >= 3 && bytes.Equal(pdf[start-3:start], []byte("end")) {
+			continue
+		}
+
+		objStart := bytes.LastIndex(pdf[:start], objKeyword)
+		if objStart < 0 {
+			continue
+		}
+		dict := pdf[objStart:start]
+		if !isJpegImage(dict) {
+			continue
+		}
+
+		data := streamData(pdf, offset, dict)
+		if data == nil || !bytes.HasPrefix(data, jpegMagic) {
+			continue
+		}
+		images = append(images, data)
+		offset += len(data)
+	}
+
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+	return images, nil
+}
+
+func isJpegImage(dict []byte) bool {
+	return bytes.Contains(dict, []byte("/Image")) && filterRegexp.Match(dict)
+}
+
+// streamData returns the content of the stream starting after the "stream"
+// keyword at offset
+func streamData(pdf []byte, offset int, dict []byte) []byte {
+	// The keyword is followed by CRLF or LF
+	if bytes.HasPrefix(pdf[offset:], []byte("\r\n")) {
+		offset += 2
+	} else if bytes.HasPrefix(pdf[offset:], []byte("\n")) {
+		offset++
+	} else {
+		return nil
+	}
+
+	// A direct length is reliable, an indirect one (e.g. "/Length 12 0 R")
+	// would require resolving the object: look for endstream instead
+	if m := lengthRegexp.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+		length, err := strconv.Atoi(string(m[1]))
+		if err == nil && offset+length <= len(pdf) {
+			return pdf[offset : offset+length]
+		}
+	}
+
+	end := bytes.Index(pdf[offset:], endstream)
+	if end < 0 {
+		return nil
+	}
+	data := pdf[offset : offset+end]
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+	return data
+}
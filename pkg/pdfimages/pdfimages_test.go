@@ -0,0 +1,69 @@
+package pdfimages_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/pdfimages"
+)
+
+func jpegPage(t *testing.T, width int) []byte {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, width, 100)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scannedPdf builds a PDF like the ones produced by scanners: one JPEG
+// image per page. The first image has a direct length, the second one an
+// indirect one.
+func scannedPdf(pages [][]byte) []byte {
+	buf := bytes.NewBufferString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	fmt.Fprintf(buf, "1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	fmt.Fprintf(buf, "3 0 obj\n<< /Length 44 >>\nstream\nq 200 0 0 100 0 0 cm /Im0 Do Q\nendstream\nendobj\n")
+	for i, p := range pages {
+		length := fmt.Sprintf("%d", len(p))
+		if i%2 == 1 {
+			length = "99 0 R"
+		}
+		fmt.Fprintf(buf, "%d 0 obj\n<< /Type /XObject /Subtype /Image /Width 200 /Height 100 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode /Length %s >>\nstream\n", 10+i, length)
+		buf.Write(p)
+		fmt.Fprintf(buf, "\nendstream\nendobj\n")
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Root 1 0 R >>\n%%%%EOF\n")
+	return buf.Bytes()
+}
+
+func TestExtractJPEGs(t *testing.T) {
+	pages := [][]byte{jpegPage(t, 200), jpegPage(t, 300)}
+	images, err := pdfimages.ExtractJPEGs(scannedPdf(pages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != len(pages) {
+		t.Fatalf("expected %d images, got %d", len(pages), len(images))
+	}
+	for i := range pages {
+		if !bytes.Equal(images[i], pages[i]) {
+			t.Fatalf("image %d doesn't match", i)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(images[i])); err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+	}
+}
+
+func TestExtractJPEGs_NoImages(t *testing.T) {
+	_, err := pdfimages.ExtractJPEGs(scannedPdf(nil))
+	if !errors.Is(err, pdfimages.ErrNoImages) {
+		t.Fatalf("expected ErrNoImages, got %v", err)
+	}
+	if _, err := pdfimages.ExtractJPEGs([]byte("hello")); err == nil {
+		t.Fatalf("expected an error for a non-PDF file")
+	}
+}
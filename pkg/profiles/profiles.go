@@ -0,0 +1,176 @@
+// Package profiles defines named scan settings, e.g. "receipts" scanned in
+// grayscale at 200 DPI or "photos" in color from the platen.
+package profiles
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/stapelberg/airscan"
+	"github.com/stapelberg/airscan/preset"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultName = "default"
+
+type Profile struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	// Source is the input source of the scanner: Feeder or Platen
+	Source string `yaml:"source" json:"source"`
+	// Resolution in DPI, used for both axes
+	Resolution int `yaml:"resolution" json:"resolution"`
+	// ColorMode is the eSCL color mode: BlackAndWhite1, Grayscale8 or RGB24
+	ColorMode string `yaml:"colorMode" json:"colorMode"`
+	Duplex    bool   `yaml:"duplex" json:"duplex"`
+	// PaperSize is one of A4, A5, Letter or Legal
+	PaperSize string `yaml:"paperSize" json:"paperSize"`
+	// Format is the MIME type requested to the scanner, only image/jpeg since
+	// the pages are stored and served as JPEG
+	Format string `yaml:"format" json:"format"`
+
+	// Tags and Owner are set on every document scanned with this profile
+	Tags  []string `yaml:"tags" json:"tags,omitempty"`
+	Owner string   `yaml:"owner" json:"owner,omitempty"`
+}
+
+type Config struct {
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// paperSizes in 1/300th of inch, the unit used by the eSCL scan regions
+var paperSizes = map[string][2]int{
+	"a4":     {2480, 3508},
+	"a5":     {1748, 2480},
+	"letter": {2550, 3300},
+	"legal":  {2550, 4200},
+}
+
+var colorModes = []string{"BlackAndWhite1", "Grayscale8", "RGB24"}
+var sources = []string{"Feeder", "Platen"}
+var formats = []string{"image/jpeg"}
+
+// Default returns the profile used when none is configured: A4 from the
+// feeder, in color, single-sided
+func Default() Profile {
+	return Profile{
+		Name:       DefaultName,
+		Source:     "Feeder",
+		Resolution: 300,
+		ColorMode:  "RGB24",
+		Duplex:     false,
+		PaperSize:  "A4",
+		Format:     "image/jpeg",
+	}
+}
+
+// Load reads the profiles from a YAML file, the fields that are not set
+// are taken from the default profile. The default profile is always
+// available, unless the file overrides it.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read profiles: %w", err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse profiles: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i := range c.Profiles {
+		c.Profiles[i].fillDefaults()
+		if err := c.Profiles[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[c.Profiles[i].Name] {
+			return nil, fmt.Errorf("duplicate profile %q", c.Profiles[i].Name)
+		}
+		names[c.Profiles[i].Name] = true
+	}
+	if !names[DefaultName] {
+		c.Profiles = append(c.Profiles, Default())
+	}
+	return &c, nil
+}
+
+// DefaultConfig returns a configuration with only the default profile
+func DefaultConfig() *Config {
+	return &Config{Profiles: []Profile{Default()}}
+}
+
+func (c *Config) Get(name string) (Profile, error) {
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q", name)
+}
+
+func (p *Profile) fillDefaults() {
+	d := Default()
+	if p.Source == "" {
+		p.Source = d.Source
+	}
+	if p.Resolution == 0 {
+		p.Resolution = d.Resolution
+	}
+	if p.ColorMode == "" {
+		p.ColorMode = d.ColorMode
+	}
+	if p.PaperSize == "" {
+		p.PaperSize = d.PaperSize
+	}
+	if p.Format == "" {
+		p.Format = d.Format
+	}
+}
+
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile without a name")
+	}
+	if !contains(sources, p.Source) {
+		return fmt.Errorf("profile %q: invalid source %q, expected one of %v", p.Name, p.Source, sources)
+	}
+	if p.Resolution < 75 || p.Resolution > 1200 {
+		return fmt.Errorf("profile %q: invalid resolution %d", p.Name, p.Resolution)
+	}
+	if !contains(colorModes, p.ColorMode) {
+		return fmt.Errorf("profile %q: invalid color mode %q, expected one of %v", p.Name, p.ColorMode, colorModes)
+	}
+	if _, ok := paperSizes[strings.ToLower(p.PaperSize)]; !ok {
+		return fmt.Errorf("profile %q: invalid paper size %q", p.Name, p.PaperSize)
+	}
+	if !contains(formats, p.Format) {
+		return fmt.Errorf("profile %q: invalid format %q, expected one of %v", p.Name, p.Format, formats)
+	}
+	return nil
+}
+
+// ScanSettings returns the eSCL settings of the profile
+func (p Profile) ScanSettings() *airscan.ScanSettings {
+	settings := preset.GrayscaleA4ADF()
+	size := paperSizes[strings.ToLower(p.PaperSize)]
+	settings.ScanRegions.Regions[0].Width = size[0]
+	settings.ScanRegions.Regions[0].Height = size[1]
+	settings.InputSource = p.Source
+	settings.ColorMode = p.ColorMode
+	settings.XResolution = p.Resolution
+	settings.YResolution = p.Resolution
+	settings.Duplex = p.Duplex
+	settings.DocumentFormat = p.Format
+	return settings
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
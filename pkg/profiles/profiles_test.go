@@ -0,0 +1,67 @@
+package profiles_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/profiles"
+)
+
+func writeProfiles(t *testing.T, content string) string {
+	p := path.Join(t.TempDir(), "profiles.yaml")
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	c, err := profiles.Load(writeProfiles(t, `
+profiles:
+  - name: receipts
+    resolution: 200
+    colorMode: Grayscale8
+    duplex: true
+    paperSize: A5
+    tags: [receipt]
+    owner: denys
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := c.Get("receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Source != "Feeder" || p.Format != "image/jpeg" {
+		t.Fatalf("expected the defaults to be filled, got %+v", p)
+	}
+
+	settings := p.ScanSettings()
+	if settings.XResolution != 200 || settings.ColorMode != "Grayscale8" || !settings.Duplex {
+		t.Fatalf("unexpected settings %+v", settings)
+	}
+	if r := settings.ScanRegions.Regions[0]; r.Width != 1748 || r.Height != 2480 {
+		t.Fatalf("expected an A5 region, got %dx%d", r.Width, r.Height)
+	}
+
+	if _, err := c.Get(profiles.DefaultName); err != nil {
+		t.Fatalf("expected the default profile to be available: %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"color mode": "profiles: [{name: a, colorMode: Sepia}]",
+		"paper size": "profiles: [{name: a, paperSize: A0}]",
+		"format":     "profiles: [{name: a, format: image/png}]",
+		"no name":    "profiles: [{resolution: 300}]",
+		"duplicate":  "profiles: [{name: a}, {name: a}]",
+	} {
+		if _, err := profiles.Load(writeProfiles(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
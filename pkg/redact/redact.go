@@ -0,0 +1,210 @@
+// Package redact blacks out regions of a scanned page and removes the text
+// that was recognized in them.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	"github.com/denysvitali/odi-backend/pkg/indexer"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/ocrclient"
+	"github.com/denysvitali/odi-backend/pkg/ocrtext"
+)
+
+const jpegQuality = 90
+
+// Image returns the page, encoded as JPEG, with the given boxes filled in black
+func Image(r io.Reader, boxes []models.BoundingBox) ([]byte, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	black := image.NewUniform(color.Black)
+	for _, b := range boxes {
+		rect := image.Rect(b.Left, b.Top, b.Right, b.Bottom).
+			Add(src.Bounds().Min).
+			Intersect(dst.Bounds())
+		draw.Draw(dst, rect, black, image.Point{}, draw.Src)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("unable to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Document removes from the document the lines of the text blocks that
+// intersect the boxes and rebuilds the text from the remaining blocks, the
+// additional terms are then removed wherever they appear. The fields found
+// by the extractors may hold the redacted text, they are cleared together
+// with the versions of the extractors so that a backfill finds them again in
+// the redacted text. The barcodes can't be found again without the page, the
+// ones that are neither covered by a box nor contain a term are kept.
+func Document(doc *models.Document, boxes []models.BoundingBox, terms []string) {
+	barcodes := keptBarcodes(doc, boxes, terms)
+	version := doc.Extractors[indexer.ExtractorBarcodes]
+
+	if len(boxes) > 0 && len(doc.Blocks) > 0 {
+		var kept []models.TextBlock
+		for _, b := range doc.Blocks {
+			kept = append(kept, redactBlock(b, boxes)...)
+		}
+		doc.Blocks = kept
+		doc.Text = text(kept)
+	}
+
+	for _, t := range terms {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		doc.Text = strings.ReplaceAll(doc.Text, t, "")
+		for i := range doc.Blocks {
+			doc.Blocks[i].Text = strings.ReplaceAll(doc.Blocks[i].Text, t, "")
+		}
+	}
+
+	clearExtracted(doc)
+	if len(barcodes) > 0 {
+		doc.Barcode = &barcodes[0]
+		if len(barcodes) > 1 {
+			doc.AdditionalBarcodes = barcodes[1:]
+		}
+		doc.Total = models.QRBillAmount(barcodes)
+		if version > 0 {
+			doc.Extractors = map[string]int{indexer.ExtractorBarcodes: version}
+		}
+	}
+}
+
+// keptBarcodes returns the barcodes of the document that survive the
+// redaction. A barcode whose position isn't known is removed when there are
+// boxes, it may be under one of them.
+func keptBarcodes(doc *models.Document, boxes []models.BoundingBox, terms []string) []models.Barcode {
+	var barcodes []models.Barcode
+	if doc.Barcode != nil {
+		barcodes = append(barcodes, *doc.Barcode)
+	}
+	barcodes = append(barcodes, doc.AdditionalBarcodes...)
+
+	var kept []models.Barcode
+	for _, b := range barcodes {
+		if len(boxes) > 0 && (b.BoundingBox == nil || intersectsAny(*b.BoundingBox, boxes)) {
+			continue
+		}
+		if containsAny(b, terms) {
+			continue
+		}
+		kept = append(kept, b)
+	}
+	return kept
+}
+
+// containsAny tells whether one of the terms appears in the barcode, the
+// fields of a QR bill are compared through their JSON encoding
+func containsAny(b models.Barcode, terms []string) bool {
+	value := b.Text
+	if b.QRBill != nil {
+		qrBill, err := json.Marshal(b.QRBill)
+		if err != nil {
+			return true
+		}
+		value += string(qrBill)
+	}
+	for _, t := range terms {
+		if t = strings.TrimSpace(t); t != "" && strings.Contains(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBlock returns the parts of the block made of the lines that don't
+// intersect the boxes. The lines are assumed to share the height of the
+// block evenly.
+func redactBlock(b models.TextBlock, boxes []models.BoundingBox) []models.TextBlock {
+	if !intersectsAny(b.BoundingBox, boxes) {
+		return []models.TextBlock{b}
+	}
+	lines := strings.Split(b.Text, "\n")
+	height := float64(b.BoundingBox.Bottom-b.BoundingBox.Top) / float64(len(lines))
+
+	var parts []models.TextBlock
+	var part *models.TextBlock
+	for n, l := range lines {
+		box := b.BoundingBox
+		box.Top = b.BoundingBox.Top + int(float64(n)*height)
+		box.Bottom = b.BoundingBox.Top + int(float64(n+1)*height)
+		if intersectsAny(box, boxes) {
+			part = nil
+			continue
+		}
+		if part == nil {
+			parts = append(parts, models.TextBlock{Text: l, BoundingBox: box})
+			part = &parts[len(parts)-1]
+			continue
+		}
+		part.Text += "\n" + l
+		part.BoundingBox.Bottom = box.Bottom
+	}
+	return parts
+}
+
+// text returns the text of the blocks in reading order, as the indexer does
+func text(blocks []models.TextBlock) string {
+	result := &ocrclient.OcrResult{}
+	for _, b := range blocks {
+		result.TextBlocks = append(result.TextBlocks, ocrclient.TextBlock{
+			Text: b.Text,
+			BoundingBox: ocrclient.BoundingBox{
+				Top:    b.BoundingBox.Top,
+				Bottom: b.BoundingBox.Bottom,
+				Left:   b.BoundingBox.Left,
+				Right:  b.BoundingBox.Right,
+			},
+		})
+	}
+	return ocrtext.GetText(result)
+}
+
+// clearExtracted clears the fields found by the extractors
+func clearExtracted(doc *models.Document) {
+	doc.Date = nil
+	doc.Dates = nil
+	doc.IssueDate = nil
+	doc.DueDate = nil
+	doc.PeriodStart = nil
+	doc.PeriodEnd = nil
+	doc.Barcode = nil
+	doc.AdditionalBarcodes = nil
+	doc.Company = nil
+	doc.Companies = nil
+	doc.CompanyScore = 0
+	doc.Total = nil
+	doc.Vat = nil
+	doc.VatRate = nil
+	doc.Entities = nil
+	doc.Extractors = nil
+}
+
+func intersectsAny(b models.BoundingBox, boxes []models.BoundingBox) bool {
+	for _, o := range boxes {
+		if b.Intersects(o) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package redact_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	swissqrcode "github.com/denysvitali/go-swiss-qr-bill"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/redact"
+)
+
+func TestImage(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			src.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := redact.Image(buf, []models.BoundingBox{
+		{Top: 10, Bottom: 50, Left: 20, Right: 120},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	luminance := func(x, y int) uint32 {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r >> 8
+	}
+	if l := luminance(60, 30); l > 10 {
+		t.Fatalf("expected the redacted region to be black, got %d", l)
+	}
+	if l := luminance(160, 80); l < 245 {
+		t.Fatalf("expected the rest of the page to be untouched, got %d", l)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := models.Document{
+		Text: "Swisscom AG\nIBAN CH93 0076 2011 6238 5295 7\nTotal CHF 42.00",
+		Blocks: []models.TextBlock{
+			{Text: "Swisscom AG", BoundingBox: models.BoundingBox{Top: 0, Bottom: 10, Left: 0, Right: 100}},
+			{Text: "IBAN CH93 0076 2011 6238 5295 7", BoundingBox: models.BoundingBox{Top: 20, Bottom: 30, Left: 0, Right: 300}},
+			{Text: "Total CHF 42.00", BoundingBox: models.BoundingBox{Top: 40, Bottom: 50, Left: 0, Right: 150}},
+		},
+	}
+
+	redact.Document(&doc, []models.BoundingBox{{Top: 18, Bottom: 32, Left: 0, Right: 50}}, []string{"42.00"})
+
+	if strings.Contains(doc.Text, "CH93") {
+		t.Fatalf("expected the IBAN to be scrubbed: %q", doc.Text)
+	}
+	if strings.Contains(doc.Text, "42.00") {
+		t.Fatalf("expected the additional term to be scrubbed: %q", doc.Text)
+	}
+	if !strings.Contains(doc.Text, "Swisscom AG") {
+		t.Fatalf("expected the rest of the text to be kept: %q", doc.Text)
+	}
+	if len(doc.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(doc.Blocks))
+	}
+}
+
+func TestDocumentByPosition(t *testing.T) {
+	total := models.Amount{Value: 42, Currency: "CHF"}
+	doc := models.Document{
+		Text: "Invoice\nCHF\n1\nTotal CHF 42.00",
+		Blocks: []models.TextBlock{
+			{Text: "Invoice\nCHF\n1", BoundingBox: models.BoundingBox{Top: 0, Bottom: 30, Left: 0, Right: 100}},
+			{Text: "Total CHF 42.00", BoundingBox: models.BoundingBox{Top: 40, Bottom: 50, Left: 0, Right: 150}},
+		},
+		Total:      &total,
+		Entities:   []models.Entity{{Type: "iban", Value: "CH9300762011623852957"}},
+		Extractors: map[string]int{"amounts": 1},
+		Tags:       []string{"invoice"},
+	}
+
+	// Only the second line of the first block is redacted
+	redact.Document(&doc, []models.BoundingBox{{Top: 12, Bottom: 18, Left: 0, Right: 50}}, nil)
+
+	if doc.Text != "Invoice\n\n1\n\nTotal CHF 42.00\n" {
+		t.Errorf("expected the other occurrences of the redacted line to be kept, got %q", doc.Text)
+	}
+	if len(doc.Blocks) != 3 || doc.Blocks[0].Text != "Invoice" || doc.Blocks[1].Text != "1" {
+		t.Errorf("expected the first block to be split around the redacted line, got %+v", doc.Blocks)
+	}
+	if doc.Blocks[1].BoundingBox.Top != 20 || doc.Blocks[1].BoundingBox.Bottom != 30 {
+		t.Errorf("unexpected bounding box %+v", doc.Blocks[1].BoundingBox)
+	}
+	if doc.Total != nil || doc.Entities != nil || doc.Extractors != nil {
+		t.Errorf("expected the extracted fields to be cleared, got %+v", doc)
+	}
+	if len(doc.Tags) != 1 {
+		t.Errorf("expected the tags to be kept, got %v", doc.Tags)
+	}
+}
+
+func TestDocumentBarcodes(t *testing.T) {
+	qrBill := &swissqrcode.QrCode{PaymentAmount: swissqrcode.PaymentAmount{
+		Amount:   &swissqrcode.MoneyValue{Base: 42, Cents: 50},
+		Currency: swissqrcode.CurrencyChf,
+	}}
+	doc := models.Document{
+		Text: "Invoice",
+		Barcode: &models.Barcode{
+			Text:        "https://example.com/private",
+			BoundingBox: &models.BoundingBox{Top: 0, Bottom: 20, Left: 0, Right: 20},
+		},
+		AdditionalBarcodes: []models.Barcode{
+			{QRBill: qrBill, BoundingBox: &models.BoundingBox{Top: 100, Bottom: 200, Left: 0, Right: 100}},
+			{Text: "ACME-1234", BoundingBox: &models.BoundingBox{Top: 300, Bottom: 320, Left: 0, Right: 100}},
+			{Text: "indexed without a position"},
+		},
+		Extractors: map[string]int{"amounts": 1, "barcodes": 1},
+	}
+
+	redact.Document(&doc, []models.BoundingBox{{Top: 10, Bottom: 30, Left: 10, Right: 30}}, []string{"ACME"})
+
+	if doc.Barcode == nil || doc.Barcode.QRBill != qrBill || len(doc.AdditionalBarcodes) != 0 {
+		t.Fatalf("expected only the QR bill to be kept, got %+v %+v", doc.Barcode, doc.AdditionalBarcodes)
+	}
+	if doc.Total == nil || doc.Total.Value != 42.5 || doc.Total.Currency != "CHF" {
+		t.Errorf("expected the total of the QR bill to be kept, got %+v", doc.Total)
+	}
+	if len(doc.Extractors) != 1 || doc.Extractors["barcodes"] != 1 {
+		t.Errorf("expected only the version of the barcodes to be kept, got %v", doc.Extractors)
+	}
+}
@@ -0,0 +1,75 @@
+package scrub
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+type IssueType string
+
+const (
+	// IssueMissingFile is reported when a document is indexed but its page
+	// can't be found in the storage backend
+	IssueMissingFile IssueType = "missing_file"
+	// IssueNotIndexed is reported when a page is stored but there is no
+	// document in the index for it
+	IssueNotIndexed IssueType = "not_indexed"
+	// IssueAuthenticationFailed is reported when a page can't be decrypted
+	// because the GCM authentication tag doesn't match
+	IssueAuthenticationFailed IssueType = "authentication_failed"
+	// IssueHashMismatch is reported when the content of a page doesn't match
+	// the hash that was computed when it was indexed
+	IssueHashMismatch IssueType = "hash_mismatch"
+	// IssueReadError is reported when a page can't be read for any other reason
+	IssueReadError IssueType = "read_error"
+)
+
+type Issue struct {
+	Type       IssueType `json:"type"`
+	Id         string    `json:"id"`
+	ScanId     string    `json:"scanId"`
+	SequenceId int       `json:"sequenceId"`
+	Detail     string    `json:"detail,omitempty"`
+	Fixed      bool      `json:"fixed"`
+	FixError   string    `json:"fixError,omitempty"`
+}
+
+type Report struct {
+	StartedAt        time.Time `json:"startedAt"`
+	FinishedAt       time.Time `json:"finishedAt"`
+	DocumentsChecked int       `json:"documentsChecked"`
+	FilesChecked     int       `json:"filesChecked"`
+	Issues           []Issue   `json:"issues"`
+}
+
+func (r *Report) add(issue Issue) {
+	log.Warnf("%s: %s %s", issue.Id, issue.Type, issue.Detail)
+	r.Issues = append(r.Issues, issue)
+}
+
+// Unfixed returns the number of issues that haven't been fixed
+func (r *Report) Unfixed() int {
+	count := 0
+	for _, i := range r.Issues {
+		if !i.Fixed {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary returns the number of issues grouped by type
+func (r *Report) Summary() map[IssueType]int {
+	summary := map[IssueType]int{}
+	for _, i := range r.Issues {
+		summary[i.Type]++
+	}
+	return summary
+}
+
+func (r *Report) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,330 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+	"github.com/sirupsen/logrus"
+
+	odicrypt "github.com/denysvitali/odi-backend/pkg/crypt"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+var log = logrus.StandardLogger().WithField("package", "scrub")
+
+// Indexer is used to re-index the orphan files when fixing issues
+type Indexer interface {
+	Index(page models.ScannedPage) error
+}
+
+type Config struct {
+	OpenSearchAddr     string
+	OpenSearchUsername string
+	OpenSearchPassword string
+	OpenSearchSkipTLS  bool
+	OpenSearchIndex    string
+
+	Storage model.RWStorage
+
+	// Fix enables the automatic repair of the issues that are found.
+	// When Indexer is nil, orphan files can't be re-indexed.
+	Fix     bool
+	Indexer Indexer
+}
+
+type Scrubber struct {
+	osClient *opensearch.Client
+	index    string
+	storage  model.RWStorage
+	fix      bool
+	indexer  Indexer
+}
+
+func New(config Config) (*Scrubber, error) {
+	if config.Storage == nil {
+		return nil, fmt.Errorf("storage is required")
+	}
+	if config.OpenSearchIndex == "" {
+		return nil, fmt.Errorf("opensearch index is required")
+	}
+
+	osClient, err := opensearch.NewClient(opensearch.Config{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.OpenSearchSkipTLS},
+		},
+		Addresses: []string{config.OpenSearchAddr},
+		Username:  config.OpenSearchUsername,
+		Password:  config.OpenSearchPassword,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opensearch client: %w", err)
+	}
+
+	return &Scrubber{
+		osClient: osClient,
+		index:    config.OpenSearchIndex,
+		storage:  config.Storage,
+		fix:      config.Fix,
+		indexer:  config.Indexer,
+	}, nil
+}
+
+type indexedPage struct {
+	ScanId      string `json:"scanId"`
+	SequenceId  int    `json:"sequenceId"`
+	Hash        string `json:"hash"`
+	MissingFile bool   `json:"missingFile"`
+}
+
+// Run walks through OpenSearch and the storage backend and returns a Report
+// containing all the inconsistencies that were found.
+func (s *Scrubber) Run(ctx context.Context) (*Report, error) {
+	report := &Report{StartedAt: time.Now()}
+
+	log.Infof("fetching indexed documents")
+	indexed, err := s.indexedPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch indexed documents: %w", err)
+	}
+	report.DocumentsChecked = len(indexed)
+
+	log.Infof("listing stored scans")
+	scans, err := s.storage.ListScans()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scans: %w", err)
+	}
+
+	stored := map[string]bool{}
+	for _, scanId := range scans {
+		files, err := s.storage.ListPages(scanId)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list files of scan %s: %w", scanId, err)
+		}
+		for _, f := range files {
+			stored[f.Id()] = true
+			report.FilesChecked++
+			s.checkFile(ctx, report, f, indexed[f.Id()])
+		}
+	}
+
+	for id, p := range indexed {
+		if stored[id] {
+			continue
+		}
+		s.checkIndexedPage(ctx, report, id, p)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// checkFile verifies that a stored file can be read, decrypted, is indexed
+// and matches the hash stored in the index
+func (s *Scrubber) checkFile(ctx context.Context, report *Report, f models.ScannedPage, indexed *indexedPage) {
+	page, err := s.storage.Retrieve(f.ScanId, f.SequenceId)
+	if err != nil {
+		issueType := IssueReadError
+		if errors.Is(err, odicrypt.ErrAuthenticationFailed) {
+			issueType = IssueAuthenticationFailed
+		}
+		report.add(Issue{Type: issueType, Id: f.Id(), ScanId: f.ScanId, SequenceId: f.SequenceId, Detail: err.Error()})
+		return
+	}
+
+	if indexed == nil {
+		issue := Issue{Type: IssueNotIndexed, Id: f.Id(), ScanId: f.ScanId, SequenceId: f.SequenceId}
+		if s.fix {
+			issue.Fixed, issue.FixError = s.reindex(*page)
+		}
+		report.add(issue)
+		return
+	}
+
+	if indexed.Hash != "" {
+		h := sha1.New()
+		if _, err := io.Copy(h, page.Reader); err != nil {
+			report.add(Issue{Type: IssueReadError, Id: f.Id(), ScanId: f.ScanId, SequenceId: f.SequenceId, Detail: err.Error()})
+			return
+		}
+		hash := hex.EncodeToString(h.Sum(nil))
+		if hash != indexed.Hash {
+			report.add(Issue{
+				Type:       IssueHashMismatch,
+				Id:         f.Id(),
+				ScanId:     f.ScanId,
+				SequenceId: f.SequenceId,
+				Detail:     fmt.Sprintf("expected %s, got %s", indexed.Hash, hash),
+			})
+			return
+		}
+	}
+
+	if indexed.MissingFile && s.fix {
+		// The file has re-appeared (e.g. restored from a backup)
+		if err := s.setMissingFile(ctx, f.Id(), false); err != nil {
+			log.Warnf("unable to clear missing file flag on %s: %v", f.Id(), err)
+		}
+	}
+}
+
+// checkIndexedPage handles the documents that don't have a backing file
+// in the listing. The file is retrieved anyway to make sure the listing isn't
+// lying to us (e.g. pages stored under an unexpected name).
+func (s *Scrubber) checkIndexedPage(ctx context.Context, report *Report, id string, p *indexedPage) {
+	_, err := s.storage.Retrieve(p.ScanId, p.SequenceId)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		report.add(Issue{Type: IssueReadError, Id: id, ScanId: p.ScanId, SequenceId: p.SequenceId, Detail: err.Error()})
+		return
+	}
+
+	issue := Issue{Type: IssueMissingFile, Id: id, ScanId: p.ScanId, SequenceId: p.SequenceId}
+	switch {
+	case p.MissingFile:
+		// Flagged by a previous run, the missing file is still reported but
+		// doesn't need attention anymore
+		issue.Detail = "already flagged"
+		issue.Fixed = true
+	case s.fix:
+		issue.Fixed, issue.FixError = fixResult(s.setMissingFile(ctx, id, true))
+	}
+	report.add(issue)
+}
+
+func (s *Scrubber) reindex(page models.ScannedPage) (bool, string) {
+	if s.indexer == nil {
+		return false, "no indexer configured"
+	}
+	return fixResult(s.indexer.Index(page))
+}
+
+func fixResult(err error) (bool, string) {
+	if err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+func (s *Scrubber) setMissingFile(ctx context.Context, id string, missing bool) error {
+	body, err := json.Marshal(map[string]any{
+		"doc": map[string]any{"missingFile": missing},
+	})
+	if err != nil {
+		return err
+	}
+	req := opensearchapi.UpdateRequest{
+		Index:      s.index,
+		DocumentID: id,
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("unable to update %s: %s", id, res.Status())
+	}
+	return nil
+}
+
+func (s *Scrubber) indexedPages(ctx context.Context) (map[string]*indexedPage, error) {
+	size := 1000
+	req := opensearchapi.SearchRequest{
+		Index:          []string{s.index},
+		Scroll:         5 * time.Minute,
+		Size:           &size,
+		SourceIncludes: []string{"scanId", "sequenceId", "hash", "missingFile"},
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]*indexedPage{}
+	var scrollId string
+	defer func() {
+		if scrollId != "" {
+			s.clearScroll(scrollId)
+		}
+	}()
+	for {
+		hits, id, err := decodeScrollResponse(res)
+		if err != nil {
+			return nil, err
+		}
+		scrollId = id
+		for _, h := range hits {
+			p := h.Source
+			pages[h.Id] = &p
+		}
+		if len(hits) == 0 || scrollId == "" {
+			break
+		}
+
+		scrollReq := opensearchapi.ScrollRequest{
+			ScrollID: scrollId,
+			Scroll:   5 * time.Minute,
+		}
+		res, err = scrollReq.Do(ctx, s.osClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// clearScroll releases the scroll context instead of keeping it open on
+// OpenSearch until it expires
+func (s *Scrubber) clearScroll(scrollId string) {
+	req := opensearchapi.ClearScrollRequest{ScrollID: []string{scrollId}}
+	res, err := req.Do(context.Background(), s.osClient)
+	if err != nil {
+		log.Warnf("unable to clear scroll: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		log.Warnf("unable to clear scroll: %s", res.Status())
+	}
+}
+
+type scrollHit struct {
+	Id     string      `json:"_id"`
+	Source indexedPage `json:"_source"`
+}
+
+func decodeScrollResponse(res *opensearchapi.Response) ([]scrollHit, string, error) {
+	defer res.Body.Close()
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		return nil, "", fmt.Errorf("unexpected status %s: %s", res.Status(), strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		ScrollId string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []scrollHit `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+	return result.Hits.Hits, result.ScrollId, nil
+}
@@ -0,0 +1,199 @@
+package scrub_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/scrub"
+	"github.com/denysvitali/odi-backend/pkg/storage/fs"
+)
+
+// fakeOpenSearch returns all the indexed pages in the first scroll page and
+// records the updates and the cleared scrolls
+type fakeOpenSearch struct {
+	mu      sync.Mutex
+	pages   map[string]map[string]any
+	updates map[string]bool
+	cleared []string
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprint(w, `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`)
+	case strings.HasSuffix(r.URL.Path, "/documents/_search"):
+		var hits []any
+		for id, p := range f.pages {
+			hits = append(hits, map[string]any{"_id": id, "_source": p})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"_scroll_id": "scroll-1", "hits": map[string]any{"hits": hits}})
+	case strings.HasPrefix(r.URL.Path, "/_search/scroll/") && r.Method == http.MethodDelete:
+		f.cleared = append(f.cleared, strings.TrimPrefix(r.URL.Path, "/_search/scroll/"))
+		fmt.Fprint(w, `{"succeeded": true}`)
+	case strings.HasPrefix(r.URL.Path, "/_search/scroll"):
+		fmt.Fprint(w, `{"_scroll_id": "scroll-1", "hits": {"hits": []}}`)
+	case strings.HasSuffix(r.URL.Path, "/_update"):
+		var body struct {
+			Doc struct {
+				MissingFile bool `json:"missingFile"`
+			} `json:"doc"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/documents/_doc/"), "/_update")
+		f.updates[id] = body.Doc.MissingFile
+		fmt.Fprint(w, `{"result": "updated"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type fakeIndexer struct {
+	indexed []string
+}
+
+func (f *fakeIndexer) Index(page models.ScannedPage) error {
+	f.indexed = append(f.indexed, page.Id())
+	return nil
+}
+
+func hash(content string) string {
+	h := sha1.Sum([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+// newScrubber stores scan-1/1 (indexed), scan-1/2 (indexed with another
+// hash) and scan-1/3 (not indexed). scan-2/1 and scan-2/2 are indexed but
+// not stored, scan-2/2 was already flagged.
+func newScrubber(t *testing.T, fix bool) (*scrub.Scrubber, *fakeOpenSearch, *fakeIndexer) {
+	t.Helper()
+	storage, err := fs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		err := storage.Store(models.ScannedPage{
+			Reader:     bytes.NewReader([]byte(fmt.Sprintf("page %d", seq))),
+			ScanId:     "scan-1",
+			SequenceId: seq,
+			ScanTime:   time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &fakeOpenSearch{
+		pages: map[string]map[string]any{
+			"scan-1_1": {"scanId": "scan-1", "sequenceId": 1, "hash": hash("page 1")},
+			"scan-1_2": {"scanId": "scan-1", "sequenceId": 2, "hash": hash("another page")},
+			"scan-2_1": {"scanId": "scan-2", "sequenceId": 1},
+			"scan-2_2": {"scanId": "scan-2", "sequenceId": 2, "missingFile": true},
+		},
+		updates: map[string]bool{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	idx := &fakeIndexer{}
+	s, err := scrub.New(scrub.Config{
+		OpenSearchAddr:  server.URL,
+		OpenSearchIndex: "documents",
+		Storage:         storage,
+		Fix:             fix,
+		Indexer:         idx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, f, idx
+}
+
+func issues(r *scrub.Report) map[string]scrub.Issue {
+	byId := map[string]scrub.Issue{}
+	for _, i := range r.Issues {
+		byId[i.Id] = i
+	}
+	return byId
+}
+
+func TestRun(t *testing.T) {
+	s, f, idx := newScrubber(t, false)
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.DocumentsChecked != 4 || report.FilesChecked != 3 {
+		t.Errorf("unexpected counts %d documents, %d files", report.DocumentsChecked, report.FilesChecked)
+	}
+	got := issues(report)
+	want := map[string]scrub.IssueType{
+		"scan-1_2": scrub.IssueHashMismatch,
+		"scan-1_3": scrub.IssueNotIndexed,
+		"scan-2_1": scrub.IssueMissingFile,
+		"scan-2_2": scrub.IssueMissingFile,
+	}
+	if len(got) != len(want) {
+		t.Errorf("unexpected issues %+v", report.Issues)
+	}
+	for id, issueType := range want {
+		if got[id].Type != issueType || (got[id].Fixed && id != "scan-2_2") {
+			t.Errorf("%s: expected an unfixed %s, got %+v", id, issueType, got[id])
+		}
+	}
+	// Flagged by a previous run with --fix, it doesn't make the run fail
+	if got["scan-2_2"].Detail != "already flagged" || !got["scan-2_2"].Fixed {
+		t.Errorf("expected scan-2_2 to be already flagged, got %+v", got["scan-2_2"])
+	}
+	if report.Unfixed() != 3 || report.Summary()[scrub.IssueMissingFile] != 2 {
+		t.Errorf("unexpected summary %v (%d unfixed)", report.Summary(), report.Unfixed())
+	}
+	if len(f.updates) != 0 || len(idx.indexed) != 0 {
+		t.Errorf("nothing should be fixed, got updates %v and indexed %v", f.updates, idx.indexed)
+	}
+	if fmt.Sprint(f.cleared) != "[scroll-1]" {
+		t.Errorf("expected the scroll to be cleared, got %v", f.cleared)
+	}
+}
+
+func TestRunFix(t *testing.T) {
+	s, f, idx := newScrubber(t, true)
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := issues(report)
+	if !got["scan-1_3"].Fixed || fmt.Sprint(idx.indexed) != "[scan-1_3]" {
+		t.Errorf("expected scan-1_3 to be indexed, got %+v", got["scan-1_3"])
+	}
+	if !got["scan-2_1"].Fixed || len(f.updates) != 1 || !f.updates["scan-2_1"] {
+		t.Errorf("expected scan-2_1 to be flagged, got %+v and updates %v", got["scan-2_1"], f.updates)
+	}
+	// The hash mismatch can't be fixed, scan-2_2 was already flagged
+	if got["scan-1_2"].Fixed || !got["scan-2_2"].Fixed || report.Unfixed() != 1 {
+		t.Errorf("unexpected issues %+v", report.Issues)
+	}
+
+	var b bytes.Buffer
+	if err := report.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded scrub.Report
+	if err := json.NewDecoder(&b).Decode(&decoded); err != nil || len(decoded.Issues) != 4 {
+		t.Errorf("unable to decode the report: %v", err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/denysvitali/odi-backend/pkg/storage/b2"
 	"github.com/denysvitali/odi-backend/pkg/storage/fs"
 	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
 )
 
 var log = logrus.StandardLogger().WithField("package", "storage")
@@ -25,3 +27,19 @@ func SetupB2Storage(config b2.Config) model.RWStorage {
 	}
 	return selectedStorage
 }
+
+func SetupRcloneStorage(config rclone.Config) model.RWStorage {
+	selectedStorage, err := rclone.New(config)
+	if err != nil {
+		log.Fatalf("unable to create rclone storage: %v", err)
+	}
+	return selectedStorage
+}
+
+func SetupS3Storage(config s3.Config) model.RWStorage {
+	selectedStorage, err := s3.New(config)
+	if err != nil {
+		log.Fatalf("unable to create s3 storage: %v", err)
+	}
+	return selectedStorage
+}
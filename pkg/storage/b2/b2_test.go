@@ -10,6 +10,8 @@ import (
 
 	"github.com/denysvitali/odi-backend/pkg/models"
 	"github.com/denysvitali/odi-backend/pkg/storage/b2"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/storagetest"
 )
 
 func TestMain(m *testing.M) {
@@ -132,3 +134,26 @@ func TestB2_Retrieve(t *testing.T) {
 		t.Fatalf("expected 'hello world', got '%s'", buf.String())
 	}
 }
+
+func TestB2_Conformance(t *testing.T) {
+	if os.Getenv("E2E_TEST") != "true" {
+		t.Skip("skipping test; E2E_TEST is not set")
+	}
+	// The suite expects an empty bucket
+	bucketName := os.Getenv("B2_EMPTY_BUCKET_NAME")
+	if bucketName == "" {
+		t.Skip("B2_EMPTY_BUCKET_NAME not set, skipping test")
+	}
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		b2Storage, err := b2.New(b2.Config{
+			Account:    os.Getenv("B2_ACCOUNT"),
+			Key:        os.Getenv("B2_KEY"),
+			BucketName: bucketName,
+			Passphrase: testEncryptionKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b2Storage
+	})
+}
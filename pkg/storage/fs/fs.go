@@ -1,10 +1,16 @@
 package fs
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -18,16 +24,24 @@ type Fs struct {
 	dir string
 }
 
+func (fs *Fs) fileName(scanId string, sequenceNumber int) string {
+	return path.Join(fs.dir, scanId, fmt.Sprintf("%d.jpg", sequenceNumber))
+}
+
 func (fs *Fs) Retrieve(scanId string, sequenceNumber int) (*models.ScannedPage, error) {
-	f, err := os.Open(path.Join(fs.dir, scanId, fmt.Sprintf("%d.jpg", sequenceNumber)))
+	f, err := os.Open(fs.fileName(scanId, sequenceNumber))
 	if err != nil {
 		return nil, err
 	}
-	return &models.ScannedPage{
+	page := &models.ScannedPage{
 		ScanId:     scanId,
 		SequenceId: sequenceNumber,
 		Reader:     f,
-	}, nil
+	}
+	if fi, err := f.Stat(); err == nil {
+		page.ScanTime = fi.ModTime()
+	}
+	return page, nil
 }
 
 func (fs *Fs) Store(page models.ScannedPage) error {
@@ -40,7 +54,7 @@ func (fs *Fs) Store(page models.ScannedPage) error {
 		}
 	}
 
-	f, err := os.Create(path.Join(fs.dir, page.ScanId, fmt.Sprintf("%d.jpg", page.SequenceId)))
+	f, err := os.Create(fs.fileName(page.ScanId, page.SequenceId))
 	if err != nil {
 		return err
 	}
@@ -51,12 +65,105 @@ func (fs *Fs) Store(page models.ScannedPage) error {
 	if _, err := page.Reader.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
+	if !page.ScanTime.IsZero() {
+		// Keep the scan time as modification time, like the other backends do
+		if err := os.Chtimes(f.Name(), page.ScanTime, page.ScanTime); err != nil {
+			return err
+		}
+	}
 	log.Debugf("Created file %s", f.Name())
 	return nil
 }
 
-var _ model.Storer = (*Fs)(nil)
-var _ model.Retriever = (*Fs)(nil)
+// ListScans returns the IDs of all the scans in the storage directory
+func (fs *Fs) ListScans() ([]string, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var scans []string
+	for _, e := range entries {
+		if e.IsDir() {
+			scans = append(scans, e.Name())
+		}
+	}
+	return scans, nil
+}
+
+// ListPages returns the pages of a given scan
+func (fs *Fs) ListPages(scanId string) ([]models.ScannedPage, error) {
+	entries, err := os.ReadDir(path.Join(fs.dir, scanId))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []models.ScannedPage
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".jpg") {
+			continue
+		}
+		seqId, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".jpg"), 10, 64)
+		if err != nil {
+			log.Warnf("skipping unexpected file %s in scan %s", e.Name(), scanId)
+			continue
+		}
+		files = append(files, models.ScannedPage{
+			ScanId:     scanId,
+			SequenceId: int(seqId),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].SequenceId < files[j].SequenceId
+	})
+	return files, nil
+}
+
+func (fs *Fs) Stat(scanId string, sequenceNumber int) (*model.FileInfo, error) {
+	fileName := fs.fileName(scanId, sequenceNumber)
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return &model.FileInfo{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(fileName)),
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		HashType:    "sha1",
+	}, nil
+}
+
+func (fs *Fs) Delete(scanId string, sequenceNumber int) error {
+	err := os.Remove(fs.fileName(scanId, sequenceNumber))
+	if err != nil {
+		return err
+	}
+
+	// Remove the scan directory once it's empty
+	scanDir := path.Join(fs.dir, scanId)
+	entries, err := os.ReadDir(scanDir)
+	if err == nil && len(entries) == 0 {
+		if err := os.Remove(scanDir); err != nil {
+			log.Warnf("unable to remove scan directory %s: %v", scanId, err)
+		}
+	}
+	return nil
+}
+
+var _ model.RWStorage = (*Fs)(nil)
 
 func New(dir string) (*Fs, error) {
 	_, err := os.Stat(dir)
@@ -0,0 +1,19 @@
+package fs_test
+
+import (
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/storage/fs"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/storagetest"
+)
+
+func TestFs_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		s, err := fs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
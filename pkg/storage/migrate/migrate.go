@@ -0,0 +1,322 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+var log = logrus.StandardLogger().WithField("package", "storage/migrate")
+
+type Config struct {
+	Source      model.RWStorage
+	Destination model.RWStorage
+
+	// Workers is the number of pages that are copied concurrently
+	Workers int
+	// DeleteSource removes every page from the source once its copy has
+	// been verified. Don't use it when source and destination are the same
+	// location (e.g. when rotating the passphrase).
+	DeleteSource bool
+	// StateFile keeps track of the pages that have already been migrated,
+	// so that an interrupted migration can be resumed. StateKey identifies
+	// the source and the destination: a state file written for another key
+	// is refused.
+	StateFile string
+	StateKey  string
+}
+
+type Migrator struct {
+	src          model.RWStorage
+	dst          model.RWStorage
+	workers      int
+	deleteSource bool
+
+	stateFile string
+	stateKey  string
+	state     map[string]bool
+	stateMu   sync.Mutex
+	stateW    *os.File
+}
+
+// Result summarizes a migration run
+type Result struct {
+	Total   int64
+	Copied  int64
+	Skipped int64
+	Deleted int64
+	Failed  int64
+	Errors  []PageError
+}
+
+type PageError struct {
+	Id  string
+	Err error
+}
+
+func (e PageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Id, e.Err)
+}
+
+// ErrHashMismatch is returned when the copy of a page doesn't match the original
+var ErrHashMismatch = errors.New("hash mismatch")
+
+func New(config Config) (*Migrator, error) {
+	if config.Source == nil || config.Destination == nil {
+		return nil, fmt.Errorf("source and destination are required")
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	return &Migrator{
+		src:          config.Source,
+		dst:          config.Destination,
+		workers:      config.Workers,
+		deleteSource: config.DeleteSource,
+		stateFile:    config.StateFile,
+		stateKey:     config.StateKey,
+		state:        map[string]bool{},
+	}, nil
+}
+
+// Run copies every page from the source to the destination
+func (m *Migrator) Run(ctx context.Context) (*Result, error) {
+	if err := m.loadState(); err != nil {
+		return nil, fmt.Errorf("unable to load state: %w", err)
+	}
+	defer m.closeState()
+
+	scans, err := m.src.ListScans()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list scans: %w", err)
+	}
+
+	result := &Result{}
+	var resultMu sync.Mutex
+	pages := make(chan models.ScannedPage)
+	wg := sync.WaitGroup{}
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pages {
+				err := m.migratePage(p, result)
+				if err != nil {
+					log.Errorf("unable to migrate %s: %v", p.Id(), err)
+					atomic.AddInt64(&result.Failed, 1)
+					resultMu.Lock()
+					result.Errors = append(result.Errors, PageError{Id: p.Id(), Err: err})
+					resultMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, scanId := range scans {
+		scanPages, err := m.src.ListPages(scanId)
+		if err != nil {
+			close(pages)
+			wg.Wait()
+			return result, fmt.Errorf("unable to list pages of scan %s: %w", scanId, err)
+		}
+		for _, p := range scanPages {
+			atomic.AddInt64(&result.Total, 1)
+			select {
+			case pages <- p:
+			case <-ctx.Done():
+				close(pages)
+				wg.Wait()
+				return result, ctx.Err()
+			}
+		}
+	}
+	close(pages)
+	wg.Wait()
+	return result, nil
+}
+
+func (m *Migrator) migratePage(p models.ScannedPage, result *Result) error {
+	if m.isDone(p.Id()) {
+		// The page might have been removed from the destination since it
+		// was copied, or it can't be decrypted anymore
+		_, err := m.destinationHash(p)
+		if err == nil {
+			atomic.AddInt64(&result.Skipped, 1)
+			return m.maybeDeleteSource(p, result)
+		}
+		log.Warnf("%s was migrated but can't be read from the destination, copying it again: %v", p.Id(), err)
+	}
+
+	page, err := m.src.Retrieve(p.ScanId, p.SequenceId)
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+	content, err := readAll(page.Reader)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	hash := sha1Hex(content)
+
+	// The page might have been copied by a run that was interrupted before
+	// it could save its state
+	if dstHash, err := m.destinationHash(p); err == nil && dstHash == hash {
+		log.Debugf("%s already migrated", p.Id())
+		atomic.AddInt64(&result.Skipped, 1)
+		if err := m.markDone(p.Id()); err != nil {
+			return err
+		}
+		return m.maybeDeleteSource(p, result)
+	}
+
+	err = m.dst.Store(models.ScannedPage{
+		Reader:     bytes.NewReader(content),
+		ScanId:     p.ScanId,
+		SequenceId: p.SequenceId,
+		ScanTime:   page.ScanTime,
+	})
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	dstHash, err := m.destinationHash(p)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if dstHash != hash {
+		return fmt.Errorf("verify: %w: expected %s, got %s", ErrHashMismatch, hash, dstHash)
+	}
+
+	log.Debugf("migrated %s", p.Id())
+	atomic.AddInt64(&result.Copied, 1)
+	if err := m.markDone(p.Id()); err != nil {
+		return err
+	}
+	return m.maybeDeleteSource(p, result)
+}
+
+// destinationHash returns the SHA-1 of the decrypted page in the destination
+func (m *Migrator) destinationHash(p models.ScannedPage) (string, error) {
+	page, err := m.dst.Retrieve(p.ScanId, p.SequenceId)
+	if err != nil {
+		return "", err
+	}
+	content, err := readAll(page.Reader)
+	if err != nil {
+		return "", err
+	}
+	return sha1Hex(content), nil
+}
+
+func (m *Migrator) maybeDeleteSource(p models.ScannedPage, result *Result) error {
+	if !m.deleteSource {
+		return nil
+	}
+	err := m.src.Delete(p.ScanId, p.SequenceId)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete source: %w", err)
+	}
+	if err == nil {
+		atomic.AddInt64(&result.Deleted, 1)
+	}
+	return nil
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(r)
+	if closer, ok := r.(io.Closer); ok {
+		closer.Close()
+	}
+	return b, err
+}
+
+func sha1Hex(b []byte) string {
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:])
+}
+
+// stateHeader starts the first line of the state file, followed by the key
+// of the migration
+const stateHeader = "# migration: "
+
+func (m *Migrator) loadState() error {
+	if m.stateFile == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(m.stateFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	header := stateHeader + m.stateKey
+	scanner := bufio.NewScanner(f)
+	empty := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if empty {
+			empty = false
+			if line != header {
+				f.Close()
+				return fmt.Errorf("%s was written for another migration (%q)", m.stateFile, strings.TrimPrefix(line, stateHeader))
+			}
+			continue
+		}
+		if line != "" {
+			m.state[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	if empty {
+		if _, err := fmt.Fprintln(f, header); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if len(m.state) > 0 {
+		log.Infof("resuming migration, %d pages already migrated", len(m.state))
+	}
+	m.stateW = f
+	return nil
+}
+
+func (m *Migrator) closeState() {
+	if m.stateW != nil {
+		m.stateW.Close()
+	}
+}
+
+func (m *Migrator) isDone(id string) bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.state[id]
+}
+
+func (m *Migrator) markDone(id string) error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	m.state[id] = true
+	if m.stateW == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintln(m.stateW, id); err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	return nil
+}
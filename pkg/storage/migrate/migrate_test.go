@@ -0,0 +1,175 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/fs"
+	"github.com/denysvitali/odi-backend/pkg/storage/migrate"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+)
+
+func newSource(t *testing.T) model.RWStorage {
+	s, err := fs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scanId := range []string{"scan-1", "scan-2"} {
+		for seq := 1; seq <= 3; seq++ {
+			err := s.Store(models.ScannedPage{
+				Reader:     bytes.NewReader([]byte(fmt.Sprintf("%s/%d", scanId, seq))),
+				ScanId:     scanId,
+				SequenceId: seq,
+				ScanTime:   time.Now(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return s
+}
+
+func newDestination(t *testing.T, passphrase string) model.RWStorage {
+	s, err := rclone.New(rclone.Config{Remote: t.TempDir(), Passphrase: passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func assertMigrated(t *testing.T, dst model.RWStorage) {
+	for _, scanId := range []string{"scan-1", "scan-2"} {
+		for seq := 1; seq <= 3; seq++ {
+			page, err := dst.Retrieve(scanId, seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(page.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != fmt.Sprintf("%s/%d", scanId, seq) {
+				t.Fatalf("unexpected content %q", b)
+			}
+		}
+	}
+}
+
+func TestMigrator_Run(t *testing.T) {
+	src := newSource(t)
+	dst := newDestination(t, "new key")
+	stateFile := path.Join(t.TempDir(), "state")
+
+	m, err := migrate.New(migrate.Config{
+		Source:      src,
+		Destination: dst,
+		Workers:     4,
+		StateFile:   stateFile,
+		StateKey:    "fs -> rclone",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 6 || res.Copied != 6 || res.Failed != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	assertMigrated(t, dst)
+
+	// A second run only resumes from the state file
+	m, err = migrate.New(migrate.Config{
+		Source:       src,
+		Destination:  dst,
+		StateFile:    stateFile,
+		StateKey:     "fs -> rclone",
+		DeleteSource: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = m.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Copied != 0 || res.Skipped != 6 || res.Deleted != 6 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	scans, err := src.ListScans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 0 {
+		t.Fatalf("expected an empty source, got %v", scans)
+	}
+	assertMigrated(t, dst)
+}
+
+func TestMigrator_RunWithoutState(t *testing.T) {
+	src := newSource(t)
+	dst := newDestination(t, "")
+
+	for i, expectedCopies := range []int64{6, 0} {
+		m, err := migrate.New(migrate.Config{Source: src, Destination: dst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Copied != expectedCopies {
+			t.Fatalf("run %d: expected %d copies, got %+v", i, expectedCopies, res)
+		}
+	}
+	assertMigrated(t, dst)
+}
+
+func TestMigrator_RunWithAnotherState(t *testing.T) {
+	src := newSource(t)
+	stateFile := path.Join(t.TempDir(), "state")
+	run := func(dst model.RWStorage, key string) (*migrate.Result, error) {
+		m, err := migrate.New(migrate.Config{
+			Source:      src,
+			Destination: dst,
+			StateFile:   stateFile,
+			StateKey:    key,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Run(context.Background())
+	}
+
+	if _, err := run(newDestination(t, ""), "fs -> first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state of the first migration isn't used for another destination
+	if _, err := run(newDestination(t, ""), "fs -> second"); err == nil {
+		t.Fatal("expected the state file to be refused")
+	}
+
+	// With the same key, the pages that aren't in the destination are
+	// copied again
+	dst := newDestination(t, "")
+	res, err := run(dst, "fs -> first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Copied != 6 || res.Skipped != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	assertMigrated(t, dst)
+}
@@ -1,6 +1,10 @@
 package model
 
-import "github.com/denysvitali/odi-backend/pkg/models"
+import (
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
 
 type Storer interface {
 	Store(models.ScannedPage) error
@@ -10,7 +14,42 @@ type Retriever interface {
 	Retrieve(scanId string, sequenceNumber int) (*models.ScannedPage, error)
 }
 
+type Lister interface {
+	// ListScans returns the IDs of all the scans in the storage
+	ListScans() ([]string, error)
+	// ListPages returns the pages of a scan, sorted by sequence number.
+	// The returned pages don't have a Reader.
+	ListPages(scanId string) ([]models.ScannedPage, error)
+}
+
+type Stater interface {
+	// Stat returns the information about a stored page without retrieving
+	// it. It returns os.ErrNotExist when the page doesn't exist.
+	Stat(scanId string, sequenceNumber int) (*FileInfo, error)
+}
+
+type Deleter interface {
+	// Delete removes a stored page. It returns os.ErrNotExist when the page
+	// doesn't exist.
+	Delete(scanId string, sequenceNumber int) error
+}
+
 type RWStorage interface {
 	Storer
 	Retriever
+	Lister
+	Stater
+	Deleter
+}
+
+// FileInfo describes a page as it is stored in the backend: when encryption is
+// enabled, Size and Hash refer to the encrypted file.
+type FileInfo struct {
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	ContentType string    `json:"contentType"`
+	// Hash is the hex encoded hash of the stored file, HashType is the
+	// algorithm that was used to compute it (e.g. sha1, md5).
+	Hash     string `json:"hash,omitempty"`
+	HashType string `json:"hashType,omitempty"`
 }
@@ -0,0 +1,12 @@
+package rclone
+
+// The rclone backends that can be used in a remote. We don't import
+// backend/all since it pulls in every cloud SDK.
+import (
+	_ "github.com/rclone/rclone/backend/b2"
+	_ "github.com/rclone/rclone/backend/crypt"
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/s3"
+	_ "github.com/rclone/rclone/backend/sftp"
+	_ "github.com/rclone/rclone/backend/webdav"
+)
@@ -0,0 +1,269 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/sirupsen/logrus"
+
+	odicrypt "github.com/denysvitali/odi-backend/pkg/crypt"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+var log = logrus.StandardLogger().WithField("package", "storage/rclone")
+
+var _ model.RWStorage = (*Storage)(nil)
+
+// Storage stores the pages on any rclone remote, optionally encrypting them
+// with OdiCrypt before they leave the machine.
+type Storage struct {
+	f     fs.Fs
+	crypt *odicrypt.OdiCrypt
+}
+
+type Config struct {
+	// Remote is an rclone remote, either a local path (/srv/odi), a remote
+	// defined in the rclone config file (minio:odi) or a connection string
+	// (:s3,provider=Minio,endpoint=http...:bucket/prefix).
+	Remote string
+
+	// Encryption specific
+	Passphrase string
+}
+
+func New(config Config) (*Storage, error) {
+	if config.Remote == "" {
+		return nil, fmt.Errorf("remote is required")
+	}
+
+	f, err := fs.NewFs(context.Background(), config.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create rclone fs: %w", err)
+	}
+	return NewWithFs(f, config.Passphrase)
+}
+
+// NewWithFs creates a Storage on top of an already configured rclone fs.Fs
+func NewWithFs(f fs.Fs, passphrase string) (*Storage, error) {
+	s := &Storage{f: f}
+	if len(passphrase) == 0 {
+		log.Warnf("no passphrase provided, encryption will be disabled")
+		return s, nil
+	}
+
+	var err error
+	s.crypt, err = odicrypt.New(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Storage) Store(page models.ScannedPage) (err error) {
+	ctx := context.Background()
+
+	if s.crypt != nil {
+		// The nonce needs to be unique, but not secure.
+		// It should not be reused for more than 64GB of data for the same key.
+		page.Reader, err = s.crypt.Encrypt(page.Reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	fileSize, err := page.Reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = page.Reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	obj, err := s.f.Put(ctx, page.Reader, s.toStorageFile(page, fileSize), &fs.RangeOption{Start: 0, End: fileSize})
+	if err != nil {
+		return err
+	}
+	log.Debugf("stored %s", obj.Remote())
+	return nil
+}
+
+func fileName(scanId string, sequenceNumber int) string {
+	return fmt.Sprintf("%s/%d.jpg", scanId, sequenceNumber)
+}
+
+func (s *Storage) toStorageFile(page models.ScannedPage, fileSize int64) fs.ObjectInfo {
+	return NewSourceFile(
+		s.f.Name(),
+		fileName(page.ScanId, page.SequenceId),
+		page.ScanTime,
+		fileSize,
+	)
+}
+
+func (s *Storage) Retrieve(scanId string, sequenceId int) (*models.ScannedPage, error) {
+	ctx := context.Background()
+	obj, err := s.object(ctx, scanId, sequenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	objReader, err := obj.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer objReader.Close()
+
+	var reader io.ReadSeeker
+	if s.crypt != nil {
+		reader, err = s.crypt.Decrypt(objReader)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		buffer := bytes.NewBuffer(nil)
+		_, err = io.Copy(buffer, objReader)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buffer.Bytes())
+	}
+
+	return &models.ScannedPage{
+		Reader:     reader,
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		ScanTime:   obj.ModTime(ctx),
+	}, nil
+}
+
+// ListScans returns the IDs of all the scans in the remote
+func (s *Storage) ListScans() ([]string, error) {
+	entries, err := s.f.List(context.Background(), "")
+	if err != nil {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var scans []string
+	for _, e := range entries {
+		if _, ok := e.(fs.Directory); ok {
+			scans = append(scans, e.Remote())
+		}
+	}
+	return scans, nil
+}
+
+// ListPages returns the pages of a given scan
+func (s *Storage) ListPages(scanId string) ([]models.ScannedPage, error) {
+	entries, err := s.f.List(context.Background(), scanId)
+	if err != nil {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	var files []models.ScannedPage
+	for _, e := range entries {
+		if _, ok := e.(fs.Object); !ok {
+			continue
+		}
+		page, ok := objToScannedPage(e)
+		if !ok {
+			log.Warnf("skipping unexpected file %s", e.Remote())
+			continue
+		}
+		files = append(files, page)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].SequenceId < files[j].SequenceId
+	})
+	return files, nil
+}
+
+func (s *Storage) object(ctx context.Context, scanId string, sequenceId int) (fs.Object, error) {
+	obj, err := s.f.NewObject(ctx, fileName(scanId, sequenceId))
+	if err != nil {
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *Storage) Stat(scanId string, sequenceId int) (*model.FileInfo, error) {
+	ctx := context.Background()
+	obj, err := s.object(ctx, scanId, sequenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := &model.FileInfo{
+		Size:        obj.Size(),
+		ModTime:     obj.ModTime(ctx),
+		ContentType: fs.MimeType(ctx, obj),
+	}
+
+	// Use the strongest hash the remote supports natively
+	for _, ht := range []hash.Type{hash.SHA1, hash.MD5} {
+		if !s.f.Hashes().Contains(ht) {
+			continue
+		}
+		h, err := obj.Hash(ctx, ht)
+		if err != nil || h == "" {
+			continue
+		}
+		fi.Hash = h
+		fi.HashType = ht.String()
+		break
+	}
+	return fi, nil
+}
+
+func (s *Storage) Delete(scanId string, sequenceId int) error {
+	ctx := context.Background()
+	obj, err := s.object(ctx, scanId, sequenceId)
+	if err != nil {
+		return err
+	}
+	if err := obj.Remove(ctx); err != nil {
+		return err
+	}
+
+	// Remove the scan directory once it's empty, on bucket based remotes
+	// this is a no-op.
+	if err := s.f.Rmdir(ctx, scanId); err != nil {
+		log.Debugf("unable to remove scan directory %s: %v", scanId, err)
+	}
+	return nil
+}
+
+func objToScannedPage(obj fs.DirEntry) (models.ScannedPage, bool) {
+	fileName := path.Base(obj.Remote())
+	if !strings.HasSuffix(fileName, ".jpg") {
+		return models.ScannedPage{}, false
+	}
+	seqId, err := strconv.ParseInt(strings.TrimSuffix(fileName, ".jpg"), 10, 64)
+	if err != nil {
+		return models.ScannedPage{}, false
+	}
+	return models.ScannedPage{
+		ScanId:     path.Dir(obj.Remote()),
+		SequenceId: int(seqId),
+	}, true
+}
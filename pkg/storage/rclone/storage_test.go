@@ -0,0 +1,104 @@
+package rclone_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	odicrypt "github.com/denysvitali/odi-backend/pkg/crypt"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/rclone"
+	"github.com/denysvitali/odi-backend/pkg/storage/storagetest"
+)
+
+func newStorage(t *testing.T, remote string, passphrase string) *rclone.Storage {
+	s, err := rclone.New(rclone.Config{Remote: remote, Passphrase: passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func store(t *testing.T, s *rclone.Storage, scanId string, sequenceId int, content string) {
+	err := s.Store(models.ScannedPage{
+		Reader:     bytes.NewReader([]byte(content)),
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		ScanTime:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func retrieve(t *testing.T, s *rclone.Storage, scanId string, sequenceId int) string {
+	page, err := s.Retrieve(scanId, sequenceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(page.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestStorage_Local(t *testing.T) {
+	dir := t.TempDir()
+	s := newStorage(t, dir, "")
+	store(t, s, "scan-1", 1, "hello world")
+
+	if got := retrieve(t, s, "scan-1", 1); got != "hello world" {
+		t.Fatalf("expected 'hello world', got '%s'", got)
+	}
+
+	// Unencrypted pages are readable as plain files
+	b, err := os.ReadFile(path.Join(dir, "scan-1", "1.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("unexpected file content '%s'", string(b))
+	}
+}
+
+func TestStorage_LocalEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	s := newStorage(t, dir, "my key")
+	store(t, s, "scan-1", 1, "hello world")
+
+	if got := retrieve(t, s, "scan-1", 1); got != "hello world" {
+		t.Fatalf("expected 'hello world', got '%s'", got)
+	}
+
+	b, err := os.ReadFile(path.Join(dir, "scan-1", "1.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("hello world")) {
+		t.Fatalf("file is stored in plain text")
+	}
+
+	wrongKey := newStorage(t, dir, "another key")
+	_, err = wrongKey.Retrieve("scan-1", 1)
+	if !errors.Is(err, odicrypt.ErrAuthenticationFailed) {
+		t.Fatalf("expected authentication failure, got %v", err)
+	}
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		return newStorage(t, t.TempDir(), "")
+	})
+}
+
+func TestStorage_ConformanceEncrypted(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		return newStorage(t, t.TempDir(), "my key")
+	})
+}
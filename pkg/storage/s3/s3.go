@@ -0,0 +1,331 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/sirupsen/logrus"
+
+	odicrypt "github.com/denysvitali/odi-backend/pkg/crypt"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+var log = logrus.StandardLogger().WithField("package", "storage/s3")
+
+var _ model.RWStorage = (*S3)(nil)
+
+const (
+	// DefaultPartSize is the size of the parts of a multipart upload, objects
+	// bigger than this are uploaded in multiple parts.
+	DefaultPartSize  = manager.DefaultUploadPartSize
+	scanTimeMetadata = "scan-time"
+)
+
+type S3 struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+	crypt    *odicrypt.OdiCrypt
+
+	serverSideEncryption types.ServerSideEncryption
+	sseKmsKeyId          string
+}
+
+type Config struct {
+	// Endpoint of the S3 compatible service (e.g. http://127.0.0.1:9000),
+	// leave empty to use AWS
+	Endpoint        string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Bucket          string
+	// Prefix is prepended to every key, it allows sharing a bucket
+	Prefix string
+	// PathStyle enables path-style addressing (http://host/bucket/key),
+	// which is required by most MinIO and Garage deployments
+	PathStyle bool
+	// PartSize is the size of each part of a multipart upload
+	PartSize int64
+
+	// ServerSideEncryption is either empty, AES256 or aws:kms
+	ServerSideEncryption string
+	SSEKMSKeyId          string
+
+	// Passphrase enables the client-side encryption
+	Passphrase string
+}
+
+func New(config Config) (*S3, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize == 0 {
+		config.PartSize = DefaultPartSize
+	}
+	if config.PartSize < manager.MinUploadPartSize {
+		return nil, fmt.Errorf("part size must be at least %d bytes", manager.MinUploadPartSize)
+	}
+
+	sse := types.ServerSideEncryption(config.ServerSideEncryption)
+	switch sse {
+	case "", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms:
+	default:
+		return nil, fmt.Errorf("unsupported server side encryption %q", config.ServerSideEncryption)
+	}
+
+	opts := s3.Options{
+		Region:       config.Region,
+		UsePathStyle: config.PathStyle,
+	}
+	if config.Endpoint != "" {
+		opts.BaseEndpoint = aws.String(config.Endpoint)
+	}
+	if config.AccessKeyId != "" {
+		opts.Credentials = credentials.NewStaticCredentialsProvider(config.AccessKeyId, config.SecretAccessKey, "")
+	}
+	client := s3.New(opts)
+
+	s := &S3{
+		client: client,
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			u.PartSize = config.PartSize
+		}),
+		bucket:               config.Bucket,
+		prefix:               strings.Trim(config.Prefix, "/"),
+		serverSideEncryption: sse,
+		sseKmsKeyId:          config.SSEKMSKeyId,
+	}
+
+	if len(config.Passphrase) == 0 {
+		log.Warnf("no passphrase provided, client-side encryption will be disabled")
+		return s, nil
+	}
+
+	var err error
+	s.crypt, err = odicrypt.New(config.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *S3) key(elem ...string) string {
+	return path.Join(append([]string{s.prefix}, elem...)...)
+}
+
+func (s *S3) fileKey(scanId string, sequenceNumber int) string {
+	return s.key(scanId, fmt.Sprintf("%d.jpg", sequenceNumber))
+}
+
+func (s *S3) Store(page models.ScannedPage) (err error) {
+	contentType := "image/jpeg"
+	if s.crypt != nil {
+		page.Reader, err = s.crypt.Encrypt(page.Reader)
+		if err != nil {
+			return err
+		}
+		contentType = "application/octet-stream"
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.fileKey(page.ScanId, page.SequenceId)),
+		Body:        page.Reader,
+		ContentType: aws.String(contentType),
+	}
+	if !page.ScanTime.IsZero() {
+		// Without it, the time of the upload is used
+		input.Metadata = map[string]string{
+			scanTimeMetadata: page.ScanTime.UTC().Format(time.RFC3339),
+		}
+	}
+	if s.serverSideEncryption != "" {
+		input.ServerSideEncryption = s.serverSideEncryption
+		if s.sseKmsKeyId != "" {
+			input.SSEKMSKeyId = aws.String(s.sseKmsKeyId)
+		}
+	}
+
+	out, err := s.uploader.Upload(context.Background(), input)
+	if err != nil {
+		return err
+	}
+	log.Debugf("stored %s", aws.ToString(out.Key))
+	return nil
+}
+
+func (s *S3) Retrieve(scanId string, sequenceId int) (*models.ScannedPage, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fileKey(scanId, sequenceId)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var reader io.ReadSeeker
+	if s.crypt != nil {
+		reader, err = s.crypt.Decrypt(out.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := io.ReadAll(out.Body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	page := &models.ScannedPage{
+		Reader:     reader,
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		ScanTime:   aws.ToTime(out.LastModified),
+	}
+	// Pages stored without a scan time used to get the zero time
+	if t, err := time.Parse(time.RFC3339, out.Metadata[scanTimeMetadata]); err == nil && !t.IsZero() {
+		page.ScanTime = t
+	}
+	return page, nil
+}
+
+// ListScans returns the IDs of all the scans under the prefix
+func (s *S3) ListScans() ([]string, error) {
+	prefix := s.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var scans []string
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range out.CommonPrefixes {
+			scanId := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(cp.Prefix), prefix), "/")
+			scans = append(scans, scanId)
+		}
+	}
+	return scans, nil
+}
+
+// ListPages returns the pages of a given scan
+func (s *S3) ListPages(scanId string) ([]models.ScannedPage, error) {
+	var files []models.ScannedPage
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key(scanId) + "/"),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			fileName := path.Base(aws.ToString(obj.Key))
+			if !strings.HasSuffix(fileName, ".jpg") {
+				continue
+			}
+			seqId, err := strconv.ParseInt(strings.TrimSuffix(fileName, ".jpg"), 10, 64)
+			if err != nil {
+				log.Warnf("skipping unexpected file %s", aws.ToString(obj.Key))
+				continue
+			}
+			files = append(files, models.ScannedPage{
+				ScanId:     scanId,
+				SequenceId: int(seqId),
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].SequenceId < files[j].SequenceId
+	})
+	return files, nil
+}
+
+func (s *S3) Stat(scanId string, sequenceId int) (*model.FileInfo, error) {
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fileKey(scanId, sequenceId)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	fi := &model.FileInfo{
+		Size:        aws.ToInt64(out.ContentLength),
+		ModTime:     aws.ToTime(out.LastModified),
+		ContentType: aws.ToString(out.ContentType),
+	}
+	// The ETag is the MD5 of the object, unless it was uploaded in multiple
+	// parts (or encrypted with SSE-KMS) in which case it's opaque.
+	etag := strings.Trim(aws.ToString(out.ETag), `"`)
+	if etag != "" {
+		fi.Hash = etag
+		fi.HashType = "etag"
+	}
+	return fi, nil
+}
+
+func (s *S3) Delete(scanId string, sequenceId int) error {
+	// S3 doesn't complain when deleting a key that doesn't exist
+	if _, err := s.Stat(scanId, sequenceId); err != nil {
+		return err
+	}
+
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.fileKey(scanId, sequenceId)),
+	})
+	return err
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey"
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/gofakes3/s3mem"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+	"github.com/denysvitali/odi-backend/pkg/storage/s3"
+	"github.com/denysvitali/odi-backend/pkg/storage/storagetest"
+)
+
+const testBucket = "odi"
+
+// newFakeS3 starts an in-process S3 compatible server
+func newFakeS3(t *testing.T) string {
+	backend := s3mem.New()
+	if err := backend.CreateBucket(context.Background(), testBucket); err != nil {
+		t.Fatal(err)
+	}
+	faker := gofakes3.New(backend)
+	srv := httptest.NewServer(faker.Server())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newStorage(t *testing.T, endpoint string, passphrase string) *s3.S3 {
+	s, err := s3.New(s3.Config{
+		Endpoint:        endpoint,
+		AccessKeyId:     "access-key",
+		SecretAccessKey: "secret-key",
+		Bucket:          testBucket,
+		Prefix:          "documents",
+		PathStyle:       true,
+		Passphrase:      passphrase,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func storeAndRetrieve(t *testing.T, s *s3.S3, content []byte) {
+	scanTime := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	err := s.Store(models.ScannedPage{
+		Reader:     bytes.NewReader(content),
+		ScanId:     "scan-1",
+		SequenceId: 1,
+		ScanTime:   scanTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := s.Retrieve("scan-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(page.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatalf("retrieved content doesn't match")
+	}
+	if !page.ScanTime.Equal(scanTime) {
+		t.Fatalf("expected scan time %v, got %v", scanTime, page.ScanTime)
+	}
+}
+
+func TestS3_StoreRetrieve(t *testing.T) {
+	s := newStorage(t, newFakeS3(t), "")
+	storeAndRetrieve(t, s, []byte("hello world"))
+}
+
+func TestS3_StoreWithoutScanTime(t *testing.T) {
+	s := newStorage(t, newFakeS3(t), "")
+	before := time.Now().Add(-time.Minute)
+	err := s.Store(models.ScannedPage{
+		Reader:     bytes.NewReader([]byte("hello world")),
+		ScanId:     "scan-1",
+		SequenceId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := s.Retrieve("scan-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.ScanTime.Before(before) {
+		t.Fatalf("expected the upload time, got %v", page.ScanTime)
+	}
+}
+
+func TestS3_StoreRetrieveEncrypted(t *testing.T) {
+	endpoint := newFakeS3(t)
+	s := newStorage(t, endpoint, "my key")
+	storeAndRetrieve(t, s, []byte("hello world"))
+
+	plain := newStorage(t, endpoint, "")
+	page, err := plain.Retrieve("scan-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(page.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("hello world")) {
+		t.Fatalf("object is stored in plain text")
+	}
+}
+
+func TestS3_Multipart(t *testing.T) {
+	content := make([]byte, s3.DefaultPartSize+1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	s := newStorage(t, newFakeS3(t), "my key")
+	storeAndRetrieve(t, s, content)
+}
+
+func TestS3_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		return newStorage(t, newFakeS3(t), "")
+	})
+}
+
+func TestS3_ConformanceEncrypted(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) model.RWStorage {
+		return newStorage(t, newFakeS3(t), "my key")
+	})
+}
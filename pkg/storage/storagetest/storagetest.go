@@ -0,0 +1,210 @@
+// Package storagetest contains the conformance test suite that every
+// model.RWStorage implementation must pass.
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+// Factory returns a new, empty, storage
+type Factory func(t *testing.T) model.RWStorage
+
+// Run runs the conformance test suite against the storage returned by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("StoreRetrieve", func(t *testing.T) { testStoreRetrieve(t, newStorage(t)) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newStorage(t)) })
+	t.Run("NotExist", func(t *testing.T) { testNotExist(t, newStorage(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newStorage(t)) })
+	t.Run("Stat", func(t *testing.T) { testStat(t, newStorage(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStorage(t)) })
+}
+
+var scanTime = time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+
+func pageContent(scanId string, sequenceId int) []byte {
+	return []byte(fmt.Sprintf("page %d of %s", sequenceId, scanId))
+}
+
+func store(t *testing.T, s model.RWStorage, scanId string, sequenceId int, content []byte) {
+	t.Helper()
+	err := s.Store(models.ScannedPage{
+		Reader:     bytes.NewReader(content),
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		ScanTime:   scanTime,
+	})
+	if err != nil {
+		t.Fatalf("store %s_%d: %v", scanId, sequenceId, err)
+	}
+}
+
+func retrieve(t *testing.T, s model.RWStorage, scanId string, sequenceId int) (*models.ScannedPage, []byte) {
+	t.Helper()
+	page, err := s.Retrieve(scanId, sequenceId)
+	if err != nil {
+		t.Fatalf("retrieve %s_%d: %v", scanId, sequenceId, err)
+	}
+	b, err := io.ReadAll(page.Reader)
+	if err != nil {
+		t.Fatalf("read %s_%d: %v", scanId, sequenceId, err)
+	}
+	if closer, ok := page.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return page, b
+}
+
+func testStoreRetrieve(t *testing.T, s model.RWStorage) {
+	content := pageContent("scan-1", 1)
+	store(t, s, "scan-1", 1, content)
+
+	page, b := retrieve(t, s, "scan-1", 1)
+	if !bytes.Equal(b, content) {
+		t.Fatalf("expected %q, got %q", content, b)
+	}
+	if page.ScanId != "scan-1" || page.SequenceId != 1 {
+		t.Fatalf("unexpected page %s", page.Id())
+	}
+	if !page.ScanTime.Truncate(time.Second).Equal(scanTime) {
+		t.Fatalf("expected scan time %v, got %v", scanTime, page.ScanTime)
+	}
+}
+
+func testOverwrite(t *testing.T, s model.RWStorage) {
+	store(t, s, "scan-1", 1, []byte("first version"))
+	store(t, s, "scan-1", 1, []byte("second version"))
+
+	_, b := retrieve(t, s, "scan-1", 1)
+	if string(b) != "second version" {
+		t.Fatalf("expected the second version, got %q", b)
+	}
+}
+
+func testNotExist(t *testing.T, s model.RWStorage) {
+	if _, err := s.Retrieve("missing", 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Retrieve: expected os.ErrNotExist, got %v", err)
+	}
+	if _, err := s.Stat("missing", 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat: expected os.ErrNotExist, got %v", err)
+	}
+	if err := s.Delete("missing", 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Delete: expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func testList(t *testing.T, s model.RWStorage) {
+	for _, p := range []models.ScannedPage{
+		{ScanId: "scan-1", SequenceId: 10},
+		{ScanId: "scan-1", SequenceId: 2},
+		{ScanId: "scan-1", SequenceId: 1},
+		{ScanId: "scan-2", SequenceId: 1},
+	} {
+		store(t, s, p.ScanId, p.SequenceId, pageContent(p.ScanId, p.SequenceId))
+	}
+
+	scans, err := s.ListScans()
+	if err != nil {
+		t.Fatalf("ListScans: %v", err)
+	}
+	if !sameElements(scans, []string{"scan-1", "scan-2"}) {
+		t.Fatalf("unexpected scans %v", scans)
+	}
+
+	pages, err := s.ListPages("scan-1")
+	if err != nil {
+		t.Fatalf("ListPages: %v", err)
+	}
+	var sequenceIds []int
+	for _, p := range pages {
+		if p.ScanId != "scan-1" {
+			t.Fatalf("unexpected scan id %s", p.ScanId)
+		}
+		sequenceIds = append(sequenceIds, p.SequenceId)
+	}
+	if fmt.Sprint(sequenceIds) != "[1 2 10]" {
+		t.Fatalf("expected pages [1 2 10], got %v", sequenceIds)
+	}
+}
+
+func testStat(t *testing.T, s model.RWStorage) {
+	store(t, s, "scan-1", 1, pageContent("scan-1", 1))
+
+	fi, err := s.Stat("scan-1", 1)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Size <= 0 {
+		t.Fatalf("expected a positive size, got %d", fi.Size)
+	}
+	if fi.ModTime.IsZero() {
+		t.Fatalf("expected a modification time")
+	}
+	if fi.ContentType == "" {
+		t.Fatalf("expected a content type")
+	}
+	if fi.Hash == "" || fi.HashType == "" {
+		t.Fatalf("expected a hash, got %q (%q)", fi.Hash, fi.HashType)
+	}
+
+	// The hash must change with the content
+	store(t, s, "scan-1", 1, []byte("something else"))
+	fi2, err := s.Stat("scan-1", 1)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Hash == fi2.Hash {
+		t.Fatalf("hash didn't change after overwriting the page")
+	}
+}
+
+func testDelete(t *testing.T, s model.RWStorage) {
+	store(t, s, "scan-1", 1, pageContent("scan-1", 1))
+	store(t, s, "scan-1", 2, pageContent("scan-1", 2))
+
+	if err := s.Delete("scan-1", 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Retrieve("scan-1", 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist after delete, got %v", err)
+	}
+	if _, b := retrieve(t, s, "scan-1", 2); !bytes.Equal(b, pageContent("scan-1", 2)) {
+		t.Fatalf("deleting a page changed another one")
+	}
+
+	if err := s.Delete("scan-1", 2); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	scans, err := s.ListScans()
+	if err != nil {
+		t.Fatalf("ListScans: %v", err)
+	}
+	if len(scans) != 0 {
+		t.Fatalf("expected no scans after deleting all the pages, got %v", scans)
+	}
+}
+
+func sameElements(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, v := range a {
+		count[v]++
+	}
+	for _, v := range b {
+		count[v]--
+		if count[v] < 0 {
+			return false
+		}
+	}
+	return true
+}
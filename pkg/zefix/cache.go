@@ -0,0 +1,233 @@
+package zefix
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denysvitali/zefix-tools/pkg/zefix"
+)
+
+const (
+	DefaultCacheSize = 10000
+	DefaultCacheTTL  = 7 * 24 * time.Hour
+)
+
+// CacheStats are the lookups answered by the cache (Hits) and by the
+// resolver (Misses) since the cache was created
+type CacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Entries int     `json:"entries"`
+}
+
+// CachedResolver keeps the results of a CompanyResolver, including the names
+// that aren't found, for ttl. When it's full, the least recently used result
+// is dropped. It's safe for concurrent use.
+type CachedResolver struct {
+	resolver CompanyResolver
+	size     int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	Key       string          `json:"key"`
+	Companies []zefix.Company `json:"companies,omitempty"`
+	Expires   time.Time       `json:"expires"`
+}
+
+func NewCachedResolver(resolver CompanyResolver, size int, ttl time.Duration) *CachedResolver {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachedResolver{
+		resolver: resolver,
+		size:     size,
+		ttl:      ttl,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *CachedResolver) FindCompany(name string) (*zefix.Company, error) {
+	return c.findOne("name:"+name, func() (*zefix.Company, error) {
+		return c.resolver.FindCompany(name)
+	})
+}
+
+func (c *CachedResolver) SearchCompanies(word string, limit int) ([]zefix.Company, error) {
+	key := "search:" + strconv.Itoa(limit) + ":" + word
+	if companies, ok := c.get(key); ok {
+		return companies, nil
+	}
+	companies, err := c.resolver.SearchCompanies(word, limit)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, companies)
+	return companies, nil
+}
+
+// FindCompanyByUid finds nothing when the resolver can't find companies by
+// UID
+func (c *CachedResolver) FindCompanyByUid(uid string) (*zefix.Company, error) {
+	r, ok := c.resolver.(UidResolver)
+	if !ok {
+		return nil, nil
+	}
+	return c.findOne("uid:"+uid, func() (*zefix.Company, error) {
+		return r.FindCompanyByUid(uid)
+	})
+}
+
+func (c *CachedResolver) findOne(key string, find func() (*zefix.Company, error)) (*zefix.Company, error) {
+	if companies, ok := c.get(key); ok {
+		if len(companies) == 0 {
+			return nil, nil
+		}
+		company := companies[0]
+		return &company, nil
+	}
+	company, err := find()
+	if err != nil {
+		// Errors aren't cached, the next lookup tries again
+		return nil, err
+	}
+	if company == nil {
+		c.put(key, nil)
+		return nil, nil
+	}
+	c.put(key, []zefix.Company{*company})
+	return company, nil
+}
+
+func (c *CachedResolver) get(key string) ([]zefix.Company, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && time.Now().After(el.Value.(*cacheEntry).Expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).Companies, true
+}
+
+func (c *CachedResolver) put(key string, companies []zefix.Company) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(&cacheEntry{Key: key, Companies: companies, Expires: time.Now().Add(c.ttl)})
+}
+
+// add adds or replaces an entry, c.mu must be held
+func (c *CachedResolver) add(e *cacheEntry) {
+	if el, ok := c.entries[e.Key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.Key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *CachedResolver) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).Key)
+}
+
+// Stats returns the hits and misses of the cache
+func (c *CachedResolver) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len()}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// Ping checks the resolver, when it can tell
+func (c *CachedResolver) Ping() error {
+	if pinger, ok := c.resolver.(interface{ Ping() error }); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// Save writes the entries that haven't expired, the most recently used first
+func (c *CachedResolver) Save(w io.Writer) error {
+	c.mu.Lock()
+	now := time.Now()
+	entries := make([]*cacheEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*cacheEntry); now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	c.mu.Unlock()
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// Load adds the entries written by Save, skipping the expired ones
+func (c *CachedResolver) Load(r io.Reader) error {
+	var entries []*cacheEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// Added in reverse so that the most recently used ends up first
+	for n := len(entries) - 1; n >= 0; n-- {
+		if e := entries[n]; e != nil && now.Before(e.Expires) {
+			c.add(e)
+		}
+	}
+	return nil
+}
+
+// SaveFile saves the cache to path, see Save
+func (c *CachedResolver) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadFile loads the cache saved to path, a missing file is an empty cache
+func (c *CachedResolver) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
@@ -0,0 +1,115 @@
+package zefix_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	zefixtools "github.com/denysvitali/zefix-tools/pkg/zefix"
+
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+// countingResolver counts the lookups that reach the resolver
+type countingResolver struct {
+	*zefix.MemoryResolver
+	lookups int
+	err     error
+}
+
+func (r *countingResolver) FindCompany(name string) (*zefixtools.Company, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.MemoryResolver.FindCompany(name)
+}
+
+func newCountingResolver() *countingResolver {
+	return &countingResolver{MemoryResolver: zefix.NewMemoryResolver(
+		zefix.CompanyRecord{Company: zefixtools.Company{LegalName: "Muster AG"}},
+		zefix.CompanyRecord{Company: zefixtools.Company{LegalName: "Beispiel GmbH"}},
+		zefix.CompanyRecord{Company: zefixtools.Company{LegalName: "Test SA"}},
+	)}
+}
+
+func TestCachedResolver(t *testing.T) {
+	r := newCountingResolver()
+	c := zefix.NewCachedResolver(r, 2, time.Hour)
+
+	for n := 0; n < 3; n++ {
+		if company, _ := c.FindCompany("Muster AG"); company == nil || company.LegalName != "Muster AG" {
+			t.Fatalf("expected Muster AG, got %+v", company)
+		}
+		// Unresolved names are cached too
+		if company, _ := c.FindCompany("Unknown AG"); company != nil {
+			t.Fatalf("expected no company, got %+v", company)
+		}
+	}
+	if r.lookups != 2 {
+		t.Errorf("expected 2 lookups, got %d", r.lookups)
+	}
+	stats := c.Stats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Muster AG is the least recently used, it's dropped
+	c.FindCompany("Beispiel GmbH")
+	c.FindCompany("Muster AG")
+	if r.lookups != 4 {
+		t.Errorf("expected 4 lookups, got %d", r.lookups)
+	}
+}
+
+func TestCachedResolverErrors(t *testing.T) {
+	r := newCountingResolver()
+	r.err = errors.New("connection refused")
+	c := zefix.NewCachedResolver(r, 10, time.Hour)
+
+	if _, err := c.FindCompany("Muster AG"); err == nil {
+		t.Fatal("expected an error")
+	}
+	r.err = nil
+	if company, _ := c.FindCompany("Muster AG"); company == nil {
+		t.Error("expected the error not to be cached")
+	}
+}
+
+func TestCachedResolverTTL(t *testing.T) {
+	r := newCountingResolver()
+	c := zefix.NewCachedResolver(r, 10, 10*time.Millisecond)
+
+	c.FindCompany("Muster AG")
+	time.Sleep(20 * time.Millisecond)
+	c.FindCompany("Muster AG")
+	if r.lookups != 2 {
+		t.Errorf("expected the entry to expire, got %d lookups", r.lookups)
+	}
+}
+
+func TestCachedResolverSave(t *testing.T) {
+	c := zefix.NewCachedResolver(newCountingResolver(), 10, time.Hour)
+	c.FindCompany("Muster AG")
+	c.FindCompany("Unknown AG")
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newCountingResolver()
+	loaded := zefix.NewCachedResolver(r, 10, time.Hour)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if company, _ := loaded.FindCompany("Muster AG"); company == nil || company.LegalName != "Muster AG" {
+		t.Errorf("expected Muster AG, got %+v", company)
+	}
+	if company, _ := loaded.FindCompany("Unknown AG"); company != nil {
+		t.Errorf("expected no company, got %+v", company)
+	}
+	if r.lookups != 0 {
+		t.Errorf("expected no lookup, got %d", r.lookups)
+	}
+}
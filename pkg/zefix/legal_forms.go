@@ -0,0 +1,140 @@
+package zefix
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// legalForms maps the legal forms of Swiss companies, in German, French,
+// Italian and English, to their usual abbreviation. The keys are normalized
+// with formKey.
+var legalForms = map[string]string{
+	"ag":                                    "AG",
+	"aktiengesellschaft":                    "AG",
+	"sa":                                    "SA",
+	"societe anonyme":                       "SA",
+	"societa anonima":                       "SA",
+	"gmbh":                                  "GmbH",
+	"gesellschaft mit beschrankter haftung": "GmbH",
+	"sarl":                                  "Sàrl",
+	"sa rl":                                 "Sàrl",
+	"societe a responsabilite limitee":      "Sàrl",
+	"sagl":                                  "Sagl",
+	"societa a garanzia limitata":           "Sagl",
+	"klg":                                   "KlG",
+	"kollektivgesellschaft":                 "KlG",
+	"snc":                                   "SNC",
+	"societe en nom collectif":              "SNC",
+	"societa in nome collettivo":            "SNC",
+	"kmg":                                   "KmG",
+	"kg":                                    "KmG",
+	"kommanditgesellschaft":                 "KmG",
+	"scs":                                   "SCS",
+	"societe en commandite":                 "SCS",
+	"societa in accomandita":                "SCS",
+	"genossenschaft":                        "Genossenschaft",
+	"societe cooperative":                   "Genossenschaft",
+	"cooperative":                           "Genossenschaft",
+	"societa cooperativa":                   "Genossenschaft",
+	"cooperativa":                           "Genossenschaft",
+	"stiftung":                              "Stiftung",
+	"fondation":                             "Stiftung",
+	"fondazione":                            "Stiftung",
+	"verein":                                "Verein",
+	"association":                           "Verein",
+	"associazione":                          "Verein",
+	"ltd":                                   "Ltd",
+	"limited":                               "Ltd",
+	"inc":                                   "Ltd",
+}
+
+// prefixForms are the legal forms that are also written before the name, as
+// in "Stiftung Kinderhilfe" or "Association des amis du musée"
+var prefixForms = map[string]bool{
+	"Genossenschaft": true,
+	"Stiftung":       true,
+	"Verein":         true,
+}
+
+// maxFormWords is the number of words of the longest legal form
+const maxFormWords = 5
+
+var removeAccents = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// fold lower-cases s and removes its accents
+func fold(s string) string {
+	folded, _, err := transform.String(removeAccents, strings.ToLower(s))
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return folded
+}
+
+// formKey normalizes a legal form as written in a document: "S.à r.l." is
+// "sa rl"
+func formKey(s string) string {
+	s = strings.ReplaceAll(fold(s), ".", "")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeName normalizes a company name so that the spelling, accents,
+// punctuation and legal form don't matter: "Muster Aktiengesellschaft" and
+// "MUSTER AG" are both "muster ag"
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(fold(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '&'
+	})
+	var out []string
+	for i := 0; i < len(words); i++ {
+		matched := false
+		for k := min(maxFormWords, len(words)-i); k > 0; k-- {
+			if form, ok := legalForms[formKey(strings.Join(words[i:i+k], " "))]; ok {
+				out = append(out, strings.ToLower(form))
+				i += k - 1
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			if w := strings.Trim(words[i], "."); w != "" {
+				out = append(out, w)
+			}
+		}
+	}
+	return fold(strings.Join(out, " "))
+}
+
+// similarity returns how similar two normalized names are, between 0 and 1,
+// based on their edit distance
+func similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,364 @@
+package zefix
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/denysvitali/zefix-tools/pkg/zefix"
+
+	"github.com/denysvitali/odi-backend/pkg/entities"
+)
+
+// Candidate is a company name or UID found in the text
+type Candidate struct {
+	// Name is the name as it's written, with its legal form ("Muster AG"),
+	// it's empty when only the UID was found
+	Name string
+	// LegalForm is the abbreviation of the legal form (AG, Sàrl, Stiftung...)
+	LegalForm string
+	// Uid is the UID written on the same line
+	Uid string
+	// Line is the number of the line, starting at 0
+	Line int
+	// Letterhead is set for the names at the top of the document, usually
+	// the sender
+	Letterhead bool
+
+	// variants are the name with fewer leading words: "Zahlbar an Muster AG"
+	// might be "Muster AG"
+	variants []string
+	words    []string
+}
+
+// Match is a company of the registry found in the text
+type Match struct {
+	Company zefix.Company
+	// Score is the confidence in the match, between 0 and 1: the
+	// similarity of the names, lowered by 10% outside of the letterhead
+	Score      float64
+	Text       string
+	Uid        string
+	Letterhead bool
+}
+
+// DefaultIgnored are companies that appear on too many documents to tell
+// anything about them, such as the Swiss Post on payment slips
+var DefaultIgnored = []string{"Post CH AG"}
+
+const (
+	DefaultMinSimilarity = 0.85
+	// letterheadLines is the number of non-empty lines at the top of the
+	// text considered part of the letterhead
+	letterheadLines = 8
+	// maxNameWords is the maximum number of words of a name, before or
+	// after its legal form
+	maxNameWords = 6
+	searchLimit  = 20
+)
+
+type Matcher struct {
+	resolver      CompanyResolver
+	ignored       map[string]bool
+	minSimilarity float64
+}
+
+type Option func(*Matcher)
+
+// WithIgnored adds companies to DefaultIgnored, they're never returned
+func WithIgnored(names ...string) Option {
+	return func(m *Matcher) {
+		for _, n := range names {
+			m.ignored[normalizeName(n)] = true
+		}
+	}
+}
+
+// WithMinSimilarity sets how similar (0-1) the name of a company must be to
+// the text to be a match, DefaultMinSimilarity by default
+func WithMinSimilarity(s float64) Option {
+	return func(m *Matcher) {
+		m.minSimilarity = s
+	}
+}
+
+func NewMatcher(resolver CompanyResolver, opts ...Option) *Matcher {
+	m := &Matcher{
+		resolver:      resolver,
+		ignored:       map[string]bool{},
+		minSimilarity: DefaultMinSimilarity,
+	}
+	WithIgnored(DefaultIgnored...)(m)
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Match looks up the candidates of the text in the registry and returns the
+// companies found, the most likely first
+func (m *Matcher) Match(text string) []Match {
+	best := map[string]Match{}
+	for _, c := range FindCandidates(text) {
+		if c.Name != "" && m.ignored[normalizeName(c.Name)] {
+			log.Debugf("ignoring %s", c.Name)
+			continue
+		}
+		match, ok := m.match(c)
+		if !ok || m.ignored[normalizeName(match.Company.LegalName)] {
+			continue
+		}
+		if existing, ok := best[match.Company.LegalName]; !ok || match.Score > existing.Score {
+			best[match.Company.LegalName] = match
+		}
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Company.LegalName < matches[j].Company.LegalName
+	})
+	return matches
+}
+
+func (m *Matcher) match(c Candidate) (Match, bool) {
+	result := func(company *zefix.Company, similarity float64) (Match, bool) {
+		score := similarity
+		if !c.Letterhead {
+			score *= 0.9
+		}
+		text := c.Name
+		if text == "" {
+			text = c.Uid
+		}
+		return Match{Company: *company, Score: score, Text: text, Uid: c.Uid, Letterhead: c.Letterhead}, true
+	}
+
+	// The UID is checked with its check digit, it's more reliable than the
+	// name
+	if r, ok := m.resolver.(UidResolver); ok && c.Uid != "" {
+		company, err := r.FindCompanyByUid(c.Uid)
+		if err != nil {
+			log.Warnf("unable to find company %s: %v", c.Uid, err)
+		} else if company != nil {
+			return result(company, 1)
+		}
+	}
+	if c.Name == "" {
+		return Match{}, false
+	}
+
+	for _, v := range c.variants {
+		company, err := m.resolver.FindCompany(v)
+		if err != nil {
+			log.Warnf("unable to find company %s: %v", v, err)
+			return Match{}, false
+		}
+		if company != nil {
+			return result(company, nameSimilarity(c, *company))
+		}
+	}
+
+	var bestCompany *zefix.Company
+	bestSimilarity := 0.0
+	for _, word := range searchWords(c.words) {
+		companies, err := m.resolver.SearchCompanies(word, searchLimit)
+		if err != nil {
+			log.Warnf("unable to search companies matching %s: %v", word, err)
+			return Match{}, false
+		}
+		for n, company := range companies {
+			if s := nameSimilarity(c, company); s > bestSimilarity {
+				bestSimilarity = s
+				bestCompany = &companies[n]
+			}
+		}
+	}
+	if bestCompany == nil || bestSimilarity < m.minSimilarity {
+		return Match{}, false
+	}
+	return result(bestCompany, bestSimilarity)
+}
+
+// nameSimilarity is the highest similarity between the variants of the
+// candidate and the names of the company
+func nameSimilarity(c Candidate, company zefix.Company) float64 {
+	best := 0.0
+	for _, name := range []string{company.LegalName, company.Name} {
+		if name == "" {
+			continue
+		}
+		normalized := normalizeName(name)
+		for _, v := range c.variants {
+			best = max(best, similarity(normalizeName(v), normalized))
+		}
+	}
+	return best
+}
+
+// searchWords returns the two longest words of the name, the registry is
+// searched with each of them so that an OCR error in one of them doesn't
+// prevent finding the company
+func searchWords(words []string) []string {
+	var candidates []string
+	for _, w := range words {
+		w = strings.Trim(w, "().,")
+		if len([]rune(w)) >= 3 && !isNumber(w) {
+			candidates = append(candidates, w)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len([]rune(candidates[i])) > len([]rune(candidates[j]))
+	})
+	if len(candidates) > 2 {
+		candidates = candidates[:2]
+	}
+	return candidates
+}
+
+var word = regexp.MustCompile(`\(?[\p{L}\d&][\p{L}\d&.'’+/)-]*`)
+
+// FindCandidates returns the company names, recognized by their legal form,
+// and the UIDs of the text
+func FindCandidates(text string) []Candidate {
+	var candidates []Candidate
+	nonEmpty := 0
+	for l, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		letterhead := nonEmpty < letterheadLines
+		nonEmpty++
+
+		uid := ""
+		if uids := entities.Uids(line); len(uids) > 0 {
+			uid = uids[0].Value
+		}
+		found := findNames(line)
+		for n := range found {
+			found[n].Uid = uid
+			found[n].Line = l
+			found[n].Letterhead = letterhead
+		}
+		if len(found) == 0 && uid != "" {
+			found = append(found, Candidate{Uid: uid, Line: l, Letterhead: letterhead})
+		}
+		candidates = append(candidates, found...)
+	}
+	return candidates
+}
+
+func findNames(line string) []Candidate {
+	locs := word.FindAllStringIndex(line, -1)
+	lowercase := strings.ToLower(line) == line
+	// joined tells whether two consecutive words are part of the same name
+	joined := func(i int) bool {
+		gap := line[locs[i][1]:locs[i+1][0]]
+		return gap == " "
+	}
+
+	var candidates []Candidate
+	used := -1
+	for i := 0; i < len(locs); i++ {
+		form, k := legalFormAt(line, locs, i, lowercase)
+		if k == 0 {
+			continue
+		}
+		formText := line[locs[i][0]:locs[i+k-1][1]]
+
+		// Name before the legal form: "Muster AG"
+		start := i
+		for start > used+1 && i-start < maxNameWords && joined(start-1) {
+			start--
+		}
+		if start < i {
+			if c, ok := candidate(line, locs, start, i, formText, form, false); ok {
+				candidates = append(candidates, c)
+				used = i + k - 1
+				i = used
+				continue
+			}
+		}
+
+		// Name after the legal form: "Stiftung Kinderhilfe"
+		if prefixForms[form] {
+			end := i + k
+			for end < len(locs) && end-(i+k) < maxNameWords && joined(end-1) {
+				if f, _ := legalFormAt(line, locs, end, lowercase); f != "" {
+					break
+				}
+				end++
+			}
+			if end > i+k {
+				if c, ok := candidate(line, locs, i+k, end, formText, form, true); ok {
+					candidates = append(candidates, c)
+					used = end - 1
+					i = used
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// legalFormAt returns the legal form starting at the i-th word and its
+// number of words, 0 when there's none
+func legalFormAt(line string, locs [][]int, i int, lowercase bool) (string, int) {
+	for k := min(maxFormWords, len(locs)-i); k > 0; k-- {
+		written := line[locs[i][0]:locs[i+k-1][1]]
+		form, ok := legalForms[formKey(written)]
+		if !ok {
+			continue
+		}
+		// "kg" or "sa" in lower case are more likely words than legal
+		// forms, unless the OCR lost the case of the whole line
+		if len(written) <= 4 && !lowercase && strings.ToLower(written) == written {
+			continue
+		}
+		return form, k
+	}
+	return "", 0
+}
+
+// candidate returns the candidate made of the words [start, end) and the
+// legal form, formFirst is set when the form is written before the name
+func candidate(line string, locs [][]int, start int, end int, formText string, form string, formFirst bool) (Candidate, bool) {
+	var words []string
+	for _, loc := range locs[start:end] {
+		words = append(words, line[loc[0]:loc[1]])
+	}
+	if isNumber(strings.Join(words, "")) {
+		return Candidate{}, false
+	}
+
+	c := Candidate{LegalForm: form, words: words}
+	for n := range words {
+		name := strings.Join(words[n:], " ") + " " + formText
+		if formFirst {
+			name = formText + " " + strings.Join(words[:len(words)-n], " ")
+		}
+		c.variants = append(c.variants, name)
+	}
+	if formFirst {
+		// The registry often has associations and foundations without it:
+		// "Verein Harmonie Thun" is "Harmonie Thun"
+		c.variants = append(c.variants, strings.Join(words, " "))
+	}
+	c.Name = c.variants[0]
+	return c, true
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
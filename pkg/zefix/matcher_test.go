@@ -0,0 +1,103 @@
+package zefix_test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+type corpus struct {
+	Registry  []zefix.CompanyRecord `json:"registry"`
+	Documents []struct {
+		Name       string   `json:"name"`
+		Text       string   `json:"text"`
+		Companies  []string `json:"companies"`
+		Letterhead []string `json:"letterhead"`
+	} `json:"documents"`
+}
+
+func loadCorpus(t *testing.T) corpus {
+	t.Helper()
+	f, err := os.Open("testdata/corpus.json")
+	if err != nil {
+		t.Fatalf("unable to open corpus: %v", err)
+	}
+	defer f.Close()
+	var c corpus
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		t.Fatalf("unable to decode corpus: %v", err)
+	}
+	return c
+}
+
+func TestMatcherCorpus(t *testing.T) {
+	c := loadCorpus(t)
+	m := zefix.NewMatcher(zefix.NewMemoryResolver(c.Registry...))
+
+	for _, doc := range c.Documents {
+		t.Run(doc.Name, func(t *testing.T) {
+			matches := m.Match(doc.Text)
+			var names, letterhead []string
+			for _, match := range matches {
+				names = append(names, match.Company.LegalName)
+				if match.Letterhead {
+					letterhead = append(letterhead, match.Company.LegalName)
+				}
+				if match.Score <= 0 || match.Score > 1 {
+					t.Errorf("%s: score %v out of range", match.Company.LegalName, match.Score)
+				}
+			}
+			if strings.Join(names, "|") != strings.Join(doc.Companies, "|") {
+				t.Errorf("expected companies %q, got %q (candidates: %+v)", doc.Companies, names, zefix.FindCandidates(doc.Text))
+			}
+			if strings.Join(letterhead, "|") != strings.Join(doc.Letterhead, "|") {
+				t.Errorf("expected %q in the letterhead, got %q", doc.Letterhead, letterhead)
+			}
+		})
+	}
+}
+
+func TestMatcherIgnored(t *testing.T) {
+	c := loadCorpus(t)
+	text := "Swisscom (Schweiz) AG\nLabor Team W AG"
+
+	m := zefix.NewMatcher(zefix.NewMemoryResolver(c.Registry...), zefix.WithIgnored("SWISSCOM (Schweiz) Aktiengesellschaft"))
+	matches := m.Match(text)
+	if len(matches) != 1 || matches[0].Company.LegalName != "Labor Team W AG" {
+		t.Errorf("expected only Labor Team W AG, got %+v", matches)
+	}
+}
+
+func TestMatcherScore(t *testing.T) {
+	c := loadCorpus(t)
+	m := zefix.NewMatcher(zefix.NewMemoryResolver(c.Registry...))
+
+	// An OCR error lowers the score, the letterhead comes first
+	text := "Helsana Versicherungen AG\n1\n2\n3\n4\n5\n6\n7\n8\nSwisscorn (Schweiz) AG"
+	matches := m.Match(text)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %+v", matches)
+	}
+	if matches[0].Company.LegalName != "Helsana Versicherungen AG" || matches[0].Score != 1 {
+		t.Errorf("expected Helsana with a score of 1, got %+v", matches[0])
+	}
+	if matches[1].Company.LegalName != "Swisscom (Schweiz) AG" || matches[1].Score >= 0.9 {
+		t.Errorf("expected Swisscom with a lower score, got %+v", matches[1])
+	}
+}
+
+func TestFindCandidates(t *testing.T) {
+	candidates := zefix.FindCandidates("Muster Versicherungen AG, CHE-109.322.551 MWST\nFondation Terre des hommes\n5 kg")
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %+v", candidates)
+	}
+	if candidates[0].Name != "Muster Versicherungen AG" || candidates[0].LegalForm != "AG" || candidates[0].Uid != "CHE-109.322.551" {
+		t.Errorf("unexpected candidate %+v", candidates[0])
+	}
+	if candidates[1].Name != "Fondation Terre des hommes" || candidates[1].LegalForm != "Stiftung" || candidates[1].Line != 1 {
+		t.Errorf("unexpected candidate %+v", candidates[1])
+	}
+}
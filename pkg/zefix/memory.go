@@ -0,0 +1,161 @@
+package zefix
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/denysvitali/zefix-tools/pkg/zefix"
+
+	"github.com/denysvitali/odi-backend/pkg/entities"
+)
+
+// MemoryResolver looks up the companies in a list kept in memory, usually
+// read from a CSV file
+type MemoryResolver struct {
+	companies []CompanyRecord
+	byName    map[string]int
+	byUid     map[string]int
+}
+
+func NewMemoryResolver(companies ...CompanyRecord) *MemoryResolver {
+	r := &MemoryResolver{
+		byName: map[string]int{},
+		byUid:  map[string]int{},
+	}
+	for _, c := range companies {
+		r.Add(c)
+	}
+	return r
+}
+
+// Add adds a company, replacing the one with the same legal name
+func (r *MemoryResolver) Add(c CompanyRecord) {
+	n, ok := r.byName[fold(c.LegalName)]
+	if ok {
+		r.companies[n] = c
+	} else {
+		n = len(r.companies)
+		r.companies = append(r.companies, c)
+	}
+	r.byName[fold(c.LegalName)] = n
+	if c.Name != "" {
+		r.byName[fold(c.Name)] = n
+	}
+	if c.Uid != "" {
+		r.byUid[c.Uid] = n
+	}
+}
+
+// Companies returns all the companies
+func (r *MemoryResolver) Companies() []CompanyRecord {
+	return r.companies
+}
+
+func (r *MemoryResolver) FindCompany(name string) (*zefix.Company, error) {
+	n, ok := r.byName[fold(name)]
+	if !ok {
+		return nil, nil
+	}
+	c := r.companies[n].Company
+	return &c, nil
+}
+
+func (r *MemoryResolver) SearchCompanies(word string, limit int) ([]zefix.Company, error) {
+	word = fold(word)
+	var found []zefix.Company
+	for _, c := range r.companies {
+		if len(found) >= limit {
+			break
+		}
+		if strings.Contains(fold(c.LegalName), word) || strings.Contains(fold(c.Name), word) {
+			found = append(found, c.Company)
+		}
+	}
+	return found, nil
+}
+
+func (r *MemoryResolver) FindCompanyByUid(uid string) (*zefix.Company, error) {
+	n, ok := r.byUid[uid]
+	if !ok {
+		return nil, nil
+	}
+	c := r.companies[n].Company
+	return &c, nil
+}
+
+// LoadCSV returns a resolver with the companies of a CSV file, see ReadCSV
+func LoadCSV(path string) (*MemoryResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	companies, err := ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewMemoryResolver(companies...), nil
+}
+
+// ReadCSV reads companies from a CSV file. The first line names the columns:
+// legalName is required, name, uid, locality, type, address and uri are
+// optional. UIDs are checked with their check digit.
+func ReadCSV(r io.Reader) ([]CompanyRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+	columns := map[string]int{}
+	for n, h := range header {
+		columns[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(h), "_", ""))] = n
+	}
+	if _, ok := columns["legalname"]; !ok {
+		return nil, errors.New("missing legalName column")
+	}
+
+	var companies []CompanyRecord
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			n, ok := columns[column]
+			if !ok || n >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[n])
+		}
+		c := CompanyRecord{
+			Company: zefix.Company{
+				LegalName: get("legalname"),
+				Name:      get("name"),
+				Locality:  get("locality"),
+				Type:      get("type"),
+				Address:   get("address"),
+				Uri:       get("uri"),
+			},
+		}
+		if c.LegalName == "" {
+			return nil, fmt.Errorf("line %d: empty legal name", line)
+		}
+		if uid := get("uid"); uid != "" {
+			uids := entities.Uids(uid)
+			if len(uids) != 1 {
+				return nil, fmt.Errorf("line %d: invalid UID %q", line, uid)
+			}
+			c.Uid = uids[0].Value
+		}
+		companies = append(companies, c)
+	}
+	return companies, nil
+}
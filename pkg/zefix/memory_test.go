@@ -0,0 +1,49 @@
+package zefix_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/zefix"
+)
+
+func TestReadCSV(t *testing.T) {
+	csv := "legalName,name,uid,locality\n" +
+		"Muster Versicherungen AG,,CHE-109.322.551,Zürich\n" +
+		"\"Müller & Söhne GmbH\",Müller,,Bern\n"
+	companies, err := zefix.ReadCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(companies) != 2 {
+		t.Fatalf("expected 2 companies, got %+v", companies)
+	}
+	if companies[0].Uid != "CHE-109.322.551" || companies[0].Locality != "Zürich" {
+		t.Errorf("unexpected company %+v", companies[0])
+	}
+
+	r := zefix.NewMemoryResolver(companies...)
+	if c, _ := r.FindCompany("MULLER & SOHNE GMBH"); c == nil || c.Name != "Müller" {
+		t.Errorf("expected Müller & Söhne GmbH, got %+v", c)
+	}
+	if c, _ := r.FindCompanyByUid("CHE-109.322.551"); c == nil || c.LegalName != "Muster Versicherungen AG" {
+		t.Errorf("expected Muster Versicherungen AG, got %+v", c)
+	}
+	if found, _ := r.SearchCompanies("versicherung", 10); len(found) != 1 {
+		t.Errorf("expected 1 company, got %+v", found)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for name, csv := range map[string]string{
+		"missing column": "name,uid\nMuster AG,\n",
+		"empty name":     "legalName,uid\n,\n",
+		"invalid uid":    "legalName,uid\nMuster AG,CHE-109.322.552\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := zefix.ReadCSV(strings.NewReader(csv)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package zefix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/denysvitali/zefix-tools/pkg/zefix"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+const DefaultCompaniesIndex = "companies"
+
+// companiesMapping searches the names as text and finds them, and the UIDs,
+// as keywords
+const companiesMapping = `{
+  "mappings": {
+    "properties": {
+      "legalName": {"type": "text", "fields": {"keyword": {"type": "keyword", "normalizer": "folded"}}},
+      "name": {"type": "text", "fields": {"keyword": {"type": "keyword", "normalizer": "folded"}}},
+      "uid": {"type": "keyword"}
+    }
+  },
+  "settings": {
+    "analysis": {
+      "normalizer": {
+        "folded": {"type": "custom", "filter": ["lowercase", "asciifolding"]}
+      }
+    }
+  }
+}`
+
+// OpenSearchResolver looks up the companies in an OpenSearch index, filled
+// with Index. The transport is an OpenSearch client, v1 or v2.
+type OpenSearchResolver struct {
+	transport opensearchapi.Transport
+	index     string
+}
+
+func NewOpenSearchResolver(transport opensearchapi.Transport, index string) *OpenSearchResolver {
+	if index == "" {
+		index = DefaultCompaniesIndex
+	}
+	return &OpenSearchResolver{transport: transport, index: index}
+}
+
+func (r *OpenSearchResolver) FindCompany(name string) (*zefix.Company, error) {
+	companies, err := r.search(map[string]any{
+		"bool": map[string]any{
+			"should": []any{
+				map[string]any{"term": map[string]any{"legalName.keyword": name}},
+				map[string]any{"term": map[string]any{"name.keyword": name}},
+			},
+			"minimum_should_match": 1,
+		},
+	}, 1)
+	if err != nil || len(companies) == 0 {
+		return nil, err
+	}
+	return &companies[0].Company, nil
+}
+
+func (r *OpenSearchResolver) SearchCompanies(word string, limit int) ([]zefix.Company, error) {
+	records, err := r.search(map[string]any{
+		"multi_match": map[string]any{
+			"query":     word,
+			"fields":    []string{"legalName", "name"},
+			"fuzziness": "AUTO",
+		},
+	}, limit)
+	if err != nil {
+		return nil, err
+	}
+	companies := make([]zefix.Company, 0, len(records))
+	for _, c := range records {
+		companies = append(companies, c.Company)
+	}
+	return companies, nil
+}
+
+func (r *OpenSearchResolver) FindCompanyByUid(uid string) (*zefix.Company, error) {
+	companies, err := r.search(map[string]any{
+		"term": map[string]any{"uid": uid},
+	}, 1)
+	if err != nil || len(companies) == 0 {
+		return nil, err
+	}
+	return &companies[0].Company, nil
+}
+
+func (r *OpenSearchResolver) search(query map[string]any, size int) ([]CompanyRecord, error) {
+	body, err := json.Marshal(map[string]any{"query": query, "size": size})
+	if err != nil {
+		return nil, err
+	}
+	req := opensearchapi.SearchRequest{
+		Index: []string{r.index},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(context.Background(), r.transport)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	// Without the index, there's no company
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("unexpected status %s", res.Status())
+	}
+
+	var result OpensearchResult[CompanyRecord]
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	companies := make([]CompanyRecord, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		companies = append(companies, hit.Source)
+	}
+	return companies, nil
+}
+
+// Index creates the index when it doesn't exist and adds the companies to
+// it, a company with the same UID, or legal name, is replaced
+func (r *OpenSearchResolver) Index(companies []CompanyRecord) error {
+	if err := r.createIndex(); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	for _, c := range companies {
+		id := c.Uid
+		if id == "" {
+			id = fold(c.LegalName)
+		}
+		action, err := json.Marshal(map[string]any{"index": map[string]any{"_id": id}})
+		if err != nil {
+			return err
+		}
+		doc, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req := opensearchapi.BulkRequest{
+		Index: r.index,
+		Body:  &body,
+	}
+	res, err := req.Do(context.Background(), r.transport)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("unexpected status %s", res.Status())
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Errors {
+		return fmt.Errorf("unable to index some of the companies in %s", r.index)
+	}
+	return nil
+}
+
+func (r *OpenSearchResolver) createIndex() error {
+	existsReq := opensearchapi.IndicesExistsRequest{Index: []string{r.index}}
+	res, err := existsReq.Do(context.Background(), r.transport)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	createReq := opensearchapi.IndicesCreateRequest{
+		Index: r.index,
+		Body:  strings.NewReader(companiesMapping),
+	}
+	res, err = createReq.Do(context.Background(), r.transport)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("unable to create index %s: unexpected status %s", r.index, res.Status())
+	}
+	return nil
+}
+
+// Ping checks that the index exists
+func (r *OpenSearchResolver) Ping() error {
+	req := opensearchapi.IndicesExistsRequest{Index: []string{r.index}}
+	res, err := req.Do(context.Background(), r.transport)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("index %s: unexpected status %s", r.index, res.Status())
+	}
+	return nil
+}
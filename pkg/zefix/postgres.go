@@ -0,0 +1,49 @@
+package zefix
+
+import (
+	"strings"
+
+	"github.com/denysvitali/zefix-tools/pkg/zefix"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// PostgresResolver looks up the companies in the database imported by
+// zefix-tools
+type PostgresResolver struct {
+	client *zefix.Client
+	db     *gorm.DB
+}
+
+func NewPostgresResolver(dsn string) (*PostgresResolver, error) {
+	client, err := zefix.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// The client only finds exact names, the table is searched directly
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresResolver{client: client, db: db}, nil
+}
+
+func (r *PostgresResolver) FindCompany(name string) (*zefix.Company, error) {
+	return r.client.FindCompany(name)
+}
+
+func (r *PostgresResolver) SearchCompanies(word string, limit int) ([]zefix.Company, error) {
+	var companies []zefix.Company
+	pattern := "%" + escapeLike(word) + "%"
+	tx := r.db.Where("legal_name ILIKE ? OR name ILIKE ?", pattern, pattern).Limit(limit).Find(&companies)
+	return companies, tx.Error
+}
+
+func (r *PostgresResolver) Ping() error {
+	return r.client.Ping()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
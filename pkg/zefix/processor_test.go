@@ -1,13 +1,9 @@
 package zefix_test
 
 import (
-	"crypto/tls"
-	"net/http"
 	"os"
 	"testing"
 
-	"github.com/opensearch-project/opensearch-go/v2"
-
 	"github.com/denysvitali/odi-backend/pkg/zefix"
 )
 
@@ -22,35 +18,6 @@ func getClient(t *testing.T) *zefix.Processor {
 	return p
 }
 
-func getOpenSearchClient(t *testing.T) *opensearch.Client {
-	opensearchAddr := os.Getenv("OPENSEARCH_ADDR")
-	if opensearchAddr == "" {
-		t.Skip("OPENSEARCH_ADDR not set, skipping test")
-	}
-	cfg := opensearch.Config{
-		Addresses: []string{opensearchAddr},
-		Username:  os.Getenv("OPENSEARCH_USERNAME"),
-		Password:  os.Getenv("OPENSEARCH_PASSWORD"),
-	}
-	if os.Getenv("OPENSEARCH_SKIP_TLS") == "true" {
-		cfg.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	}
-	osClient, err := opensearch.NewClient(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return osClient
-}
-
-func TestProcessor(t *testing.T) {
-	p := getClient(t)
-	osClient := getOpenSearchClient(t)
-	err := p.ProcessFromOpenSearch(osClient, "documents")
-	if err != nil {
-		t.Fatal(err)
-	}
-}
-
 func TestProcessor_FindCompanies(t *testing.T) {
 	text := `Baloise Assicurazione SA
 Aeschengraben 21, Casella postale
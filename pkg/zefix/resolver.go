@@ -0,0 +1,40 @@
+package zefix
+
+import "github.com/denysvitali/zefix-tools/pkg/zefix"
+
+// CompanyResolver is where the companies found in the text are looked up:
+// the Zefix Postgres database, an OpenSearch index or a CSV file
+type CompanyResolver interface {
+	// FindCompany returns the company with this legal name or name, nil
+	// when there's none
+	FindCompany(name string) (*zefix.Company, error)
+	// SearchCompanies returns up to limit companies whose legal name
+	// contains word, regardless of the case
+	SearchCompanies(word string, limit int) ([]zefix.Company, error)
+}
+
+// UidResolver is a CompanyResolver that can also find the companies by UID
+// (CHE-123.456.789)
+type UidResolver interface {
+	CompanyResolver
+	FindCompanyByUid(uid string) (*zefix.Company, error)
+}
+
+// CompanyRecord is a company of a CompanyResolver that isn't backed by
+// Zefix, with its UID when it has one
+type CompanyRecord struct {
+	zefix.Company
+	Uid string `json:"uid,omitempty"`
+}
+
+// NoopResolver doesn't know any company, it's used when no company registry
+// is configured
+type NoopResolver struct{}
+
+func (NoopResolver) FindCompany(string) (*zefix.Company, error) {
+	return nil, nil
+}
+
+func (NoopResolver) SearchCompanies(string, int) ([]zefix.Company, error) {
+	return nil, nil
+}
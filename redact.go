@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+
+	"github.com/denysvitali/odi-backend/pkg/dedup"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/redact"
+)
+
+type RedactRequest struct {
+	// Boxes are the regions of the page to black out, in pixels
+	Boxes []models.BoundingBox `json:"boxes"`
+	// Text are additional terms to remove from the document text
+	Text []string `json:"text"`
+}
+
+// handleRedactDocument blacks out the requested regions of the stored page
+// and removes the text found in them from the indexed document
+func (s *Server) handleRedactDocument(c *gin.Context) {
+	docId := c.Param("id")
+	m := docIdRegexp.FindStringSubmatch(docId)
+	if m == nil {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	scanId := m[1]
+	sequenceId, err := strconv.Atoi(m[2])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+
+	var redactRequest RedactRequest
+	if err := c.BindJSON(&redactRequest); err != nil {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	if len(redactRequest.Boxes) == 0 && len(redactRequest.Text) == 0 {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+
+	doc, err := s.getDocument(c.Request.Context(), docId)
+	if err != nil {
+		log.Errorf("unable to get document %s: %v", docId, err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if doc == nil || doc.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+
+	// The document is reindexed before the redacted page replaces the stored
+	// one: the original page can't be recovered once it's overwritten
+	original := *doc
+	original.Blocks = slices.Clone(doc.Blocks)
+
+	var page *models.ScannedPage
+	if len(redactRequest.Boxes) > 0 {
+		page, err = s.redactPage(doc, scanId, sequenceId, redactRequest.Boxes)
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "not found",
+			})
+			return
+		}
+		if err != nil {
+			log.Errorf("unable to redact page %s: %v", docId, err)
+			c.JSON(http.StatusInternalServerError, internalServerError)
+			return
+		}
+	}
+
+	redact.Document(doc, redactRequest.Boxes, redactRequest.Text)
+	now := time.Now().UTC()
+	doc.RedactedAt = &now
+
+	if err := s.indexDocument(c.Request.Context(), docId, doc); err != nil {
+		log.Errorf("unable to index redacted document %s: %v", docId, err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+
+	if page != nil {
+		if err := s.storage.Store(*page); err != nil {
+			log.Errorf("unable to store redacted page %s: %v", docId, err)
+			// The stored page is still the original one, so must be the index
+			if err := s.indexDocument(c.Request.Context(), docId, &original); err != nil {
+				log.Errorf("unable to restore document %s, it no longer matches its page: %v", docId, err)
+			}
+			c.JSON(http.StatusInternalServerError, internalServerError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// redactPage returns the redacted version of the stored page, to be stored in
+// its place, and updates the hashes of the document accordingly
+func (s *Server) redactPage(doc *models.Document, scanId string, sequenceId int, boxes []models.BoundingBox) (*models.ScannedPage, error) {
+	page, err := s.storage.Retrieve(scanId, sequenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := redact.Image(page.Reader, boxes)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum(b)
+	doc.Hash = hex.EncodeToString(h[:])
+	if p, err := dedup.PerceptualHash(bytes.NewReader(b)); err == nil {
+		doc.PerceptualHash = dedup.FormatHash(p)
+	}
+	return &models.ScannedPage{
+		Reader:     bytes.NewReader(b),
+		ScanId:     scanId,
+		SequenceId: sequenceId,
+		ScanTime:   page.ScanTime,
+	}, nil
+}
+
+// getDocument returns the document with the given ID, or nil when it doesn't
+// exist
+func (s *Server) getDocument(ctx context.Context, docId string) (*models.Document, error) {
+	req := opensearchapi.GetRequest{Index: s.osIndex, DocumentID: docId}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+
+	var doc Document[models.Document]
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if !doc.Found {
+		return nil, nil
+	}
+	return &doc.Source, nil
+}
+
+func (s *Server) indexDocument(ctx context.Context, docId string, doc *models.Document) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index:      s.osIndex,
+		DocumentID: docId,
+		Body:       bytes.NewReader(body),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+	return nil
+}
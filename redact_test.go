@@ -0,0 +1,134 @@
+package backend_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+// failingOpenSearch fails the requests matching the method
+type failingOpenSearch struct {
+	*fakeOpenSearch
+	method string
+}
+
+func (f failingOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == f.method {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.fakeOpenSearch.ServeHTTP(w, r)
+}
+
+// readOnlyStorage fails to store the pages once it's created
+type readOnlyStorage struct {
+	model.RWStorage
+}
+
+func (readOnlyStorage) Store(models.ScannedPage) error {
+	return errors.New("read-only")
+}
+
+func newPageStorage(t *testing.T, scanId string) (model.RWStorage, []byte) {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 100, 100)), nil); err != nil {
+		t.Fatal(err)
+	}
+	storage := newStorage(t, scanId, 0)
+	err := storage.Store(models.ScannedPage{
+		Reader:     bytes.NewReader(buf.Bytes()),
+		ScanId:     scanId,
+		SequenceId: 1,
+		ScanTime:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage, buf.Bytes()
+}
+
+func storedPage(t *testing.T, storage model.RWStorage, scanId string) []byte {
+	t.Helper()
+	page, err := storage.Retrieve(scanId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(page.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func redactRequest() *http.Request {
+	body := `{"boxes": [{"top": 0, "bottom": 50, "left": 0, "right": 50}], "text": ["secret"]}`
+	return httptest.NewRequest(http.MethodPost, "/api/v1/documents/0a1b_1/redact", strings.NewReader(body))
+}
+
+func TestRedact(t *testing.T) {
+	const scanId = "0a1b"
+	f := &fakeOpenSearch{docs: map[string]models.Document{
+		scanId + "_1": {ScanId: scanId, SequenceId: 1, Text: "a secret"},
+	}}
+	storage, original := newPageStorage(t, scanId)
+	handler := newServer(t, f, storage)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, redactRequest())
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if doc := f.docs[scanId+"_1"]; doc.RedactedAt == nil || strings.Contains(doc.Text, "secret") {
+		t.Errorf("expected the document to be redacted, got %+v", doc)
+	}
+	if bytes.Equal(storedPage(t, storage, scanId), original) {
+		t.Error("expected the page to be replaced")
+	}
+}
+
+func TestRedactIndexFailure(t *testing.T) {
+	const scanId = "0a1b"
+	f := &fakeOpenSearch{docs: map[string]models.Document{
+		scanId + "_1": {ScanId: scanId, SequenceId: 1, Text: "a secret"},
+	}}
+	storage, original := newPageStorage(t, scanId)
+	handler := newServer(t, failingOpenSearch{fakeOpenSearch: f, method: http.MethodPut}, storage)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, redactRequest())
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if !bytes.Equal(storedPage(t, storage, scanId), original) {
+		t.Error("expected the page to be kept when the document can't be indexed")
+	}
+}
+
+func TestRedactStoreFailure(t *testing.T) {
+	const scanId = "0a1b"
+	f := &fakeOpenSearch{docs: map[string]models.Document{
+		scanId + "_1": {ScanId: scanId, SequenceId: 1, Text: "a secret"},
+	}}
+	storage, _ := newPageStorage(t, scanId)
+	handler := newServer(t, f, readOnlyStorage{storage})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, redactRequest())
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if doc := f.docs[scanId+"_1"]; doc.RedactedAt != nil || doc.Text != "a secret" {
+		t.Errorf("expected the document to be restored, got %+v", doc)
+	}
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/odi-backend/pkg/discovery"
+)
+
+const (
+	defaultDiscoveryTimeout = 3 * time.Second
+	maxDiscoveryTimeout     = 30 * time.Second
+)
+
+// handleGetScanners browses the network for eSCL scanners, the browsing
+// time can be set with ?timeout=5s
+func (s *Server) handleGetScanners(c *gin.Context) {
+	timeout := defaultDiscoveryTimeout
+	if t := c.Query("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout <= 0 || timeout > maxDiscoveryTimeout {
+			c.JSON(http.StatusBadRequest, badRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	scanners, err := discovery.Discover(ctx)
+	if err != nil {
+		log.Errorf("unable to discover scanners: %v", err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if scanners == nil {
+		scanners = []discovery.Scanner{}
+	}
+	c.JSON(http.StatusOK, scanners)
+}
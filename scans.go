@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
+)
+
+// Finished jobs are kept for this long, so that their progress can still be
+// fetched after the scan
+const scanJobRetention = 24 * time.Hour
+
+type ScanJobStatus string
+
+const (
+	ScanJobRunning ScanJobStatus = "running"
+	ScanJobDone    ScanJobStatus = "done"
+	ScanJobFailed  ScanJobStatus = "failed"
+)
+
+// ScanJob is a scan started from the API, its ID is also the ID of the scan
+// the pages are stored with
+type ScanJob struct {
+	Id         string        `json:"id"`
+	Scanner    string        `json:"scanner"`
+	Profile    string        `json:"profile"`
+	Status     ScanJobStatus `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+
+	Scanned    int `json:"scanned"`
+	Indexed    int `json:"indexed"`
+	Duplicates int `json:"duplicates"`
+	Failed     int `json:"failed"`
+
+	mu          sync.Mutex
+	events      []ingestor.PageEvent
+	subscribers map[chan ingestor.PageEvent]bool
+	done        chan struct{}
+}
+
+type ScanRequest struct {
+	// Profile is the name of the scan profile, the default one when empty
+	Profile string `json:"profile"`
+	// Scanner overrides the scanner configured in the backend
+	Scanner string `json:"scanner"`
+}
+
+func (j *ScanJob) publish(e ingestor.PageEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch e.Stage {
+	case ingestor.StageScanned:
+		j.Scanned++
+	case ingestor.StageIndexed:
+		j.Indexed++
+	case ingestor.StageDuplicate:
+		j.Duplicates++
+	case ingestor.StageFailed:
+		j.Failed++
+	}
+	j.events = append(j.events, e)
+	for ch := range j.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// Slow client, it will miss this event rather than block the scan
+		}
+	}
+}
+
+func (j *ScanJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Status = ScanJobDone
+	if err != nil {
+		j.Status = ScanJobFailed
+		j.Error = err.Error()
+	}
+	close(j.done)
+}
+
+// subscribe returns the events published so far and a channel receiving the
+// next ones
+func (j *ScanJob) subscribe() ([]ingestor.PageEvent, chan ingestor.PageEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ch := make(chan ingestor.PageEvent, 64)
+	j.subscribers[ch] = true
+	return append([]ingestor.PageEvent(nil), j.events...), ch
+}
+
+func (j *ScanJob) unsubscribe(ch chan ingestor.PageEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.subscribers, ch)
+}
+
+func (j *ScanJob) snapshot() *ScanJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &ScanJob{
+		Id:         j.Id,
+		Scanner:    j.Scanner,
+		Profile:    j.Profile,
+		Status:     j.Status,
+		Error:      j.Error,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		Scanned:    j.Scanned,
+		Indexed:    j.Indexed,
+		Duplicates: j.Duplicates,
+		Failed:     j.Failed,
+	}
+}
+
+// handleStartScan starts a scan in the background, only one scan can run at
+// a time
+func (s *Server) handleStartScan(c *gin.Context) {
+	if s.ingestor == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "scanning is not configured",
+		})
+		return
+	}
+
+	// The body is optional
+	var scanRequest ScanRequest
+	if err := c.ShouldBindJSON(&scanRequest); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	if scanRequest.Profile == "" {
+		scanRequest.Profile = profiles.DefaultName
+	}
+	profile, err := s.profiles.Get(scanRequest.Profile)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	scanner := scanRequest.Scanner
+	if scanner == "" {
+		scanner = s.scannerName
+	}
+	if scanner == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no scanner configured",
+		})
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	for id, j := range s.jobs {
+		j := j.snapshot()
+		if j.Status == ScanJobRunning && j.Scanner != uploadScanner {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "a scan is already running",
+				"id":    id,
+			})
+			return
+		}
+	}
+
+	job := s.newJob(scanner, profile.Name)
+	go func() {
+		log.Infof("starting scan %s on %s with profile %s", job.Id, scanner, profile.Name)
+		_, err := s.ingestor.Ingest(scanner, profile,
+			ingestor.WithScanId(job.Id),
+			ingestor.WithProgress(job.publish),
+		)
+		if err != nil {
+			log.Errorf("scan %s failed: %v", job.Id, err)
+		}
+		job.finish(err)
+	}()
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+// newJob registers a running job and forgets the ones that finished more
+// than scanJobRetention ago. The caller must hold jobsMu.
+func (s *Server) newJob(scanner string, profile string) *ScanJob {
+	for id, j := range s.jobs {
+		j := j.snapshot()
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > scanJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+
+	job := &ScanJob{
+		Id:          uuid.NewString(),
+		Scanner:     scanner,
+		Profile:     profile,
+		Status:      ScanJobRunning,
+		StartedAt:   time.Now(),
+		subscribers: map[chan ingestor.PageEvent]bool{},
+		done:        make(chan struct{}),
+	}
+	s.jobs[job.Id] = job
+	return job
+}
+
+func (s *Server) getJob(c *gin.Context) *ScanJob {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	job, ok := s.jobs[c.Param("scanId")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return nil
+	}
+	return job
+}
+
+func (s *Server) handleGetScan(c *gin.Context) {
+	job := s.getJob(c)
+	if job == nil {
+		return
+	}
+	c.JSON(http.StatusOK, job.snapshot())
+}
+
+// handleScanEvents streams the progress of a scan as Server-Sent Events: a
+// "page" event every time a page reaches a new stage, then a "done" event
+// with the summary of the job
+func (s *Server) handleScanEvents(c *gin.Context) {
+	job := s.getJob(c)
+	if job == nil {
+		return
+	}
+
+	past, ch := job.subscribe()
+	defer job.unsubscribe(ch)
+	for _, e := range past {
+		c.SSEvent("page", e)
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-ch:
+			c.SSEvent("page", e)
+			return true
+		case <-job.done:
+			// Drain what's left before closing the stream
+			for {
+				select {
+				case e := <-ch:
+					c.SSEvent("page", e)
+				default:
+					c.SSEvent("done", job.snapshot())
+					return false
+				}
+			}
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// handleGetIngestorMetrics returns the pages currently queued and being
+// processed, and the totals since the backend was started
+func (s *Server) handleGetIngestorMetrics(c *gin.Context) {
+	if s.ingestor == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "scanning is not configured",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, s.ingestor.Metrics())
+}
@@ -0,0 +1,195 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	backend "github.com/denysvitali/odi-backend"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// fakeScanner is an eSCL scanner with a loaded feeder. The first page is
+// returned once release is closed.
+type fakeScanner struct {
+	pages   int
+	release chan struct{}
+
+	mu      sync.Mutex
+	scanned int
+}
+
+func (f *fakeScanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/eSCL/ScannerStatus":
+		fmt.Fprint(w, `<ScannerStatus><State>Idle</State><AdfState>ScannerAdfLoaded</AdfState></ScannerStatus>`)
+	case r.URL.Path == "/eSCL/ScannerCapabilities":
+		fmt.Fprint(w, `<ScannerCapabilities><Platen></Platen><Adf></Adf></ScannerCapabilities>`)
+	case r.URL.Path == "/eSCL/ScanJobs" && r.Method == http.MethodPost:
+		w.Header().Set("Location", "/eSCL/ScanJobs/1")
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/eSCL/ScanJobs/1/NextDocument":
+		<-f.release
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.scanned == f.pages {
+			http.NotFound(w, r)
+			return
+		}
+		f.scanned++
+		fmt.Fprintf(w, "page %d", f.scanned)
+	default:
+		// Deleting the job is acknowledged with a 404
+		http.NotFound(w, r)
+	}
+}
+
+type fakeAnalyzer struct{}
+
+func (fakeAnalyzer) Analyze(page models.ScannedPage) (*models.Document, error) {
+	return &models.Document{ScanId: page.ScanId, SequenceId: page.SequenceId}, nil
+}
+
+func (fakeAnalyzer) IndexDocumentAsync(id string, d *models.Document, done func(error)) {
+	done(nil)
+}
+
+func (fakeAnalyzer) Flush() {}
+
+func newScanServer(t *testing.T, scanner *fakeScanner) *httptest.Server {
+	t.Helper()
+	scannerServer := httptest.NewServer(scanner)
+	t.Cleanup(scannerServer.Close)
+	ing := ingestor.NewWithAnalyzer(ingestor.Config{Storage: newStorage(t, "", 0)}, fakeAnalyzer{})
+	handler := newServer(t, &fakeOpenSearch{}, newStorage(t, "", 0),
+		backend.WithIngestor(ing, strings.TrimPrefix(scannerServer.URL, "http://")))
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func do(t *testing.T, method string, url string, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, b
+}
+
+func TestStartScan(t *testing.T) {
+	scanner := &fakeScanner{pages: 2, release: make(chan struct{})}
+	server := newScanServer(t, scanner)
+	api := server.URL + "/api/v1"
+
+	// The body is optional
+	status, body := do(t, http.MethodPost, api+"/scans", "")
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+	var job backend.ScanJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Id == "" || job.Status != backend.ScanJobRunning || job.Profile != "default" {
+		t.Errorf("unexpected job %s", body)
+	}
+
+	for req, want := range map[string]int{
+		`{`:                    http.StatusBadRequest,
+		`{"profile": "photo"}`: http.StatusBadRequest,
+		`{}`:                   http.StatusConflict,
+	} {
+		if status, body := do(t, http.MethodPost, api+"/scans", req); status != want {
+			t.Errorf("%s: expected status %d, got %d: %s", req, want, status, body)
+		}
+	}
+	close(scanner.release)
+
+	// The events are streamed until the scan is done
+	status, body = do(t, http.MethodGet, api+"/scans/"+job.Id+"/events", "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+	events := map[ingestor.Stage]int{}
+	var done backend.ScanJob
+	for _, event := range strings.Split(strings.TrimSpace(string(body)), "\n\n") {
+		name, data, _ := strings.Cut(event, "\n")
+		data = strings.TrimPrefix(data, "data:")
+		switch name {
+		case "event:page":
+			var e ingestor.PageEvent
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.ScanId != job.Id {
+				t.Errorf("unexpected event %s", data)
+			}
+			events[e.Stage]++
+		case "event:done":
+			if err := json.Unmarshal([]byte(data), &done); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+	for _, stage := range []ingestor.Stage{ingestor.StageScanned, ingestor.StageStored, ingestor.StageAnalyzed, ingestor.StageIndexed} {
+		if events[stage] != 2 {
+			t.Errorf("expected 2 %s events, got %v", stage, events)
+		}
+	}
+	if done.Status != backend.ScanJobDone || done.Scanned != 2 || done.Indexed != 2 || done.FinishedAt == nil {
+		t.Errorf("unexpected job %+v", &done)
+	}
+
+	status, body = do(t, http.MethodGet, api+"/scans/"+job.Id, "")
+	if status != http.StatusOK || !strings.Contains(string(body), `"status":"done"`) {
+		t.Errorf("unexpected job %d: %s", status, body)
+	}
+	if status, _ := do(t, http.MethodGet, api+"/scans/unknown", ""); status != http.StatusNotFound {
+		t.Errorf("expected an unknown scan not to be found, got %d", status)
+	}
+
+	status, body = do(t, http.MethodGet, api+"/ingestor/metrics", "")
+	var metrics ingestor.Metrics
+	if err := json.Unmarshal(body, &metrics); err != nil || status != http.StatusOK {
+		t.Fatalf("unexpected metrics %d: %s", status, body)
+	}
+	if metrics.Stored != 2 || metrics.Indexed != 2 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestStartScanWithoutIngestor(t *testing.T) {
+	handler := newServer(t, &fakeOpenSearch{}, newStorage(t, "", 0))
+	for _, path := range []string{"/api/v1/scans", "/api/v1/ingestor/metrics"} {
+		method := http.MethodPost
+		if strings.HasSuffix(path, "metrics") {
+			method = http.MethodGet
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusServiceUnavailable, w.Code)
+		}
+	}
+}
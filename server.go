@@ -13,6 +13,8 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -21,7 +23,10 @@ import (
 	"github.com/opensearch-project/opensearch-go/opensearchapi"
 	"github.com/sirupsen/logrus"
 
+	"github.com/denysvitali/odi-backend/pkg/entities"
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
 	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/profiles"
 	"github.com/denysvitali/odi-backend/pkg/storage/model"
 )
 
@@ -33,12 +38,24 @@ type Server struct {
 	osIndex              string
 	osInsecureSkipVerify bool
 	osClient             *opensearch.Client
-	storage              model.Retriever
+	storage              model.RWStorage
+
+	trashPeriod   time.Duration
+	purgeInterval time.Duration
+	profiles      *profiles.Config
+
+	ingestor    *ingestor.Ingestor
+	scannerName string
+	jobs        map[string]*ScanJob
+	jobsMu      sync.Mutex
+
+	maxUploadFileSize int64
+	maxUploadFiles    int
 }
 
 var log = logrus.StandardLogger().WithField("package", "backend")
 
-func New(osAddr string, osUsername string, osPassword string, osInsecureSkipVerify bool, osIndex string, ret model.Retriever) (*Server, error) {
+func New(osAddr string, osUsername string, osPassword string, osInsecureSkipVerify bool, osIndex string, storage model.RWStorage, opts ...Option) (*Server, error) {
 	u, err := url.Parse(osAddr)
 	if err != nil {
 		return nil, err
@@ -51,7 +68,16 @@ func New(osAddr string, osUsername string, osPassword string, osInsecureSkipVeri
 		osPassword:           osPassword,
 		osInsecureSkipVerify: osInsecureSkipVerify,
 		osIndex:              osIndex,
-		storage:              ret,
+		storage:              storage,
+		trashPeriod:          DefaultTrashPeriod,
+		purgeInterval:        time.Hour,
+		profiles:             profiles.DefaultConfig(),
+		jobs:                 map[string]*ScanJob{},
+		maxUploadFileSize:    DefaultMaxUploadFileSize,
+		maxUploadFiles:       DefaultMaxUploadFiles,
+	}
+	for _, opt := range opts {
+		opt(&s)
 	}
 
 	var transport http.RoundTripper
@@ -97,9 +123,16 @@ func (s *Server) verifyOpensearch(osIndex string) error {
 }
 
 func (s *Server) Run(addr string) error {
+	go s.purgeTrash(context.Background())
 	return s.e.Run(addr)
 }
 
+// Handler returns the HTTP handler of the API, e.g. to serve it with another
+// server
+func (s *Server) Handler() http.Handler {
+	return s.e
+}
+
 func (s *Server) initRoutes() {
 	s.e.Use(gin.Logger())
 	s.e.Use(cors.Default())
@@ -109,10 +142,92 @@ func (s *Server) initRoutes() {
 	g.GET("/documents/:id", s.handleGetDocument)
 	g.GET("/documents", s.handleGetDocuments)
 	g.GET("/files/:scanId/:sequenceId", s.handleGetFile)
+	g.GET("/duplicates", s.handleGetDuplicates)
+	g.POST("/duplicates/:id/dismiss", s.handleDismissDuplicate)
+	g.POST("/duplicates/:id/merge", s.handleMergeDuplicate)
+	g.DELETE("/documents/:id", s.handleDeleteDocument)
+	g.POST("/documents/:id/restore", s.handleRestoreDocument)
+	g.POST("/documents/:id/redact", s.handleRedactDocument)
+	g.DELETE("/scans/:scanId", s.handleDeleteScan)
+	g.POST("/scans/:scanId/restore", s.handleRestoreScan)
+	g.GET("/trash", s.handleGetTrash)
+	g.GET("/profiles", s.handleGetProfiles)
+	g.GET("/scanners", s.handleGetScanners)
+	g.POST("/scans", s.handleStartScan)
+	g.GET("/scans/:scanId", s.handleGetScan)
+	g.GET("/scans/:scanId/events", s.handleScanEvents)
+	g.POST("/uploads", s.handleUpload)
+	g.GET("/ingestor/metrics", s.handleGetIngestorMetrics)
 }
 
 type SearchRequest struct {
 	SearchTerm string `json:"searchTerm"`
+
+	// MinTotal and MaxTotal filter on the total of the document, Currency on
+	// its currency, From and To on its date (e.g. 2024-01-01). They're all
+	// optional.
+	MinTotal *float64 `json:"minTotal,omitempty"`
+	MaxTotal *float64 `json:"maxTotal,omitempty"`
+	Currency string   `json:"currency,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+
+	// Entity filters on an identifier found in the document, such as an IBAN
+	// or a customer number, as it's written on it (e.g. "044 123 45 67")
+	Entity string `json:"entity,omitempty"`
+}
+
+func (r SearchRequest) query() map[string]any {
+	var must any = map[string]any{"match_all": map[string]any{}}
+	if r.SearchTerm != "" {
+		must = map[string]any{
+			"query_string": map[string]any{
+				"query": r.SearchTerm,
+			},
+		}
+	}
+
+	var filter []any
+	if r.MinTotal != nil || r.MaxTotal != nil {
+		total := map[string]any{}
+		if r.MinTotal != nil {
+			total["gte"] = *r.MinTotal
+		}
+		if r.MaxTotal != nil {
+			total["lte"] = *r.MaxTotal
+		}
+		filter = append(filter, map[string]any{"range": map[string]any{"total.value": total}})
+	}
+	if r.Currency != "" {
+		filter = append(filter, map[string]any{
+			"term": map[string]any{"total.currency.keyword": strings.ToUpper(r.Currency)},
+		})
+	}
+	if r.From != "" || r.To != "" {
+		date := map[string]any{}
+		if r.From != "" {
+			date["gte"] = r.From
+		}
+		if r.To != "" {
+			date["lte"] = r.To
+		}
+		filter = append(filter, map[string]any{"range": map[string]any{"date": date}})
+	}
+	if r.Entity != "" {
+		// The entities are stored normalized by their extractor
+		filter = append(filter, map[string]any{
+			"terms": map[string]any{"entities.value": entities.Values(r.Entity)},
+		})
+	}
+
+	q := map[string]any{
+		"must":     must,
+		"must_not": isDeleted,
+	}
+	if len(filter) > 0 {
+		q["filter"] = filter
+	}
+	return map[string]any{"bool": q}
 }
 
 func (s *Server) handleSearch(c *gin.Context) {
@@ -124,12 +239,8 @@ func (s *Server) handleSearch(c *gin.Context) {
 	}
 
 	searchContent := map[string]any{
-		"size": 50,
-		"query": map[string]any{
-			"query_string": map[string]any{
-				"query": searchRequest.SearchTerm,
-			},
-		},
+		"size":  50,
+		"query": searchRequest.query(),
 		"highlight": map[string]any{
 			"fields": map[string]any{
 				"text": map[string]any{},
@@ -207,6 +318,25 @@ func (s *Server) handleGetFile(c *gin.Context) {
 		return
 	}
 
+	// The pages of the documents in the trash are hidden like the documents
+	docId := scanId + "_" + sequenceIdStr
+	if !docIdRegexp.MatchString(docId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	doc, err := s.getDocument(c.Request.Context(), docId)
+	if err != nil {
+		log.Errorf("unable to get document %s: %v", docId, err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if doc != nil && doc.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+
 	s.returnDocument(c, scanId, sequenceIdStr)
 }
 
@@ -263,7 +393,7 @@ func (s *Server) handleGetDocument(c *gin.Context) {
 		return
 	}
 
-	if !doc.Found {
+	if !doc.Found || doc.Source.DeletedAt != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "not found",
 		})
@@ -284,8 +414,19 @@ func (s *Server) handleGetDocuments(c *gin.Context) {
 		}
 		res, err = req.Do(context.Background(), s.osClient)
 	} else {
+		var body []byte
+		body, err = json.Marshal(map[string]any{
+			"query": map[string]any{
+				"bool": map[string]any{"must_not": isDeleted},
+			},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, internalServerError)
+			return
+		}
 		req := opensearchapi.SearchRequest{
 			Index: []string{s.osIndex},
+			Body:  bytes.NewReader(body),
 			Sort: []string{
 				"indexedAt:desc",
 			},
@@ -294,9 +435,11 @@ func (s *Server) handleGetDocuments(c *gin.Context) {
 		res, err = req.Do(context.Background(), s.osClient)
 	}
 	if err != nil {
+		log.Errorf("unable to get documents: %v", err)
 		c.JSON(http.StatusInternalServerError, internalServerError)
 		return
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
 		log.Warnf("unable to get documents: %s", res.Status())
@@ -320,6 +463,10 @@ func (s *Server) handleGetDocuments(c *gin.Context) {
 	c.JSON(http.StatusOK, docs)
 }
 
+func (s *Server) handleGetProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, s.profiles.Profiles)
+}
+
 func (s *Server) pingOs() error {
 	req := opensearchapi.PingRequest{}
 	res, err := req.Do(context.Background(), s.osClient)
@@ -0,0 +1,113 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// search sends the request to the search API and returns the query sent to
+// OpenSearch
+func search(t *testing.T, request string) string {
+	t.Helper()
+	f := &fakeOpenSearch{docs: map[string]models.Document{}}
+	handler := newServer(t, f, newStorage(t, "", 0))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/search", strings.NewReader(request)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	if len(f.searches) != 1 {
+		t.Fatalf("expected 1 search, got %d", len(f.searches))
+	}
+	q, err := json.Marshal(f.searches[0]["query"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(q)
+}
+
+func TestSearchQuery(t *testing.T) {
+	notDeleted := `"must_not":{"exists":{"field":"deletedAt"}}`
+	tests := []struct {
+		name    string
+		request string
+		query   string
+	}{
+		{
+			name:    "everything",
+			request: `{}`,
+			query:   `{"bool":{"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "search term",
+			request: `{"searchTerm": "invoice"}`,
+			query:   `{"bool":{"must":{"query_string":{"query":"invoice"}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "total and currency",
+			request: `{"minTotal": 10, "maxTotal": 99.5, "currency": "chf"}`,
+			query: `{"bool":{"filter":[` +
+				`{"range":{"total.value":{"gte":10,"lte":99.5}}},` +
+				`{"term":{"total.currency.keyword":"CHF"}}],` +
+				`"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "only a minimum",
+			request: `{"minTotal": 0}`,
+			query: `{"bool":{"filter":[{"range":{"total.value":{"gte":0}}}],` +
+				`"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "dates",
+			request: `{"from": "2024-01-01", "to": "2024-12-31"}`,
+			query: `{"bool":{"filter":[{"range":{"date":{"gte":"2024-01-01","lte":"2024-12-31"}}}],` +
+				`"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "entity",
+			request: `{"entity": "ch93 0076 2011 6238 5295 7"}`,
+			query: `{"bool":{"filter":[{"terms":{"entities.value":` +
+				`["CH9300762011623852957","ch9300762011623852957"]}}],` +
+				`"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+		{
+			name:    "phone number",
+			request: `{"entity": "044 123 45 67"}`,
+			query: `{"bool":{"filter":[{"terms":{"entities.value":` +
+				`["+41441234567","0441234567"]}}],` +
+				`"must":{"match_all":{}},` + notDeleted + `}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := search(t, tt.request); got != tt.query {
+				t.Errorf("expected\n%s\ngot\n%s", tt.query, got)
+			}
+		})
+	}
+}
+
+func TestGetDocumentsUnreachable(t *testing.T) {
+	// OpenSearch answers the client setup, then drops the connections
+	handler := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"version": {"number": "2.11.0", "distribution": "opensearch"}}`))
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}), newStorage(t, "", 0))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/documents", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,310 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+
+	"github.com/denysvitali/odi-backend/pkg/models"
+)
+
+// Documents are deleted in two steps: they're first moved to the trash by
+// setting their deletedAt field, which hides them from the search and can be
+// undone, then the purge removes the stored page and the OpenSearch entry.
+// The page is removed first so that a failed purge is retried on the next
+// run instead of leaving an entry without its file.
+
+const purgeBatchSize = 1000
+
+var scanIdRegexp = regexp.MustCompile("^[0-9a-f-]+$")
+
+// isDeleted matches the documents in the trash
+var isDeleted = map[string]any{
+	"exists": map[string]any{"field": "deletedAt"},
+}
+
+type DeleteResponse struct {
+	Deleted   int64      `json:"deleted"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	PurgeAt   *time.Time `json:"purgeAt,omitempty"`
+}
+
+func (s *Server) handleDeleteDocument(c *gin.Context) {
+	docId := c.Param("id")
+	if !docIdRegexp.MatchString(docId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	s.deleteByQuery(c, map[string]any{
+		"ids": map[string]any{"values": []string{docId}},
+	})
+}
+
+func (s *Server) handleDeleteScan(c *gin.Context) {
+	scanId := c.Param("scanId")
+	if !scanIdRegexp.MatchString(scanId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	s.deleteByQuery(c, scanQuery(scanId))
+}
+
+func (s *Server) handleRestoreDocument(c *gin.Context) {
+	docId := c.Param("id")
+	if !docIdRegexp.MatchString(docId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	s.restoreByQuery(c, map[string]any{
+		"ids": map[string]any{"values": []string{docId}},
+	})
+}
+
+func (s *Server) handleRestoreScan(c *gin.Context) {
+	scanId := c.Param("scanId")
+	if !scanIdRegexp.MatchString(scanId) {
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	s.restoreByQuery(c, scanQuery(scanId))
+}
+
+func scanQuery(scanId string) map[string]any {
+	return map[string]any{
+		"term": map[string]any{"scanId.keyword": scanId},
+	}
+}
+
+// deleteByQuery moves the matching documents to the trash, or purges them
+// right away when requested with ?permanent=true or when there's no trash
+func (s *Server) deleteByQuery(c *gin.Context, query map[string]any) {
+	permanent, _ := strconv.ParseBool(c.Query("permanent"))
+	if permanent || s.trashPeriod <= 0 {
+		purged, err := s.purge(c.Request.Context(), query)
+		if err != nil {
+			log.Errorf("unable to purge documents: %v", err)
+			c.JSON(http.StatusInternalServerError, internalServerError)
+			return
+		}
+		if purged == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "not found",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, DeleteResponse{Deleted: purged})
+		return
+	}
+
+	deletedAt := time.Now().UTC()
+	updated, err := s.updateByQuery(c.Request.Context(), query, map[string]any{
+		"source": "ctx._source.deletedAt = params.deletedAt",
+		"lang":   "painless",
+		"params": map[string]any{"deletedAt": deletedAt.Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		log.Errorf("unable to delete documents: %v", err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+
+	purgeAt := deletedAt.Add(s.trashPeriod)
+	c.JSON(http.StatusAccepted, DeleteResponse{
+		Deleted:   updated,
+		DeletedAt: &deletedAt,
+		PurgeAt:   &purgeAt,
+	})
+}
+
+func (s *Server) restoreByQuery(c *gin.Context, query map[string]any) {
+	updated, err := s.updateByQuery(c.Request.Context(), map[string]any{
+		"bool": map[string]any{
+			"must":   query,
+			"filter": isDeleted,
+		},
+	}, map[string]any{
+		"source": "ctx._source.remove('deletedAt')",
+		"lang":   "painless",
+	})
+	if err != nil {
+		log.Errorf("unable to restore documents: %v", err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	if updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"restored": updated})
+}
+
+func (s *Server) updateByQuery(ctx context.Context, query map[string]any, script map[string]any) (int64, error) {
+	body, err := json.Marshal(map[string]any{
+		"query":  query,
+		"script": script,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	refresh := true
+	req := opensearchapi.UpdateByQueryRequest{
+		Index:   []string{s.osIndex},
+		Body:    bytes.NewReader(body),
+		Refresh: &refresh,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+
+	var result struct {
+		Updated int64 `json:"updated"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Updated, nil
+}
+
+func (s *Server) handleGetTrash(c *gin.Context) {
+	hits, err := s.searchDocuments(c.Request.Context(), isDeleted, purgeBatchSize)
+	if err != nil {
+		log.Errorf("unable to get trash: %v", err)
+		c.JSON(http.StatusInternalServerError, internalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, hits)
+}
+
+func (s *Server) searchDocuments(ctx context.Context, query map[string]any, size int) ([]Document[models.Document], error) {
+	body, err := json.Marshal(map[string]any{
+		"size":  size,
+		"query": query,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req := opensearchapi.SearchRequest{
+		Index: []string{s.osIndex},
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+
+	var docs struct {
+		Hits struct {
+			Hits []Document[models.Document] `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&docs); err != nil {
+		return nil, err
+	}
+	return docs.Hits.Hits, nil
+}
+
+// purgeTrash periodically purges the documents that have been in the trash
+// for longer than the trash period
+func (s *Server) purgeTrash(ctx context.Context) {
+	if s.trashPeriod <= 0 || s.purgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().Add(-s.trashPeriod).UTC()
+		purged, err := s.purge(ctx, map[string]any{
+			"range": map[string]any{
+				"deletedAt": map[string]any{"lte": cutoff.Format(time.RFC3339Nano)},
+			},
+		})
+		if err != nil {
+			log.Errorf("unable to purge the trash: %v", err)
+		} else if purged > 0 {
+			log.Infof("purged %d documents from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge permanently removes the matching documents and their pages, in
+// batches until none is left
+func (s *Server) purge(ctx context.Context, query map[string]any) (int64, error) {
+	var purged int64
+	for {
+		hits, err := s.searchDocuments(ctx, query, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		if len(hits) == 0 {
+			return purged, nil
+		}
+		for _, h := range hits {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			// The deletion is refreshed, the next search doesn't return it
+			if err := s.purgeDocument(ctx, h.Id, h.Source.ScanId, h.Source.SequenceId); err != nil {
+				return purged, fmt.Errorf("unable to purge %s: %w", h.Id, err)
+			}
+			purged++
+		}
+	}
+}
+
+func (s *Server) purgeDocument(ctx context.Context, docId string, scanId string, sequenceId int) error {
+	err := s.storage.Delete(scanId, sequenceId)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to delete page: %w", err)
+	}
+
+	req := opensearchapi.DeleteRequest{
+		Index:      s.osIndex,
+		DocumentID: docId,
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("opensearch returned an invalid status %s", res.Status())
+	}
+	return nil
+}
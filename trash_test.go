@@ -0,0 +1,190 @@
+package backend_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	backend "github.com/denysvitali/odi-backend"
+	"github.com/denysvitali/odi-backend/pkg/models"
+	"github.com/denysvitali/odi-backend/pkg/storage/fs"
+	"github.com/denysvitali/odi-backend/pkg/storage/model"
+)
+
+// fakeOpenSearch serves the documents API over a map of documents. Every
+// search matches all the documents, sorted by ID. The updates only support
+// the trash (by IDs) and adding tags, the documents are replaced with PUT.
+type fakeOpenSearch struct {
+	mu       sync.Mutex
+	docs     map[string]models.Document
+	searches []map[string]any
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.Path, "/documents/_doc/")
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprint(w, `{"version": {"number": "2.11.0", "distribution": "opensearch"}}`)
+	case r.URL.Path == "/documents/_search":
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		f.searches = append(f.searches, req)
+		size, _ := req["size"].(float64)
+		ids := make([]string, 0, len(f.docs))
+		for id := range f.docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		var hits []any
+		for _, id := range ids[:min(len(ids), int(size))] {
+			hits = append(hits, map[string]any{"_id": id, "_source": f.docs[id]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"hits": hits}})
+	case strings.HasPrefix(r.URL.Path, "/documents/_doc/") && r.Method == http.MethodGet:
+		doc, found := f.docs[id]
+		json.NewEncoder(w).Encode(map[string]any{"_id": id, "found": found, "_source": doc})
+	case strings.HasPrefix(r.URL.Path, "/documents/_doc/") && r.Method == http.MethodPut:
+		var doc models.Document
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.docs[id] = doc
+		fmt.Fprint(w, `{"result": "updated"}`)
+	case strings.HasPrefix(r.URL.Path, "/documents/_doc/") && r.Method == http.MethodDelete:
+		delete(f.docs, id)
+		fmt.Fprint(w, `{"result": "deleted"}`)
+	case strings.HasSuffix(r.URL.Path, "/_update"):
+		id := strings.TrimSuffix(id, "/_update")
+		var req struct {
+			Script struct {
+				Params struct {
+					Tags []string `json:"tags"`
+				} `json:"params"`
+			} `json:"script"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		doc, found := f.docs[id]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		for _, tag := range req.Script.Params.Tags {
+			if !slices.Contains(doc.Tags, tag) {
+				doc.Tags = append(doc.Tags, tag)
+			}
+		}
+		f.docs[id] = doc
+		fmt.Fprint(w, `{"result": "updated"}`)
+	case r.URL.Path == "/documents/_update_by_query":
+		var req struct {
+			Query struct {
+				Ids struct {
+					Values []string `json:"values"`
+				} `json:"ids"`
+			} `json:"query"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		now := time.Now()
+		updated := 0
+		for _, id := range req.Query.Ids.Values {
+			if doc, found := f.docs[id]; found {
+				doc.DeletedAt = &now
+				f.docs[id] = doc
+				updated++
+			}
+		}
+		fmt.Fprintf(w, `{"updated": %d}`, updated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newServer(t *testing.T, f http.Handler, storage model.RWStorage, opts ...backend.Option) http.Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	opensearch := httptest.NewServer(f)
+	t.Cleanup(opensearch.Close)
+	s, err := backend.New(opensearch.URL, "", "", false, "documents", storage, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Handler()
+}
+
+func newStorage(t *testing.T, scanId string, pages int) model.RWStorage {
+	t.Helper()
+	storage, err := fs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= pages; seq++ {
+		err := storage.Store(models.ScannedPage{
+			Reader:     bytes.NewReader([]byte("page")),
+			ScanId:     scanId,
+			SequenceId: seq,
+			ScanTime:   time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return storage
+}
+
+func TestPurgeAllBatches(t *testing.T) {
+	const scanId = "0a1b"
+	const pages = 1500
+	f := &fakeOpenSearch{docs: map[string]models.Document{}}
+	for seq := 1; seq <= pages; seq++ {
+		f.docs[fmt.Sprintf("%s_%d", scanId, seq)] = models.Document{ScanId: scanId, SequenceId: seq}
+	}
+	storage := newStorage(t, scanId, pages)
+	handler := newServer(t, f, storage)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/scans/"+scanId+"?permanent=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	var res backend.DeleteResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != pages || len(f.docs) != 0 {
+		t.Errorf("expected %d documents to be purged, got %d (%d left)", pages, res.Deleted, len(f.docs))
+	}
+	if files, err := storage.ListPages(scanId); err == nil && len(files) != 0 {
+		t.Errorf("expected the pages to be deleted, %d left", len(files))
+	}
+}
+
+func TestGetFileInTrash(t *testing.T) {
+	const scanId = "0a1b"
+	deletedAt := time.Now()
+	f := &fakeOpenSearch{docs: map[string]models.Document{
+		scanId + "_1": {ScanId: scanId, SequenceId: 1},
+		scanId + "_2": {ScanId: scanId, SequenceId: 2, DeletedAt: &deletedAt},
+	}}
+	handler := newServer(t, f, newStorage(t, scanId, 2))
+
+	for seq, status := range map[int]int{1: http.StatusOK, 2: http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/files/%s/%d", scanId, seq), nil))
+		if w.Code != status {
+			t.Errorf("page %d: expected status %d, got %d", seq, status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/odi-backend/pkg/ingestor"
+)
+
+// uploadScanner is the scanner name of the jobs created by uploads
+const uploadScanner = "upload"
+
+const (
+	DefaultMaxUploadFileSize = 20 << 20
+	DefaultMaxUploadFiles    = 50
+)
+
+type UploadError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// handleUpload ingests the files of a multipart request (field "files") as
+// the pages of a new scan. The optional "tags" and "owner" fields are set on
+// every document. The progress can be followed like a scan.
+func (s *Server) handleUpload(c *gin.Context) {
+	if s.ingestor == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "ingestion is not configured",
+		})
+		return
+	}
+
+	// Leave some room for the multipart overhead and the other fields
+	maxBody := s.maxUploadFileSize*int64(s.maxUploadFiles) + 1<<20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "request too large",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, badRequest)
+		return
+	}
+	defer form.RemoveAll()
+
+	files := form.File["files"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "no files",
+		})
+		return
+	}
+	if len(files) > s.maxUploadFiles {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("too many files, the maximum is %d", s.maxUploadFiles),
+		})
+		return
+	}
+
+	var pages []io.Reader
+	var uploadErrors []UploadError
+	for _, fh := range files {
+		if fh.Size > s.maxUploadFileSize {
+			uploadErrors = append(uploadErrors, UploadError{
+				File:  fh.Filename,
+				Error: fmt.Sprintf("file too large, the maximum is %d bytes", s.maxUploadFileSize),
+			})
+			continue
+		}
+		b, err := readFormFile(fh)
+		if err != nil {
+			uploadErrors = append(uploadErrors, UploadError{File: fh.Filename, Error: err.Error()})
+			continue
+		}
+		filePages, err := ingestor.NormalizePages(b)
+		if err != nil {
+			uploadErrors = append(uploadErrors, UploadError{File: fh.Filename, Error: err.Error()})
+			continue
+		}
+		for _, page := range filePages {
+			pages = append(pages, bytes.NewReader(page))
+		}
+	}
+	if len(uploadErrors) > 0 {
+		// Nothing is ingested unless every file is valid, so that a retry
+		// doesn't create the same pages twice
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "invalid files",
+			"errors": uploadErrors,
+		})
+		return
+	}
+
+	var tags []string
+	for _, t := range form.Value["tags"] {
+		for _, tag := range strings.Split(t, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	var owner string
+	if o := form.Value["owner"]; len(o) > 0 {
+		owner = o[0]
+	}
+
+	s.jobsMu.Lock()
+	job := s.newJob(uploadScanner, "")
+	s.jobsMu.Unlock()
+
+	go func() {
+		log.Infof("ingesting %d uploaded files as scan %s", len(pages), job.Id)
+		_, err := s.ingestor.ScanPages(ingestor.NewSliceScanner(pages...),
+			ingestor.WithScanId(job.Id),
+			ingestor.WithTags(tags...),
+			ingestor.WithOwner(owner),
+			ingestor.WithProgress(job.publish),
+		)
+		if err != nil {
+			log.Errorf("upload %s failed: %v", job.Id, err)
+		}
+		job.finish(err)
+	}()
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}